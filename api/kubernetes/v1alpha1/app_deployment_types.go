@@ -11,10 +11,12 @@ package v1alpha1
 import (
 	"errors"
 	"fmt"
+	"slices"
 
 	"github.com/xigxog/kubefox/api"
 	common "github.com/xigxog/kubefox/api/kubernetes"
 	"github.com/xigxog/kubefox/core"
+	"github.com/xigxog/kubefox/matcher"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
@@ -121,6 +123,28 @@ func (d *AppDeployment) Validate(data *api.Data, getAdapter common.GetAdapterFun
 		}
 
 		for depName, dep := range comp.Dependencies {
+			if dep.App != "" && dep.App != d.Spec.AppName {
+				if dep.Type != api.ComponentTypeKubeFox {
+					problems = append(problems, api.Problem{
+						Type: api.ProblemTypeDependencyInvalid,
+						Message: fmt.Sprintf(`Component "%s" dependency "%s" of type "%s" cannot belong to another App.`,
+							compName, depName, dep.Type),
+						Causes: []api.ProblemSource{
+							{
+								Kind:               api.ProblemSourceKindAppDeployment,
+								Name:               d.Name,
+								ObservedGeneration: d.Generation,
+								Path: fmt.Sprintf("$.spec.components.%s.dependencies.%s.app",
+									compName, depName),
+								Value: &dep.App,
+							},
+						},
+					})
+				}
+				// Components of other Apps are validated against the Release.
+				continue
+			}
+
 			found := true
 			switch {
 			case dep.Type == api.ComponentTypeKubeFox:
@@ -179,9 +203,136 @@ func (d *AppDeployment) Validate(data *api.Data, getAdapter common.GetAdapterFun
 		}
 	}
 
+	if data != nil {
+		// Routes that fail to resolve are reported by the Broker when matching.
+		if routes, err := d.Routes(data, &core.EventContext{AppDeployment: d.Name}); err == nil {
+			m := matcher.New()
+			if err := m.AddRoutes(routes...); err == nil {
+				for _, c := range m.Conflicts() {
+					problems = append(problems, RouteConflictProblem(c, func(*core.Route) int64 {
+						return d.Generation
+					}))
+				}
+				for _, g := range m.SplitGroups() {
+					if p, invalid := RouteWeightProblem(g, func(*core.Route) int64 {
+						return d.Generation
+					}); invalid {
+						problems = append(problems, p)
+					}
+				}
+			}
+		}
+	}
+
 	return problems, nil
 }
 
+// Routes returns the routes of all Components resolved using data. The Event
+// context of the routes is set to evtCtx. Routes are ordered by Component name
+// and route id.
+func (d *AppDeployment) Routes(data *api.Data, evtCtx *core.EventContext) ([]*core.Route, error) {
+	compNames := make([]string, 0, len(d.Spec.Components))
+	for name := range d.Spec.Components {
+		compNames = append(compNames, name)
+	}
+	slices.Sort(compNames)
+
+	var routes []*core.Route
+	for _, compName := range compNames {
+		compSpec := d.Spec.Components[compName]
+		comp := core.NewComponent(compSpec.Type, d.Spec.AppName, compName, compSpec.Hash)
+
+		specs := slices.Clone(compSpec.Routes)
+		slices.SortFunc(specs, func(a, b api.RouteSpec) int { return a.Id - b.Id })
+		for _, r := range specs {
+			route, err := core.NewRoute(r.Id, r.Rule)
+			if err != nil {
+				return nil, err
+			}
+			route.Priority = r.Priority
+			route.Component = comp
+			route.EventContext = evtCtx
+			if err := route.Resolve(data); err != nil {
+				return nil, err
+			}
+			routes = append(routes, route)
+		}
+	}
+
+	return routes, nil
+}
+
+// RouteConflictProblem returns a RouteConflict Problem describing c.
+// generation is used to get the generation of the AppDeployment a route
+// belongs to.
+func RouteConflictProblem(c matcher.Conflict, generation func(*core.Route) int64) api.Problem {
+	var msg string
+	if c.Identical {
+		msg = fmt.Sprintf(`Route "%s" of %s is identical to route of %s.`,
+			c.Route.ResolvedRule, describeRoute(c.Route), describeRoute(c.ShadowedBy))
+	} else {
+		msg = fmt.Sprintf(`Route "%s" of %s is shadowed by route "%s" of %s.`,
+			c.Route.ResolvedRule, describeRoute(c.Route), c.ShadowedBy.ResolvedRule, describeRoute(c.ShadowedBy))
+	}
+
+	return api.Problem{
+		Type:    api.ProblemTypeRouteConflict,
+		Message: msg,
+		Causes: []api.ProblemSource{
+			routeSource(c.Route, generation),
+			routeSource(c.ShadowedBy, generation),
+		},
+	}
+}
+
+// RouteWeightProblem returns a RouteWeightInvalid Problem if the weights of
+// the routes of g add up to more than 100%, or to less than 100% without a
+// route matching the remaining Events. generation is used to get the
+// generation of the AppDeployment a route belongs to.
+func RouteWeightProblem(g matcher.SplitGroup, generation func(*core.Route) int64) (api.Problem, bool) {
+	var msg string
+	switch {
+	case g.Weight > 100:
+		msg = fmt.Sprintf(`Weights of routes of %s add up to %g%%, routes after 100%% are never matched.`,
+			describeRoute(g.Routes[0]), g.Weight)
+	case g.Weight < 100 && !g.Fallback:
+		msg = fmt.Sprintf(`Weights of routes of %s add up to %g%% and no route matches the remaining events.`,
+			describeRoute(g.Routes[0]), g.Weight)
+	default:
+		return api.Problem{}, false
+	}
+
+	causes := make([]api.ProblemSource, len(g.Routes))
+	for i, r := range g.Routes {
+		causes[i] = routeSource(r, generation)
+	}
+
+	return api.Problem{
+		Type:    api.ProblemTypeRouteWeightInvalid,
+		Message: msg,
+		Causes:  causes,
+	}, true
+}
+
+func describeRoute(r *core.Route) string {
+	s := fmt.Sprintf(`Component "%s" of AppDeployment "%s"`, r.Component.Name, r.EventContext.AppDeployment)
+	if r.EventContext.VirtualEnvironment != "" {
+		s += fmt.Sprintf(` in VirtualEnvironment "%s"`, r.EventContext.VirtualEnvironment)
+	}
+	return s
+}
+
+func routeSource(r *core.Route, generation func(*core.Route) int64) api.ProblemSource {
+	rule := r.Template()
+	return api.ProblemSource{
+		Kind:               api.ProblemSourceKindAppDeployment,
+		Name:               r.EventContext.AppDeployment,
+		ObservedGeneration: generation(r),
+		Path:               fmt.Sprintf("$.spec.components.%s.routes[?(@.id==%d)].rule", r.Component.Name, r.Id),
+		Value:              &rule,
+	}
+}
+
 func (a *AppDeployment) GetDefinition(comp *core.Component) (*api.ComponentDefinition, error) {
 	if comp == nil {
 		return nil, core.ErrComponentMismatch(fmt.Errorf("component not part of app"))
@@ -15,6 +15,12 @@ import (
 
 type EnvironmentSpec struct {
 	ReleasePolicy EnvReleasePolicy `json:"releasePolicy,omitempty"`
+	// Limits requests routed in VirtualEnvironments of the Environment.
+	// VirtualEnvironments can override the policy.
+	RateLimitPolicy *api.RateLimitPolicy `json:"rateLimitPolicy,omitempty"`
+	// Authorizes events routed in VirtualEnvironments of the Environment.
+	// VirtualEnvironments can override the policy.
+	AccessPolicy *api.AccessPolicy `json:"accessPolicy,omitempty"`
 }
 
 type EnvReleasePolicy struct {
@@ -27,6 +27,10 @@ type PlatformSpec struct {
 	// +kubebuilder:default=IfNotPresent
 	ImagePullPolicy corev1.PullPolicy `json:"imagePullPolicy,omitempty"`
 	Debug           DebugSpec         `json:"debug,omitempty"`
+	// Marks the Platform as non-production. Features that expose the
+	// internals of the Platform, such as explaining route matches, can only
+	// be enabled on non-production Platforms.
+	NonProduction bool `json:"nonProduction,omitempty"`
 }
 
 type EventsSpec struct {
@@ -38,6 +42,167 @@ type EventsSpec struct {
 	// Maximum 16Mi.
 	// +kubebuilder:default=5242880
 	MaxSize resource.Quantity `json:"maxSize,omitempty"`
+
+	Recording   RecordingSpec   `json:"recording,omitempty"`
+	Idempotency IdempotencySpec `json:"idempotency,omitempty"`
+	RateLimit   RateLimitSpec   `json:"rateLimit,omitempty"`
+	DeadLetter  DeadLetterSpec  `json:"deadLetter,omitempty"`
+	Offload     OffloadSpec     `json:"offload,omitempty"`
+
+	ResponseCache ResponseCacheSpec `json:"responseCache,omitempty"`
+}
+
+// RecordingSpec configures recording of routed events to the JetStream event
+// stream, recorded events can be queried and replayed using the Broker admin
+// API. Events can contain sensitive data, recording is disabled by default.
+type RecordingSpec struct {
+	// Set to true to record routed events for 3 days.
+	Enabled bool `json:"enabled,omitempty"`
+
+	// Headers whose values are masked in recorded events. The
+	// 'Authorization', 'Cookie', 'Proxy-Authorization' and 'Set-Cookie'
+	// headers are always masked.
+	RedactHeaders []string `json:"redactHeaders,omitempty"`
+}
+
+// IdempotencySpec configures deduplication of requests that provide an
+// idempotency key using the 'Idempotency-Key' header or 'idempotencyKey' event
+// value. The first response for a key is stored and returned to duplicates.
+type IdempotencySpec struct {
+	// Set to true to disable deduplication of requests.
+	Disabled bool `json:"disabled,omitempty"`
+
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default=86400
+
+	// Duration keys and stored responses are kept. Default 86400 (24 hours).
+	WindowSeconds uint `json:"windowSeconds,omitempty"`
+
+	// +kubebuilder:validation:Enum=File;Memory
+	// +kubebuilder:default=File
+
+	// Storage backend of the NATS key value bucket holding stored responses.
+	Storage api.StorageType `json:"storage,omitempty"`
+}
+
+// RateLimitSpec configures how Brokers count requests limited by the
+// RateLimitPolicy of a VirtualEnvironment or route.
+type RateLimitSpec struct {
+	// Set to true to count requests in the memory of each Broker instead of
+	// sharing counts between Brokers using NATS. Limits are then applied by
+	// each Broker separately.
+	Local bool `json:"local,omitempty"`
+
+	// +kubebuilder:validation:Enum=File;Memory
+	// +kubebuilder:default=Memory
+
+	// Storage backend of the NATS key value bucket holding shared counts.
+	Storage api.StorageType `json:"storage,omitempty"`
+}
+
+// DeadLetterSpec configures handling of messages that cannot be delivered.
+// Messages failing with a transient error are redelivered with exponential
+// backoff, once attempts are exhausted or if the error is not transient they
+// are written to the dead-letter stream. Dead letters can be inspected and
+// requeued using the Broker admin API.
+type DeadLetterSpec struct {
+	// Set to true to discard messages that cannot be delivered.
+	Disabled bool `json:"disabled,omitempty"`
+
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default=604800
+
+	// Duration dead letters are kept. Default 604800 (7 days).
+	RetentionSeconds uint `json:"retentionSeconds,omitempty"`
+
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default=5
+
+	// Number of times delivery of a message is attempted, including the first
+	// attempt, before it is dead-lettered. Set to 1 to disable redelivery.
+	MaxAttempts uint `json:"maxAttempts,omitempty"`
+
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default=1
+
+	// Delay before the first redelivery, doubled for each following attempt.
+	BackoffSeconds uint `json:"backoffSeconds,omitempty"`
+
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default=300
+
+	// Maximum delay between redeliveries.
+	MaxBackoffSeconds uint `json:"maxBackoffSeconds,omitempty"`
+
+	// Maximum size of the dead-letter stream and of the stream of messages
+	// waiting to be redelivered. The oldest messages are discarded once it is
+	// exceeded. Default 256Mi.
+	// +kubebuilder:default=268435456
+	MaxSize resource.Quantity `json:"maxSize,omitempty"`
+
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default=100000
+
+	// Maximum number of messages in the dead-letter stream and in the stream
+	// of messages waiting to be redelivered. The oldest messages are discarded
+	// once it is exceeded.
+	MaxMessages uint `json:"maxMessages,omitempty"`
+}
+
+// OffloadSpec configures storing the content of large events in a NATS object
+// store bucket. Events sent between Brokers, recorded or dead-lettered carry a
+// reference to the content instead. Components fetch the content from their
+// Broker, which streams it in chunks. Stored content expires with recorded
+// events. Offloading is disabled by default.
+type OffloadSpec struct {
+	// Set to true to offload the content of large events and allow
+	// Components to send and receive events up to maxSize.
+	Enabled bool `json:"enabled,omitempty"`
+
+	// Content larger than the threshold is offloaded. Limited to maxSize of
+	// events. Default 1Mi.
+	// +kubebuilder:default=1048576
+	ThresholdSize resource.Quantity `json:"thresholdSize,omitempty"`
+
+	// Maximum size of events sent and received by Components while offloading
+	// is enabled. Default 256Mi. Maximum 1Gi.
+	// +kubebuilder:default=268435456
+	MaxSize resource.Quantity `json:"maxSize,omitempty"`
+
+	// +kubebuilder:validation:Enum=File;Memory
+	// +kubebuilder:default=File
+
+	// Storage backend of the NATS object store bucket holding content.
+	Storage api.StorageType `json:"storage,omitempty"`
+}
+
+// ResponseCacheSpec configures caching of responses by Brokers for routes and
+// Components with a CachePolicy. Cached responses are purged when the Release
+// of their VirtualEnvironment changes.
+type ResponseCacheSpec struct {
+	// Set to true to disable caching of responses.
+	Disabled bool `json:"disabled,omitempty"`
+
+	// Set to true to share cached responses between Brokers using NATS.
+	// Responses are always cached in the memory of each Broker.
+	Shared bool `json:"shared,omitempty"`
+
+	// Responses with content larger than the max entry size are not cached.
+	// Default 1Mi.
+	// +kubebuilder:default=1048576
+	MaxEntrySize resource.Quantity `json:"maxEntrySize,omitempty"`
+
+	// Maximum total size of responses cached in the memory of each Broker.
+	// The least recently used responses are evicted once it is exceeded.
+	// Default 64Mi.
+	// +kubebuilder:default=67108864
+	MaxMemorySize resource.Quantity `json:"maxMemorySize,omitempty"`
+
+	// +kubebuilder:validation:Enum=File;Memory
+	// +kubebuilder:default=Memory
+
+	// Storage backend of the NATS key value bucket holding shared responses.
+	Storage api.StorageType `json:"storage,omitempty"`
 }
 
 type NATSSpec struct {
@@ -49,6 +214,27 @@ type HTTPSrvSpec struct {
 	PodSpec       common.PodSpec       `json:"podSpec,omitempty"`
 	ContainerSpec common.ContainerSpec `json:"containerSpec,omitempty"`
 	Service       HTTPSrvService       `json:"service,omitempty"`
+	JWT           JWTSpec              `json:"jwt,omitempty"`
+	// If true requests that set the 'kf-explain' header to 'true' and do not
+	// match a route receive the trace of matching the request against all
+	// routes. The trace exposes rules of all released Components and can only
+	// be enabled if the Platform is marked non-production. Requests must also
+	// set the 'kf-explain-token' header to a token allowed to perform the
+	// 'admin' verb on the Platform.
+	AllowExplain bool `json:"allowExplain,omitempty"`
+}
+
+// JWTSpec configures verification of bearer tokens by the HTTP adapter. Claims
+// of verified tokens can be matched by routes using the Claim predicate.
+// Requests with an invalid token are rejected.
+type JWTSpec struct {
+	// URL of the JSON Web Key Set used to verify tokens. If not set tokens are
+	// not verified.
+	JWKSURL string `json:"jwksURL,omitempty"`
+	// If set tokens must be issued by issuer.
+	Issuer string `json:"issuer,omitempty"`
+	// If set tokens must contain audience.
+	Audience string `json:"audience,omitempty"`
 }
 
 type BrokerSpec struct {
@@ -151,6 +151,17 @@ func (d *ReleaseManifest) GetAppDeployment(name string) (*AppDeployment, error)
 	return nil, core.ErrNotFound()
 }
 
+// GetAppDeploymentOfApp returns the AppDeployment of the App in the manifest.
+func (d *ReleaseManifest) GetAppDeploymentOfApp(app string) (*AppDeployment, error) {
+	for _, a := range d.Spec.AppDeployments {
+		if a.Spec.AppName == app {
+			return d.GetAppDeployment(a.Name)
+		}
+	}
+
+	return nil, core.ErrNotFound()
+}
+
 func (d *ReleaseManifest) AddAppDeployment(appDep *AppDeployment) {
 	if cur, _ := d.GetAppDeployment(appDep.Name); cur != nil {
 		// Adapter already present.
@@ -25,6 +25,12 @@ type VirtualEnvironmentSpec struct {
 	Environment   string         `json:"environment"`
 	Release       *Release       `json:"release,omitempty"`
 	ReleasePolicy *ReleasePolicy `json:"releasePolicy,omitempty"`
+	// Limits requests routed in the VirtualEnvironment, including requests
+	// between Components. Overrides the policy of the Environment.
+	RateLimitPolicy *api.RateLimitPolicy `json:"rateLimitPolicy,omitempty"`
+	// Authorizes events routed in the VirtualEnvironment, including requests
+	// between Components. Overrides the policy of the Environment.
+	AccessPolicy *api.AccessPolicy `json:"accessPolicy,omitempty"`
 }
 
 type Release struct {
@@ -227,6 +233,33 @@ func (ve *VirtualEnvironment) GetReleasePolicy(env *Environment) *ReleasePolicy
 	return vePol
 }
 
+// GetRateLimitPolicy returns the RateLimitPolicy of the VirtualEnvironment if
+// set, otherwise the policy of the Environment. Nil is returned if neither is
+// set.
+func (ve *VirtualEnvironment) GetRateLimitPolicy(env *Environment) *api.RateLimitPolicy {
+	if ve.Spec.RateLimitPolicy != nil {
+		return ve.Spec.RateLimitPolicy
+	}
+	if env != nil {
+		return env.Spec.RateLimitPolicy
+	}
+
+	return nil
+}
+
+// GetAccessPolicy returns the AccessPolicy of the VirtualEnvironment if set,
+// otherwise the policy of the Environment. Nil is returned if neither is set.
+func (ve *VirtualEnvironment) GetAccessPolicy(env *Environment) *api.AccessPolicy {
+	if ve.Spec.AccessPolicy != nil {
+		return ve.Spec.AccessPolicy
+	}
+	if env != nil {
+		return env.Spec.AccessPolicy
+	}
+
+	return nil
+}
+
 func (ve *VirtualEnvironment) UsesAppDeployment(name string) bool {
 	if ve.Status.ActiveRelease.ContainsAppDeployment(name) {
 		return true
@@ -279,6 +312,16 @@ func (s *ReleaseStatus) ContainsAppDeployment(name string) bool {
 	return false
 }
 
+// AppDeployment returns the name of the AppDeployment of the App in the
+// Release. An empty string is returned if the App is not part of the Release.
+func (s *ReleaseStatus) AppDeployment(app string) string {
+	if s == nil {
+		return ""
+	}
+
+	return s.Apps[app].AppDeployment
+}
+
 func (s *ReleaseStatus) ContainsReleaseManifest(name string) bool {
 	if s == nil {
 		return false
@@ -206,6 +206,22 @@ func (in *ComponentStatus) DeepCopy() *ComponentStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DeadLetterSpec) DeepCopyInto(out *DeadLetterSpec) {
+	*out = *in
+	out.MaxSize = in.MaxSize.DeepCopy()
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DeadLetterSpec.
+func (in *DeadLetterSpec) DeepCopy() *DeadLetterSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(DeadLetterSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *DebugSpec) DeepCopyInto(out *DebugSpec) {
 	*out = *in
@@ -351,6 +367,16 @@ func (in *EnvironmentManifest) DeepCopy() *EnvironmentManifest {
 func (in *EnvironmentSpec) DeepCopyInto(out *EnvironmentSpec) {
 	*out = *in
 	in.ReleasePolicy.DeepCopyInto(&out.ReleasePolicy)
+	if in.RateLimitPolicy != nil {
+		in, out := &in.RateLimitPolicy, &out.RateLimitPolicy
+		*out = new(api.RateLimitPolicy)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.AccessPolicy != nil {
+		in, out := &in.AccessPolicy, &out.AccessPolicy
+		*out = new(api.AccessPolicy)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EnvironmentSpec.
@@ -367,6 +393,12 @@ func (in *EnvironmentSpec) DeepCopy() *EnvironmentSpec {
 func (in *EventsSpec) DeepCopyInto(out *EventsSpec) {
 	*out = *in
 	out.MaxSize = in.MaxSize.DeepCopy()
+	in.Recording.DeepCopyInto(&out.Recording)
+	out.Idempotency = in.Idempotency
+	out.RateLimit = in.RateLimit
+	in.DeadLetter.DeepCopyInto(&out.DeadLetter)
+	in.Offload.DeepCopyInto(&out.Offload)
+	in.ResponseCache.DeepCopyInto(&out.ResponseCache)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EventsSpec.
@@ -530,6 +562,7 @@ func (in *HTTPSrvSpec) DeepCopyInto(out *HTTPSrvSpec) {
 	in.PodSpec.DeepCopyInto(&out.PodSpec)
 	in.ContainerSpec.DeepCopyInto(&out.ContainerSpec)
 	in.Service.DeepCopyInto(&out.Service)
+	out.JWT = in.JWT
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HTTPSrvSpec.
@@ -567,6 +600,36 @@ func (in *HistoryLimits) DeepCopy() *HistoryLimits {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IdempotencySpec) DeepCopyInto(out *IdempotencySpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IdempotencySpec.
+func (in *IdempotencySpec) DeepCopy() *IdempotencySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(IdempotencySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *JWTSpec) DeepCopyInto(out *JWTSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new JWTSpec.
+func (in *JWTSpec) DeepCopy() *JWTSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(JWTSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *NATSSpec) DeepCopyInto(out *NATSSpec) {
 	*out = *in
@@ -584,6 +647,23 @@ func (in *NATSSpec) DeepCopy() *NATSSpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OffloadSpec) DeepCopyInto(out *OffloadSpec) {
+	*out = *in
+	out.ThresholdSize = in.ThresholdSize.DeepCopy()
+	out.MaxSize = in.MaxSize.DeepCopy()
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OffloadSpec.
+func (in *OffloadSpec) DeepCopy() *OffloadSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(OffloadSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Platform) DeepCopyInto(out *Platform) {
 	*out = *in
@@ -708,6 +788,41 @@ func (in *PlatformStatus) DeepCopy() *PlatformStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RateLimitSpec) DeepCopyInto(out *RateLimitSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RateLimitSpec.
+func (in *RateLimitSpec) DeepCopy() *RateLimitSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(RateLimitSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RecordingSpec) DeepCopyInto(out *RecordingSpec) {
+	*out = *in
+	if in.RedactHeaders != nil {
+		in, out := &in.RedactHeaders, &out.RedactHeaders
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RecordingSpec.
+func (in *RecordingSpec) DeepCopy() *RecordingSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(RecordingSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Release) DeepCopyInto(out *Release) {
 	*out = *in
@@ -912,6 +1027,23 @@ func (in *ReleaseStatus) DeepCopy() *ReleaseStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ResponseCacheSpec) DeepCopyInto(out *ResponseCacheSpec) {
+	*out = *in
+	out.MaxEntrySize = in.MaxEntrySize.DeepCopy()
+	out.MaxMemorySize = in.MaxMemorySize.DeepCopy()
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ResponseCacheSpec.
+func (in *ResponseCacheSpec) DeepCopy() *ResponseCacheSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ResponseCacheSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *VirtualEnvironment) DeepCopyInto(out *VirtualEnvironment) {
 	*out = *in
@@ -1006,6 +1138,16 @@ func (in *VirtualEnvironmentSpec) DeepCopyInto(out *VirtualEnvironmentSpec) {
 		*out = new(ReleasePolicy)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.RateLimitPolicy != nil {
+		in, out := &in.RateLimitPolicy, &out.RateLimitPolicy
+		*out = new(api.RateLimitPolicy)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.AccessPolicy != nil {
+		in, out := &in.AccessPolicy, &out.AccessPolicy
+		*out = new(api.AccessPolicy)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VirtualEnvironmentSpec.
@@ -39,6 +39,10 @@ type ComponentDefinition struct {
 	DefaultHandler bool                   `json:"defaultHandler,omitempty"`
 	EnvVarSchema   EnvVarSchema           `json:"envVarSchema,omitempty"`
 	Dependencies   map[string]*Dependency `json:"dependencies,omitempty"`
+	LoadBalancing  *LoadBalancing         `json:"loadBalancing,omitempty"`
+	// Caches responses of the Component. The policy of a route takes
+	// precedence.
+	CachePolicy *CachePolicy `json:"cachePolicy,omitempty"`
 
 	// +kubebuilder:validation:Required
 	// +kubebuilder:validation:Pattern="^[a-z0-9]{32}$"
@@ -50,15 +54,160 @@ type RouteSpec struct {
 	// +kubebuilder:validation:Required
 	Id int `json:"id"`
 	// +kubebuilder:validation:Required
-	Rule         string       `json:"rule"`
+	Rule string `json:"rule"`
+	// Routes with a higher priority are tested first. Routes with equal
+	// priority are ordered by the length of their resolved rule, longest first.
 	Priority     int          `json:"priority,omitempty"`
 	EnvVarSchema EnvVarSchema `json:"envVarSchema,omitempty"`
+	// Limits requests matching the route. Applied in addition to the policy of
+	// the VirtualEnvironment.
+	RateLimitPolicy *RateLimitPolicy `json:"rateLimitPolicy,omitempty"`
+	// Caches responses to requests matching the route. Takes precedence over
+	// the policy of the Component.
+	CachePolicy *CachePolicy `json:"cachePolicy,omitempty"`
+}
+
+// RateLimitPolicy limits the rate requests and messages are routed using token
+// buckets. A request is rejected with status 429 if any of the limits is
+// exceeded, a message is redelivered later.
+type RateLimitPolicy struct {
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinItems=1
+
+	Limits []RateLimit `json:"limits"`
+}
+
+type RateLimit struct {
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Enum=VirtualEnvironment;App;Source;Header
+
+	// Requests with the same key share a bucket. 'App' uses the App the
+	// request is routed to, 'Source' the App and name of the Component
+	// sending the request and 'Header' the value of the header.
+	Key RateLimitKey `json:"key"`
+
+	// Name of the header used as key if key is 'Header'. Requests without the
+	// header share a bucket.
+	Header string `json:"header,omitempty"`
+
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Minimum=1
+
+	// Number of requests allowed each period.
+	Requests uint `json:"requests"`
+
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=86400
+	// +kubebuilder:default=1
+
+	// Period, in seconds, the number of requests are allowed in. Use a long
+	// period to set a quota.
+	PeriodSeconds uint `json:"periodSeconds,omitempty"`
+
+	// +kubebuilder:validation:Minimum=1
+
+	// Maximum number of requests allowed at once. Defaults to requests.
+	Burst uint `json:"burst,omitempty"`
+}
+
+// AccessPolicy authorizes events routed in a VirtualEnvironment. Rules are
+// evaluated in order and the action of the first rule matching the event is
+// taken. If no rule matches the default action is taken. Denied events are
+// rejected with status 403.
+type AccessPolicy struct {
+	// +kubebuilder:validation:Enum=Enforce;Audit
+	// +kubebuilder:default=Enforce
+
+	// 'Enforce' rejects denied events, 'Audit' logs denied events but allows
+	// them. Use 'Audit' to check the effect of a policy before enforcing it.
+	Mode AccessPolicyMode `json:"mode,omitempty"`
+
+	// +kubebuilder:validation:Enum=Allow;Deny
+	// +kubebuilder:default=Allow
+
+	// Action taken for events not matching any rule.
+	DefaultAction AccessAction `json:"defaultAction,omitempty"`
+
+	Rules []AccessRule `json:"rules,omitempty"`
+}
+
+// AccessRule matches events by source, target, route and event type. Empty
+// fields match all events.
+type AccessRule struct {
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Enum=Allow;Deny
+
+	Action AccessAction `json:"action"`
+
+	// Components sending the event. KubeFox Components are specified as
+	// '<app>/<component>' and Adapters by name. Glob patterns such as
+	// 'payments/*' are supported.
+	Sources []string `json:"sources,omitempty"`
+
+	// Components the event is sent to, in the same form as sources.
+	Targets []string `json:"targets,omitempty"`
+
+	// Ids of the routes of the target the event matched. Events sent directly
+	// to a Component use the default route id -1.
+	Routes []int `json:"routes,omitempty"`
+
+	// Types of the event, for example 'io.kubefox.http'.
+	EventTypes []string `json:"eventTypes,omitempty"`
+}
+
+// CachePolicy caches responses in the Broker of the requesting Component.
+// Requests using methods other than GET or HEAD, or with content, are not
+// cached. Responses are cached for the 's-maxage' or 'max-age' of their
+// Cache-Control header and vary by the request headers listed in their Vary
+// header. Responses setting 'no-store', 'no-cache' or 'private' are not cached.
+// Responses to requests with an Authorization or Cookie header are only cached
+// if the header is listed in vary.
+type CachePolicy struct {
+	// +kubebuilder:validation:Maximum=86400
+
+	// Seconds responses are cached if their Cache-Control header does not set
+	// a max age. If 0 only responses setting a max age are cached.
+	TTLSeconds uint `json:"ttlSeconds,omitempty"`
+
+	// Set to true to cache all successful responses for ttlSeconds, ignoring
+	// the Cache-Control and Vary headers of responses.
+	IgnoreCacheControl bool `json:"ignoreCacheControl,omitempty"`
+
+	// Request headers responses vary by, in addition to those listed in the
+	// Vary header of responses.
+	Vary []string `json:"vary,omitempty"`
+}
+
+// LoadBalancing selects the replica of a Component events are sent to among
+// the replicas subscribed to the Broker routing the event. Events for a
+// Component without replicas subscribed to the Broker are sent to a Broker
+// picked by NATS, which selects among its own replicas. Strategies do not
+// balance load or hash keys across Brokers.
+type LoadBalancing struct {
+	// +kubebuilder:validation:Enum=LocalFirst;RoundRobin;LeastLoaded;Hash
+	// +kubebuilder:default=LocalFirst
+
+	// 'LocalFirst' sends events to the first replica ready to receive them,
+	// 'RoundRobin' to each replica in turn, 'LeastLoaded' to the replica
+	// with the fewest requests in flight from the Broker and 'Hash' to the
+	// replica selected by hashing the value of the header. All strategies
+	// select among the replicas subscribed to the Broker.
+	Strategy LoadBalancingStrategy `json:"strategy,omitempty"`
+
+	// Header hashed if strategy is 'Hash'. Events without the header are
+	// hashed by trace id.
+	Header string `json:"header,omitempty"`
 }
 
 type Dependency struct {
 	// +kubebuilder:validation:Required
 	// +kubebuilder:validation:Enum=DBAdapter;KubeFox;HTTPAdapter
 	Type ComponentType `json:"type"`
+
+	// App the Component belongs to if it is part of another App. The
+	// AppDeployment of the App is pinned by the Release of the
+	// VirtualEnvironment. Only valid for dependencies of type 'KubeFox'.
+	App string `json:"app,omitempty"`
 }
 
 type Details struct {
@@ -97,3 +246,21 @@ type ProblemSource struct {
 	// empty string.
 	Value *string `json:"value,omitempty"`
 }
+
+// ReplicaHealth is sent by the Broker to subscribed replicas with each
+// heartbeat. An ejected replica is not sent events for its Component until it
+// is reinstated.
+type ReplicaHealth struct {
+	Ejected bool   `json:"ejected"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// ReplicaStatus is sent by replicas in reply to a heartbeat. Requests are
+// queued while all workers of the replica are busy, a request queued for a long
+// time indicates the workers are stalled.
+type ReplicaStatus struct {
+	// Number of requests waiting for a worker.
+	Queued int `json:"queued,omitempty"`
+	// Milliseconds the oldest queued request has been waiting for a worker.
+	QueuedMillis int64 `json:"queuedMillis,omitempty"`
+}
@@ -17,8 +17,11 @@ import (
 
 // Misc
 const (
-	SecretMask             = "••••••"
-	MaxEventSizeBytesLimit = 16777216 // 16 MiB
+	SecretMask               = "••••••"
+	MaxEventSizeBytesLimit   = 16777216   // 16 MiB
+	MaxOffloadSizeBytesLimit = 1073741824 // 1 GiB
+	MaxCacheTTLSeconds       = 86400      // 24 hours
+	MaxBodyMatchSizeBytes    = 1048576    // 1 MiB
 )
 
 var (
@@ -32,10 +35,21 @@ var (
 const (
 	DefaultLogFormat                        = "json"
 	DefaultLogLevel                         = "info"
-	DefaultMaxEventSizeBytes                = 5242880 // 5 MiB
-	DefaultReleaseActivationDeadlineSeconds = 300     // 5 mins
+	DefaultDeadLetterBackoffSeconds         = 1
+	DefaultDeadLetterMaxAttempts            = 5
+	DefaultDeadLetterMaxBackoffSeconds      = 300       // 5 mins
+	DefaultDeadLetterMaxMessages            = 100000    // 100 thousand
+	DefaultDeadLetterMaxSizeBytes           = 268435456 // 256 MiB
+	DefaultDeadLetterRetentionSeconds       = 604800    // 7 days
+	DefaultIdempotencyWindowSeconds         = 86400     // 24 hours
+	DefaultMaxEventSizeBytes                = 5242880   // 5 MiB
+	DefaultOffloadMaxSizeBytes              = 268435456 // 256 MiB
+	DefaultOffloadThresholdBytes            = 1048576   // 1 MiB
+	DefaultReleaseActivationDeadlineSeconds = 300       // 5 mins
 	DefaultReleaseHistoryAgeLimit           = 0
 	DefaultReleaseHistoryCountLimit         = 10
+	DefaultResponseCacheMaxEntrySizeBytes   = 1048576  // 1 MiB
+	DefaultResponseCacheMaxMemorySizeBytes  = 67108864 // 64 MiB
 	DefaultTimeoutSeconds                   = 30
 )
 
@@ -81,9 +95,10 @@ const (
 )
 
 const (
-	EnvNodeName = "KUBEFOX_NODE"
-	EnvPodIP    = "KUBEFOX_POD_IP"
-	EnvPodName  = "KUBEFOX_POD"
+	EnvBrokerAdminToken = "KUBEFOX_BROKER_ADMIN_TOKEN"
+	EnvNodeName         = "KUBEFOX_NODE"
+	EnvPodIP            = "KUBEFOX_POD_IP"
+	EnvPodName          = "KUBEFOX_POD"
 )
 
 const (
@@ -106,6 +121,7 @@ const (
 	ConditionReasonComponentDeploymentFailed      string = "ComponentDeploymentFailed"
 	ConditionReasonComponentDeploymentProgressing string = "ComponentDeploymentProgressing"
 	ConditionReasonComponentsAvailable            string = "ComponentsAvailable"
+	ConditionReasonComponentsDegraded             string = "ComponentsDegraded"
 	ConditionReasonComponentsDeployed             string = "ComponentsDeployed"
 	ConditionReasonComponentUnavailable           string = "ComponentUnavailable"
 	ConditionReasonContextAvailable               string = "ContextAvailable"
@@ -158,6 +174,7 @@ const (
 	ProblemTypeRelManifestNotFound    ProblemType = "ReleaseManifestNotFound"
 	ProblemTypeRelManifestUnavailable ProblemType = "ReleaseManifestUnavailable"
 	ProblemTypeRouteConflict          ProblemType = "RouteConflict"
+	ProblemTypeRouteWeightInvalid     ProblemType = "RouteWeightInvalid"
 	ProblemTypeVarNotFound            ProblemType = "VarNotFound"
 	ProblemTypeVarWrongType           ProblemType = "VarWrongType"
 	ProblemTypeVersionConflict        ProblemType = "VersionConflict"
@@ -215,6 +232,45 @@ const (
 	ReleaseTypeTesting ReleaseType = "Testing"
 )
 
+type StorageType string
+
+const (
+	StorageTypeFile   StorageType = "File"
+	StorageTypeMemory StorageType = "Memory"
+)
+
+type LoadBalancingStrategy string
+
+const (
+	LoadBalancingHash        LoadBalancingStrategy = "Hash"
+	LoadBalancingLeastLoaded LoadBalancingStrategy = "LeastLoaded"
+	LoadBalancingLocalFirst  LoadBalancingStrategy = "LocalFirst"
+	LoadBalancingRoundRobin  LoadBalancingStrategy = "RoundRobin"
+)
+
+type RateLimitKey string
+
+const (
+	RateLimitKeyApp                RateLimitKey = "App"
+	RateLimitKeyHeader             RateLimitKey = "Header"
+	RateLimitKeySource             RateLimitKey = "Source"
+	RateLimitKeyVirtualEnvironment RateLimitKey = "VirtualEnvironment"
+)
+
+type AccessPolicyMode string
+
+const (
+	AccessPolicyModeAudit   AccessPolicyMode = "Audit"
+	AccessPolicyModeEnforce AccessPolicyMode = "Enforce"
+)
+
+type AccessAction string
+
+const (
+	AccessActionAllow AccessAction = "Allow"
+	AccessActionDeny  AccessAction = "Deny"
+)
+
 type FollowRedirects string
 
 const (
@@ -236,6 +292,8 @@ const (
 	// Platform event types
 	EventTypeAck       EventType = "io.kubefox.ack"
 	EventTypeBootstrap EventType = "io.kubefox.bootstrap"
+	EventTypeContent   EventType = "io.kubefox.content"
+	EventTypeDrain     EventType = "io.kubefox.drain"
 	EventTypeError     EventType = "io.kubefox.error"
 	EventTypeHealth    EventType = "io.kubefox.health"
 	EventTypeMetrics   EventType = "io.kubefox.metrics"
@@ -246,20 +304,49 @@ const (
 	EventTypeUnknown   EventType = "io.kubefox.unknown"
 )
 
+// Keys of well known error details.
+const (
+	ErrDetailExplain    = "explain"
+	ErrDetailRetryAfter = "retryAfter"
+)
+
 // Keys for well known values.
 const (
-	ValKeyHeader       = "header"
-	ValKeyHost         = "host"
-	ValKeyMaxEventSize = "maxEventSize"
-	ValKeyMethod       = "method"
-	ValKeyPath         = "path"
-	ValKeyPathSuffix   = "pathSuffix"
-	ValKeyQuery        = "queryParam"
-	ValKeyStatus       = "status"
-	ValKeyStatusCode   = "statusCode"
-	ValKeyURL          = "url"
-	ValKeyVaultURL     = "vaultURL"
-	ValKeySpec         = "spec"
+	ValKeyClaims               = "claims"
+	ValKeyDeadLetterAttempts   = "deadLetterAttempts"
+	ValKeyDeadLetterBackoff    = "deadLetterBackoff"
+	ValKeyDeadLetterMaxBackoff = "deadLetterMaxBackoff"
+	ValKeyDeadLetterMaxMsgs    = "deadLetterMaxMessages"
+	ValKeyDeadLetterMaxSize    = "deadLetterMaxSize"
+	ValKeyDeadLetterRetention  = "deadLetterRetention"
+	ValKeyHeader               = "header"
+	ValKeyHost                 = "host"
+	ValKeyIdempotencyKey       = "idempotencyKey"
+	ValKeyIdempotencyStorage   = "idempotencyStorage"
+	ValKeyIdempotencyWindow    = "idempotencyWindow"
+	ValKeyMaxEventSize         = "maxEventSize"
+	ValKeyContentEnd           = "contentEnd"
+	ValKeyContentRef           = "contentRef"
+	ValKeyOffloadMaxSize       = "offloadMaxSize"
+	ValKeyOffloadStorage       = "offloadStorage"
+	ValKeyOffloadThreshold     = "offloadThreshold"
+	ValKeyRateLimitLocal       = "rateLimitLocal"
+	ValKeyRateLimitStorage     = "rateLimitStorage"
+	ValKeyRecordEvents         = "recordEvents"
+	ValKeyRecordRedactHeaders  = "recordRedactHeaders"
+	ValKeyRespCacheMaxSize     = "responseCacheMaxEntrySize"
+	ValKeyRespCacheMaxMemory   = "responseCacheMaxMemorySize"
+	ValKeyRespCacheShared      = "responseCacheShared"
+	ValKeyRespCacheStorage     = "responseCacheStorage"
+	ValKeyMethod               = "method"
+	ValKeyPath                 = "path"
+	ValKeyPathSuffix           = "pathSuffix"
+	ValKeyQuery                = "queryParam"
+	ValKeyStatus               = "status"
+	ValKeyStatusCode           = "statusCode"
+	ValKeyURL                  = "url"
+	ValKeyVaultURL             = "vaultURL"
+	ValKeySpec                 = "spec"
 )
 
 // Headers and query params.
@@ -267,17 +354,28 @@ const (
 	HeaderAdapter              = "kubefox-adapter"
 	HeaderAppDeployment        = "kubefox-app-deployment"
 	HeaderAppDeploymentAbbrv   = "kf-dep"
+	HeaderAge                  = "Age"
+	HeaderAuthorization        = "Authorization"
+	HeaderCacheControl         = "Cache-Control"
 	HeaderContentLength        = "Content-Length"
 	HeaderContentType          = "Content-Type"
+	HeaderCookie               = "Cookie"
 	HeaderEventId              = "kubefox-event-id"
 	HeaderEventType            = "kubefox-event-type"
 	HeaderEventTypeAbbrv       = "kf-type"
+	HeaderExplain              = "kf-explain"
+	HeaderExplainToken         = "kf-explain-token"
 	HeaderHost                 = "Host"
+	HeaderIdempotencyKey       = "Idempotency-Key"
 	HeaderPlatform             = "kubefox-platform"
+	HeaderProxyAuthorization   = "Proxy-Authorization"
 	HeaderRelManifest          = "kubefox-release-manifest"
+	HeaderRetryAfter           = "Retry-After"
+	HeaderSetCookie            = "Set-Cookie"
 	HeaderTelemetrySample      = "kubefox-telemetry-sample"
 	HeaderTelemetrySampleAbbrv = "kf-sample"
 	HeaderTraceId              = "kubefox-trace-id"
+	HeaderVary                 = "Vary"
 	HeaderVirtualEnv           = "kubefox-virtual-environment"
 	HeaderVirtualEnvAbbrv      = "kf-ve"
 )
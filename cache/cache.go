@@ -11,6 +11,8 @@ package cache
 import (
 	"fmt"
 	"math"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 )
@@ -19,6 +21,17 @@ type Cache[T any] interface {
 	Get(key string) (T, bool)
 	Set(key string, value T)
 	Delete(key string)
+	// Clear deletes all items from the cache.
+	Clear()
+	// Entries describes the items in the cache, values are not included.
+	Entries() []Entry
+}
+
+// Entry describes an item in the cache.
+type Entry struct {
+	Key      string    `json:"key"`
+	Created  time.Time `json:"created"`
+	Accessed time.Time `json:"accessed"`
 }
 
 type cache[T any] struct {
@@ -82,6 +95,32 @@ func (c *cache[T]) Delete(key string) {
 	delete(c.m, key)
 }
 
+func (c *cache[T]) Clear() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.m = make(map[string]*item[T])
+}
+
+func (c *cache[T]) Entries() []Entry {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	entries := make([]Entry, 0, len(c.m))
+	for k, v := range c.m {
+		entries = append(entries, Entry{
+			Key:      k,
+			Created:  time.Unix(v.cTime, 0),
+			Accessed: time.Unix(v.aTime, 0),
+		})
+	}
+	slices.SortFunc(entries, func(a, b Entry) int {
+		return strings.Compare(a.Key, b.Key)
+	})
+
+	return entries
+}
+
 func (it *item[T]) String() string {
 	return fmt.Sprintf("key: %s, ctime: %d, atime: %d", it.Key, it.cTime, it.aTime)
 }
@@ -0,0 +1,120 @@
+// Copyright 2024 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/xigxog/kubefox/api"
+)
+
+const adminUsage = `Inspect and manage a running Broker.
+
+Usage:
+  broker admin subscriptions [flags]
+  broker admin groups [flags]
+  broker admin routes [flags]
+  broker admin reload-routes [flags]
+  broker admin caches [flags]
+  broker admin evict-cache [flags]
+  broker admin inflight [flags]
+  broker admin disconnect [flags]
+
+Use "broker admin <command> -h" for command flags.
+`
+
+// admin implements the admin subcommand, a client of the Broker admin API.
+func admin(args []string) {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, adminUsage)
+		os.Exit(1)
+	}
+
+	var addr, name, id string
+
+	cmd, args := args[0], args[1:]
+	flags := flag.NewFlagSet("admin "+cmd, flag.ExitOnError)
+	flags.StringVar(&addr, "admin-addr", "127.0.0.1:1112", "Address and port of the Broker admin server.")
+	token := tokenFlag(flags)
+
+	method, path := http.MethodGet, ""
+	switch cmd {
+	case "subscriptions", "groups", "routes", "caches", "inflight":
+		flags.Parse(args)
+		path = "/" + cmd
+
+	case "reload-routes":
+		flags.Parse(args)
+		method, path = http.MethodPost, "/routes/reload"
+
+	case "evict-cache":
+		flags.StringVar(&name, "name", "", `Name of cache to evict; one of ["deploymentMatcher", "secrets", "validation"], all caches are evicted if not set.`)
+		flags.Parse(args)
+		method, path = http.MethodDelete, "/caches"
+		if name != "" {
+			path += "/" + url.PathEscape(name)
+		}
+
+	case "disconnect":
+		flags.StringVar(&id, "id", "", "Id of the replica to disconnect. (required)")
+		flags.Parse(args)
+		if id == "" {
+			fmt.Fprint(os.Stderr, "The flag \"id\" is required.\n\n")
+			flags.Usage()
+			os.Exit(1)
+		}
+		method, path = http.MethodPost, fmt.Sprintf("/subscriptions/%s/disconnect", url.PathEscape(id))
+
+	default:
+		fmt.Fprint(os.Stderr, adminUsage)
+		os.Exit(1)
+	}
+
+	req, _ := http.NewRequest(method, fmt.Sprintf("http://%s%s", addr, path), nil)
+	callAdmin(req, *token)
+}
+
+// tokenFlag adds the token flag to flags. If the flag is not set the token is
+// read from the KUBEFOX_BROKER_ADMIN_TOKEN environment variable. The Service
+// Account token of the Pod is not used as the Broker's own Service Account is
+// not allowed to administer the Broker, a token of the
+// '<platform>-broker-admin' Service Account must be provided.
+func tokenFlag(flags *flag.FlagSet) *string {
+	return flags.String("token", os.Getenv(api.EnvBrokerAdminToken), `Kubernetes token used to authenticate with the Broker admin server, such as one created with "kubectl create token <platform>-broker-admin". Defaults to the value of `+api.EnvBrokerAdminToken+`. (required)`)
+}
+
+// callAdmin sends the request to the Broker admin server and copies the
+// response to stdout. If the request fails the response is written to stderr
+// and the process exits.
+func callAdmin(req *http.Request, token string) {
+	if token == "" {
+		fmt.Fprintf(os.Stderr, "The flag \"token\" or environment variable %s is required.\n", api.EnvBrokerAdminToken)
+		os.Exit(1)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error calling Broker admin server: %v\n", err)
+		os.Exit(1)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		io.Copy(os.Stderr, resp.Body)
+		fmt.Fprintln(os.Stderr)
+		os.Exit(1)
+	}
+	io.Copy(os.Stdout, resp.Body)
+}
@@ -18,16 +18,53 @@ var (
 	MaxEventSize      int64
 	NumWorkers        int
 	TelemetryInterval time.Duration
+	ShutdownTimeout   time.Duration
+
+	RecordEvents        bool
+	RecordRedactHeaders []string
+
+	HeartbeatInterval   time.Duration
+	OutlierThreshold    int
+	OutlierEjectionTime time.Duration
+
+	IdempotencyWindow  time.Duration
+	IdempotencyStorage string
+
+	RateLimitLocal   bool
+	RateLimitStorage string
+
+	DeadLetterRetention   time.Duration
+	DeadLetterMaxAttempts int
+	DeadLetterBackoff     time.Duration
+	DeadLetterMaxBackoff  time.Duration
+	DeadLetterMaxSize     int64
+	DeadLetterMaxMsgs     int64
+
+	OffloadThreshold int64
+	OffloadMaxSize   int64
+	OffloadStorage   string
+
+	RespCacheMaxSize   int64
+	RespCacheMaxMemory int64
+	RespCacheShared    bool
+	RespCacheStorage   string
 
 	LogFormat string
 	LogLevel  string
 
 	GRPCSrvAddr   string
 	HealthSrvAddr string
+	AdminSrvAddr  string
 
 	NATSAddr      string
 	VaultURL      string
 	TelemetryAddr string
 
 	TokenPath string
+
+	// Set to run the Broker without Kubernetes using resources from a local
+	// directory.
+	ResourceDir string
+	SecretsFile string
+	Insecure    bool
 )
@@ -0,0 +1,95 @@
+// Copyright 2024 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+)
+
+const deadLettersUsage = `Inspect, requeue and delete messages the Broker was unable to deliver.
+
+Usage:
+  broker deadletters query [flags]
+  broker deadletters requeue [flags]
+  broker deadletters delete [flags]
+
+Use "broker deadletters <command> -h" for command flags.
+`
+
+// deadLetters implements the deadletters subcommand, a client of the Broker
+// admin API.
+func deadLetters(args []string) {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, deadLettersUsage)
+		os.Exit(1)
+	}
+
+	var (
+		addr, traceId, evtId, comp, start, end string
+		limit                                  int
+	)
+
+	cmd, args := args[0], args[1:]
+	flags := flag.NewFlagSet("deadletters "+cmd, flag.ExitOnError)
+	flags.StringVar(&addr, "admin-addr", "127.0.0.1:1112", "Address and port of the Broker admin server.")
+	token := tokenFlag(flags)
+
+	queryFlags := func() url.Values {
+		flags.StringVar(&traceId, "trace-id", "", "Only include dead letters of events that are part of trace.")
+		flags.StringVar(&evtId, "event-id", "", "Only include the dead letter of event with id.")
+		flags.StringVar(&comp, "component", "", "Only include dead letters with a source or target matching Component name or group key.")
+		flags.StringVar(&start, "start", "", "Only include dead letters written at or after time, RFC3339 format.")
+		flags.StringVar(&end, "end", "", "Only include dead letters written at or before time, RFC3339 format.")
+		flags.IntVar(&limit, "limit", 0, "Maximum number of dead letters to include, 0 includes all matching dead letters. Requeue defaults to 100.")
+		flags.Parse(args)
+
+		q := url.Values{}
+		setParam(q, "traceId", traceId)
+		setParam(q, "eventId", evtId)
+		setParam(q, "component", comp)
+		setParam(q, "start", start)
+		setParam(q, "end", end)
+		if limit > 0 {
+			q.Set("limit", strconv.Itoa(limit))
+		}
+		return q
+	}
+
+	var req *http.Request
+	switch cmd {
+	case "query":
+		q := queryFlags()
+		req, _ = http.NewRequest(http.MethodGet, fmt.Sprintf("http://%s/deadletters?%s", addr, q.Encode()), nil)
+
+	case "requeue":
+		q := queryFlags()
+		req, _ = http.NewRequest(http.MethodPost, fmt.Sprintf("http://%s/deadletters/requeue?%s", addr, q.Encode()), nil)
+
+	case "delete":
+		flags.StringVar(&evtId, "event-id", "", "Id of the event to delete the dead letter of. (required)")
+		flags.Parse(args)
+		if evtId == "" {
+			fmt.Fprint(os.Stderr, "The flag \"event-id\" is required.\n\n")
+			flags.Usage()
+			os.Exit(1)
+		}
+		req, _ = http.NewRequest(http.MethodDelete, fmt.Sprintf("http://%s/deadletters/%s", addr, url.PathEscape(evtId)), nil)
+
+	default:
+		fmt.Fprint(os.Stderr, deadLettersUsage)
+		os.Exit(1)
+	}
+
+	callAdmin(req, *token)
+}
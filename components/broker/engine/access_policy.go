@@ -0,0 +1,137 @@
+// Copyright 2024 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package engine
+
+import (
+	"fmt"
+	"path"
+	"slices"
+
+	"github.com/xigxog/kubefox/api"
+	"github.com/xigxog/kubefox/core"
+)
+
+// checkAccess evaluates the AccessPolicy of the event's VirtualEnvironment.
+// Responses are not checked as the request was authorized, events received
+// from NATS were checked by the sending Broker.
+func (brk *broker) checkAccess(ctx *BrokerEventContext) error {
+	if ctx.Event.Category == core.Category_RESPONSE || ctx.Receiver == ReceiverNATS ||
+		ctx.VirtualEnv == nil {
+		return nil
+	}
+
+	env, err := brk.store.Environment(ctx, ctx.VirtualEnv.Spec.Environment)
+	if err != nil {
+		return err
+	}
+	policy := ctx.VirtualEnv.GetAccessPolicy(env)
+	if policy == nil {
+		return nil
+	}
+
+	return authorizeAccess(ctx, policy)
+}
+
+// authorizeAccess returns ErrUnauthorized if the policy denies the event. In
+// audit mode denied events are logged and allowed.
+func authorizeAccess(ctx *BrokerEventContext, policy *api.AccessPolicy) error {
+	source := accessName(ctx.Event.Source)
+	target := accessTarget(ctx)
+
+	action, rule := evalAccessPolicy(policy, source, target, int(ctx.RouteId), ctx.Event.Type)
+	if action != api.AccessActionDeny {
+		return nil
+	}
+
+	reason := "default action"
+	if rule >= 0 {
+		reason = fmt.Sprintf("rule %d", rule)
+	}
+	err := fmt.Errorf("event of type '%s' from '%s' to '%s' route %d denied by %s",
+		ctx.Event.Type, source, target, ctx.RouteId, reason)
+
+	if policy.Mode == api.AccessPolicyModeAudit {
+		ctx.Log.Warnf("audit: %v", err)
+		return nil
+	}
+
+	return core.ErrUnauthorized(err)
+}
+
+// accessTarget returns the name rules refer to the target of the event by.
+func accessTarget(ctx *BrokerEventContext) string {
+	switch {
+	case ctx.TargetAdapter != nil:
+		// The target of events to adapters is the adapter Component, not the
+		// named adapter.
+		return ctx.TargetAdapter.GetName()
+
+	case ctx.Event.Target != nil && ctx.Event.Target.App == "" &&
+		ctx.Event.Target.Type == string(api.ComponentTypeKubeFox) && ctx.AppDeployment != nil:
+		// Components of the same App might be targeted without their App.
+		return ctx.AppDeployment.Spec.AppName + "/" + ctx.Event.Target.Name
+
+	default:
+		return accessName(ctx.Event.Target)
+	}
+}
+
+// evalAccessPolicy returns the action of the first rule matching the event and
+// the index of the rule. If no rule matches the default action and -1 are
+// returned.
+func evalAccessPolicy(policy *api.AccessPolicy, source, target string, routeId int, evtType string) (api.AccessAction, int) {
+	for i, r := range policy.Rules {
+		if matchAccess(r.Sources, source) &&
+			matchAccess(r.Targets, target) &&
+			(len(r.Routes) == 0 || slices.Contains(r.Routes, routeId)) &&
+			matchAccess(r.EventTypes, evtType) {
+
+			return r.Action, i
+		}
+	}
+
+	if policy.DefaultAction == "" {
+		return api.AccessActionAllow, -1
+	}
+
+	return policy.DefaultAction, -1
+}
+
+// matchAccess returns true if patterns is empty or any of the glob patterns
+// match the value. The pattern '*' matches all values, including those
+// containing a '/'.
+func matchAccess(patterns []string, value string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, p := range patterns {
+		if p == "*" {
+			return true
+		}
+		if ok, _ := path.Match(p, value); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// accessName returns the name a Component is referred to by rules of an
+// AccessPolicy, '<app>/<component>' for KubeFox Components and the name for
+// Adapters.
+func accessName(comp *core.Component) string {
+	switch {
+	case comp == nil:
+		return ""
+	case comp.App != "":
+		return comp.App + "/" + comp.Name
+	default:
+		return comp.Name
+	}
+}
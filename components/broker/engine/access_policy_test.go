@@ -0,0 +1,180 @@
+// Copyright 2024 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package engine
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/xigxog/kubefox/api"
+	"github.com/xigxog/kubefox/api/kubernetes/v1alpha1"
+	"github.com/xigxog/kubefox/core"
+	"github.com/xigxog/kubefox/logkf"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestEvalAccessPolicy(t *testing.T) {
+	allow, deny := api.AccessActionAllow, api.AccessActionDeny
+
+	tests := []struct {
+		name    string
+		policy  api.AccessPolicy
+		source  string
+		target  string
+		routeId int
+		evtType string
+		action  api.AccessAction
+		rule    int
+	}{
+		{
+			name:   "empty default action allows",
+			policy: api.AccessPolicy{},
+			source: "shop/web", target: "payments/billing",
+			action: allow, rule: -1,
+		},
+		{
+			name:   "default action deny",
+			policy: api.AccessPolicy{DefaultAction: deny},
+			source: "shop/web", target: "payments/billing",
+			action: deny, rule: -1,
+		},
+		{
+			name: "first matching rule wins",
+			policy: api.AccessPolicy{Rules: []api.AccessRule{
+				{Action: deny, Targets: []string{"payments/*"}},
+				{Action: allow, Sources: []string{"shop/web"}},
+			}},
+			source: "shop/web", target: "payments/billing",
+			action: deny, rule: 0,
+		},
+		{
+			name: "later rule matches when earlier does not",
+			policy: api.AccessPolicy{DefaultAction: deny, Rules: []api.AccessRule{
+				{Action: deny, Targets: []string{"orders/*"}},
+				{Action: allow, Sources: []string{"shop/web"}},
+			}},
+			source: "shop/web", target: "payments/billing",
+			action: allow, rule: 1,
+		},
+		{
+			name: "star matches values containing slash",
+			policy: api.AccessPolicy{Rules: []api.AccessRule{
+				{Action: deny, Sources: []string{"*"}},
+			}},
+			source: "shop/web", target: "payments/billing",
+			action: deny, rule: 0,
+		},
+		{
+			name: "glob does not cross slash",
+			policy: api.AccessPolicy{Rules: []api.AccessRule{
+				{Action: deny, Targets: []string{"pay*"}},
+			}},
+			source: "shop/web", target: "payments/billing",
+			action: allow, rule: -1,
+		},
+		{
+			name: "glob matches component of any app",
+			policy: api.AccessPolicy{Rules: []api.AccessRule{
+				{Action: deny, Targets: []string{"*/billing"}},
+			}},
+			source: "shop/web", target: "payments/billing",
+			action: deny, rule: 0,
+		},
+		{
+			name: "adapter matched by name",
+			policy: api.AccessPolicy{Rules: []api.AccessRule{
+				{Action: deny, Targets: []string{"stripe"}},
+			}},
+			source: "shop/web", target: "stripe",
+			action: deny, rule: 0,
+		},
+		{
+			name: "route and event type must match",
+			policy: api.AccessPolicy{Rules: []api.AccessRule{
+				{Action: deny, Routes: []int{1}, EventTypes: []string{"io.kubefox.http"}},
+			}},
+			source: "shop/web", target: "payments/billing", routeId: 2, evtType: "io.kubefox.http",
+			action: allow, rule: -1,
+		},
+		{
+			name: "route and event type match",
+			policy: api.AccessPolicy{Rules: []api.AccessRule{
+				{Action: deny, Routes: []int{1}, EventTypes: []string{"io.kubefox.*"}},
+			}},
+			source: "shop/web", target: "payments/billing", routeId: 1, evtType: "io.kubefox.http",
+			action: deny, rule: 0,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			action, rule := evalAccessPolicy(&test.policy, test.source, test.target, test.routeId, test.evtType)
+			if action != test.action || rule != test.rule {
+				t.Errorf("expected %s by rule %d, got %s by rule %d", test.action, test.rule, action, rule)
+			}
+		})
+	}
+}
+
+func TestAuthorizeAccess(t *testing.T) {
+	appDep := &v1alpha1.AppDeployment{Spec: v1alpha1.AppDeploymentSpec{AppName: "payments"}}
+	newCtx := func(target *core.Component) *BrokerEventContext {
+		return &BrokerEventContext{
+			AppDeployment: appDep,
+			Event: core.NewReq(core.EventOpts{
+				Type:   api.EventTypeHTTP,
+				Source: core.NewComponent(api.ComponentTypeKubeFox, "payments", "web", "abc"),
+				Target: target,
+			}),
+			Log: logkf.Global,
+		}
+	}
+	denyBilling := &api.AccessPolicy{Rules: []api.AccessRule{
+		{Action: api.AccessActionDeny, Targets: []string{"payments/billing"}},
+	}}
+
+	isUnauthorized := func(err error) bool {
+		kfErr := &core.Err{}
+		return errors.As(err, &kfErr) && kfErr.Code() == core.CodeUnauthorized
+	}
+
+	ctx := newCtx(core.NewComponent(api.ComponentTypeKubeFox, "payments", "billing", "abc"))
+	if err := authorizeAccess(ctx, denyBilling); !isUnauthorized(err) {
+		t.Errorf("expected unauthorized error, got %v", err)
+	}
+
+	// Targets in the same App might not set the App.
+	ctx = newCtx(core.NewTargetComponent(api.ComponentTypeKubeFox, "billing"))
+	if err := authorizeAccess(ctx, denyBilling); !isUnauthorized(err) {
+		t.Errorf("expected unauthorized error for target without App, got %v", err)
+	}
+
+	ctx = newCtx(core.NewComponent(api.ComponentTypeKubeFox, "payments", "ledger", "abc"))
+	if err := authorizeAccess(ctx, denyBilling); err != nil {
+		t.Errorf("expected other target to be allowed, got %v", err)
+	}
+
+	// Adapters are referred to by the name of the adapter, not the adapter
+	// Component.
+	denyAdapter := &api.AccessPolicy{Rules: []api.AccessRule{
+		{Action: api.AccessActionDeny, Targets: []string{"stripe"}},
+	}}
+	ctx = newCtx(core.NewTargetComponent(api.ComponentTypeHTTPAdapter, "httpsrv"))
+	ctx.TargetAdapter = &v1alpha1.HTTPAdapter{ObjectMeta: metav1.ObjectMeta{Name: "stripe"}}
+	if err := authorizeAccess(ctx, denyAdapter); !isUnauthorized(err) {
+		t.Errorf("expected unauthorized error for adapter, got %v", err)
+	}
+
+	audit := denyBilling.DeepCopy()
+	audit.Mode = api.AccessPolicyModeAudit
+	ctx = newCtx(core.NewComponent(api.ComponentTypeKubeFox, "payments", "billing", "abc"))
+	if err := authorizeAccess(ctx, audit); err != nil {
+		t.Errorf("expected audit mode to allow denied event, got %v", err)
+	}
+}
@@ -0,0 +1,385 @@
+// Copyright 2024 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xigxog/kubefox/api"
+	"github.com/xigxog/kubefox/components/broker/config"
+	"github.com/xigxog/kubefox/core"
+	"github.com/xigxog/kubefox/logkf"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+const (
+	contentTypeJSON      = "application/json"
+	contentTypeJSONLines = "application/jsonl"
+)
+
+// AdminServer exposes Broker administration endpoints over HTTP. It is
+// intended to be reached through a port-forward and binds to localhost by
+// default. Requests must provide the Kubernetes token of a user allowed the
+// 'admin' verb on the Platform.
+type AdminServer struct {
+	httpSrv *http.Server
+	brk     Broker
+
+	log *logkf.Logger
+}
+
+func NewAdminServer(brk Broker) *AdminServer {
+	return &AdminServer{
+		brk: brk,
+		log: logkf.Global,
+	}
+}
+
+func (srv *AdminServer) Start() error {
+	srv.log.Debug("admin server starting")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /events", srv.queryEvents)
+	mux.HandleFunc("POST /events/{id}/replay", srv.replayEvent)
+	mux.HandleFunc("GET /deadletters", srv.queryDeadLetters)
+	mux.HandleFunc("POST /deadletters/requeue", srv.requeueDeadLetters)
+	mux.HandleFunc("POST /deadletters/{id}/requeue", srv.requeueDeadLetters)
+	mux.HandleFunc("DELETE /deadletters/{id}", srv.deleteDeadLetter)
+	mux.HandleFunc("POST /explain", srv.explainEvent)
+	mux.HandleFunc("GET /subscriptions", srv.getSubscriptions)
+	mux.HandleFunc("POST /subscriptions/{id}/disconnect", srv.disconnectReplica)
+	mux.HandleFunc("GET /groups", srv.getGroups)
+	mux.HandleFunc("GET /routes", srv.getRoutes)
+	mux.HandleFunc("POST /routes/reload", srv.reloadRoutes)
+	mux.HandleFunc("GET /caches", srv.getCaches)
+	mux.HandleFunc("DELETE /caches", srv.evictCache)
+	mux.HandleFunc("DELETE /caches/{name}", srv.evictCache)
+	mux.HandleFunc("GET /inflight", srv.getInFlight)
+
+	srv.httpSrv = &http.Server{
+		ReadTimeout: time.Second * 30,
+		IdleTimeout: time.Second * 30,
+		Handler:     srv.authorize(mux),
+	}
+
+	ln, err := net.Listen("tcp", config.AdminSrvAddr)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		err := srv.httpSrv.Serve(ln)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			srv.log.Error(err)
+		}
+	}()
+
+	srv.log.Info("admin server started")
+	return nil
+}
+
+func (srv *AdminServer) Shutdown(timeout time.Duration) {
+	srv.log.Info("admin server shutting down")
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if srv.httpSrv != nil {
+		if err := srv.httpSrv.Shutdown(ctx); err != nil {
+			srv.log.Error(err)
+		}
+	}
+}
+
+// authorize requires requests to provide a Kubernetes token, as a bearer
+// token, of a user allowed to administer the Broker. Standalone Brokers do not
+// require a token.
+func (srv *AdminServer) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		token, found := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if (!found || token == "") && !standalone() {
+			srv.writeError(resp, core.ErrUnauthorized(fmt.Errorf("bearer token is missing")))
+			return
+		}
+		if err := srv.brk.AuthorizeAdmin(req.Context(), token); err != nil {
+			srv.writeError(resp, core.ErrUnauthorized(err))
+			return
+		}
+
+		next.ServeHTTP(resp, req)
+	})
+}
+
+func (srv *AdminServer) queryEvents(resp http.ResponseWriter, req *http.Request) {
+	q, err := parseEventQuery(req)
+	if err != nil {
+		srv.writeError(resp, err)
+		return
+	}
+
+	resp.Header().Set("Content-Type", contentTypeJSONLines)
+
+	written := false
+	err = srv.brk.QueryEvents(req.Context(), q, func(evt *core.Event) error {
+		b, err := protojson.Marshal(evt)
+		if err != nil {
+			return err
+		}
+		written = true
+		_, err = resp.Write(append(b, '\n'))
+		return err
+	})
+	if err != nil {
+		// Once events are written the status cannot be changed.
+		if written {
+			srv.log.Warnf("error querying events: %v", err)
+			return
+		}
+		srv.writeError(resp, err)
+	}
+}
+
+func (srv *AdminServer) queryDeadLetters(resp http.ResponseWriter, req *http.Request) {
+	q, err := parseEventQuery(req)
+	if err != nil {
+		srv.writeError(resp, err)
+		return
+	}
+
+	resp.Header().Set("Content-Type", contentTypeJSONLines)
+
+	written := false
+	err = srv.brk.QueryDeadLetters(req.Context(), q, func(dl *DeadLetter) error {
+		b, err := json.Marshal(dl)
+		if err != nil {
+			return err
+		}
+		written = true
+		_, err = resp.Write(append(b, '\n'))
+		return err
+	})
+	if err != nil {
+		// Once dead letters are written the status cannot be changed.
+		if written {
+			srv.log.Warnf("error querying dead letters: %v", err)
+			return
+		}
+		srv.writeError(resp, err)
+	}
+}
+
+// requeueDeadLetters redelivers the dead letter of the event in the path, or
+// all dead letters matching the query if an event is not provided.
+func (srv *AdminServer) requeueDeadLetters(resp http.ResponseWriter, req *http.Request) {
+	q, err := parseEventQuery(req)
+	if err != nil {
+		srv.writeError(resp, err)
+		return
+	}
+	if id := req.PathValue("id"); id != "" {
+		q.EventId = id
+	}
+
+	res, err := srv.brk.RequeueDeadLetters(req.Context(), q)
+	if err != nil {
+		srv.writeError(resp, err)
+		return
+	}
+	srv.writeJSON(resp, res)
+}
+
+func (srv *AdminServer) deleteDeadLetter(resp http.ResponseWriter, req *http.Request) {
+	if err := srv.brk.DeleteDeadLetter(req.Context(), req.PathValue("id")); err != nil {
+		srv.writeError(resp, err)
+		return
+	}
+	resp.WriteHeader(http.StatusNoContent)
+}
+
+func (srv *AdminServer) replayEvent(resp http.ResponseWriter, req *http.Request) {
+	params := req.URL.Query()
+	opts := &ReplayOpts{
+		EventId:    req.PathValue("id"),
+		VirtualEnv: params.Get("virtualEnv"),
+		NewId:      params.Get("newId") == "true",
+		Testing:    params.Get("testing") == "true",
+	}
+	if opts.VirtualEnv == "" {
+		srv.writeError(resp, core.ErrInvalid(fmt.Errorf("virtualEnv is required")))
+		return
+	}
+	if s := params.Get("timeout"); s != "" {
+		var err error
+		if opts.Timeout, err = time.ParseDuration(s); err != nil {
+			srv.writeError(resp, core.ErrInvalid(fmt.Errorf("timeout is invalid: %w", err)))
+			return
+		}
+	}
+
+	evt, err := srv.brk.ReplayEvent(req.Context(), opts)
+	if err != nil {
+		srv.writeError(resp, err)
+		return
+	}
+
+	b, err := protojson.Marshal(evt)
+	if err != nil {
+		srv.writeError(resp, err)
+		return
+	}
+	resp.Header().Set("Content-Type", contentTypeJSON)
+	resp.Write(b)
+}
+
+// explainEvent matches the Event in the request body, in protobuf JSON format,
+// and returns the match trace.
+func (srv *AdminServer) explainEvent(resp http.ResponseWriter, req *http.Request) {
+	b, err := io.ReadAll(io.LimitReader(req.Body, api.DefaultMaxEventSizeBytes))
+	if err != nil {
+		srv.writeError(resp, core.ErrInvalid(err))
+		return
+	}
+	evt := core.NewEvent()
+	if err := protojson.Unmarshal(b, evt); err != nil {
+		srv.writeError(resp, core.ErrInvalid(fmt.Errorf("event is invalid: %w", err)))
+		return
+	}
+
+	exp, err := srv.brk.ExplainEvent(req.Context(), evt)
+	if err != nil {
+		srv.writeError(resp, err)
+		return
+	}
+
+	srv.writeJSON(resp, exp)
+}
+
+func (srv *AdminServer) getSubscriptions(resp http.ResponseWriter, req *http.Request) {
+	srv.writeJSON(resp, srv.brk.Subscriptions())
+}
+
+func (srv *AdminServer) disconnectReplica(resp http.ResponseWriter, req *http.Request) {
+	if err := srv.brk.DisconnectReplica(req.PathValue("id")); err != nil {
+		srv.writeError(resp, err)
+		return
+	}
+	resp.WriteHeader(http.StatusNoContent)
+}
+
+func (srv *AdminServer) getGroups(resp http.ResponseWriter, req *http.Request) {
+	srv.writeJSON(resp, srv.brk.SubscriptionGroups())
+}
+
+func (srv *AdminServer) getRoutes(resp http.ResponseWriter, req *http.Request) {
+	routes, err := srv.brk.ReleaseRoutes(req.Context())
+	if err != nil {
+		srv.writeError(resp, err)
+		return
+	}
+	srv.writeJSON(resp, routes)
+}
+
+func (srv *AdminServer) reloadRoutes(resp http.ResponseWriter, req *http.Request) {
+	if err := srv.brk.ReloadReleaseMatcher(req.Context()); err != nil {
+		srv.writeError(resp, err)
+		return
+	}
+	srv.getRoutes(resp, req)
+}
+
+func (srv *AdminServer) getCaches(resp http.ResponseWriter, req *http.Request) {
+	srv.writeJSON(resp, srv.brk.Caches())
+}
+
+// evictCache clears the cache named in the path, or all caches if a name is
+// not provided.
+func (srv *AdminServer) evictCache(resp http.ResponseWriter, req *http.Request) {
+	if err := srv.brk.EvictCache(req.PathValue("name")); err != nil {
+		srv.writeError(resp, err)
+		return
+	}
+	resp.WriteHeader(http.StatusNoContent)
+}
+
+func (srv *AdminServer) getInFlight(resp http.ResponseWriter, req *http.Request) {
+	srv.writeJSON(resp, srv.brk.InFlight())
+}
+
+func (srv *AdminServer) writeJSON(resp http.ResponseWriter, v any) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		srv.writeError(resp, err)
+		return
+	}
+	resp.Header().Set("Content-Type", contentTypeJSON)
+	resp.Write(b)
+}
+
+func (srv *AdminServer) writeError(resp http.ResponseWriter, err error) {
+	kfErr := &core.Err{}
+	if ok := errors.As(err, &kfErr); !ok {
+		kfErr = core.ErrUnexpected(err)
+	}
+	if kfErr.Code() == core.CodeUnexpected {
+		srv.log.Error(err)
+	}
+
+	b, _ := json.Marshal(kfErr)
+	resp.Header().Set("Content-Type", contentTypeJSON)
+	resp.WriteHeader(kfErr.HTTPCode())
+	resp.Write(b)
+}
+
+// parseEventQuery reads an EventQuery from the query parameters of the
+// request.
+func parseEventQuery(req *http.Request) (*EventQuery, error) {
+	params := req.URL.Query()
+	q := &EventQuery{
+		TraceId:   params.Get("traceId"),
+		EventId:   params.Get("eventId"),
+		Component: params.Get("component"),
+	}
+
+	var err error
+	if q.Start, err = parseTime(params.Get("start")); err != nil {
+		return nil, core.ErrInvalid(err)
+	}
+	if q.End, err = parseTime(params.Get("end")); err != nil {
+		return nil, core.ErrInvalid(err)
+	}
+	if s := params.Get("limit"); s != "" {
+		if q.Limit, err = strconv.Atoi(s); err != nil {
+			return nil, core.ErrInvalid(fmt.Errorf("limit is invalid: %w", err))
+		}
+	}
+
+	return q, nil
+}
+
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("time '%s' is invalid, must be RFC3339: %w", s, err)
+	}
+
+	return t, nil
+}
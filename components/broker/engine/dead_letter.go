@@ -0,0 +1,436 @@
+// Copyright 2024 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/xigxog/kubefox/api"
+	"github.com/xigxog/kubefox/components/broker/config"
+	"github.com/xigxog/kubefox/core"
+	"github.com/xigxog/kubefox/logkf"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	deadLetterStream  = "DEAD_LETTERS"
+	deadLetterSubject = "dlq"
+	retryStream       = "DEAD_LETTER_RETRIES"
+	retrySubject      = "dlq-retry"
+	retryConsumer     = "broker-retry"
+	// Long enough for a redelivered message to time out.
+	retryAckWait = 2 * api.DefaultTimeoutSeconds * time.Second
+	// Number of dead letters requeued by a request without a limit.
+	requeueDefaultLimit = 100
+	// Maximum time a request spends requeuing dead letters.
+	requeueTimeout = 2 * time.Minute
+)
+
+// Headers of dead letter messages.
+const (
+	headerDLReason       = "kf_reason"
+	headerDLCode         = "kf_code"
+	headerDLAttempts     = "kf_attempts"
+	headerDLFirstFailure = "kf_first_failure"
+	headerDLLastFailure  = "kf_last_failure"
+	headerDLRetryAt      = "kf_retry_at"
+)
+
+// DeadLetter is a message that could not be delivered and the reason of the
+// last failed attempt.
+type DeadLetter struct {
+	Event        *core.Event `json:"-"`
+	Reason       string      `json:"reason"`
+	Code         string      `json:"code"`
+	Attempts     int         `json:"attempts"`
+	FirstFailure time.Time   `json:"firstFailure"`
+	LastFailure  time.Time   `json:"lastFailure"`
+	// Set while the message is waiting to be redelivered.
+	RetryAt time.Time `json:"-"`
+
+	seq uint64
+}
+
+// RequeueResult reports the dead letters requeued by the admin API.
+type RequeueResult struct {
+	Requeued int `json:"requeued"`
+	Failed   int `json:"failed"`
+	// Number of matching dead letters not requeued because the time limit was
+	// reached.
+	Skipped int      `json:"skipped,omitempty"`
+	Errors  []string `json:"errors,omitempty"`
+}
+
+// deadLetterMgr handles messages that could not be delivered. Messages that
+// failed with a transient error are redelivered with exponential backoff
+// until attempts are exhausted. Messages that failed with an error that is not
+// transient, or exhausted their attempts, are written to the dead-letter
+// stream where they are kept until requeued, deleted or they expire.
+type deadLetterMgr struct {
+	js      jetstream.JetStream
+	nc      *NATSClient
+	stream  jetstream.Stream
+	consCtx jetstream.ConsumeContext
+
+	recvEvent func(*core.Event, Receiver) *BrokerEventContext
+
+	log *logkf.Logger
+}
+
+func newDeadLetterMgr(nc *NATSClient, recvEvent func(*core.Event, Receiver) *BrokerEventContext) *deadLetterMgr {
+	return &deadLetterMgr{
+		js:        nc.js,
+		nc:        nc,
+		recvEvent: recvEvent,
+		log:       logkf.Global,
+	}
+}
+
+// Start creates the dead-letter and retry streams and starts consuming
+// messages waiting to be redelivered. The retry consumer is shared by all
+// Brokers so each message is redelivered once.
+func (mgr *deadLetterMgr) Start(ctx context.Context) error {
+	var err error
+	mgr.stream, err = mgr.js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:        deadLetterStream,
+		Description: "Messages KubeFox Brokers were unable to deliver.",
+		Subjects:    []string{deadLetterSubject + ".>"},
+		Retention:   jetstream.LimitsPolicy,
+		Discard:     jetstream.DiscardOld,
+		MaxAge:      config.DeadLetterRetention,
+		MaxBytes:    config.DeadLetterMaxSize,
+		MaxMsgs:     config.DeadLetterMaxMsgs,
+		Storage:     jetstream.FileStorage,
+	})
+	if err != nil {
+		return err
+	}
+
+	retry, err := mgr.js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:        retryStream,
+		Description: "Messages waiting to be redelivered by KubeFox Brokers.",
+		Subjects:    []string{retrySubject + ".>"},
+		Retention:   jetstream.WorkQueuePolicy,
+		Discard:     jetstream.DiscardOld,
+		MaxAge:      config.DeadLetterRetention,
+		MaxBytes:    config.DeadLetterMaxSize,
+		MaxMsgs:     config.DeadLetterMaxMsgs,
+		Storage:     jetstream.FileStorage,
+	})
+	if err != nil {
+		return err
+	}
+	cons, err := retry.CreateOrUpdateConsumer(ctx, jetstream.ConsumerConfig{
+		Durable:    retryConsumer,
+		AckPolicy:  jetstream.AckExplicitPolicy,
+		AckWait:    retryAckWait,
+		MaxDeliver: -1,
+	})
+	if err != nil {
+		return err
+	}
+	if mgr.consCtx, err = cons.Consume(mgr.handleRetry); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (mgr *deadLetterMgr) Stop() {
+	if mgr.consCtx != nil {
+		mgr.consCtx.Stop()
+	}
+}
+
+// DeadLetter schedules redelivery of a message that could not be delivered or
+// writes it to the dead-letter stream.
+func (mgr *deadLetterMgr) DeadLetter(ctx *BrokerEventContext, err *core.Err) {
+	dl := &DeadLetter{
+		Event:        ctx.Event,
+		FirstFailure: time.Now(),
+	}
+	if err := mgr.fail(dl, err); err != nil {
+		ctx.Log.Errorf("unable to dead-letter message, message lost: %v", err)
+	}
+}
+
+// Query reads dead letters matching the query. Matching dead letters are
+// passed to fn in the order they were written.
+func (mgr *deadLetterMgr) Query(ctx context.Context, q *EventQuery, fn func(*DeadLetter) error) error {
+	return mgr.nc.queryStream(ctx, deadLetterStream, q.subject(deadLetterSubject), q,
+		func(msg jetstream.Msg, evt *core.Event) error {
+			dl, err := parseDeadLetter(evt, msg.Headers())
+			if err != nil {
+				mgr.log.With(logkf.KeyEventId, evt.Id).Warnf("dead letter is invalid: %v", err)
+				return nil
+			}
+			if md, err := msg.Metadata(); err == nil { // success
+				dl.seq = md.Sequence.Stream
+			}
+
+			return fn(dl)
+		})
+}
+
+// Requeue redelivers the dead letters matching the query, at most
+// requeueDefaultLimit if the query does not set a limit. Dead letters that are
+// delivered are deleted, those that fail again are kept. Dead letters not
+// redelivered within requeueTimeout are skipped.
+func (mgr *deadLetterMgr) Requeue(ctx context.Context, q *EventQuery) (*RequeueResult, error) {
+	if q.Limit <= 0 {
+		q.Limit = requeueDefaultLimit
+	}
+	deadline := time.Now().Add(requeueTimeout)
+
+	// Collect dead letters first, redelivering while reading the stream could
+	// read dead letters written by the redelivery.
+	var list []*DeadLetter
+	err := mgr.Query(ctx, q, func(dl *DeadLetter) error {
+		list = append(list, dl)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if q.EventId != "" && len(list) == 0 {
+		return nil, core.ErrNotFound(fmt.Errorf("dead letter '%s' not found", q.EventId))
+	}
+
+	res := &RequeueResult{}
+	for i, dl := range list {
+		ttl := min(time.Until(deadline), api.DefaultTimeoutSeconds*time.Second)
+		if ttl <= 0 || ctx.Err() != nil {
+			res.Skipped = len(list) - i
+			break
+		}
+
+		log := mgr.log.WithEvent(dl.Event)
+		log.Infof("requeuing dead letter after %d attempts", dl.Attempts)
+
+		if err := mgr.redeliver(dl.Event, ttl); err != nil {
+			log.Warnf("requeued dead letter failed: %v", err)
+			res.Failed++
+			res.Errors = append(res.Errors, fmt.Sprintf("%s: %v", dl.Event.Id, err))
+			continue
+		}
+		if err := mgr.stream.DeleteMsg(ctx, dl.seq); err != nil {
+			log.Warnf("unable to delete requeued dead letter: %v", err)
+		}
+		res.Requeued++
+	}
+
+	return res, nil
+}
+
+// Delete deletes the dead letter of the event.
+func (mgr *deadLetterMgr) Delete(ctx context.Context, evtId string) error {
+	var found *DeadLetter
+	err := mgr.Query(ctx, &EventQuery{EventId: evtId, Limit: 1}, func(dl *DeadLetter) error {
+		found = dl
+		return errQueryDone
+	})
+	if err != nil && !errors.Is(err, errQueryDone) {
+		return err
+	}
+	if found == nil {
+		return core.ErrNotFound(fmt.Errorf("dead letter '%s' not found", evtId))
+	}
+
+	return mgr.stream.DeleteMsg(ctx, found.seq)
+}
+
+func (mgr *deadLetterMgr) handleRetry(msg jetstream.Msg) {
+	evt := core.NewEvent()
+	if err := proto.Unmarshal(msg.Data(), evt); err != nil {
+		mgr.log.With(logkf.KeyEventId, msg.Headers().Get(CloudEventId)).
+			Warnf("message waiting to be redelivered contains invalid event data: %v", err)
+		msg.Term()
+		return
+	}
+	dl, err := parseDeadLetter(evt, msg.Headers())
+	if err != nil {
+		mgr.log.With(logkf.KeyEventId, evt.Id).Warnf("message waiting to be redelivered is invalid: %v", err)
+		msg.Term()
+		return
+	}
+	if wait := time.Until(dl.RetryAt); wait > 0 {
+		msg.NakWithDelay(wait)
+		return
+	}
+
+	go func() {
+		log := mgr.log.WithEvent(evt)
+		log.Debugf("redelivering message, attempt %d", dl.Attempts+1)
+
+		if err := mgr.redeliver(evt, api.DefaultTimeoutSeconds*time.Second); err != nil {
+			if err := mgr.fail(dl, err); err != nil {
+				log.Warnf("unable to reschedule message: %v", err)
+				msg.NakWithDelay(config.DeadLetterBackoff)
+				return
+			}
+		}
+		msg.Ack()
+	}()
+}
+
+// redeliver routes the event again with the TTL and waits for routing to
+// complete. The replica and Broker of the target are cleared so the event is
+// sent to any replica of the target Component.
+func (mgr *deadLetterMgr) redeliver(evt *core.Event, ttl time.Duration) *core.Err {
+	evt.CreateTime = time.Now().UnixNano()
+	evt.SetTTL(ttl)
+	if evt.Target != nil {
+		evt.Target.Id = ""
+		evt.Target.BrokerId = ""
+	}
+
+	ctx := mgr.recvEvent(evt, ReceiverDeadLetterMgr)
+	<-ctx.Done()
+
+	return ctx.CoreErr()
+}
+
+// fail records the failed attempt to deliver the message. If the error is
+// transient and attempts remain the message is scheduled for redelivery,
+// otherwise it is written to the dead-letter stream.
+func (mgr *deadLetterMgr) fail(dl *DeadLetter, err *core.Err) error {
+	dl.Attempts++
+	dl.LastFailure = time.Now()
+	dl.Reason = err.Error()
+	dl.Code = err.Code().String()
+	dl.RetryAt = time.Time{}
+
+	subject := fmt.Sprintf("%s.%s.%s", deadLetterSubject, traceIdOf(dl.Event), dl.Event.Id)
+	if err.Retryable() && dl.Attempts < config.DeadLetterMaxAttempts {
+		dl.RetryAt = dl.LastFailure.Add(backoff(dl.Attempts))
+		subject = fmt.Sprintf("%s.%s", retrySubject, dl.Event.Id)
+	}
+
+	msg, e := mgr.nc.Msg(subject, dl.Event)
+	if e != nil {
+		return e
+	}
+	msg.Header.Set(headerDLReason, dl.Reason)
+	msg.Header.Set(headerDLCode, dl.Code)
+	msg.Header.Set(headerDLAttempts, strconv.Itoa(dl.Attempts))
+	msg.Header.Set(headerDLFirstFailure, dl.FirstFailure.Format(time.RFC3339Nano))
+	msg.Header.Set(headerDLLastFailure, dl.LastFailure.Format(time.RFC3339Nano))
+	if !dl.RetryAt.IsZero() {
+		msg.Header.Set(headerDLRetryAt, dl.RetryAt.Format(time.RFC3339Nano))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	if _, e := mgr.js.PublishMsg(ctx, msg); e != nil {
+		return e
+	}
+
+	log := mgr.log.WithEvent(dl.Event)
+	if dl.RetryAt.IsZero() {
+		log.Warnf("message dead-lettered after %d attempts: %v", dl.Attempts, err)
+	} else {
+		log.Debugf("message redelivery scheduled for %s: %v", dl.RetryAt.Format(time.RFC3339), err)
+	}
+
+	return nil
+}
+
+// backoff returns the delay before the redelivery following attempt. The
+// delay is doubled for each attempt up to the maximum.
+func backoff(attempt int) time.Duration {
+	d := config.DeadLetterBackoff
+	for i := 1; i < attempt && d < config.DeadLetterMaxBackoff; i++ {
+		d *= 2
+	}
+
+	return min(d, config.DeadLetterMaxBackoff)
+}
+
+func parseDeadLetter(evt *core.Event, h nats.Header) (*DeadLetter, error) {
+	dl := &DeadLetter{
+		Event:  evt,
+		Reason: h.Get(headerDLReason),
+		Code:   h.Get(headerDLCode),
+	}
+
+	var err error
+	if dl.Attempts, err = strconv.Atoi(h.Get(headerDLAttempts)); err != nil {
+		return nil, fmt.Errorf("attempts are invalid: %w", err)
+	}
+	if dl.FirstFailure, err = time.Parse(time.RFC3339Nano, h.Get(headerDLFirstFailure)); err != nil {
+		return nil, fmt.Errorf("first failure is invalid: %w", err)
+	}
+	if dl.LastFailure, err = time.Parse(time.RFC3339Nano, h.Get(headerDLLastFailure)); err != nil {
+		return nil, fmt.Errorf("last failure is invalid: %w", err)
+	}
+	if s := h.Get(headerDLRetryAt); s != "" {
+		if dl.RetryAt, err = time.Parse(time.RFC3339Nano, s); err != nil {
+			return nil, fmt.Errorf("retry at is invalid: %w", err)
+		}
+	}
+
+	return dl, nil
+}
+
+func (brk *broker) QueryDeadLetters(ctx context.Context, q *EventQuery, fn func(*DeadLetter) error) error {
+	if brk.dlMgr == nil {
+		return core.ErrNotFound(fmt.Errorf("dead-lettering is disabled"))
+	}
+
+	return brk.dlMgr.Query(ctx, q, fn)
+}
+
+func (brk *broker) RequeueDeadLetters(ctx context.Context, q *EventQuery) (*RequeueResult, error) {
+	if brk.dlMgr == nil {
+		return nil, core.ErrNotFound(fmt.Errorf("dead-lettering is disabled"))
+	}
+
+	return brk.dlMgr.Requeue(ctx, q)
+}
+
+func (brk *broker) DeleteDeadLetter(ctx context.Context, evtId string) error {
+	if brk.dlMgr == nil {
+		return core.ErrNotFound(fmt.Errorf("dead-lettering is disabled"))
+	}
+
+	return brk.dlMgr.Delete(ctx, evtId)
+}
+
+func traceIdOf(evt *core.Event) string {
+	if id := evt.TraceId(); id != "" {
+		return id
+	}
+
+	return "_"
+}
+
+// MarshalJSON includes the event in protobuf JSON format.
+func (dl *DeadLetter) MarshalJSON() ([]byte, error) {
+	evt, err := protojson.Marshal(dl.Event)
+	if err != nil {
+		return nil, err
+	}
+
+	type deadLetter DeadLetter
+	return json.Marshal(&struct {
+		*deadLetter
+		Event json.RawMessage `json:"event"`
+	}{
+		deadLetter: (*deadLetter)(dl),
+		Event:      evt,
+	})
+}
@@ -0,0 +1,186 @@
+// Copyright 2024 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package engine
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/xigxog/kubefox/api"
+	"github.com/xigxog/kubefox/core"
+)
+
+const (
+	drainPollInterval    = 100 * time.Millisecond
+	pendingPurgeInterval = time.Second
+)
+
+// pendingReqs tracks requests that were routed but whose response has not been
+// routed yet. The Broker waits for them to complete before closing
+// subscriptions during shutdown and replicas use them to count their requests
+// in flight.
+type pendingReqs struct {
+	reqs   map[string]time.Time
+	purged time.Time
+	// Number of requests removed because they expired.
+	expired int
+	mutex   sync.Mutex
+}
+
+func newPendingReqs() *pendingReqs {
+	return &pendingReqs{
+		reqs: make(map[string]time.Time),
+	}
+}
+
+func (p *pendingReqs) add(id string, expiration time.Time) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.reqs[id] = expiration
+}
+
+func (p *pendingReqs) remove(id string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	delete(p.reqs, id)
+}
+
+// has returns true if the request is pending and has not expired.
+func (p *pendingReqs) has(id string) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	exp, found := p.reqs[id]
+	return found && time.Now().Before(exp)
+}
+
+// take removes the request and returns true if it was pending and had not
+// expired.
+func (p *pendingReqs) take(id string) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	exp, found := p.reqs[id]
+	delete(p.reqs, id)
+
+	return found && time.Now().Before(exp)
+}
+
+// count removes expired requests and returns the number remaining. Expired
+// requests are removed at most once every pendingPurgeInterval.
+func (p *pendingReqs) count() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	now := time.Now()
+	if now.Sub(p.purged) < pendingPurgeInterval {
+		return len(p.reqs)
+	}
+	p.purged = now
+
+	for id, exp := range p.reqs {
+		if now.After(exp) {
+			delete(p.reqs, id)
+			p.expired++
+		}
+	}
+
+	return len(p.reqs)
+}
+
+// takeExpired returns the number of requests that expired since it was last
+// called.
+func (p *pendingReqs) takeExpired() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	n := p.expired
+	p.expired = 0
+
+	return n
+}
+
+// trackRouted records a request after it was sent to its target and removes
+// the request a response was sent for.
+func (brk *broker) trackRouted(ctx *BrokerEventContext) {
+	switch ctx.Event.Category {
+	case core.Category_REQUEST:
+		brk.pending.add(ctx.Event.Id, time.Now().Add(ctx.Event.TTL()))
+	case core.Category_RESPONSE:
+		brk.pending.remove(ctx.Event.ParentId)
+	}
+}
+
+func (brk *broker) startPendingReaper() {
+	ticker := time.NewTicker(time.Second * 30)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			brk.pending.count()
+		case <-brk.ctx.Done():
+			return
+		}
+	}
+}
+
+// IsHealthy reports the Broker as unhealthy once it starts draining so it is
+// marked not ready.
+func (brk *broker) IsHealthy(ctx context.Context) bool {
+	return !brk.draining.Load()
+}
+
+func (brk *broker) Name() string {
+	return "broker"
+}
+
+// drain notifies subscribed components and waits for in-flight events to
+// complete or the timeout to expire. Genesis events are still routed while
+// draining as components keep sending them until they are reported not ready.
+func (brk *broker) drain(timeout time.Duration) {
+	if brk.draining.Swap(true) {
+		return
+	}
+	brk.log.Infof("draining in-flight events, waiting up to %s", timeout)
+
+	for _, sub := range brk.subMgr.Subscriptions() {
+		evt := &BrokerEventContext{
+			Event: core.NewMsg(core.EventOpts{
+				Type:   api.EventTypeDrain,
+				Source: brk.comp,
+				Target: sub.Component(),
+			}),
+		}
+		if err := sub.SendEvent(evt); err != nil {
+			brk.log.WithComponent(sub.Component()).Debugf("unable to send drain event: %v", err)
+		}
+	}
+
+	deadline := time.Now().Add(timeout)
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for {
+		queued, routing, pending := brk.queued.Load(), brk.routing.Load(), brk.pending.count()
+		if queued == 0 && routing == 0 && pending == 0 {
+			brk.log.Info("in-flight events drained")
+			return
+		}
+		if time.Now().After(deadline) {
+			brk.log.Warnf("drain timed out with %d queued, %d routing and %d pending events",
+				queued, routing, pending)
+			return
+		}
+		<-ticker.C
+	}
+}
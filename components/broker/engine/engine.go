@@ -10,25 +10,34 @@ package engine
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/go-logr/zapr"
 	"github.com/lestrrat-go/jwx/jwt"
+	"github.com/nats-io/nats.go/jetstream"
 	"github.com/xigxog/kubefox/api"
+	"github.com/xigxog/kubefox/api/kubernetes/v1alpha1"
 	"github.com/xigxog/kubefox/build"
+	"github.com/xigxog/kubefox/cache"
 	"github.com/xigxog/kubefox/components/broker/config"
 	brktel "github.com/xigxog/kubefox/components/broker/telemetry"
 	"github.com/xigxog/kubefox/core"
 	"github.com/xigxog/kubefox/logkf"
+	"github.com/xigxog/kubefox/matcher"
 	"github.com/xigxog/kubefox/telemetry"
 	"github.com/xigxog/kubefox/utils"
 	authv1 "k8s.io/api/authentication/v1"
+	authzv1 "k8s.io/api/authorization/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -54,14 +63,36 @@ var (
 
 type Engine interface {
 	Start()
+	// OnShutdown registers a func to be called after the Broker shuts down,
+	// before the process exits.
+	OnShutdown(func())
 }
 
 type Broker interface {
 	RecordTelemetry(*core.Component, *core.Telemetry)
 	AuthorizeComponent(context.Context, *Metadata) error
+	AuthorizeTap(context.Context, string, *core.TapRequest) error
+	AuthorizeAdmin(context.Context, string) error
 	Subscribe(context.Context, *SubscriptionConf) (ReplicaSubscription, error)
 	RecvEvent(evt *core.Event, receiver Receiver) *BrokerEventContext
+	Tap(context.Context, *core.TapRequest) (<-chan *core.Event, error)
+	QueryEvents(context.Context, *EventQuery, func(*core.Event) error) error
+	ReplayEvent(context.Context, *ReplayOpts) (*core.Event, error)
+	QueryDeadLetters(context.Context, *EventQuery, func(*DeadLetter) error) error
+	RequeueDeadLetters(context.Context, *EventQuery) (*RequeueResult, error)
+	DeleteDeadLetter(context.Context, string) error
+	ExplainEvent(context.Context, *core.Event) (*matcher.Explanation, error)
+	OpenContent(ctx context.Context, ref string) (io.ReadCloser, error)
 	Component() *core.Component
+
+	Subscriptions() []*SubscriptionInfo
+	SubscriptionGroups() []*GroupInfo
+	ReleaseRoutes(context.Context) ([]*RouteInfo, error)
+	ReloadReleaseMatcher(context.Context) error
+	Caches() map[string][]cache.Entry
+	EvictCache(string) error
+	InFlight() *InFlightInfo
+	DisconnectReplica(string) error
 }
 
 type broker struct {
@@ -74,11 +105,32 @@ type broker struct {
 
 	healthSrv *brktel.HealthServer
 	telClient *brktel.Client
+	adminSrv  *AdminServer
 
 	subMgr SubscriptionMgr
+	tapMgr TapMgr
 	recvCh chan *BrokerEventContext
 
-	store *store
+	// Number of events waiting for a worker and being routed.
+	queued  atomic.Int64
+	routing atomic.Int64
+	pending *pendingReqs
+
+	// Set when shutdown starts, the Broker is reported not ready while draining.
+	draining atomic.Bool
+
+	// Nil if idempotency is disabled.
+	idemMgr *idempotencyMgr
+	// Nil if dead-lettering is disabled.
+	dlMgr *deadLetterMgr
+	// Nil if response caching is disabled.
+	respCache *respCache
+
+	rateLimiter *rateLimiter
+
+	store Store
+
+	onShutdown []func()
 
 	ctx    context.Context
 	cancel context.CancelFunc
@@ -99,21 +151,33 @@ func New() Engine {
 		With(logkf.KeyBrokerName, comp.Name)
 	ctrl.SetLogger(zapr.NewLogger(logkf.Global.Unwrap().Desugar()))
 
+	var src ResourceSource
+	if standalone() {
+		src = NewFileSource(config.ResourceDir, config.SecretsFile)
+	} else {
+		src = NewK8sSource()
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	brk := &broker{
 		comp:      comp,
 		healthSrv: brktel.NewHealthServer(),
 		telClient: brktel.NewClient(),
 		subMgr:    NewManager(),
+		tapMgr:    NewTapMgr(),
 		recvCh:    make(chan *BrokerEventContext),
-		store:     NewStore(),
+		pending:   newPendingReqs(),
+		store:     NewStore(src),
 		ctx:       ctx,
 		cancel:    cancel,
 		log:       logkf.Global,
 	}
 	brk.grpcSrv = NewGRPCServer(brk)
+	brk.adminSrv = NewAdminServer(brk)
 	brk.natsClient = NewNATSClient(brk)
 
+	brktel.RegisterSubscriptions(brk.subMgr.Count)
+
 	return brk
 }
 
@@ -121,21 +185,32 @@ func (brk *broker) Component() *core.Component {
 	return brk.comp
 }
 
+func (brk *broker) OnShutdown(fn func()) {
+	brk.onShutdown = append(brk.onShutdown, fn)
+}
+
 func (brk *broker) Start() {
 	brk.log.Debugf("broker %s starting", brk.comp.Key())
 
 	ctx, cancel := context.WithTimeout(brk.ctx, timeout)
 	defer cancel()
 
-	cfg, err := ctrl.GetConfig()
-	if err != nil {
-		brk.shutdown(ExitCodeKubernetes, err)
-	}
-	k8s, err := client.New(cfg, client.Options{})
-	if err != nil {
-		brk.shutdown(ExitCodeKubernetes, err)
+	var err error
+	if standalone() {
+		if err := checkStandalone(); err != nil {
+			brk.shutdown(ExitCodeConfiguration, err)
+		}
+	} else {
+		cfg, err := ctrl.GetConfig()
+		if err != nil {
+			brk.shutdown(ExitCodeKubernetes, err)
+		}
+		k8s, err := client.New(cfg, client.Options{})
+		if err != nil {
+			brk.shutdown(ExitCodeKubernetes, err)
+		}
+		brk.k8sClient = k8s
 	}
-	brk.k8sClient = k8s
 
 	if config.HealthSrvAddr != "false" {
 		if err := brk.healthSrv.Start(); err != nil {
@@ -153,6 +228,56 @@ func (brk *broker) Start() {
 		brk.shutdown(ExitCodeNATS, err)
 	}
 	brk.healthSrv.Register(brk.natsClient)
+	brk.healthSrv.Register(brk)
+
+	if config.OffloadThreshold > 0 {
+		err := brk.natsClient.EnableOffload(ctx, config.OffloadThreshold,
+			api.StorageType(config.OffloadStorage))
+		if err != nil {
+			brk.shutdown(ExitCodeNATS, err)
+		}
+	}
+
+	if config.IdempotencyWindow > 0 {
+		kv, err := brk.natsClient.KeyValue(ctx, idempotencyBucket,
+			config.IdempotencyWindow, api.StorageType(config.IdempotencyStorage))
+		if err != nil {
+			brk.shutdown(ExitCodeNATS, err)
+		}
+		brk.idemMgr = newIdempotencyMgr(kv, config.IdempotencyWindow,
+			brk.natsClient.OffloadContent, brk.RecvEvent)
+	}
+
+	if config.DeadLetterRetention > 0 {
+		brk.dlMgr = newDeadLetterMgr(brk.natsClient, brk.RecvEvent)
+		if err := brk.dlMgr.Start(ctx); err != nil {
+			brk.shutdown(ExitCodeNATS, err)
+		}
+	}
+
+	if config.RespCacheMaxSize > 0 {
+		var kv jetstream.KeyValue
+		if config.RespCacheShared {
+			kv, err = brk.natsClient.KeyValue(ctx, respCacheBucket,
+				api.MaxCacheTTLSeconds*time.Second, api.StorageType(config.RespCacheStorage))
+			if err != nil {
+				brk.shutdown(ExitCodeNATS, err)
+			}
+		}
+		brk.respCache = newRespCache(kv, config.RespCacheMaxSize, config.RespCacheMaxMemory,
+			brk.natsClient.OffloadContent, brk.RecvEvent)
+		brk.store.OnReleaseChange(brk.respCache.Purge)
+	}
+
+	var rateLimitKV jetstream.KeyValue
+	if !config.RateLimitLocal {
+		rateLimitKV, err = brk.natsClient.KeyValue(ctx, rateLimitBucket,
+			RateLimitTTL, api.StorageType(config.RateLimitStorage))
+		if err != nil {
+			brk.shutdown(ExitCodeNATS, err)
+		}
+	}
+	brk.rateLimiter = newRateLimiter(rateLimitKV)
 
 	if err := brk.store.Open(); err != nil {
 		brk.shutdown(ExitCodeResourceStore, err)
@@ -162,6 +287,12 @@ func (brk *broker) Start() {
 		brk.shutdown(ExitCodeGRPCServer, err)
 	}
 
+	if config.AdminSrvAddr != "false" {
+		if err := brk.adminSrv.Start(); err != nil {
+			brk.shutdown(ExitCodeHTTP, err)
+		}
+	}
+
 	consumer := fmt.Sprintf("broker-%s", brk.comp.Id)
 	subj := brk.comp.BrokerSubject()
 	if err := brk.natsClient.ConsumeEvents(brk.ctx, consumer, subj); err != nil {
@@ -173,6 +304,8 @@ func (brk *broker) Start() {
 	for i := 0; i < config.NumWorkers; i++ {
 		go brk.startWorker(i)
 	}
+	go brk.startPendingReaper()
+	go brk.startHeartbeats()
 
 	brk.log.Info("broker started")
 
@@ -202,8 +335,6 @@ func (brk *broker) Subscribe(ctx context.Context, conf *SubscriptionConf) (Repli
 		}
 	}
 
-	// TODO deal with health checks
-
 	return sub, nil
 }
 
@@ -211,6 +342,9 @@ func (brk *broker) AuthorizeComponent(ctx context.Context, meta *Metadata) error
 	if meta.Platform != config.Platform {
 		return fmt.Errorf("component provided incorrect platform")
 	}
+	if standalone() {
+		return brk.authorizeStandalone(ctx, meta)
+	}
 
 	parsed, err := jwt.ParseString(meta.Token)
 	if err != nil {
@@ -285,9 +419,121 @@ func (brk *broker) AuthorizeComponent(ctx context.Context, meta *Metadata) error
 	return nil
 }
 
+// authorizeStandalone checks the Component is part of an AppDeployment. There
+// are no Service Account tokens to verify without Kubernetes, Components
+// connecting to a standalone Broker are trusted.
+func (brk *broker) authorizeStandalone(ctx context.Context, meta *Metadata) error {
+	typ := api.ComponentType(meta.Component.Type)
+	if typ != api.ComponentTypeKubeFox {
+		return nil
+	}
+
+	def, err := brk.store.ComponentDef(ctx, meta.Component)
+	if err != nil || typ != def.Type || meta.Component.Hash != def.Hash {
+		return fmt.Errorf("component not found")
+	}
+
+	return nil
+}
+
+// AuthorizeTap verifies the token belongs to a user or Service Account that is
+// allowed to perform the 'tap' verb on the VirtualEnvironment being tapped.
+func (brk *broker) AuthorizeTap(ctx context.Context, token string, req *core.TapRequest) error {
+	user, err := brk.authorize(ctx, token, &authzv1.ResourceAttributes{
+		Namespace: config.Namespace,
+		Verb:      "tap",
+		Group:     v1alpha1.GroupVersion.Group,
+		Resource:  "virtualenvironments",
+		Name:      req.VirtualEnvironment,
+	})
+	if err != nil && user != "" {
+		return fmt.Errorf("user '%s' is not allowed to tap events: %w", user, err)
+	}
+
+	return err
+}
+
+// AuthorizeAdmin verifies the token belongs to a user or Service Account that
+// is allowed to perform the 'admin' verb on the Platform of the Broker.
+func (brk *broker) AuthorizeAdmin(ctx context.Context, token string) error {
+	user, err := brk.authorize(ctx, token, &authzv1.ResourceAttributes{
+		Namespace: config.Namespace,
+		Verb:      "admin",
+		Group:     v1alpha1.GroupVersion.Group,
+		Resource:  "platforms",
+		Name:      config.Platform,
+	})
+	if err != nil && user != "" {
+		return fmt.Errorf("user '%s' is not allowed to administer broker: %w", user, err)
+	}
+
+	return err
+}
+
+// authorize verifies the token with a TokenReview and checks the user is
+// allowed to access the resource with a SubjectAccessReview. The name of the
+// user is returned.
+func (brk *broker) authorize(ctx context.Context, token string, attrs *authzv1.ResourceAttributes) (string, error) {
+	if standalone() {
+		// There is no Kubernetes API to review the token with, the admin
+		// server and taps of a standalone Broker are only reachable from
+		// localhost, see checkStandalone.
+		return "", nil
+	}
+
+	review := authv1.TokenReview{
+		Spec: authv1.TokenReviewSpec{
+			Token: token,
+		},
+	}
+	if err := brk.k8sClient.Create(ctx, &review); err != nil {
+		return "", err
+	}
+	if !review.Status.Authenticated {
+		return "", fmt.Errorf("unauthenticated user: %s", review.Status.Error)
+	}
+
+	user := review.Status.User
+	extra := make(map[string]authzv1.ExtraValue, len(user.Extra))
+	for k, v := range user.Extra {
+		extra[k] = authzv1.ExtraValue(v)
+	}
+	access := authzv1.SubjectAccessReview{
+		Spec: authzv1.SubjectAccessReviewSpec{
+			User:               user.Username,
+			UID:                user.UID,
+			Groups:             user.Groups,
+			Extra:              extra,
+			ResourceAttributes: attrs,
+		},
+	}
+	if err := brk.k8sClient.Create(ctx, &access); err != nil {
+		return user.Username, err
+	}
+	if !access.Status.Allowed {
+		return user.Username, errors.New(access.Status.Reason)
+	}
+
+	return user.Username, nil
+}
+
+func (brk *broker) OpenContent(ctx context.Context, ref string) (io.ReadCloser, error) {
+	return brk.natsClient.OpenContent(ctx, ref)
+}
+
+func (brk *broker) Tap(ctx context.Context, req *core.TapRequest) (<-chan *core.Event, error) {
+	return brk.tapMgr.Create(ctx, req)
+}
+
 func (brk *broker) RecvEvent(evt *core.Event, receiver Receiver) *BrokerEventContext {
-	parentCtx, cancel := context.WithCancelCause(context.Background())
-	ctx, _ := context.WithTimeoutCause(parentCtx, evt.TTL(), core.ErrTimeout())
+	parentCtx, cancelParent := context.WithCancelCause(context.Background())
+	ctx, cancelTimeout := context.WithTimeoutCause(parentCtx, evt.TTL(), core.ErrTimeout())
+	cancel := func(cause error) {
+		// The cause of the parent is used as ctx is canceled first by its
+		// parent, releasing the timer afterwards has no effect on the cause.
+		cancelParent(cause)
+		cancelTimeout()
+	}
 
 	span := telemetry.StartSpan(
 		fmt.Sprintf("Route %s from %s", evt.Category, evt.Source.GroupKey()), evt.ParentSpan)
@@ -301,6 +547,7 @@ func (brk *broker) RecvEvent(evt *core.Event, receiver Receiver) *BrokerEventCon
 		Span:       span,
 	}
 
+	brk.queued.Add(1)
 	go func() {
 		brk.recvCh <- evtCtx
 	}()
@@ -319,7 +566,10 @@ func (brk *broker) startWorker(id int) {
 		select {
 		case ctx := <-brk.recvCh:
 			ctx.Log = log
+			brk.queued.Add(-1)
+			brk.routing.Add(1)
 
+			code := "OK"
 			if err := brk.routeEvent(ctx); err != nil {
 				if apierrors.IsNotFound(err) {
 					err = core.ErrNotFound(err)
@@ -331,6 +581,7 @@ func (brk *broker) startWorker(id int) {
 				}
 
 				ctx.Span.RecordErr(kfErr)
+				code = kfErr.Code().String()
 
 				switch kfErr.Code() {
 				case core.CodeUnexpected:
@@ -345,15 +596,38 @@ func (brk *broker) startWorker(id int) {
 					ctx.Cancel(kfErr)
 				}()
 
+				// Messages are not returned to their source, redeliver or
+				// dead-letter them instead of dropping them. The dead-letter
+				// manager handles failures of events it redelivers.
+				if brk.dlMgr != nil && ctx.Event.Category == core.Category_MESSAGE &&
+					ctx.Receiver != ReceiverDeadLetterMgr {
+
+					go brk.dlMgr.DeadLetter(ctx, kfErr)
+				}
+
 			} else {
+				brk.trackRouted(ctx)
 				ctx.Cancel(nil)
 			}
+			brk.routing.Add(-1)
+			brktel.EventsRouted.
+				WithLabelValues(ctx.Event.Category.String(), ctx.Receiver.String(), code).
+				Inc()
 
 			ctx.Span.SetEventAttributes(ctx.Event)
 			ctx.Span.End()
 
 			brk.telClient.AddSpans(brk.comp, ctx.Span)
 
+			brk.tapMgr.Publish(ctx.Event)
+
+			// Events received from NATS were recorded by the sending Broker.
+			if config.RecordEvents && ctx.Receiver != ReceiverNATS {
+				if err := brk.natsClient.RecordEvent(ctx.Event); err != nil {
+					ctx.Log.Warnf("unable to record event: %v", err)
+				}
+			}
+
 		case <-brk.ctx.Done():
 			return
 		}
@@ -370,15 +644,60 @@ func (brk *broker) routeEvent(ctx *BrokerEventContext) (err error) {
 	defer routeSpan.End()
 
 	findSpan := routeSpan.StartChildSpan("Find Target")
+	findStart := time.Now()
 	if err = brk.validateEvent(ctx); err == nil { //success
 		err = brk.findTarget(ctx)
 	}
+	brktel.RouteDuration.WithLabelValues(brktel.PhaseFindTarget).Observe(time.Since(findStart).Seconds())
 	if err != nil {
 		findSpan.End(err)
 		return
 	}
 	findSpan.End()
 
+	if err = brk.checkRateLimit(ctx); err != nil {
+		return
+	}
+
+	if brk.respCache != nil {
+		switch {
+		case ctx.Event.Category == core.Category_REQUEST && ctx.Receiver != ReceiverNATS:
+			// Requests received from NATS were checked by the sending Broker.
+			var hit bool
+			if hit, err = brk.respCache.Check(ctx); err != nil || hit {
+				return
+			}
+
+		case ctx.Event.Category == core.Category_RESPONSE:
+			brk.respCache.Complete(ctx)
+		}
+	}
+
+	if brk.idemMgr != nil {
+		switch {
+		case ctx.Event.Category == core.Category_REQUEST:
+			var dup bool
+			if ctx.Receiver == ReceiverNATS {
+				// Requests received from NATS were checked by the sending
+				// Broker, only redeliveries are detected.
+				dup, err = brk.idemMgr.CheckDelivery(ctx)
+			} else {
+				dup, err = brk.idemMgr.Check(ctx)
+			}
+			if err != nil || dup {
+				return
+			}
+			defer func() {
+				if err != nil {
+					brk.idemMgr.Abandon(ctx)
+				}
+			}()
+
+		case ctx.Event.Category == core.Category_RESPONSE:
+			brk.idemMgr.Complete(ctx)
+		}
+	}
+
 	// Update log and span attributes after matching.
 	routeSpan.Name += " to " + ctx.Event.Target.GroupKey()
 
@@ -386,6 +705,7 @@ func (brk *broker) routeEvent(ctx *BrokerEventContext) (err error) {
 	ctx.Log.Debugf("matched event to target '%s'", ctx.Event.Target.GroupKey())
 
 	sendSpan := routeSpan.StartChildSpan("Send Event")
+	sendStart := time.Now()
 
 	sub, found := brk.subMgr.Subscription(ctx.Event.Target)
 	switch {
@@ -393,6 +713,7 @@ func (brk *broker) routeEvent(ctx *BrokerEventContext) (err error) {
 		// Found component subscribed via gRPC.
 		sendSpan.Name = "Send gRPC event"
 		ctx.Log.Debug("subscription found, sending event with gRPC")
+		// Offloaded content is fetched by the Component, see sendContent.
 		err = sub.SendEvent(ctx)
 
 	case ctx.Receiver != ReceiverNATS && ctx.Event.Target.BrokerId != brk.comp.Id:
@@ -405,6 +726,7 @@ func (brk *broker) routeEvent(ctx *BrokerEventContext) (err error) {
 		err = core.ErrComponentGone()
 	}
 	sendSpan.End(err)
+	brktel.RouteDuration.WithLabelValues(brktel.PhaseSend).Observe(time.Since(sendStart).Seconds())
 
 	return
 }
@@ -445,19 +767,48 @@ func (brk *broker) validateEvent(ctx *BrokerEventContext) error {
 
 			return core.ErrInvalid(fmt.Errorf("event context is invalid"))
 		}
+
+		// Claims are only trusted from genesis adapters which verified them,
+		// drop any set by other Components so routes cannot be spoofed.
+		if _, found := ctx.Event.Values[api.ValKeyClaims]; found &&
+			!brk.store.IsGenesisAdapter(ctx, ctx.Event.Source) {
+
+			ctx.Log.Debugf("removing claims from event sent by '%s'", ctx.Event.Source.Key())
+			delete(ctx.Event.Values, api.ValKeyClaims)
+		}
+
+		if token := ctx.Event.Header(api.HeaderExplainToken); token != "" {
+			ctx.ExplainToken = token
+			ctx.Event.DelHeader(api.HeaderExplainToken)
+		}
 	}
 
 	return nil
 }
 
 func (brk *broker) findTarget(ctx *BrokerEventContext) (err error) {
+	defer func() {
+		// Evaluated once the target and route are known.
+		if err == nil {
+			err = brk.checkAccess(ctx)
+		}
+	}()
+
+	var crossApp bool
 	if ctx.Event.HasContext() {
 		if err := brk.store.AttachEventContext(ctx); err != nil {
 			return err
 		}
+		if crossApp, err = brk.attachTargetApp(ctx); err != nil {
+			return err
+		}
 
 		if ctx.Event.Target != nil {
 			if ctx.Event.Category == core.Category_RESPONSE && ctx.Event.Target.IsComplete() {
+				if crossApp {
+					// Response to a request from another App.
+					return nil
+				}
 				_, err := ctx.AppDeployment.GetDefinition(ctx.Event.Target)
 				if err != nil && !brk.store.IsGenesisAdapter(ctx, ctx.Event.Target) {
 					return err
@@ -496,7 +847,14 @@ func (brk *broker) findTarget(ctx *BrokerEventContext) (err error) {
 		}
 
 		route, matched := matcher.Match(ctx.Event)
+		if crossApp && matched && route.Component.Name != ctx.Event.Target.Name {
+			// Requests to other Apps are only sent to the declared Component.
+			matched = false
+		}
 		switch {
+		case !matched && ctx.Event.Target == nil:
+			return brk.routeNotFound(ctx, matcher)
+
 		case matched:
 			ctx.RouteId = int64(route.Id)
 			ctx.Event.SetRoute(route)
@@ -534,7 +892,7 @@ func (brk *broker) findTarget(ctx *BrokerEventContext) (err error) {
 
 		route, matched := matcher.Match(ctx.Event)
 		if !matched {
-			return core.ErrRouteNotFound()
+			return brk.routeNotFound(ctx, matcher)
 		}
 
 		ctx.RouteId = int64(route.Id)
@@ -550,6 +908,10 @@ func (brk *broker) findTarget(ctx *BrokerEventContext) (err error) {
 		return err
 	}
 
+	if crossApp {
+		// Source was authorized using the AppDeployment of its App.
+		return nil
+	}
 	_, err = ctx.AppDeployment.GetDefinition(ctx.Event.Source)
 	if err != nil && !brk.store.IsGenesisAdapter(ctx, ctx.Event.Source) {
 		return err
@@ -558,19 +920,138 @@ func (brk *broker) findTarget(ctx *BrokerEventContext) (err error) {
 	return nil
 }
 
-func (brk *broker) shutdown(code int, err error) {
-	// TODO deal with inflight events when shutdown occurs
+// attachTargetApp attaches the AppDeployment of the target's App to the event
+// context if the target is a Component of another App. The AppDeployment used
+// is the one pinned by the Release of the event context. Requests are only
+// allowed if the source declared the target as a dependency, responses are
+// only allowed if the request they are for was sent to the source and are sent
+// to the requesting replica without changing the context. Returns true if the
+// target is part of another App.
+func (brk *broker) attachTargetApp(ctx *BrokerEventContext) (bool, error) {
+	target := ctx.Event.Target
+	if target == nil || target.Type != string(api.ComponentTypeKubeFox) ||
+		target.App == "" || target.App == ctx.AppDeployment.Spec.AppName {
+		return false, nil
+	}
+
+	switch ctx.Event.Category {
+	case core.Category_RESPONSE:
+		// Responses received from NATS were checked by the sending Broker,
+		// others are replayed by the Broker.
+		if ctx.Receiver != ReceiverGRPCServer {
+			return true, nil
+		}
+		sub, found := brk.subMgr.ReplicaSubscription(ctx.Event.Source)
+		if !found || !sub.IsInFlight(ctx.Event.ParentId) {
+			return false, core.ErrComponentMismatch(fmt.Errorf("response is not for a request sent to source"))
+		}
+		return true, nil
+
+	default:
+		def, err := ctx.AppDeployment.GetDefinition(ctx.Event.Source)
+		if err != nil {
+			return false, err
+		}
+		dep := def.Dependencies[target.Name]
+		if dep == nil || dep.Type != api.ComponentTypeKubeFox || dep.App != target.App {
+			return false, core.ErrComponentMismatch(fmt.Errorf("target component not declared as dependency"))
+		}
+	}
+
+	var appDep string
+	if ctx.ReleaseManifest != nil {
+		if a, err := ctx.ReleaseManifest.GetAppDeploymentOfApp(target.App); err == nil {
+			appDep = a.Name
+		}
+	} else {
+		appDep = ctx.VirtualEnv.Status.ActiveRelease.AppDeployment(target.App)
+	}
+	if appDep == "" {
+		return false, core.ErrComponentMismatch(fmt.Errorf("app '%s' is not part of release", target.App))
+	}
+
+	ctx.Event.Context.AppDeployment = appDep
+	if err := brk.store.AttachEventContext(ctx); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
 
+// routeNotFound returns ErrRouteNotFound. If the Event was received from a
+// genesis adapter of a non-production Platform and requests an explanation
+// with a token allowed to administer the Platform the match trace is added to
+// the error details.
+func (brk *broker) routeNotFound(ctx *BrokerEventContext, m *matcher.EventMatcher) error {
+	kfErr := core.ErrRouteNotFound()
+	if !strings.EqualFold(ctx.Event.Header(api.HeaderExplain), "true") ||
+		!brk.store.IsGenesisAdapter(ctx, ctx.Event.Source) {
+		return kfErr
+	}
+	if p, err := brk.store.Platform(ctx); err != nil || !p.Spec.NonProduction {
+		ctx.Log.Debug("explain requested but Platform is not marked non-production")
+		return kfErr
+	}
+	if err := brk.AuthorizeAdmin(ctx, ctx.ExplainToken); err != nil {
+		ctx.Log.Debugf("explain requested but token is not authorized: %v", err)
+		return kfErr
+	}
+
+	if b, err := json.Marshal(m.Explain(ctx.Event)); err == nil {
+		kfErr.SetDetail(api.ErrDetailExplain, string(b))
+	}
+
+	return kfErr
+}
+
+// ExplainEvent matches the Event against the routes of the matcher the Event
+// would be routed with and returns the match trace.
+func (brk *broker) ExplainEvent(ctx context.Context, evt *core.Event) (*matcher.Explanation, error) {
+	brkCtx := &BrokerEventContext{
+		Context: ctx,
+		Event:   evt,
+		Log:     brk.log,
+	}
+
+	var (
+		m   *matcher.EventMatcher
+		err error
+	)
+	if evt.HasContext() {
+		if err := brk.store.AttachEventContext(brkCtx); err != nil {
+			return nil, err
+		}
+		m, err = brk.store.DeploymentMatcher(brkCtx)
+	} else {
+		m, err = brk.store.ReleaseMatcher(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return m.Explain(evt), nil
+}
+
+func (brk *broker) shutdown(code int, err error) {
 	brk.log.Infof("broker shutting down, exit code %d", code)
 	if err != nil {
 		brk.log.Error(err)
 	}
 
+	// Report not ready and finish in-flight events before subscriptions are
+	// closed. Components reconnect once their subscription is closed.
+	brk.drain(config.ShutdownTimeout)
+
 	brk.healthSrv.Shutdown(timeout)
+	brk.adminSrv.Shutdown(timeout)
 
 	brk.subMgr.Close()
 	brk.grpcSrv.Shutdown(timeout)
 
+	if brk.dlMgr != nil {
+		brk.dlMgr.Stop()
+	}
+
 	// Stops workers.
 	brk.cancel()
 	brk.wg.Wait()
@@ -580,5 +1061,9 @@ func (brk *broker) shutdown(code int, err error) {
 	brk.natsClient.Close()
 	brk.telClient.Shutdown(timeout)
 
+	for _, fn := range brk.onShutdown {
+		fn()
+	}
+
 	os.Exit(code)
 }
@@ -0,0 +1,502 @@
+// Copyright 2024 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xigxog/kubefox/api"
+	"github.com/xigxog/kubefox/api/kubernetes/v1alpha1"
+	"github.com/xigxog/kubefox/build"
+	"github.com/xigxog/kubefox/components/broker/config"
+	"github.com/xigxog/kubefox/logkf"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+const fileSourcePollInterval = 2 * time.Second
+
+// Kinds of resources read by the file source.
+var fileSourceKinds = map[string]func() client.Object{
+	"AppDeployment":      func() client.Object { return &v1alpha1.AppDeployment{} },
+	"Environment":        func() client.Object { return &v1alpha1.Environment{} },
+	"HTTPAdapter":        func() client.Object { return &v1alpha1.HTTPAdapter{} },
+	"Platform":           func() client.Object { return &v1alpha1.Platform{} },
+	"ReleaseManifest":    func() client.Object { return &v1alpha1.ReleaseManifest{} },
+	"VirtualEnvironment": func() client.Object { return &v1alpha1.VirtualEnvironment{} },
+}
+
+// fileSource provides resources from the YAML and JSON files of a local
+// directory and secrets from a local YAML file. The files are polled for
+// changes so resources can be edited while the Broker is running. Namespaces
+// are ignored, resources are looked up by kind and name.
+type fileSource struct {
+	dir         string
+	secretsFile string
+
+	hdlr toolscache.ResourceEventHandler
+
+	// Resources by kind and name.
+	objs map[string]map[string]*fileObject
+	// Secrets by kind and name of the resource they belong to.
+	secrets     map[string]map[string]map[string]*api.Val
+	secretsHash string
+	// Incremented each time the secrets file changes.
+	secretsVersion int64
+	secretsTime    time.Time
+	filesSig       string
+	mutex          sync.RWMutex
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	log *logkf.Logger
+}
+
+type fileObject struct {
+	obj  client.Object
+	hash string
+	gen  int64
+}
+
+// standalone returns true if the Broker reads resources from a local directory
+// instead of Kubernetes.
+func standalone() bool {
+	return config.ResourceDir != ""
+}
+
+// checkStandalone verifies a standalone Broker can only be reached from the
+// local host. Without Kubernetes Components, taps and admin requests are not
+// authenticated, so plaintext connections must be explicitly enabled and the
+// gRPC and admin servers must bind to loopback addresses.
+func checkStandalone() error {
+	if !config.Insecure {
+		return fmt.Errorf("resource-dir requires insecure to be set")
+	}
+	for flag, addr := range map[string]string{
+		"grpc-addr":  config.GRPCSrvAddr,
+		"admin-addr": config.AdminSrvAddr,
+	} {
+		if flag == "admin-addr" && addr == "false" {
+			continue
+		}
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return fmt.Errorf("%s is invalid: %w", flag, err)
+		}
+		if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			return fmt.Errorf("resource-dir requires %s to be a loopback address, got '%s'", flag, addr)
+		}
+	}
+
+	return nil
+}
+
+// StandaloneHash returns the hash of the httpsrv Component run with a
+// standalone Broker. Builds without a commit hash use "debug".
+func StandaloneHash() string {
+	if build.Info.Hash != "" {
+		return build.Info.Hash
+	}
+	return "debug"
+}
+
+// NewFileSource returns a ResourceSource reading resources from dir and
+// secrets from secretsFile. If secretsFile is empty no secrets are provided.
+func NewFileSource(dir, secretsFile string) ResourceSource {
+	ctx, cancel := context.WithCancel(context.Background())
+	src := &fileSource{
+		dir:    filepath.Clean(dir),
+		objs:   make(map[string]map[string]*fileObject),
+		ctx:    ctx,
+		cancel: cancel,
+		log:    logkf.Global,
+	}
+	if secretsFile != "" {
+		src.secretsFile = filepath.Clean(secretsFile)
+	}
+
+	return src
+}
+
+func (src *fileSource) Open(ctx context.Context, hdlr toolscache.ResourceEventHandler) error {
+	src.hdlr = hdlr
+	if err := src.load(true); err != nil {
+		return err
+	}
+
+	go src.poll()
+
+	return nil
+}
+
+func (src *fileSource) Close() {
+	src.cancel()
+}
+
+func (src *fileSource) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	kind := kindOf(obj)
+
+	src.mutex.RLock()
+	defer src.mutex.RUnlock()
+
+	o, found := src.objs[kind][key.Name]
+	if !found {
+		return apierrors.NewNotFound(
+			v1alpha1.GroupVersion.WithResource(strings.ToLower(kind)).GroupResource(), key.Name)
+	}
+	reflect.ValueOf(obj).Elem().Set(reflect.ValueOf(o.obj.DeepCopyObject()).Elem())
+
+	return nil
+}
+
+func (src *fileSource) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	kind := strings.TrimSuffix(kindOf(list), "List")
+
+	src.mutex.RLock()
+	defer src.mutex.RUnlock()
+
+	items := make([]runtime.Object, 0, len(src.objs[kind]))
+	for _, o := range src.objs[kind] {
+		items = append(items, o.obj.DeepCopyObject())
+	}
+
+	return meta.SetList(list, items)
+}
+
+func (src *fileSource) GetData(ctx context.Context, key api.DataKey, data *api.Data) error {
+	src.mutex.RLock()
+	defer src.mutex.RUnlock()
+
+	secs := src.secrets[key.Kind][key.Name]
+	if len(secs) == 0 {
+		return nil
+	}
+
+	data.Secrets = make(map[string]*api.Val, len(secs))
+	for k, v := range secs {
+		data.Secrets[k] = v
+	}
+
+	return nil
+}
+
+// DataVersion returns the version of the secrets file, the same version is
+// returned for all keys.
+func (src *fileSource) DataVersion(ctx context.Context, key api.DataKey) (int64, time.Time, error) {
+	src.mutex.RLock()
+	defer src.mutex.RUnlock()
+
+	return src.secretsVersion, src.secretsTime, nil
+}
+
+func (src *fileSource) poll() {
+	ticker := time.NewTicker(fileSourcePollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := src.load(false); err != nil {
+				src.log.Warnf("reloading resources from '%s' failed: %v", src.dir, err)
+			}
+		case <-src.ctx.Done():
+			return
+		}
+	}
+}
+
+// load reads the resources and secrets if any of the files changed since the
+// last load. Resources whose content changed are passed to the handler.
+func (src *fileSource) load(initial bool) error {
+	sig, err := src.signature()
+	if err != nil {
+		return err
+	}
+	if sig == src.filesSig {
+		return nil
+	}
+
+	objs := make(map[string]map[string]*fileObject)
+	err = filepath.WalkDir(src.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || path == src.secretsFile {
+			return nil
+		}
+		switch filepath.Ext(path) {
+		case ".yaml", ".yml", ".json":
+			return src.loadFile(path, objs)
+		default:
+			return nil
+		}
+	})
+	if err != nil {
+		return err
+	}
+	src.addPlatform(objs)
+
+	secrets, secretsHash, err := src.loadSecrets()
+	if err != nil {
+		return err
+	}
+
+	src.mutex.Lock()
+	var added, updated, deleted [][2]client.Object
+	for kind, byName := range objs {
+		for name, o := range byName {
+			old := src.objs[kind][name]
+			switch {
+			case old == nil:
+				o.gen = 1
+				added = append(added, [2]client.Object{nil, o.obj})
+			case old.hash != o.hash || secretsHash != src.secretsHash:
+				o.gen = old.gen + 1
+				updated = append(updated, [2]client.Object{old.obj, o.obj})
+			default:
+				byName[name] = old
+				continue
+			}
+			src.setDefaults(o)
+		}
+	}
+	for kind, byName := range src.objs {
+		for name, old := range byName {
+			if objs[kind][name] == nil {
+				deleted = append(deleted, [2]client.Object{old.obj, nil})
+			}
+		}
+	}
+	if secretsHash != src.secretsHash {
+		src.secretsVersion++
+		src.secretsTime = time.Now()
+	}
+	src.objs, src.secrets = objs, secrets
+	src.secretsHash, src.filesSig = secretsHash, sig
+	src.mutex.Unlock()
+
+	if src.hdlr == nil {
+		return nil
+	}
+	for _, o := range added {
+		src.hdlr.OnAdd(o[1], initial)
+	}
+	for _, o := range updated {
+		src.hdlr.OnUpdate(o[0], o[1])
+	}
+	for _, o := range deleted {
+		src.hdlr.OnDelete(o[0])
+	}
+
+	return nil
+}
+
+func (src *fileSource) loadFile(path string, objs map[string]map[string]*fileObject) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(bytes.NewReader(b)))
+	for {
+		doc, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if len(bytes.TrimSpace(doc)) == 0 {
+			continue
+		}
+
+		typ := &metav1.TypeMeta{}
+		if err := yaml.Unmarshal(doc, typ); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		newObj, found := fileSourceKinds[typ.Kind]
+		if !found {
+			src.log.Debugf("%s: skipping resource of unsupported kind '%s'", path, typ.Kind)
+			continue
+		}
+
+		obj := newObj()
+		if err := yaml.Unmarshal(doc, obj); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if obj.GetName() == "" {
+			return fmt.Errorf("%s: %s is missing name", path, typ.Kind)
+		}
+
+		if objs[typ.Kind] == nil {
+			objs[typ.Kind] = make(map[string]*fileObject)
+		}
+		if _, found := objs[typ.Kind][obj.GetName()]; found {
+			return fmt.Errorf("%s: %s '%s' is defined more than once", path, typ.Kind, obj.GetName())
+		}
+
+		sum := sha256.Sum256(doc)
+		objs[typ.Kind][obj.GetName()] = &fileObject{obj: obj, hash: hex.EncodeToString(sum[:])}
+	}
+}
+
+// loadSecrets reads the secrets file. Secrets are keyed by the kind and name of
+// the resource they belong to.
+//
+//	Environment:
+//	  dev:
+//	    db-password: hunter2
+func (src *fileSource) loadSecrets() (map[string]map[string]map[string]*api.Val, string, error) {
+	secrets := make(map[string]map[string]map[string]*api.Val)
+	if src.secretsFile == "" {
+		return secrets, "", nil
+	}
+
+	b, err := os.ReadFile(src.secretsFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return secrets, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if err := yaml.Unmarshal(b, &secrets); err != nil {
+		return nil, "", fmt.Errorf("%s: %w", src.secretsFile, err)
+	}
+
+	sum := sha256.Sum256(b)
+	return secrets, hex.EncodeToString(sum[:]), nil
+}
+
+// addPlatform adds the Platform of the Broker if it is not defined and lists
+// httpsrv as a Component of the Platform, there is no operator to report the
+// status of Platform Components.
+func (src *fileSource) addPlatform(objs map[string]map[string]*fileObject) {
+	kind := "Platform"
+	if objs[kind] == nil {
+		objs[kind] = make(map[string]*fileObject)
+	}
+
+	o := objs[kind][config.Platform]
+	if o == nil {
+		o = &fileObject{
+			obj: &v1alpha1.Platform{
+				TypeMeta: metav1.TypeMeta{
+					APIVersion: v1alpha1.GroupVersion.String(),
+					Kind:       kind,
+				},
+				ObjectMeta: metav1.ObjectMeta{
+					Name: config.Platform,
+				},
+				// Standalone Brokers are only used for development.
+				Spec: v1alpha1.PlatformSpec{
+					NonProduction: true,
+				},
+			},
+		}
+		objs[kind][config.Platform] = o
+	}
+
+	p := o.obj.(*v1alpha1.Platform)
+	for _, c := range p.Status.Components {
+		if c.Name == api.PlatformComponentHTTPSrv {
+			return
+		}
+	}
+	p.Status.Components = append(p.Status.Components, v1alpha1.ComponentStatus{
+		Ready: true,
+		Name:  api.PlatformComponentHTTPSrv,
+		Hash:  StandaloneHash(),
+		Type:  api.ComponentTypeHTTPAdapter,
+	})
+}
+
+// setDefaults sets the fields normally set by Kubernetes and the operator.
+func (src *fileSource) setDefaults(o *fileObject) {
+	obj := o.obj
+	kind := kindOf(obj)
+
+	if obj.GetNamespace() == "" && kind != "Environment" {
+		obj.SetNamespace(config.Namespace)
+	}
+	if obj.GetUID() == "" {
+		obj.SetUID(types.UID(strings.ToLower(kind) + "-" + obj.GetName()))
+	}
+	obj.SetGeneration(o.gen)
+	obj.SetResourceVersion(strconv.FormatInt(o.gen, 10))
+
+	// Releases are activated immediately, there is no operator to wait for the
+	// AppDeployments to become available.
+	if ve, ok := obj.(*v1alpha1.VirtualEnvironment); ok &&
+		ve.Status.ActiveRelease == nil && ve.Spec.Release != nil {
+
+		now := metav1.Now()
+		ve.Status.ActiveRelease = &v1alpha1.ReleaseStatus{
+			Release:        *ve.Spec.Release,
+			Id:             fmt.Sprintf("%s-%d", ve.Name, o.gen),
+			RequestTime:    now,
+			ActivationTime: &now,
+		}
+	}
+}
+
+// signature returns a string that changes when any of the files are modified,
+// added or removed.
+func (src *fileSource) signature() (string, error) {
+	var sig strings.Builder
+	err := filepath.WalkDir(src.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&sig, "%s:%d:%d;", path, info.Size(), info.ModTime().UnixNano())
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if src.secretsFile != "" {
+		if info, err := os.Stat(src.secretsFile); err == nil {
+			fmt.Fprintf(&sig, "%s:%d:%d;", src.secretsFile, info.Size(), info.ModTime().UnixNano())
+		}
+	}
+
+	return sig.String(), nil
+}
+
+func kindOf(obj runtime.Object) string {
+	return reflect.TypeOf(obj).Elem().Name()
+}
@@ -18,6 +18,7 @@ import (
 
 	"github.com/xigxog/kubefox/api"
 	"github.com/xigxog/kubefox/components/broker/config"
+	brktel "github.com/xigxog/kubefox/components/broker/telemetry"
 	"github.com/xigxog/kubefox/core"
 	"github.com/xigxog/kubefox/grpc"
 	"github.com/xigxog/kubefox/logkf"
@@ -25,12 +26,16 @@ import (
 	gogrpc "google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/reflection"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/proto"
 )
 
+// Size of the chunks offloaded content is streamed to Components in.
+const contentChunkSize = 1024 * 1024
+
 type GRPCServer struct {
 	grpc.UnimplementedBrokerServer
 	// otelgrpc.UnimplementedTraceServiceServer
@@ -58,12 +63,21 @@ func NewGRPCServer(brk Broker) *GRPCServer {
 func (srv *GRPCServer) Start(ctx context.Context) error {
 	srv.log.Debug("grpc server starting")
 
-	creds, err := credentials.NewServerTLSFromFile(api.PathTLSCert, api.PathTLSKey)
-	if err != nil {
-		return core.ErrUnexpected(err)
+	creds := insecure.NewCredentials()
+	if !config.Insecure {
+		var err error
+		if creds, err = credentials.NewServerTLSFromFile(api.PathTLSCert, api.PathTLSKey); err != nil {
+			return core.ErrUnexpected(err)
+		}
+	}
+	maxEventSize := config.MaxEventSize
+	if config.OffloadThreshold > 0 {
+		maxEventSize = max(config.OffloadMaxSize, maxEventSize)
 	}
 	srv.wrapped = gogrpc.NewServer(
 		gogrpc.Creds(creds),
+		gogrpc.MaxRecvMsgSize(grpc.MaxMsgSize(maxEventSize)),
+		gogrpc.MaxSendMsgSize(grpc.MaxMsgSize(maxEventSize)),
 		// gogrpc.StreamInterceptor(otelgrpc.StreamServerInterceptor()),
 	)
 
@@ -112,6 +126,10 @@ func (srv *GRPCServer) Shutdown(timeout time.Duration) {
 }
 
 func (srv *GRPCServer) Subscribe(stream grpc.Broker_SubscribeServer) error {
+	streams := brktel.GRPCStreams.WithLabelValues("Subscribe")
+	streams.Inc()
+	defer streams.Dec()
+
 	sub, err := srv.subscribe(stream)
 
 	l := srv.log
@@ -242,6 +260,29 @@ func (srv *GRPCServer) subscribe(stream grpc.Broker_SubscribeServer) (ReplicaSub
 			}
 			evt.Source.BrokerId = srv.brk.Component().Id
 
+			if evt.Category == core.Category_MESSAGE &&
+				evt.Type == string(api.EventTypeHealth) &&
+				evt.Target.Equal(srv.brk.Component()) {
+
+				status := &api.ReplicaStatus{}
+				if len(evt.Content) > 0 {
+					if err := evt.Bind(status); err != nil {
+						l.Debugf("unable to read heartbeat reply: %v", err)
+					}
+				}
+				sub.Seen(status)
+				continue
+			}
+			sub.Seen(nil)
+
+			if evt.Category == core.Category_REQUEST &&
+				evt.Type == string(api.EventTypeContent) &&
+				evt.Target.Equal(srv.brk.Component()) {
+
+				go srv.sendContent(stream.Context(), sub, evt, sendEvt)
+				continue
+			}
+
 			var err *core.Err
 			// TODO move routing to broker
 			if evt.Category == core.Category_MESSAGE &&
@@ -260,6 +301,11 @@ func (srv *GRPCServer) subscribe(stream grpc.Broker_SubscribeServer) (ReplicaSub
 				<-ctx.Done()
 				err = ctx.CoreErr()
 			}
+			if evt.Category == core.Category_RESPONSE {
+				// Completed after routing so the request is in flight while
+				// the response is validated.
+				sub.Complete(evt)
+			}
 
 			if err != nil &&
 				evt.Category == core.Category_REQUEST &&
@@ -282,6 +328,40 @@ func (srv *GRPCServer) subscribe(stream grpc.Broker_SubscribeServer) (ReplicaSub
 	}
 }
 
+func (srv *GRPCServer) Tap(req *core.TapRequest, stream grpc.Broker_TapServer) error {
+	streams := brktel.GRPCStreams.WithLabelValues("Tap")
+	streams.Inc()
+	defer streams.Dec()
+
+	md, found := metadata.FromIncomingContext(stream.Context())
+	if !found {
+		return core.ErrUnauthorized(fmt.Errorf("gRPC metadata missing"))
+	}
+	token, err := getMD(md, api.GRPCKeyToken, !standalone())
+	if err != nil {
+		return core.ErrUnauthorized(err)
+	}
+	if err := srv.brk.AuthorizeTap(stream.Context(), token, req); err != nil {
+		srv.log.Debug(err)
+		return core.ErrUnauthorized(err)
+	}
+
+	evtCh, err := srv.brk.Tap(stream.Context(), req)
+	if err != nil {
+		return err
+	}
+
+	srv.log.Infof("tap opened for VirtualEnvironment '%s'", req.VirtualEnvironment)
+	for evt := range evtCh {
+		if err := stream.Send(evt); err != nil {
+			return err
+		}
+	}
+	srv.log.Infof("tap closed for VirtualEnvironment '%s'", req.VirtualEnvironment)
+
+	return nil
+}
+
 func parseMD(stream grpc.Broker_SubscribeServer) (*Metadata, error) {
 	md, found := metadata.FromIncomingContext(stream.Context())
 	if !found {
@@ -317,7 +397,8 @@ func parseMD(stream grpc.Broker_SubscribeServer) (*Metadata, error) {
 	if err != nil {
 		return nil, err
 	}
-	token, err := getMD(md, api.GRPCKeyToken, true)
+	// Components connecting to a standalone Broker do not have a token.
+	token, err := getMD(md, api.GRPCKeyToken, !standalone())
 	if err != nil {
 		return nil, err
 	}
@@ -352,3 +433,65 @@ func getMD(md metadata.MD, key string, required bool) (string, error) {
 
 	return "", nil
 }
+
+// sendContent streams offloaded content requested by the replica in chunks
+// no larger than contentChunkSize, the last chunk sets the 'contentEnd' value.
+// Only content of events sent to the replica can be requested.
+func (srv *GRPCServer) sendContent(ctx context.Context, sub ReplicaSubscription, req *core.Event, sendEvt SendEvent) {
+	log := srv.log.WithComponent(sub.Component()).WithEvent(req)
+	send := func(evt *core.Event) bool {
+		if err := sendEvt(&BrokerEventContext{Event: evt}); err != nil {
+			log.Debugf("unable to send content: %v", err)
+			return false
+		}
+		return true
+	}
+	sendErr := func(err error) {
+		send(core.NewErr(err, core.EventOpts{
+			Parent: req,
+			Source: srv.brk.Component(),
+			Target: req.Source,
+		}))
+	}
+
+	ref := req.Value(api.ValKeyContentRef)
+	if !sub.TakeContentRef(ref) {
+		sendErr(core.ErrUnauthorized(fmt.Errorf("content was not sent to component")))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, req.TTL())
+	defer cancel()
+
+	r, err := srv.brk.OpenContent(ctx, ref)
+	if err != nil {
+		sendErr(err)
+		return
+	}
+	defer r.Close()
+
+	buf := make([]byte, min(contentChunkSize, config.MaxEventSize))
+	for {
+		n, err := io.ReadFull(r, buf)
+		end := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !end {
+			sendErr(core.ErrUnexpected(fmt.Errorf("reading content failed: %w", err)))
+			return
+		}
+
+		chunk := core.NewResp(core.EventOpts{
+			Type:   api.EventTypeContent,
+			Parent: req,
+			Source: srv.brk.Component(),
+			Target: req.Source,
+		})
+		// The chunk is marshaled by the stream before the buffer is reused.
+		chunk.Content = buf[:n]
+		if end {
+			chunk.SetValue(api.ValKeyContentEnd, "true")
+		}
+		if !send(chunk) || end {
+			return
+		}
+	}
+}
@@ -0,0 +1,293 @@
+// Copyright 2024 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/xigxog/kubefox/api"
+	"github.com/xigxog/kubefox/components/broker/config"
+	"github.com/xigxog/kubefox/core"
+)
+
+// Number of heartbeat intervals a replica can go without sending an event
+// before it is ejected.
+const heartbeatMisses = 3
+
+// HealthInfo describes the health of a replica as tracked by the Broker.
+type HealthInfo struct {
+	Ejected bool   `json:"ejected"`
+	Reason  string `json:"reason,omitempty"`
+	// Time the ejection expires, zero if the replica is ejected until it
+	// replies to heartbeats again.
+	EjectedUntil time.Time `json:"ejectedUntil,omitempty"`
+	// Consecutive errors and timeouts of requests sent to the replica.
+	Failures int       `json:"failures"`
+	LastSeen time.Time `json:"lastSeen"`
+}
+
+// replicaHealth tracks the liveness of a replica and the results of the
+// requests sent to it.
+type replicaHealth struct {
+	lastSeen time.Time
+	// Set once the replica replies to a heartbeat. Replicas that never reply
+	// are not ejected for missing heartbeats.
+	heartbeat bool
+	// Set if the last heartbeat reply reported stalled workers.
+	stalled      bool
+	failures     int
+	ejected      bool
+	ejectedUntil time.Time
+	reason       string
+
+	// Signaled when the replica is ejected or reinstated.
+	changed chan struct{}
+
+	mutex sync.Mutex
+}
+
+func newReplicaHealth() *replicaHealth {
+	return &replicaHealth{
+		lastSeen: time.Now(),
+		changed:  make(chan struct{}, 1),
+	}
+}
+
+func (h *replicaHealth) info() *HealthInfo {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return &HealthInfo{
+		Ejected:      h.ejected,
+		Reason:       h.reason,
+		EjectedUntil: h.ejectedUntil,
+		Failures:     h.failures,
+		LastSeen:     h.lastSeen,
+	}
+}
+
+func (h *replicaHealth) isEjected() bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return h.ejected
+}
+
+func (h *replicaHealth) notify() {
+	select {
+	case h.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Seen records an event received from the replica. A heartbeat reply
+// reporting a request has waited for a worker longer than the heartbeat
+// interval means the workers of the replica are stalled, events are not
+// counted until a reply reports otherwise so the replica is ejected once it
+// misses enough heartbeats.
+func (sub *subscription) Seen(status *api.ReplicaStatus) {
+	sub.health.mutex.Lock()
+	defer sub.health.mutex.Unlock()
+
+	if status == nil {
+		if !sub.health.stalled {
+			sub.health.lastSeen = time.Now()
+		}
+		return
+	}
+
+	sub.health.heartbeat = true
+	sub.health.stalled = config.HeartbeatInterval > 0 &&
+		time.Duration(status.QueuedMillis)*time.Millisecond > config.HeartbeatInterval
+	if !sub.health.stalled {
+		sub.health.lastSeen = time.Now()
+	}
+}
+
+func (sub *subscription) Health() *HealthInfo {
+	return sub.health.info()
+}
+
+// CheckHealth ejects the replica if it stopped sending events for
+// heartbeatMisses heartbeat intervals or its requests timed out too often.
+// Replicas are reinstated once their ejection expires or, if ejected for
+// missing heartbeats, once they are seen again.
+func (sub *subscription) CheckHealth() *HealthInfo {
+	timeouts := 0
+	if sub.inFlight.count(); config.OutlierThreshold > 0 {
+		timeouts = sub.inFlight.takeExpired()
+	}
+
+	h := sub.health
+	h.mutex.Lock()
+	now := time.Now()
+	since := now.Sub(h.lastSeen)
+	silent := h.heartbeat && config.HeartbeatInterval > 0 &&
+		since > heartbeatMisses*config.HeartbeatInterval
+	switch {
+	case !h.ejected && silent && h.stalled:
+		h.mutex.Unlock()
+		sub.eject(fmt.Sprintf("workers stalled for %s", since.Round(time.Second)), time.Time{})
+
+	case !h.ejected && silent:
+		h.mutex.Unlock()
+		sub.eject(fmt.Sprintf("no events received for %s", since.Round(time.Second)), time.Time{})
+
+	case !h.ejected && timeouts > 0:
+		h.mutex.Unlock()
+		sub.recordFailures(timeouts, "requests timed out")
+
+	case h.ejected && h.ejectedUntil.IsZero() && !silent,
+		h.ejected && !h.ejectedUntil.IsZero() && now.After(h.ejectedUntil):
+		h.mutex.Unlock()
+		sub.reinstate()
+
+	default:
+		h.mutex.Unlock()
+	}
+
+	return h.info()
+}
+
+// recordResult counts the response or send error of a request sent to the
+// replica. Errors caused by the request, such as invalid input, are not
+// counted.
+func (sub *subscription) recordResult(err error) {
+	if config.OutlierThreshold <= 0 {
+		return
+	}
+
+	kfErr := &core.Err{}
+	switch {
+	case err == nil:
+		sub.health.mutex.Lock()
+		sub.health.failures = 0
+		sub.health.mutex.Unlock()
+
+	case !errors.As(err, &kfErr) || kfErr.HTTPCode() >= http.StatusInternalServerError:
+		sub.recordFailures(1, "requests failed")
+	}
+}
+
+func (sub *subscription) recordFailures(n int, reason string) {
+	sub.health.mutex.Lock()
+	sub.health.failures += n
+	failures := sub.health.failures
+	sub.health.mutex.Unlock()
+
+	if failures >= config.OutlierThreshold {
+		sub.eject(fmt.Sprintf("%d consecutive %s", failures, reason),
+			time.Now().Add(config.OutlierEjectionTime))
+	}
+}
+
+// eject stops events for the Component from being sent to the replica. A
+// replica is not ejected if it is the last replica of its group that is not
+// ejected, as there would be nothing to send the events to.
+func (sub *subscription) eject(reason string, until time.Time) {
+	log := sub.mgr.log.WithComponent(sub.comp)
+
+	// The lock of the manager is held so replicas of the same group are not
+	// ejected at the same time.
+	sub.mgr.mutex.Lock()
+	if !sub.mgr.canEject(sub) {
+		sub.mgr.mutex.Unlock()
+		log.Warnf("replica unhealthy, %s, but not ejected as it is the last healthy replica", reason)
+		return
+	}
+	sub.health.mutex.Lock()
+	if sub.health.ejected {
+		sub.health.mutex.Unlock()
+		sub.mgr.mutex.Unlock()
+		return
+	}
+	sub.health.ejected = true
+	sub.health.ejectedUntil = until
+	sub.health.reason = reason
+	sub.health.failures = 0
+	sub.health.mutex.Unlock()
+	sub.mgr.mutex.Unlock()
+	sub.health.notify()
+
+	log.Warnf("replica ejected, %s", reason)
+}
+
+func (sub *subscription) reinstate() {
+	sub.health.mutex.Lock()
+	if !sub.health.ejected {
+		sub.health.mutex.Unlock()
+		return
+	}
+	sub.health.ejected = false
+	sub.health.ejectedUntil = time.Time{}
+	sub.health.reason = ""
+	sub.health.mutex.Unlock()
+	sub.health.notify()
+
+	sub.mgr.log.WithComponent(sub.comp).Info("replica reinstated")
+}
+
+// canEject returns true if the group of the replica has another active replica
+// that is not ejected. The lock of the manager must be held.
+func (mgr *subscriptionMgr) canEject(sub *subscription) bool {
+	grp := mgr.grpMap[sub.comp.GroupKey()]
+	if grp == nil {
+		return true
+	}
+	for _, s := range grp.subMap {
+		if s != sub && s.IsActive() && !s.health.isEjected() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// startHeartbeats sends a heartbeat to each subscribed replica every
+// heartbeat interval. The heartbeat contains the health of the replica so it
+// reports itself not ready while ejected.
+func (brk *broker) startHeartbeats() {
+	if config.HeartbeatInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(config.HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			for _, sub := range brk.subMgr.Subscriptions() {
+				brk.sendHeartbeat(sub, sub.CheckHealth())
+			}
+		case <-brk.ctx.Done():
+			return
+		}
+	}
+}
+
+func (brk *broker) sendHeartbeat(sub ReplicaSubscription, info *HealthInfo) {
+	evt := core.NewMsg(core.EventOpts{
+		Type:   api.EventTypeHealth,
+		Source: brk.comp,
+		Target: sub.Component(),
+	})
+	if err := evt.SetJSON(&api.ReplicaHealth{Ejected: info.Ejected, Reason: info.Reason}); err != nil {
+		brk.log.Error(err)
+		return
+	}
+
+	if err := sub.SendEvent(&BrokerEventContext{Event: evt}); err != nil {
+		brk.log.WithComponent(sub.Component()).Debugf("unable to send heartbeat: %v", err)
+	}
+}
@@ -0,0 +1,268 @@
+// Copyright 2024 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package engine
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/xigxog/kubefox/api"
+	"github.com/xigxog/kubefox/cache"
+	"github.com/xigxog/kubefox/core"
+	"github.com/xigxog/kubefox/logkf"
+	"google.golang.org/protobuf/proto"
+)
+
+// idempotencyRecord is stored in the idempotency bucket. While the first
+// request is in flight only Deadline is set, once the response is received it
+// is stored in Response. Delivered is set by the Broker that delivers a request
+// received from another Broker to its target.
+type idempotencyRecord struct {
+	Deadline  int64  `json:"deadline,omitempty"`
+	Delivered bool   `json:"delivered,omitempty"`
+	Response  []byte `json:"response,omitempty"`
+}
+
+// idempotencyMgr deduplicates requests that provide an idempotency key. The
+// first response for a key is stored in a NATS key value bucket and returned
+// to duplicates. Duplicates received while the first request is in flight are
+// held until its response is stored.
+type idempotencyMgr struct {
+	kv jetstream.KeyValue
+
+	// Maps id of in flight requests to their key.
+	pending cache.Cache[string]
+
+	// Large responses are stored with a reference to their offloaded content.
+	offload   func(context.Context, *core.Event) (*core.Event, error)
+	recvEvent func(*core.Event, Receiver) *BrokerEventContext
+
+	log *logkf.Logger
+}
+
+func newIdempotencyMgr(kv jetstream.KeyValue, window time.Duration,
+	offload func(context.Context, *core.Event) (*core.Event, error),
+	recvEvent func(*core.Event, Receiver) *BrokerEventContext) *idempotencyMgr {
+
+	return &idempotencyMgr{
+		kv:        kv,
+		pending:   cache.New[string](window),
+		offload:   offload,
+		recvEvent: recvEvent,
+		log:       logkf.Global,
+	}
+}
+
+// Check returns true if the request is a duplicate. The stored response of
+// duplicates is sent to the source of the request once available. If the
+// request is not a duplicate it is marked as in flight.
+func (mgr *idempotencyMgr) Check(ctx *BrokerEventContext) (bool, error) {
+	key := mgr.key(ctx)
+	if key == "" {
+		return false, nil
+	}
+
+	inFlight, _ := json.Marshal(&idempotencyRecord{
+		Deadline: time.Now().Add(ctx.TTL()).UnixNano(),
+	})
+	_, err := mgr.kv.Create(ctx, key, inFlight)
+	if err == nil {
+		mgr.pending.Set(ctx.Event.Id, key)
+		return false, nil
+	}
+	if !errors.Is(err, jetstream.ErrKeyExists) {
+		return false, core.ErrUnexpected(err)
+	}
+
+	entry, err := mgr.kv.Get(ctx, key)
+	if err != nil {
+		return false, core.ErrUnexpected(err)
+	}
+	rec := &idempotencyRecord{}
+	if err := json.Unmarshal(entry.Value(), rec); err != nil {
+		return false, core.ErrUnexpected(err)
+	}
+
+	// First request never completed, take its place.
+	if rec.Response == nil && time.Now().UnixNano() > rec.Deadline {
+		if _, err := mgr.kv.Update(ctx, key, inFlight, entry.Revision()); err != nil {
+			return false, core.ErrUnexpected(err)
+		}
+		mgr.pending.Set(ctx.Event.Id, key)
+		return false, nil
+	}
+
+	go mgr.respond(ctx.Event, key, rec)
+
+	return true, nil
+}
+
+// CheckDelivery returns true if a request received from another Broker was
+// already delivered, e.g. it was redelivered by JetStream. The stored response
+// of redelivered requests is sent to the source of the request once available.
+// Otherwise the request is marked as delivered. Requests whose first delivery
+// did not complete before their deadline are delivered again.
+func (mgr *idempotencyMgr) CheckDelivery(ctx *BrokerEventContext) (bool, error) {
+	key := mgr.key(ctx)
+	if key == "" {
+		return false, nil
+	}
+
+	entry, err := mgr.kv.Get(ctx, key)
+	switch {
+	case errors.Is(err, jetstream.ErrKeyNotFound):
+		// First request failed or key expired.
+		return false, nil
+	case err != nil:
+		return false, core.ErrUnexpected(err)
+	}
+	rec := &idempotencyRecord{}
+	if err := json.Unmarshal(entry.Value(), rec); err != nil {
+		return false, core.ErrUnexpected(err)
+	}
+
+	if rec.Response == nil && (!rec.Delivered || time.Now().UnixNano() > rec.Deadline) {
+		delivered, _ := json.Marshal(&idempotencyRecord{
+			Deadline:  time.Now().Add(ctx.TTL()).UnixNano(),
+			Delivered: true,
+		})
+		_, err := mgr.kv.Update(ctx, key, delivered, entry.Revision())
+		switch {
+		case err == nil:
+			mgr.pending.Set(ctx.Event.Id, key)
+			return false, nil
+		case !errors.Is(err, jetstream.ErrKeyExists):
+			return false, core.ErrUnexpected(err)
+		}
+		// Another delivery of the request marked it first.
+		rec = &idempotencyRecord{}
+	}
+
+	go mgr.respond(ctx.Event, key, rec)
+
+	return true, nil
+}
+
+// Complete stores the response if it is for a request with an idempotency key.
+func (mgr *idempotencyMgr) Complete(ctx *BrokerEventContext) {
+	key, found := mgr.pending.Get(ctx.Event.ParentId)
+	if !found {
+		return
+	}
+	mgr.pending.Delete(ctx.Event.ParentId)
+
+	resp, err := mgr.offload(ctx, ctx.Event)
+	if err != nil {
+		ctx.Log.Warnf("unable to store response for idempotency key: %v", err)
+		return
+	}
+	b, err := proto.Marshal(resp)
+	if err != nil {
+		ctx.Log.Warnf("unable to store response for idempotency key: %v", err)
+		return
+	}
+	rec, _ := json.Marshal(&idempotencyRecord{Response: b})
+	if _, err := mgr.kv.Put(ctx, key, rec); err != nil {
+		ctx.Log.Warnf("unable to store response for idempotency key: %v", err)
+	}
+}
+
+// Abandon removes the key of a request that failed to route so it can be
+// retried.
+func (mgr *idempotencyMgr) Abandon(ctx *BrokerEventContext) {
+	key, found := mgr.pending.Get(ctx.Event.Id)
+	if !found {
+		return
+	}
+	mgr.pending.Delete(ctx.Event.Id)
+
+	if err := mgr.kv.Delete(context.Background(), key); err != nil {
+		ctx.Log.Warnf("unable to remove idempotency key: %v", err)
+	}
+}
+
+func (mgr *idempotencyMgr) respond(req *core.Event, key string, rec *idempotencyRecord) {
+	log := mgr.log.WithEvent(req)
+
+	if rec.Response == nil {
+		log.Debug("request with idempotency key in flight, waiting for response")
+
+		ctx, cancel := context.WithTimeout(context.Background(), req.TTL())
+		defer cancel()
+
+		w, err := mgr.kv.Watch(ctx, key, jetstream.UpdatesOnly())
+		if err != nil {
+			log.Warnf("unable to watch idempotency key: %v", err)
+			return
+		}
+		defer w.Stop()
+
+		for rec.Response == nil {
+			select {
+			case entry := <-w.Updates():
+				if entry == nil {
+					continue
+				}
+				if entry.Operation() != jetstream.KeyValuePut {
+					log.Debug("idempotency key removed, first request failed")
+					return
+				}
+				if err := json.Unmarshal(entry.Value(), rec); err != nil {
+					log.Warnf("idempotency record is invalid: %v", err)
+					return
+				}
+
+			case <-ctx.Done():
+				log.Debug("timed out waiting for response of request with idempotency key")
+				return
+			}
+		}
+	}
+
+	resp := core.NewEvent()
+	if err := proto.Unmarshal(rec.Response, resp); err != nil {
+		log.Warnf("stored response is invalid: %v", err)
+		return
+	}
+	resp.Id = uuid.NewString()
+	resp.ParentId = req.Id
+	resp.ParentSpan = req.ParentSpan
+	resp.Target = proto.Clone(req.Source).(*core.Component)
+	resp.CreateTime = time.Now().UnixNano()
+	resp.SetTTL(req.TTL())
+	resp.SetContext(req.Context)
+
+	log.Debug("returning stored response to duplicate request")
+	mgr.recvEvent(resp, ReceiverIdempotencyMgr)
+}
+
+// key returns the idempotency key of the event scoped to the
+// VirtualEnvironment and route. If the event does not have an idempotency key
+// an empty string is returned.
+func (mgr *idempotencyMgr) key(ctx *BrokerEventContext) string {
+	k := ctx.Event.Header(api.HeaderIdempotencyKey)
+	if k == "" {
+		k = ctx.Event.Value(api.ValKeyIdempotencyKey)
+	}
+	if k == "" || ctx.Event.Context == nil || ctx.Event.Target == nil {
+		return ""
+	}
+
+	h := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%d|%s",
+		ctx.Event.Context.VirtualEnvironment, ctx.Event.Target.GroupKey(), ctx.RouteId, k)))
+
+	return hex.EncodeToString(h[:])
+}
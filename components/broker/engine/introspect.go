@@ -0,0 +1,132 @@
+// Copyright 2024 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package engine
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/xigxog/kubefox/cache"
+	"github.com/xigxog/kubefox/components/broker/config"
+	"github.com/xigxog/kubefox/core"
+)
+
+// SubscriptionInfo describes a replica subscribed to the Broker.
+type SubscriptionInfo struct {
+	Component    *core.Component `json:"component"`
+	GroupEnabled bool            `json:"groupEnabled"`
+	// Requests sent to the replica that it has not responded to.
+	InFlight int         `json:"inFlight"`
+	Health   *HealthInfo `json:"health"`
+}
+
+// RouteInfo describes a route of the release matcher.
+type RouteInfo struct {
+	Id                 int    `json:"id"`
+	Rule               string `json:"rule"`
+	Priority           int    `json:"priority"`
+	Component          string `json:"component"`
+	VirtualEnvironment string `json:"virtualEnvironment"`
+	AppDeployment      string `json:"appDeployment"`
+	ReleaseManifest    string `json:"releaseManifest,omitempty"`
+}
+
+// InFlightInfo contains the number of events being processed by the Broker.
+type InFlightInfo struct {
+	// Queued events are waiting for a worker.
+	Queued int64 `json:"queued"`
+	// Routing events are being matched and sent by a worker.
+	Routing int64 `json:"routing"`
+	// Pending requests were routed but their response has not been routed.
+	Pending  int  `json:"pending"`
+	Workers  int  `json:"workers"`
+	Draining bool `json:"draining"`
+}
+
+func (brk *broker) Subscriptions() []*SubscriptionInfo {
+	subs := brk.subMgr.Subscriptions()
+	list := make([]*SubscriptionInfo, len(subs))
+	for i, sub := range subs {
+		list[i] = &SubscriptionInfo{
+			Component:    sub.Component(),
+			GroupEnabled: sub.IsGroupEnabled(),
+			InFlight:     sub.InFlight(),
+			Health:       sub.Health(),
+		}
+	}
+
+	return list
+}
+
+func (brk *broker) SubscriptionGroups() []*GroupInfo {
+	return brk.subMgr.Groups()
+}
+
+// ReleaseRoutes returns the routes of the release matcher in the order they
+// are tested.
+func (brk *broker) ReleaseRoutes(ctx context.Context) ([]*RouteInfo, error) {
+	m, err := brk.store.ReleaseMatcher(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	routes := m.Routes()
+	list := make([]*RouteInfo, len(routes))
+	for i, r := range routes {
+		list[i] = &RouteInfo{
+			Id:        r.Id,
+			Rule:      r.ResolvedRule,
+			Priority:  r.Priority,
+			Component: r.Component.GroupKey(),
+		}
+		if r.EventContext != nil {
+			list[i].VirtualEnvironment = r.EventContext.VirtualEnvironment
+			list[i].AppDeployment = r.EventContext.AppDeployment
+			list[i].ReleaseManifest = r.EventContext.ReleaseManifest
+		}
+	}
+
+	return list, nil
+}
+
+func (brk *broker) ReloadReleaseMatcher(ctx context.Context) error {
+	return brk.store.ReloadReleaseMatcher(ctx)
+}
+
+func (brk *broker) Caches() map[string][]cache.Entry {
+	return brk.store.Caches()
+}
+
+func (brk *broker) EvictCache(name string) error {
+	return brk.store.EvictCache(name)
+}
+
+func (brk *broker) InFlight() *InFlightInfo {
+	return &InFlightInfo{
+		Queued:   brk.queued.Load(),
+		Routing:  brk.routing.Load(),
+		Pending:  brk.pending.count(),
+		Workers:  config.NumWorkers,
+		Draining: brk.draining.Load(),
+	}
+}
+
+// DisconnectReplica cancels the subscription of the replica with the given
+// id. The replica is expected to reconnect.
+func (brk *broker) DisconnectReplica(id string) error {
+	sub, found := brk.subMgr.ReplicaSubscription(&core.Component{Id: id})
+	if !found {
+		return core.ErrNotFound(fmt.Errorf("subscription of replica '%s' not found", id))
+	}
+
+	brk.log.WithComponent(sub.Component()).Warn("disconnecting replica by admin request")
+	sub.Cancel(core.ErrComponentGone(fmt.Errorf("disconnected by admin")))
+
+	return nil
+}
@@ -0,0 +1,130 @@
+// Copyright 2024 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package engine
+
+import (
+	"context"
+	"time"
+
+	"github.com/xigxog/kubefox/api"
+	"github.com/xigxog/kubefox/api/kubernetes/v1alpha1"
+	"github.com/xigxog/kubefox/components/broker/config"
+	"github.com/xigxog/kubefox/logkf"
+	"github.com/xigxog/kubefox/vault"
+	"k8s.io/client-go/kubernetes/scheme"
+	toolscache "k8s.io/client-go/tools/cache"
+	ctrl "sigs.k8s.io/controller-runtime"
+	ctrlcache "sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// k8sSource provides resources from an informer cache of the Platform's
+// namespace and secrets from Vault.
+type k8sSource struct {
+	ctrlcache.Cache
+
+	vaultCli *vault.Client
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	log *logkf.Logger
+}
+
+func NewK8sSource() ResourceSource {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &k8sSource{
+		ctx:    ctx,
+		cancel: cancel,
+		log:    logkf.Global,
+	}
+}
+
+func (src *k8sSource) Open(initCtx context.Context, hdlr toolscache.ResourceEventHandler) error {
+	key := vault.Key{
+		Instance:  config.Instance,
+		Namespace: config.Namespace,
+		Component: api.PlatformComponentBroker,
+	}
+
+	vaultCli, err := vault.New(vault.ClientOptions{
+		Instance:  config.Instance,
+		Role:      vault.RoleName(key),
+		URL:       config.VaultURL,
+		CACert:    api.PathCACert,
+		TokenPath: config.TokenPath,
+	})
+	if err != nil {
+		return err
+	}
+	src.vaultCli = vaultCli
+
+	if err := v1alpha1.AddToScheme(scheme.Scheme); err != nil {
+		return src.log.ErrorN("adding KubeFox CRs to scheme failed: %v", err)
+	}
+
+	cfg, err := ctrl.GetConfig()
+	if err != nil {
+		return src.log.ErrorN("loading K8s config failed: %v", err)
+	}
+
+	src.Cache, err = ctrlcache.New(cfg, ctrlcache.Options{
+		Scheme:                      scheme.Scheme,
+		DefaultNamespaces:           map[string]ctrlcache.Config{config.Namespace: {}},
+		ReaderFailOnMissingInformer: true,
+	})
+	if err != nil {
+		return src.log.ErrorN("creating resource cache failed: %v", err)
+	}
+
+	err = src.initInformers(initCtx, hdlr,
+		&v1alpha1.Environment{},
+		&v1alpha1.VirtualEnvironment{},
+		&v1alpha1.ReleaseManifest{},
+		&v1alpha1.HTTPAdapter{},
+		&v1alpha1.AppDeployment{},
+		&v1alpha1.Platform{},
+	)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		if err := src.Cache.Start(src.ctx); err != nil {
+			src.log.Error(err)
+		}
+	}()
+	src.Cache.WaitForCacheSync(initCtx)
+
+	return nil
+}
+
+func (src *k8sSource) initInformers(ctx context.Context, hdlr toolscache.ResourceEventHandler, objs ...client.Object) error {
+	for _, obj := range objs {
+		// Getting the informer adds it to the cache.
+		if inf, err := src.Cache.GetInformer(ctx, obj); err != nil {
+			return err
+		} else {
+			inf.AddEventHandler(hdlr)
+		}
+	}
+	return nil
+}
+
+func (src *k8sSource) Close() {
+	src.cancel()
+}
+
+func (src *k8sSource) GetData(ctx context.Context, key api.DataKey, data *api.Data) error {
+	return src.vaultCli.GetData(ctx, key, data)
+}
+
+func (src *k8sSource) DataVersion(ctx context.Context, key api.DataKey) (int64, time.Time, error) {
+	return src.vaultCli.DataVersion(ctx, key)
+}
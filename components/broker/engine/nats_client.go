@@ -11,12 +11,15 @@ package engine
 import (
 	"context"
 	"fmt"
+	"io"
 	"sync"
 	"time"
 
 	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
 	"github.com/xigxog/kubefox/api"
 	"github.com/xigxog/kubefox/components/broker/config"
+	brktel "github.com/xigxog/kubefox/components/broker/telemetry"
 	"github.com/xigxog/kubefox/core"
 	"github.com/xigxog/kubefox/logkf"
 	"google.golang.org/protobuf/proto"
@@ -30,20 +33,29 @@ const (
 	natsSvcName          = "nats-client"
 	eventSubjectWildcard = "evt.>"
 	compBucket           = "COMPONENTS"
+	eventStream          = "EVENTS"
+	idempotencyBucket    = "IDEMPOTENCY"
+	rateLimitBucket      = "RATE_LIMIT"
+	recordSubject        = "rec"
 )
 
 var (
 	EventStreamTTL = time.Hour * 24 * 3 // 3 days
 	ComponentsTTL  = time.Hour * 12     // 12 hours
+	RateLimitTTL   = time.Hour * 24     // 24 hours
 )
 
 type RecvMsg func(*nats.Msg)
 
 type NATSClient struct {
 	nc *nats.Conn
+	js jetstream.JetStream
 
 	consumerMap map[string]bool
 
+	// Set if the content of large events is offloaded.
+	content *contentStore
+
 	brk Broker
 
 	mutex sync.Mutex
@@ -71,20 +83,46 @@ func (c *NATSClient) Connect(ctx context.Context) error {
 
 	var err error
 
-	c.nc, err = nats.Connect(
-		fmt.Sprintf("nats://%s", config.NATSAddr),
-		nats.Name("broker-"+c.brk.Component().Id),
-		nats.RootCAs(api.PathCACert),
-		nats.ClientCert(api.PathTLSCert, api.PathTLSKey),
-	)
+	opts := []nats.Option{nats.Name("broker-" + c.brk.Component().Id)}
+	if !config.Insecure {
+		opts = append(opts,
+			nats.RootCAs(api.PathCACert),
+			nats.ClientCert(api.PathTLSCert, api.PathTLSKey),
+		)
+	}
+
+	c.nc, err = nats.Connect(fmt.Sprintf("nats://%s", config.NATSAddr), opts...)
 	if err != nil {
 		return c.log.ErrorN("connecting to NATS failed: %v", err)
 	}
 
+	if c.js, err = jetstream.New(c.nc); err != nil {
+		return c.log.ErrorN("creating JetStream context failed: %v", err)
+	}
+	if config.RecordEvents {
+		if err := c.setupEventStream(ctx); err != nil {
+			return c.log.ErrorN("setting up event stream failed: %v", err)
+		}
+	}
+
 	c.log.Info("nats client connected")
 	return nil
 }
 
+func (c *NATSClient) setupEventStream(ctx context.Context) error {
+	_, err := c.js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:        eventStream,
+		Description: "Events routed by KubeFox Brokers, used for event replay.",
+		Subjects:    []string{recordSubject + ".>"},
+		Retention:   jetstream.LimitsPolicy,
+		Discard:     jetstream.DiscardOld,
+		MaxAge:      EventStreamTTL,
+		Storage:     jetstream.FileStorage,
+	})
+
+	return err
+}
+
 func (c *NATSClient) Close() {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
@@ -119,10 +157,167 @@ func (c *NATSClient) Publish(subject string, evt *core.Event) error {
 
 	c.log.Debugf("publishing nats msg to subj: %s", subject)
 
-	return c.nc.PublishMsg(msg)
+	if err := c.nc.PublishMsg(msg); err != nil {
+		brktel.NATSPublishErrors.Inc()
+		return err
+	}
+
+	return nil
+}
+
+// KeyValue creates or updates the key value bucket and returns it. Entries
+// expire after ttl.
+func (c *NATSClient) KeyValue(ctx context.Context, bucket string, ttl time.Duration, storage api.StorageType) (jetstream.KeyValue, error) {
+	st := jetstream.FileStorage
+	if storage == api.StorageTypeMemory {
+		st = jetstream.MemoryStorage
+	}
+
+	return c.js.CreateOrUpdateKeyValue(ctx, jetstream.KeyValueConfig{
+		Bucket:  bucket,
+		TTL:     ttl,
+		Storage: st,
+	})
+}
+
+// EnableOffload creates or updates the object store bucket holding the content
+// of large events. Once enabled content larger than threshold is stored in the
+// bucket when events are published and messages carry a reference instead.
+func (c *NATSClient) EnableOffload(ctx context.Context, threshold int64, storage api.StorageType) error {
+	st := jetstream.FileStorage
+	if storage == api.StorageTypeMemory {
+		st = jetstream.MemoryStorage
+	}
+
+	obs, err := c.js.CreateOrUpdateObjectStore(ctx, jetstream.ObjectStoreConfig{
+		Bucket:      contentBucket,
+		Description: "Content of large events routed by KubeFox Brokers.",
+		TTL:         EventStreamTTL,
+		Storage:     st,
+	})
+	if err != nil {
+		return err
+	}
+	c.content = newContentStore(obs, threshold)
+
+	return nil
+}
+
+// OpenContent returns a reader of content offloaded by a Broker.
+func (c *NATSClient) OpenContent(ctx context.Context, ref string) (io.ReadCloser, error) {
+	if c.content == nil {
+		return nil, core.ErrNotFound(fmt.Errorf("offloading is disabled"))
+	}
+
+	return c.content.Open(ctx, ref)
+}
+
+// OffloadContent returns a copy of the event referencing its stored content if
+// offloading is enabled and the content is larger than the threshold. Otherwise
+// the event is returned as is.
+func (c *NATSClient) OffloadContent(ctx context.Context, evt *core.Event) (*core.Event, error) {
+	if c.content == nil {
+		return evt, nil
+	}
+
+	return c.content.Offload(ctx, evt)
+}
+
+// RecordEvent publishes a copy of the event to the event stream with sensitive
+// headers masked. Recorded events can be queried and replayed using the Broker
+// admin API.
+func (c *NATSClient) RecordEvent(evt *core.Event) error {
+	evt = redactHeaders(evt, redactedHeaders, config.RecordRedactHeaders)
+	return c.Publish(fmt.Sprintf("%s.%s.%s", recordSubject, traceIdOf(evt), evt.Id), evt)
+}
+
+// QueryEvents reads recorded events from the event stream that match the
+// query. Matching events are passed to fn in the order they were recorded.
+// Reading stops once the end of the stream or query limit is reached or fn
+// returns an error.
+func (c *NATSClient) QueryEvents(ctx context.Context, q *EventQuery, fn func(*core.Event) error) error {
+	return c.queryStream(ctx, eventStream, q.Subject(), q,
+		func(_ jetstream.Msg, evt *core.Event) error {
+			return fn(evt)
+		})
+}
+
+// queryStream reads messages containing events from the stream that match
+// the subject filter and query. Matching messages and their events are passed
+// to fn in the order they were published.
+func (c *NATSClient) queryStream(ctx context.Context, stream, subject string, q *EventQuery,
+	fn func(jetstream.Msg, *core.Event) error) error {
+
+	cfg := jetstream.OrderedConsumerConfig{
+		FilterSubjects: []string{subject},
+		DeliverPolicy:  jetstream.DeliverAllPolicy,
+	}
+	if !q.Start.IsZero() {
+		cfg.DeliverPolicy = jetstream.DeliverByStartTimePolicy
+		cfg.OptStartTime = &q.Start
+	}
+
+	cons, err := c.js.OrderedConsumer(ctx, stream, cfg)
+	if err != nil {
+		return err
+	}
+
+	count := 0
+	for {
+		batch, err := cons.Fetch(100, jetstream.FetchMaxWait(time.Second))
+		if err != nil {
+			return err
+		}
+
+		pending, received := uint64(0), false
+		for msg := range batch.Messages() {
+			received = true
+
+			md, err := msg.Metadata()
+			if err != nil {
+				return err
+			}
+			pending = md.NumPending
+			if !q.End.IsZero() && md.Timestamp.After(q.End) {
+				return nil
+			}
+
+			evt := core.NewEvent()
+			if err := proto.Unmarshal(msg.Data(), evt); err != nil {
+				c.log.With(logkf.KeyEventId, msg.Headers().Get(CloudEventId)).
+					Warnf("message in stream '%s' contains invalid event data: %v", stream, err)
+				continue
+			}
+			if !q.Match(evt) {
+				continue
+			}
+			if err := fn(msg, evt); err != nil {
+				return err
+			}
+
+			count++
+			if q.Limit > 0 && count >= q.Limit {
+				return nil
+			}
+		}
+		if err := batch.Error(); err != nil {
+			return err
+		}
+		if !received || pending == 0 {
+			return nil
+		}
+	}
 }
 
 func (c *NATSClient) Msg(subject string, evt *core.Event) (*nats.Msg, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	evt, err := c.OffloadContent(ctx, evt)
+	if err != nil {
+		return nil, err
+	}
+
 	dataBytes, err := proto.Marshal(evt)
 	if err != nil {
 		return nil, err
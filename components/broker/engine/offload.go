@@ -0,0 +1,118 @@
+// Copyright 2024 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/xigxog/kubefox/api"
+	"github.com/xigxog/kubefox/cache"
+	brktel "github.com/xigxog/kubefox/components/broker/telemetry"
+	"github.com/xigxog/kubefox/core"
+	"github.com/xigxog/kubefox/logkf"
+)
+
+const (
+	contentBucket = "EVENT_CONTENT"
+)
+
+// contentStore offloads the content of large events to a NATS object store
+// bucket. Offloaded events carry the name of the object holding their content
+// in the 'contentRef' value, Components fetch the content from their Broker.
+// Objects expire with recorded events.
+type contentStore struct {
+	obs       jetstream.ObjectStore
+	threshold int64
+
+	// Ids of events with content already stored. Events are often published
+	// more than once, e.g. sent to another Broker and recorded.
+	stored cache.Cache[bool]
+
+	log *logkf.Logger
+}
+
+func newContentStore(obs jetstream.ObjectStore, threshold int64) *contentStore {
+	return &contentStore{
+		obs:       obs,
+		threshold: threshold,
+		stored:    cache.New[bool](time.Minute),
+		log:       logkf.Global,
+	}
+}
+
+// Offload stores the content of the event if it is larger than the threshold
+// and returns a copy of the event referencing the stored content. Other events
+// are returned as is.
+func (cs *contentStore) Offload(ctx context.Context, evt *core.Event) (*core.Event, error) {
+	if int64(len(evt.Content)) <= cs.threshold {
+		return evt, nil
+	}
+
+	if _, found := cs.stored.Get(evt.Id); !found {
+		if _, err := cs.obs.PutBytes(ctx, evt.Id, evt.Content); err != nil {
+			return nil, fmt.Errorf("storing event content failed: %w", err)
+		}
+		cs.stored.Set(evt.Id, true)
+		brktel.ContentOffloads.WithLabelValues("store").Inc()
+
+		cs.log.With(logkf.KeyEventId, evt.Id).
+			Debugf("offloaded %d bytes of event content", len(evt.Content))
+	}
+
+	cp := withoutContent(evt)
+	cp.SetValue(api.ValKeyContentRef, evt.Id)
+
+	return cp, nil
+}
+
+// Open returns a reader of offloaded content. Content is read in chunks so
+// it is never held in memory as a whole.
+func (cs *contentStore) Open(ctx context.Context, ref string) (io.ReadCloser, error) {
+	r, err := cs.obs.Get(ctx, ref)
+	switch {
+	case errors.Is(err, jetstream.ErrObjectNotFound):
+		return nil, core.ErrNotFound(fmt.Errorf("offloaded content of event expired or was deleted"))
+	case err != nil:
+		return nil, fmt.Errorf("loading event content failed: %w", err)
+	}
+	brktel.ContentOffloads.WithLabelValues("load").Inc()
+
+	return r, nil
+}
+
+// withoutContent returns a copy of the event without content. The copy shares
+// all fields but values with the event.
+func withoutContent(evt *core.Event) *core.Event {
+	values := maps.Clone(evt.Values)
+	if values == nil {
+		values = make(map[string]string)
+	}
+
+	return &core.Event{
+		Id:          evt.Id,
+		ParentId:    evt.ParentId,
+		ParentSpan:  evt.ParentSpan,
+		Type:        evt.Type,
+		Category:    evt.Category,
+		CreateTime:  evt.CreateTime,
+		Ttl:         evt.Ttl,
+		Context:     evt.Context,
+		Source:      evt.Source,
+		Target:      evt.Target,
+		Params:      evt.Params,
+		Values:      values,
+		ContentType: evt.ContentType,
+	}
+}
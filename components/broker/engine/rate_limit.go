@@ -0,0 +1,305 @@
+// Copyright 2024 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package engine
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/xigxog/kubefox/api"
+	"github.com/xigxog/kubefox/cache"
+	"github.com/xigxog/kubefox/core"
+	"github.com/xigxog/kubefox/logkf"
+)
+
+// Number of times taking a token is attempted if the bucket is updated by
+// another Broker at the same time.
+const rateLimitAttempts = 5
+
+// tokenBucket holds the tokens available to requests sharing a key. Tokens are
+// added continuously at the rate of the limit up to its burst.
+type tokenBucket struct {
+	Tokens float64 `json:"tokens"`
+	// Unix time in nanoseconds tokens were last added.
+	Updated int64 `json:"updated"`
+}
+
+// rateLimiter enforces RateLimitPolicies. Buckets are shared by Brokers using
+// a NATS key value bucket, or in local mode kept in memory of the Broker.
+// Buckets not used for RateLimitTTL are removed, which refills them.
+type rateLimiter struct {
+	// Nil in local mode.
+	kv jetstream.KeyValue
+
+	local cache.Cache[*tokenBucket]
+	mutex sync.Mutex
+
+	log *logkf.Logger
+}
+
+func newRateLimiter(kv jetstream.KeyValue) *rateLimiter {
+	lim := &rateLimiter{
+		kv:  kv,
+		log: logkf.Global,
+	}
+	if kv == nil {
+		lim.local = cache.New[*tokenBucket](RateLimitTTL)
+	}
+
+	return lim
+}
+
+// rateLimitCheck is a bucket an event takes a token from and its limit.
+type rateLimitCheck struct {
+	key   string
+	limit api.RateLimit
+}
+
+// Check takes a token from the buckets of the RateLimitPolicy of the
+// VirtualEnvironment and of the matched route. Tokens are only taken if every
+// bucket has one. If a bucket is empty ErrRateLimited is returned with the
+// number of seconds until a token is available.
+func (lim *rateLimiter) Check(ctx *BrokerEventContext, vePolicy *api.RateLimitPolicy) error {
+	var checks []rateLimitCheck
+	if vePolicy != nil {
+		scope := "ve|" + ctx.Event.Context.VirtualEnvironment
+		checks = lim.appendChecks(ctx, checks, scope, vePolicy)
+	}
+	if ctx.AppDeployment != nil {
+		if def, err := ctx.AppDeployment.GetDefinition(ctx.Event.Target); err == nil {
+			for _, r := range def.Routes {
+				if int64(r.Id) == ctx.RouteId && r.RateLimitPolicy != nil {
+					scope := fmt.Sprintf("route|%s|%s|%s|%d", ctx.Event.Context.VirtualEnvironment,
+						ctx.AppDeployment.Spec.AppName, ctx.Event.Target.Name, r.Id)
+					checks = lim.appendChecks(ctx, checks, scope, r.RateLimitPolicy)
+					break
+				}
+			}
+		}
+	}
+	if len(checks) == 0 {
+		return nil
+	}
+
+	if lim.kv == nil {
+		return lim.takeLocal(checks)
+	}
+
+	return lim.takeShared(ctx, checks)
+}
+
+func (lim *rateLimiter) appendChecks(ctx *BrokerEventContext, checks []rateLimitCheck,
+	scope string, policy *api.RateLimitPolicy) []rateLimitCheck {
+
+	for i, l := range policy.Limits {
+		if l.Requests == 0 {
+			continue
+		}
+		checks = append(checks, rateLimitCheck{key: lim.key(ctx, scope, i, &l), limit: l})
+	}
+
+	return checks
+}
+
+func (lim *rateLimiter) takeLocal(checks []rateLimitCheck) error {
+	lim.mutex.Lock()
+	defer lim.mutex.Unlock()
+
+	now := time.Now()
+	buckets := make([]*tokenBucket, len(checks))
+	for i, c := range checks {
+		b, _ := lim.local.Get(c.key)
+		if b == nil {
+			b = &tokenBucket{}
+			lim.local.Set(c.key, b)
+		}
+		if ok, wait := b.refill(&c.limit, now); !ok {
+			return rateLimitErr(&c.limit, wait)
+		}
+		buckets[i] = b
+	}
+	for i, b := range buckets {
+		b.take(&checks[i].limit, now)
+	}
+
+	return nil
+}
+
+// takeShared checks all buckets before taking a token from each. A bucket
+// emptied by another Broker between the check and the take can still reject
+// the event after tokens were taken from other buckets.
+func (lim *rateLimiter) takeShared(ctx *BrokerEventContext, checks []rateLimitCheck) error {
+	now := time.Now()
+	for _, c := range checks {
+		b, _, err := lim.getShared(ctx, c.key)
+		if err != nil {
+			// Requests are allowed if the counts are unavailable.
+			ctx.Log.Warnf("unable to check rate limit: %v", err)
+			continue
+		}
+		if ok, wait := b.refill(&c.limit, now); !ok {
+			return rateLimitErr(&c.limit, wait)
+		}
+	}
+	for _, c := range checks {
+		ok, wait, err := lim.takeSharedBucket(ctx, c.key, &c.limit)
+		if err != nil {
+			ctx.Log.Warnf("unable to check rate limit: %v", err)
+			continue
+		}
+		if !ok {
+			return rateLimitErr(&c.limit, wait)
+		}
+	}
+
+	return nil
+}
+
+func (lim *rateLimiter) getShared(ctx context.Context, key string) (*tokenBucket, uint64, error) {
+	b := &tokenBucket{}
+	entry, err := lim.kv.Get(ctx, key)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(entry.Value(), b); err != nil {
+			return nil, 0, err
+		}
+		return b, entry.Revision(), nil
+	case errors.Is(err, jetstream.ErrKeyNotFound):
+		return b, 0, nil
+	default:
+		return nil, 0, err
+	}
+}
+
+func (lim *rateLimiter) takeSharedBucket(ctx context.Context, key string, l *api.RateLimit) (bool, time.Duration, error) {
+	for i := 0; i < rateLimitAttempts; i++ {
+		b, rev, err := lim.getShared(ctx, key)
+		if err != nil {
+			return false, 0, err
+		}
+
+		ok, wait := b.take(l, time.Now())
+		val, _ := json.Marshal(b)
+		if rev == 0 {
+			_, err = lim.kv.Create(ctx, key, val)
+		} else {
+			_, err = lim.kv.Update(ctx, key, val, rev)
+		}
+		switch {
+		case err == nil:
+			return ok, wait, nil
+		case errors.Is(err, jetstream.ErrKeyExists):
+			// Bucket was updated by another Broker, try again.
+			continue
+		default:
+			return false, 0, err
+		}
+	}
+
+	return false, 0, fmt.Errorf("bucket '%s' updated concurrently %d times", key, rateLimitAttempts)
+}
+
+// key returns the key of the bucket the request is counted in. The key is
+// hashed as header values might contain characters not allowed in NATS keys.
+func (lim *rateLimiter) key(ctx *BrokerEventContext, scope string, idx int, l *api.RateLimit) string {
+	var val string
+	switch l.Key {
+	case api.RateLimitKeyVirtualEnvironment:
+		val = ctx.Event.Context.VirtualEnvironment
+	case api.RateLimitKeyApp:
+		val = ctx.Event.Target.App
+	case api.RateLimitKeySource:
+		val = ctx.Event.Source.App + "/" + ctx.Event.Source.Name
+	case api.RateLimitKeyHeader:
+		val = ctx.Event.Header(l.Header)
+	}
+
+	h := sha256.Sum256([]byte(fmt.Sprintf("%s|%d|%s", scope, idx, val)))
+
+	return hex.EncodeToString(h[:])
+}
+
+// take adds the tokens accumulated since the bucket was last updated and then
+// takes a token. If the bucket is empty false is returned along with the time
+// until a token is available.
+func (b *tokenBucket) take(l *api.RateLimit, now time.Time) (bool, time.Duration) {
+	if ok, wait := b.refill(l, now); !ok {
+		return false, wait
+	}
+	b.Tokens--
+
+	return true, 0
+}
+
+// refill adds the tokens accumulated since the bucket was last updated. If the
+// bucket is empty false is returned along with the time until a token is
+// available.
+func (b *tokenBucket) refill(l *api.RateLimit, now time.Time) (bool, time.Duration) {
+	burst := float64(l.Burst)
+	if burst == 0 {
+		burst = float64(l.Requests)
+	}
+	// Tokens added per nanosecond.
+	rate := float64(l.Requests) / float64(period(l))
+
+	if b.Updated == 0 {
+		b.Tokens = burst
+	} else if elapsed := now.UnixNano() - b.Updated; elapsed > 0 {
+		b.Tokens = math.Min(burst, b.Tokens+float64(elapsed)*rate)
+	}
+	b.Updated = now.UnixNano()
+
+	if b.Tokens < 1 {
+		return false, time.Duration((1 - b.Tokens) / rate)
+	}
+
+	return true, 0
+}
+
+func rateLimitErr(l *api.RateLimit, wait time.Duration) error {
+	secs := int(math.Ceil(wait.Seconds()))
+	return core.ErrRateLimited(
+		fmt.Errorf("exceeded %d requests every %ds by %s", l.Requests, period(l)/time.Second, l.Key)).
+		SetDetail(api.ErrDetailRetryAfter, strconv.Itoa(secs))
+}
+
+func period(l *api.RateLimit) time.Duration {
+	if l.PeriodSeconds == 0 {
+		return time.Second
+	}
+
+	return time.Duration(l.PeriodSeconds) * time.Second
+}
+
+// checkRateLimit applies the RateLimitPolicies of the VirtualEnvironment and
+// matched route to requests and messages. Events received from NATS were
+// checked by the sending Broker.
+func (brk *broker) checkRateLimit(ctx *BrokerEventContext) error {
+	isReqOrMsg := ctx.Event.Category == core.Category_REQUEST || ctx.Event.Category == core.Category_MESSAGE
+	if !isReqOrMsg || ctx.Receiver == ReceiverNATS ||
+		ctx.VirtualEnv == nil {
+		return nil
+	}
+
+	env, err := brk.store.Environment(ctx, ctx.VirtualEnv.Spec.Environment)
+	if err != nil {
+		return err
+	}
+
+	return brk.rateLimiter.Check(ctx, ctx.VirtualEnv.GetRateLimitPolicy(env))
+}
@@ -0,0 +1,135 @@
+// Copyright 2024 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package engine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/xigxog/kubefox/api"
+)
+
+func TestTokenBucket_Take(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	tests := []struct {
+		name   string
+		limit  api.RateLimit
+		bucket tokenBucket
+		now    time.Time
+		ok     bool
+		tokens float64
+		wait   time.Duration
+	}{
+		{
+			name:   "new bucket starts full",
+			limit:  api.RateLimit{Requests: 10},
+			now:    now,
+			ok:     true,
+			tokens: 9,
+		},
+		{
+			name:   "new bucket starts at burst",
+			limit:  api.RateLimit{Requests: 10, Burst: 3},
+			now:    now,
+			ok:     true,
+			tokens: 2,
+		},
+		{
+			name:   "empty bucket reports wait",
+			limit:  api.RateLimit{Requests: 10},
+			bucket: tokenBucket{Tokens: 0, Updated: now.UnixNano()},
+			now:    now,
+			ok:     false,
+			wait:   100 * time.Millisecond,
+		},
+		{
+			name:   "partial token reports remaining wait",
+			limit:  api.RateLimit{Requests: 1, PeriodSeconds: 10},
+			bucket: tokenBucket{Tokens: 0.5, Updated: now.UnixNano()},
+			now:    now,
+			ok:     false,
+			tokens: 0.5,
+			wait:   5 * time.Second,
+		},
+		{
+			name:   "refills at rate",
+			limit:  api.RateLimit{Requests: 10},
+			bucket: tokenBucket{Tokens: 0, Updated: now.UnixNano()},
+			now:    now.Add(500 * time.Millisecond),
+			ok:     true,
+			tokens: 4,
+		},
+		{
+			name:   "refill is capped at burst",
+			limit:  api.RateLimit{Requests: 10, Burst: 5},
+			bucket: tokenBucket{Tokens: 1, Updated: now.UnixNano()},
+			now:    now.Add(time.Hour),
+			ok:     true,
+			tokens: 4,
+		},
+		{
+			name:   "refill is capped at requests without burst",
+			limit:  api.RateLimit{Requests: 10, PeriodSeconds: 60},
+			bucket: tokenBucket{Tokens: 0, Updated: now.UnixNano()},
+			now:    now.Add(24 * time.Hour),
+			ok:     true,
+			tokens: 9,
+		},
+		{
+			name:   "clock going backwards does not refill",
+			limit:  api.RateLimit{Requests: 10},
+			bucket: tokenBucket{Tokens: 0, Updated: now.UnixNano()},
+			now:    now.Add(-time.Second),
+			ok:     false,
+			wait:   100 * time.Millisecond,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := test.bucket
+			ok, wait := b.take(&test.limit, test.now)
+			if ok != test.ok {
+				t.Fatalf("expected ok %t, got %t", test.ok, ok)
+			}
+			if diff := b.Tokens - test.tokens; diff > 1e-9 || diff < -1e-9 {
+				t.Errorf("expected %f tokens, got %f", test.tokens, b.Tokens)
+			}
+			if diff := wait - test.wait; diff > time.Microsecond || diff < -time.Microsecond {
+				t.Errorf("expected wait %s, got %s", test.wait, wait)
+			}
+			if b.Updated != test.now.UnixNano() {
+				t.Errorf("expected bucket updated at %d, got %d", test.now.UnixNano(), b.Updated)
+			}
+		})
+	}
+}
+
+func TestRateLimiter_TakeLocal(t *testing.T) {
+	lim := newRateLimiter(nil)
+	ve := rateLimitCheck{key: "ve", limit: api.RateLimit{Requests: 2, PeriodSeconds: 3600}}
+	route := rateLimitCheck{key: "route", limit: api.RateLimit{Requests: 1, PeriodSeconds: 3600}}
+
+	if err := lim.takeLocal([]rateLimitCheck{ve, route}); err != nil {
+		t.Fatalf("first request should be allowed: %v", err)
+	}
+	// Rejected by the route, the token of the VirtualEnvironment is kept.
+	for i := 0; i < 3; i++ {
+		if err := lim.takeLocal([]rateLimitCheck{ve, route}); err == nil {
+			t.Fatal("request exceeding route limit should be rejected")
+		}
+	}
+	if err := lim.takeLocal([]rateLimitCheck{ve}); err != nil {
+		t.Fatalf("rejected requests should not take tokens of other buckets: %v", err)
+	}
+	if err := lim.takeLocal([]rateLimitCheck{ve}); err == nil {
+		t.Fatal("request exceeding VirtualEnvironment limit should be rejected")
+	}
+}
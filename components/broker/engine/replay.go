@@ -0,0 +1,205 @@
+// Copyright 2024 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/textproto"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/xigxog/kubefox/api"
+	"github.com/xigxog/kubefox/components/broker/config"
+	"github.com/xigxog/kubefox/core"
+	"github.com/xigxog/kubefox/logkf"
+)
+
+var errQueryDone = errors.New("query done")
+
+// Headers whose values are always masked in recorded events.
+var redactedHeaders = []string{
+	api.HeaderAuthorization,
+	api.HeaderCookie,
+	api.HeaderProxyAuthorization,
+	api.HeaderSetCookie,
+}
+
+// EventQuery selects recorded events from the event stream. Empty fields are
+// ignored.
+type EventQuery struct {
+	TraceId string
+	EventId string
+	// Component matches the name or group key of the source or target of the
+	// event.
+	Component string
+
+	Start time.Time
+	End   time.Time
+
+	Limit int
+}
+
+// ReplayOpts control how a recorded genesis event is re-injected.
+type ReplayOpts struct {
+	EventId    string
+	VirtualEnv string
+
+	// NewId assigns a new id to the replayed event.
+	NewId bool
+	// Testing routes the event using the VirtualEnvironment's current data
+	// instead of the ReleaseManifest of the active Release.
+	Testing bool
+
+	Timeout time.Duration
+}
+
+// Subject returns the NATS subject filter used to read recorded events
+// matching the trace and event id of the query.
+func (q *EventQuery) Subject() string {
+	return q.subject(recordSubject)
+}
+
+func (q *EventQuery) subject(prefix string) string {
+	traceId, evtId := "*", "*"
+	if q.TraceId != "" {
+		traceId = q.TraceId
+	}
+	if q.EventId != "" {
+		evtId = q.EventId
+	}
+
+	return fmt.Sprintf("%s.%s.%s", prefix, traceId, evtId)
+}
+
+// redactHeaders returns a copy of the event with the values of the headers
+// masked. If the event has none of the headers it is returned as is.
+func redactHeaders(evt *core.Event, headers ...[]string) *core.Event {
+	present := evt.ValueMap(api.ValKeyHeader)
+
+	var cp *core.Event
+	for _, hdrs := range headers {
+		for _, h := range hdrs {
+			h = textproto.CanonicalMIMEHeaderKey(h)
+			if _, found := present[h]; !found {
+				continue
+			}
+			if cp == nil {
+				cp = withoutContent(evt)
+				cp.Content = evt.Content
+			}
+			cp.SetHeader(h, api.SecretMask)
+		}
+	}
+	if cp == nil {
+		return evt
+	}
+
+	return cp
+}
+
+// Match checks the fields of the query that cannot be filtered by subject.
+func (q *EventQuery) Match(evt *core.Event) bool {
+	if q.Component == "" {
+		return true
+	}
+
+	for _, c := range []*core.Component{evt.Source, evt.Target} {
+		if c != nil && (c.Name == q.Component || c.GroupKey() == q.Component) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (brk *broker) QueryEvents(ctx context.Context, q *EventQuery, fn func(*core.Event) error) error {
+	if !config.RecordEvents {
+		return core.ErrNotFound(fmt.Errorf("event recording is disabled"))
+	}
+
+	return brk.natsClient.QueryEvents(ctx, q, fn)
+}
+
+// ReplayEvent re-injects a recorded genesis event into the VirtualEnvironment
+// specified by opts. The replayed event is returned once routing completes.
+func (brk *broker) ReplayEvent(ctx context.Context, opts *ReplayOpts) (*core.Event, error) {
+	var evt *core.Event
+	err := brk.QueryEvents(ctx, &EventQuery{EventId: opts.EventId, Limit: 1},
+		func(e *core.Event) error {
+			evt = e
+			return errQueryDone
+		})
+	if err != nil && !errors.Is(err, errQueryDone) {
+		return nil, err
+	}
+	if evt == nil {
+		return nil, core.ErrNotFound(fmt.Errorf("event '%s' not found", opts.EventId))
+	}
+	if evt.Category != core.Category_REQUEST || evt.ParentId != "" {
+		return nil, core.ErrInvalid(fmt.Errorf("event '%s' is not a genesis event", opts.EventId))
+	}
+
+	ve, err := brk.store.VirtualEnvironment(ctx, opts.VirtualEnv)
+	if err != nil {
+		return nil, err
+	}
+	release := ve.Status.ActiveRelease
+	if release == nil {
+		return nil, core.ErrNotFound(fmt.Errorf("VirtualEnvironment '%s' does not have an active Release", ve.Name))
+	}
+
+	// Use the App the event was originally routed to, if the App is not
+	// known and the Release contains a single App use it.
+	var appDep string
+	if evt.Target != nil && evt.Target.App != "" {
+		appDep = release.Apps[evt.Target.App].AppDeployment
+	} else if len(release.Apps) == 1 {
+		for _, app := range release.Apps {
+			appDep = app.AppDeployment
+		}
+	}
+	if appDep == "" {
+		return nil, core.ErrNotFound(fmt.Errorf("unable to determine App of event in VirtualEnvironment '%s'", ve.Name))
+	}
+
+	evtCtx := &core.EventContext{
+		Platform:           config.Platform,
+		VirtualEnvironment: ve.Name,
+		AppDeployment:      appDep,
+	}
+	if !opts.Testing {
+		evtCtx.ReleaseManifest = release.ReleaseManifest
+	}
+
+	if opts.NewId {
+		evt.Id = uuid.NewString()
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = api.DefaultTimeoutSeconds * time.Second
+	}
+	evt.CreateTime = time.Now().UnixNano()
+	evt.SetTTL(opts.Timeout)
+	evt.SetContext(evtCtx)
+	evt.Target = nil
+	evt.ParentSpan = nil
+	// Responses are returned to this Broker.
+	evt.Source.BrokerId = brk.comp.Id
+
+	brk.log.With(logkf.KeyVirtualEnvironment, ve.Name).Infof("replaying event '%s' as '%s'", opts.EventId, evt.Id)
+
+	routeCtx := brk.RecvEvent(evt, ReceiverAdminServer)
+	<-routeCtx.Done()
+	if err := routeCtx.CoreErr(); err != nil {
+		return nil, err
+	}
+
+	return evt, nil
+}
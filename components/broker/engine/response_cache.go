@@ -0,0 +1,582 @@
+// Copyright 2024 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package engine
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/textproto"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/xigxog/kubefox/api"
+	"github.com/xigxog/kubefox/cache"
+	brktel "github.com/xigxog/kubefox/components/broker/telemetry"
+	"github.com/xigxog/kubefox/core"
+	"github.com/xigxog/kubefox/logkf"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	respCacheBucket = "RESPONSE_CACHE"
+)
+
+// Status codes of responses cached by default by HTTP caches. Responses
+// without a status code, such as those of non-HTTP requests, are also cached.
+var cacheableStatus = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMultipleChoices:      true,
+	http.StatusMovedPermanently:     true,
+	http.StatusPermanentRedirect:    true,
+	http.StatusNotFound:             true,
+	http.StatusMethodNotAllowed:     true,
+	http.StatusGone:                 true,
+	http.StatusRequestURITooLong:    true,
+	http.StatusNotImplemented:       true,
+}
+
+// cachedResponse is stored in the response cache.
+type cachedResponse struct {
+	// Unix time in nanoseconds the response was stored and expires.
+	Stored   int64  `json:"stored"`
+	Expires  int64  `json:"expires"`
+	Response []byte `json:"response"`
+}
+
+// pendingRequest is kept while a cacheable request is in flight so its
+// response can be stored.
+type pendingRequest struct {
+	scope, base string
+	policy      *api.CachePolicy
+	header      map[string][]string
+}
+
+// respCache caches responses of routes and Components with a CachePolicy.
+// Responses are kept in memory of the Broker and, if shared, in a NATS key
+// value bucket. Keys are made of a scope, the VirtualEnvironment, a base
+// identifying the request and target, and a variant of the request headers
+// responses vary by. The headers responses vary by are stored under the base.
+type respCache struct {
+	// Nil unless responses are shared.
+	kv jetstream.KeyValue
+
+	local *lruResponses
+	vary  cache.Cache[[]string]
+
+	// Maps id of in flight requests to their cache key.
+	pending cache.Cache[*pendingRequest]
+
+	maxSize int64
+
+	offload   func(context.Context, *core.Event) (*core.Event, error)
+	recvEvent func(*core.Event, Receiver) *BrokerEventContext
+
+	log *logkf.Logger
+}
+
+func newRespCache(kv jetstream.KeyValue, maxSize, maxMemory int64,
+	offload func(context.Context, *core.Event) (*core.Event, error),
+	recvEvent func(*core.Event, Receiver) *BrokerEventContext) *respCache {
+
+	return &respCache{
+		kv:        kv,
+		local:     newLRUResponses(maxMemory),
+		vary:      cache.New[[]string](api.MaxCacheTTLSeconds * time.Second),
+		pending:   cache.New[*pendingRequest](time.Minute * 5),
+		maxSize:   maxSize,
+		offload:   offload,
+		recvEvent: recvEvent,
+		log:       logkf.Global,
+	}
+}
+
+// Check returns true if a cached response for the request was found. The
+// response is sent to the source of the request. Otherwise the request is
+// tracked so its response can be stored.
+func (rc *respCache) Check(ctx *BrokerEventContext) (bool, error) {
+	policy := rc.policy(ctx)
+	if policy == nil || !cacheableRequest(ctx.Event) {
+		return false, nil
+	}
+
+	reqCC := parseCacheControl(ctx.Event.Header(api.HeaderCacheControl))
+	if _, found := reqCC["no-store"]; found {
+		brktel.ResponseCacheRequests.WithLabelValues("bypass").Inc()
+		return false, nil
+	}
+
+	scope, base := rc.scope(ctx), rc.base(ctx)
+	pending := &pendingRequest{
+		scope:  scope,
+		base:   base,
+		policy: policy,
+		header: ctx.Event.ValueMap(api.ValKeyHeader),
+	}
+
+	_, noCache := reqCC["no-cache"]
+	if !noCache && reqCC["max-age"] != "0" {
+		vary := rc.getVary(ctx, scope, base)
+		key := scope + "." + base + "." + variant(pending.header, mergeVary(policy.Vary, vary))
+		if rec := rc.get(ctx, key); rec != nil {
+			brktel.ResponseCacheRequests.WithLabelValues("hit").Inc()
+			go rc.respond(ctx.Event, rec)
+			return true, nil
+		}
+	}
+	brktel.ResponseCacheRequests.WithLabelValues("miss").Inc()
+
+	rc.pending.Set(ctx.Event.Id, pending)
+
+	return false, nil
+}
+
+// Complete stores the response if it is for a request tracked by Check and
+// the response is cacheable.
+func (rc *respCache) Complete(ctx *BrokerEventContext) {
+	pending, found := rc.pending.Get(ctx.Event.ParentId)
+	if !found {
+		return
+	}
+	rc.pending.Delete(ctx.Event.ParentId)
+
+	resp := ctx.Event
+	code := resp.Status()
+	if resp.Err() != nil || (code != 0 && !cacheableStatus[code]) ||
+		int64(len(resp.Content)) > rc.maxSize {
+		return
+	}
+
+	ttl, vary := responseTTL(pending, resp)
+	if ttl <= 0 {
+		return
+	}
+	vary = mergeVary(pending.policy.Vary, vary)
+
+	stored, err := rc.offload(ctx, resp)
+	if err != nil {
+		ctx.Log.Warnf("unable to cache response: %v", err)
+		return
+	}
+	b, err := proto.Marshal(stored)
+	if err != nil {
+		ctx.Log.Warnf("unable to cache response: %v", err)
+		return
+	}
+
+	now := time.Now()
+	rec := &cachedResponse{
+		Stored:   now.UnixNano(),
+		Expires:  now.Add(ttl).UnixNano(),
+		Response: b,
+	}
+	baseKey := pending.scope + "." + pending.base
+	key := baseKey + "." + variant(pending.header, vary)
+
+	rc.vary.Set(baseKey, vary)
+	rc.local.Set(key, rec)
+	if rc.kv != nil {
+		varyB, _ := json.Marshal(vary)
+		recB, _ := json.Marshal(rec)
+		if _, err := rc.kv.Put(ctx, baseKey, varyB); err != nil {
+			ctx.Log.Warnf("unable to share cached response: %v", err)
+			return
+		}
+		if _, err := rc.kv.Put(ctx, key, recB); err != nil {
+			ctx.Log.Warnf("unable to share cached response: %v", err)
+		}
+	}
+
+	ctx.Log.Debugf("cached response for %s", ttl)
+}
+
+// Purge removes all cached responses of the VirtualEnvironment.
+func (rc *respCache) Purge(virtualEnv string) {
+	prefix := scopeOf(virtualEnv) + "."
+	rc.local.DeletePrefix(prefix)
+	for _, e := range rc.vary.Entries() {
+		if strings.HasPrefix(e.Key, prefix) {
+			rc.vary.Delete(e.Key)
+		}
+	}
+
+	if rc.kv != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		if err := rc.purgeShared(ctx, prefix); err != nil {
+			rc.log.Warnf("unable to purge shared responses of VirtualEnvironment '%s': %v", virtualEnv, err)
+		}
+	}
+
+	rc.log.Debugf("purged cached responses of VirtualEnvironment '%s'", virtualEnv)
+}
+
+func (rc *respCache) purgeShared(ctx context.Context, prefix string) error {
+	w, err := rc.kv.Watch(ctx, prefix+">", jetstream.MetaOnly(), jetstream.IgnoreDeletes())
+	if err != nil {
+		return err
+	}
+	defer w.Stop()
+
+	var keys []string
+	for entry := range w.Updates() {
+		// Nil entry marks the end of the initial values.
+		if entry == nil {
+			break
+		}
+		keys = append(keys, entry.Key())
+	}
+	for _, k := range keys {
+		if err := rc.kv.Purge(ctx, k); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// policy returns the CachePolicy of the matched route, or if not set of the
+// target Component.
+func (rc *respCache) policy(ctx *BrokerEventContext) *api.CachePolicy {
+	if ctx.AppDeployment == nil {
+		return nil
+	}
+	def, err := ctx.AppDeployment.GetDefinition(ctx.Event.Target)
+	if err != nil {
+		return nil
+	}
+	for _, r := range def.Routes {
+		if int64(r.Id) == ctx.RouteId && r.CachePolicy != nil {
+			return r.CachePolicy
+		}
+	}
+
+	return def.CachePolicy
+}
+
+func (rc *respCache) getVary(ctx context.Context, scope, base string) []string {
+	key := scope + "." + base
+	if vary, found := rc.vary.Get(key); found {
+		return vary
+	}
+	if rc.kv == nil {
+		return nil
+	}
+
+	entry, err := rc.kv.Get(ctx, key)
+	if err != nil {
+		if !errors.Is(err, jetstream.ErrKeyNotFound) {
+			rc.log.Warnf("unable to get shared response: %v", err)
+		}
+		return nil
+	}
+	var vary []string
+	if err := json.Unmarshal(entry.Value(), &vary); err != nil {
+		return nil
+	}
+	rc.vary.Set(key, vary)
+
+	return vary
+}
+
+func (rc *respCache) get(ctx context.Context, key string) *cachedResponse {
+	now := time.Now().UnixNano()
+	if rec, found := rc.local.Get(key); found {
+		if now < rec.Expires {
+			return rec
+		}
+		rc.local.Delete(key)
+	}
+	if rc.kv == nil {
+		return nil
+	}
+
+	entry, err := rc.kv.Get(ctx, key)
+	if err != nil {
+		if !errors.Is(err, jetstream.ErrKeyNotFound) {
+			rc.log.Warnf("unable to get shared response: %v", err)
+		}
+		return nil
+	}
+	rec := &cachedResponse{}
+	if err := json.Unmarshal(entry.Value(), rec); err != nil || now >= rec.Expires {
+		return nil
+	}
+	rc.local.Set(key, rec)
+
+	return rec
+}
+
+func (rc *respCache) respond(req *core.Event, rec *cachedResponse) {
+	log := rc.log.WithEvent(req)
+
+	resp := core.NewEvent()
+	if err := proto.Unmarshal(rec.Response, resp); err != nil {
+		log.Warnf("cached response is invalid: %v", err)
+		return
+	}
+	resp.Id = uuid.NewString()
+	resp.ParentId = req.Id
+	resp.ParentSpan = req.ParentSpan
+	resp.Target = proto.Clone(req.Source).(*core.Component)
+	resp.CreateTime = time.Now().UnixNano()
+	resp.SetTTL(req.TTL())
+	resp.SetContext(req.Context)
+
+	age := time.Duration(time.Now().UnixNano() - rec.Stored)
+	resp.SetHeader(api.HeaderAge, strconv.Itoa(int(age.Seconds())))
+
+	log.Debug("returning cached response")
+	rc.recvEvent(resp, ReceiverResponseCache)
+}
+
+// scope returns the part of the cache key shared by all responses of the
+// VirtualEnvironment of the request.
+func (rc *respCache) scope(ctx *BrokerEventContext) string {
+	return scopeOf(ctx.Event.Context.VirtualEnvironment)
+}
+
+// base returns the part of the cache key identifying the request and its
+// target.
+func (rc *respCache) base(ctx *BrokerEventContext) string {
+	evt := ctx.Event
+	return cacheKeyHash(
+		evt.Context.AppDeployment,
+		evt.Context.ReleaseManifest,
+		evt.Target.Name,
+		evt.Target.Hash,
+		strconv.FormatInt(ctx.RouteId, 10),
+		evt.Type,
+		evt.Value(api.ValKeyMethod),
+		evt.Value(api.ValKeyURL),
+	)
+}
+
+// cacheableRequest returns true if the request reads a resource, HTTP
+// requests using GET or HEAD and other requests without content.
+func cacheableRequest(evt *core.Event) bool {
+	switch evt.Value(api.ValKeyMethod) {
+	case http.MethodGet, http.MethodHead:
+		return true
+	case "":
+		return len(evt.Content) == 0
+	default:
+		return false
+	}
+}
+
+// responseTTL returns how long the response can be cached and the request
+// headers it varies by according to its Cache-Control and Vary headers and
+// the policy. A TTL of 0 means the response cannot be cached.
+func responseTTL(req *pendingRequest, resp *core.Event) (time.Duration, []string) {
+	// Responses to requests with credentials are only cached if the policy
+	// varies by them, even if Cache-Control is ignored.
+	for _, h := range []string{api.HeaderAuthorization, api.HeaderCookie} {
+		if len(req.header[h]) > 0 && !varies(req.policy.Vary, h) {
+			return 0, nil
+		}
+	}
+
+	maxTTL := time.Duration(api.MaxCacheTTLSeconds) * time.Second
+	policyTTL := time.Duration(req.policy.TTLSeconds) * time.Second
+	if req.policy.IgnoreCacheControl {
+		return min(policyTTL, maxTTL), nil
+	}
+
+	var vary []string
+	for _, v := range resp.HeaderAll(api.HeaderVary) {
+		for _, h := range strings.Split(v, ",") {
+			if h = strings.TrimSpace(h); h == "*" {
+				return 0, nil
+			} else if h != "" {
+				vary = append(vary, h)
+			}
+		}
+	}
+
+	cc := parseCacheControl(strings.Join(resp.HeaderAll(api.HeaderCacheControl), ","))
+	for _, d := range []string{"no-store", "no-cache", "private"} {
+		if _, found := cc[d]; found {
+			return 0, nil
+		}
+	}
+
+	maxAge, found := cc["s-maxage"]
+	if !found {
+		maxAge, found = cc["max-age"]
+	}
+
+	ttl := policyTTL
+	if found {
+		secs, err := strconv.Atoi(maxAge)
+		if err != nil || secs < 0 {
+			return 0, nil
+		}
+		ttl = time.Duration(secs) * time.Second
+	}
+
+	return min(ttl, maxTTL), vary
+}
+
+// varies returns true if the header is one of the headers responses vary by.
+func varies(vary []string, header string) bool {
+	return slices.ContainsFunc(vary, func(h string) bool {
+		return strings.EqualFold(h, header)
+	})
+}
+
+// parseCacheControl returns the directives of a Cache-Control header mapped
+// to their values. Directive names are lowercase.
+func parseCacheControl(header string) map[string]string {
+	directives := make(map[string]string)
+	for _, d := range strings.Split(header, ",") {
+		name, val, _ := strings.Cut(strings.TrimSpace(d), "=")
+		if name == "" {
+			continue
+		}
+		directives[strings.ToLower(name)] = strings.Trim(val, `"`)
+	}
+
+	return directives
+}
+
+// mergeVary returns the canonical names of the headers, sorted and without
+// duplicates.
+func mergeVary(lists ...[]string) []string {
+	var vary []string
+	for _, l := range lists {
+		for _, h := range l {
+			vary = append(vary, textproto.CanonicalMIMEHeaderKey(h))
+		}
+	}
+	slices.Sort(vary)
+
+	return slices.Compact(vary)
+}
+
+// variant returns the part of the cache key made of the values of the request
+// headers responses vary by.
+func variant(header map[string][]string, vary []string) string {
+	parts := make([]string, 0, len(vary)*2)
+	for _, h := range vary {
+		parts = append(parts, h, strings.Join(header[h], ","))
+	}
+
+	return cacheKeyHash(parts...)
+}
+
+func scopeOf(virtualEnv string) string {
+	return cacheKeyHash(virtualEnv)[:16]
+}
+
+func cacheKeyHash(parts ...string) string {
+	h := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(h[:])
+}
+
+// lruResponses holds cached responses in memory up to a maximum total size.
+// The least recently used responses are evicted when it is exceeded.
+type lruResponses struct {
+	items   map[string]*list.Element
+	order   *list.List
+	size    int64
+	maxSize int64
+	mutex   sync.Mutex
+}
+
+type lruEntry struct {
+	key string
+	rec *cachedResponse
+}
+
+func newLRUResponses(maxSize int64) *lruResponses {
+	return &lruResponses{
+		items:   make(map[string]*list.Element),
+		order:   list.New(),
+		maxSize: maxSize,
+	}
+}
+
+func (l *lruResponses) Get(key string) (*cachedResponse, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	el, found := l.items[key]
+	if !found {
+		return nil, false
+	}
+	l.order.MoveToFront(el)
+
+	return el.Value.(*lruEntry).rec, true
+}
+
+// Set adds the response, evicting the least recently used responses until
+// the total size is within the maximum. Responses larger than the maximum are
+// not added.
+func (l *lruResponses) Set(key string, rec *cachedResponse) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.delete(key)
+	size := entrySize(key, rec)
+	if size > l.maxSize {
+		return
+	}
+	for l.size+size > l.maxSize {
+		l.delete(l.order.Back().Value.(*lruEntry).key)
+		brktel.ResponseCacheEvictions.Inc()
+	}
+	l.items[key] = l.order.PushFront(&lruEntry{key: key, rec: rec})
+	l.size += size
+}
+
+func (l *lruResponses) Delete(key string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.delete(key)
+}
+
+// DeletePrefix deletes all responses with keys starting with prefix.
+func (l *lruResponses) DeletePrefix(prefix string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for key := range l.items {
+		if strings.HasPrefix(key, prefix) {
+			l.delete(key)
+		}
+	}
+}
+
+func (l *lruResponses) delete(key string) {
+	el, found := l.items[key]
+	if !found {
+		return
+	}
+	l.order.Remove(el)
+	delete(l.items, key)
+	l.size -= entrySize(key, el.Value.(*lruEntry).rec)
+}
+
+func entrySize(key string, rec *cachedResponse) int64 {
+	return int64(len(key) + len(rec.Response))
+}
@@ -0,0 +1,227 @@
+// Copyright 2024 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package engine
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/xigxog/kubefox/api"
+	"github.com/xigxog/kubefox/core"
+)
+
+func TestResponseTTL(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy api.CachePolicy
+		req    map[string][]string
+		resp   map[string][]string
+		ttl    time.Duration
+		vary   []string
+	}{
+		{
+			name:   "policy ttl without cache control",
+			policy: api.CachePolicy{TTLSeconds: 60},
+			ttl:    time.Minute,
+		},
+		{
+			name:   "max age",
+			policy: api.CachePolicy{TTLSeconds: 60},
+			resp:   map[string][]string{api.HeaderCacheControl: {"max-age=10"}},
+			ttl:    10 * time.Second,
+		},
+		{
+			name:   "s-maxage takes precedence over max-age",
+			policy: api.CachePolicy{TTLSeconds: 60},
+			resp:   map[string][]string{api.HeaderCacheControl: {"max-age=10, s-maxage=20"}},
+			ttl:    20 * time.Second,
+		},
+		{
+			name:   "ttl is capped",
+			policy: api.CachePolicy{TTLSeconds: 60},
+			resp:   map[string][]string{api.HeaderCacheControl: {"max-age=999999"}},
+			ttl:    api.MaxCacheTTLSeconds * time.Second,
+		},
+		{
+			name:   "invalid max age",
+			policy: api.CachePolicy{TTLSeconds: 60},
+			resp:   map[string][]string{api.HeaderCacheControl: {"max-age=-1"}},
+		},
+		{
+			name:   "no-store",
+			policy: api.CachePolicy{TTLSeconds: 60},
+			resp:   map[string][]string{api.HeaderCacheControl: {"No-Store"}},
+		},
+		{
+			name:   "private",
+			policy: api.CachePolicy{TTLSeconds: 60},
+			resp:   map[string][]string{api.HeaderCacheControl: {"max-age=10", "private"}},
+		},
+		{
+			name:   "no-cache",
+			policy: api.CachePolicy{TTLSeconds: 60},
+			resp:   map[string][]string{api.HeaderCacheControl: {"no-cache"}},
+		},
+		{
+			name:   "vary star",
+			policy: api.CachePolicy{TTLSeconds: 60},
+			resp:   map[string][]string{api.HeaderVary: {"Accept, *"}},
+		},
+		{
+			name:   "vary headers",
+			policy: api.CachePolicy{TTLSeconds: 60},
+			resp:   map[string][]string{api.HeaderVary: {"Accept, Accept-Language", "X-Tenant"}},
+			ttl:    time.Minute,
+			vary:   []string{"Accept", "Accept-Language", "X-Tenant"},
+		},
+		{
+			name:   "authorization without vary",
+			policy: api.CachePolicy{TTLSeconds: 60},
+			req:    map[string][]string{api.HeaderAuthorization: {"Bearer abc"}},
+			resp:   map[string][]string{api.HeaderCacheControl: {"public, s-maxage=10"}},
+		},
+		{
+			name:   "cookie without vary",
+			policy: api.CachePolicy{TTLSeconds: 60},
+			req:    map[string][]string{api.HeaderCookie: {"session=abc"}},
+		},
+		{
+			name:   "authorization in vary of policy",
+			policy: api.CachePolicy{TTLSeconds: 60, Vary: []string{"authorization"}},
+			req:    map[string][]string{api.HeaderAuthorization: {"Bearer abc"}},
+			ttl:    time.Minute,
+		},
+		{
+			name:   "authorization in vary of response only",
+			policy: api.CachePolicy{TTLSeconds: 60},
+			req:    map[string][]string{api.HeaderAuthorization: {"Bearer abc"}},
+			resp:   map[string][]string{api.HeaderVary: {"Authorization"}},
+		},
+		{
+			name:   "ignore cache control",
+			policy: api.CachePolicy{TTLSeconds: 60, IgnoreCacheControl: true},
+			resp: map[string][]string{
+				api.HeaderCacheControl: {"no-store"},
+				api.HeaderVary:         {"*"},
+			},
+			ttl: time.Minute,
+		},
+		{
+			name:   "ignore cache control with cookie",
+			policy: api.CachePolicy{TTLSeconds: 60, IgnoreCacheControl: true},
+			req:    map[string][]string{api.HeaderCookie: {"session=abc"}},
+		},
+		{
+			name:   "ignore cache control with cookie in vary",
+			policy: api.CachePolicy{TTLSeconds: 60, IgnoreCacheControl: true, Vary: []string{"Cookie"}},
+			req:    map[string][]string{api.HeaderCookie: {"session=abc"}},
+			ttl:    time.Minute,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := core.NewResp(core.EventOpts{})
+			for k, vals := range test.resp {
+				for _, v := range vals {
+					resp.AddHeader(k, v)
+				}
+			}
+			req := &pendingRequest{policy: &test.policy, header: test.req}
+
+			ttl, vary := responseTTL(req, resp)
+			if ttl != test.ttl {
+				t.Errorf("expected ttl %s, got %s", test.ttl, ttl)
+			}
+			if !slices.Equal(vary, test.vary) {
+				t.Errorf("expected vary %v, got %v", test.vary, vary)
+			}
+		})
+	}
+}
+
+func TestParseCacheControl(t *testing.T) {
+	cc := parseCacheControl(`Max-Age=10, private="Set-Cookie", ,no-store`)
+	if len(cc) != 3 || cc["max-age"] != "10" || cc["private"] != "Set-Cookie" {
+		t.Errorf("unexpected directives %v", cc)
+	}
+	if _, found := cc["no-store"]; !found {
+		t.Errorf("expected no-store directive, got %v", cc)
+	}
+}
+
+func TestVariant(t *testing.T) {
+	vary := mergeVary([]string{"x-tenant", "accept"}, []string{"Accept"})
+	if !slices.Equal(vary, []string{"Accept", "X-Tenant"}) {
+		t.Fatalf("unexpected vary %v", vary)
+	}
+
+	a := map[string][]string{"Accept": {"text/html"}, "X-Tenant": {"a"}, "Other": {"1"}}
+	b := map[string][]string{"Accept": {"text/html"}, "X-Tenant": {"a"}, "Other": {"2"}}
+	c := map[string][]string{"Accept": {"text/html"}, "X-Tenant": {"b"}}
+	if variant(a, vary) != variant(b, vary) {
+		t.Error("headers responses do not vary by should not change variant")
+	}
+	if variant(a, vary) == variant(c, vary) {
+		t.Error("headers responses vary by should change variant")
+	}
+}
+
+func TestLRUResponses(t *testing.T) {
+	rec := func(size int) *cachedResponse {
+		return &cachedResponse{Response: make([]byte, size)}
+	}
+
+	// Each entry is 10 bytes, key of 1 byte and response of 9 bytes.
+	l := newLRUResponses(30)
+	l.Set("a", rec(9))
+	l.Set("b", rec(9))
+	l.Set("c", rec(9))
+	if l.size != 30 {
+		t.Fatalf("expected size 30, got %d", l.size)
+	}
+
+	// Reading a marks it as recently used, b is evicted.
+	l.Get("a")
+	l.Set("d", rec(9))
+	if _, found := l.Get("b"); found {
+		t.Error("least recently used response should be evicted")
+	}
+	for _, k := range []string{"a", "c", "d"} {
+		if _, found := l.Get(k); !found {
+			t.Errorf("response %s should not be evicted", k)
+		}
+	}
+
+	// Replacing a response accounts for the size of the old one.
+	l.Set("a", rec(4))
+	if l.size != 25 || l.order.Len() != 3 {
+		t.Errorf("expected size 25 with 3 entries, got %d with %d", l.size, l.order.Len())
+	}
+
+	// Responses larger than the maximum are not added.
+	l.Set("big", rec(40))
+	if _, found := l.Get("big"); found || l.size != 25 {
+		t.Errorf("response larger than maximum should not be added, size %d", l.size)
+	}
+
+	// Order is now a, d, c and c is evicted to make room.
+	l.Set("xa", rec(8))
+	if _, found := l.Get("c"); found || l.size != 25 {
+		t.Errorf("expected c to be evicted with size 25, got size %d", l.size)
+	}
+
+	l.DeletePrefix("x")
+	l.Delete("d")
+	l.Delete("missing")
+	if l.size != 5 || l.order.Len() != 1 || len(l.items) != 1 {
+		t.Errorf("expected size 5 with 1 entry, got %d with %d entries and %d items", l.size, l.order.Len(), len(l.items))
+	}
+}
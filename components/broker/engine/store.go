@@ -10,25 +10,30 @@ package engine
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"hash/fnv"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/mitchellh/hashstructure/v2"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/xigxog/kubefox/api"
 	common "github.com/xigxog/kubefox/api/kubernetes"
 	"github.com/xigxog/kubefox/api/kubernetes/v1alpha1"
 	"github.com/xigxog/kubefox/cache"
 	"github.com/xigxog/kubefox/components/broker/config"
+	brktel "github.com/xigxog/kubefox/components/broker/telemetry"
 	"github.com/xigxog/kubefox/core"
 	"github.com/xigxog/kubefox/k8s"
 	"github.com/xigxog/kubefox/logkf"
 	"github.com/xigxog/kubefox/matcher"
-	"github.com/xigxog/kubefox/vault"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
-	"k8s.io/client-go/kubernetes/scheme"
-	ctrl "sigs.k8s.io/controller-runtime"
-	ctrlcache "sigs.k8s.io/controller-runtime/pkg/cache"
+	toolscache "k8s.io/client-go/tools/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/yaml"
 )
@@ -39,26 +44,78 @@ type Store interface {
 
 	Platform(context.Context) (*v1alpha1.Platform, error)
 	AppDeployment(context.Context, string) (*v1alpha1.AppDeployment, error)
+	VirtualEnvironment(context.Context, string) (*v1alpha1.VirtualEnvironment, error)
+	Environment(context.Context, string) (*v1alpha1.Environment, error)
 	ComponentDef(context.Context, *core.Component) (*api.ComponentDefinition, error)
 	Adapter(*BrokerEventContext, string, api.ComponentType) (common.Adapter, error)
 
 	ReleaseMatcher(context.Context) (*matcher.EventMatcher, error)
 	DeploymentMatcher(*BrokerEventContext) (*matcher.EventMatcher, error)
+	ReloadReleaseMatcher(context.Context) error
+
+	Caches() map[string][]cache.Entry
+	EvictCache(name string) error
 
 	AttachEventContext(*BrokerEventContext) error
 	IsGenesisAdapter(context.Context, *core.Component) bool
+
+	// OnReleaseChange registers fn to be called with the name of each
+	// VirtualEnvironment whose Release, or data of the Release, changes.
+	OnReleaseChange(fn func(virtualEnv string))
+}
+
+// Names of store caches.
+const (
+	cacheComponents        = "components"
+	cacheDeploymentMatcher = "deploymentMatcher"
+	cacheReleaseMatcher    = "releaseMatcher"
+	cacheSecrets           = "secrets"
+	cacheValidation        = "validation"
+)
+
+// Interval at which the versions of cached secrets are checked.
+const secretsCheckInterval = 30 * time.Second
+
+// ResourceSource provides the KubeFox resources and secrets the store is
+// built from. Changes made to resources after the source is opened are passed
+// to the handler.
+type ResourceSource interface {
+	client.Reader
+
+	Open(context.Context, toolscache.ResourceEventHandler) error
+	Close()
+
+	GetData(context.Context, api.DataKey, *api.Data) error
+	// DataVersion returns the current version of the secrets of key and the
+	// time they were written.
+	DataVersion(context.Context, api.DataKey) (int64, time.Time, error)
 }
 
 type store struct {
-	resCache ctrlcache.Cache
-	vaultCli *vault.Client
+	src ResourceSource
 
 	validationCache cache.Cache[api.Problems]
 	depMatcherCache cache.Cache[*matcher.EventMatcher]
-	secretsCache    cache.Cache[*api.Data]
-
-	compCache      map[string]*api.ComponentDefinition
-	releaseMatcher *matcher.EventMatcher
+	secretsCache    *meteredCache[*cachedSecrets]
+
+	// Component definitions by group key, merged from the definitions of each
+	// AppDeployment and the Platform.
+	compCache   map[string]*api.ComponentDefinition
+	compSources map[types.UID]*compSource
+	compMutex   sync.RWMutex
+
+	releaseMatcher atomic.Pointer[matcher.EventMatcher]
+	// Release of each VirtualEnvironment the release matcher was last built
+	// from.
+	releases     map[string]*veRelease
+	releaseHooks []func(string)
+	releaseMutex sync.Mutex
+
+	// Names of VirtualEnvironments whose Release needs to be rebuilt and the
+	// time the first change to each was received.
+	pending        map[string]time.Time
+	pendingMutex   sync.Mutex
+	releaseChanged chan struct{}
 
 	ctx    context.Context
 	cancel context.CancelFunc
@@ -66,119 +123,116 @@ type store struct {
 	log *logkf.Logger
 }
 
-func NewStore() *store {
+func NewStore(src ResourceSource) *store {
 	ctx, cancel := context.WithCancel(context.Background())
 	return &store{
-		validationCache: cache.New[api.Problems](time.Minute * 15),
-		depMatcherCache: cache.New[*matcher.EventMatcher](time.Minute * 15),
-		secretsCache:    cache.New[*api.Data](time.Minute * 15),
+		src:             src,
+		validationCache: newMeteredCache[api.Problems](cacheValidation, time.Minute*15),
+		depMatcherCache: newMeteredCache[*matcher.EventMatcher](cacheDeploymentMatcher, time.Minute*15),
+		secretsCache:    newMeteredCache[*cachedSecrets](cacheSecrets, time.Minute*15),
+		pending:         make(map[string]time.Time),
+		releaseChanged:  make(chan struct{}, 1),
 		ctx:             ctx,
 		cancel:          cancel,
 		log:             logkf.Global,
 	}
 }
 
-func (str *store) Open() error {
-	ctx, cancel := context.WithTimeout(str.ctx, time.Minute*3)
-	defer cancel()
+// meteredCache counts the hits and misses of lookups of the wrapped cache.
+type meteredCache[T any] struct {
+	cache.Cache[T]
 
-	if err := str.init(ctx); err != nil {
-		return err
-	}
+	hits, misses prometheus.Counter
+}
 
-	if err := str.updateComponentCache(ctx); err != nil {
-		return err
+func newMeteredCache[T any](name string, ttl time.Duration) *meteredCache[T] {
+	return &meteredCache[T]{
+		Cache:  cache.New[T](ttl),
+		hits:   brktel.CacheRequests.WithLabelValues(name, "hit"),
+		misses: brktel.CacheRequests.WithLabelValues(name, "miss"),
 	}
-	if err := str.updateReleaseMatcher(ctx); err != nil {
-		return err
+}
+
+func (c *meteredCache[T]) Get(key string) (T, bool) {
+	val, found := c.Cache.Get(key)
+	if found {
+		c.hits.Inc()
+	} else {
+		c.misses.Inc()
 	}
 
-	return nil
+	return val, found
 }
 
-func (str *store) init(initCtx context.Context) error {
-	key := vault.Key{
-		Instance:  config.Instance,
-		Namespace: config.Namespace,
-		Component: api.PlatformComponentBroker,
-	}
+// peek returns the value of key without counting the lookup.
+func (c *meteredCache[T]) peek(key string) (T, bool) {
+	return c.Cache.Get(key)
+}
 
-	vaultCli, err := vault.New(vault.ClientOptions{
-		Instance:  config.Instance,
-		Role:      vault.RoleName(key),
-		URL:       config.VaultURL,
-		CACert:    api.PathCACert,
-		TokenPath: config.TokenPath,
-	})
-	if err != nil {
-		return err
-	}
-	str.vaultCli = vaultCli
+// compSource holds the Component definitions of an AppDeployment or the
+// Platform and the generation of the resource they were read from.
+type compSource struct {
+	gen  int64
+	defs map[string]*api.ComponentDefinition
+}
 
-	if err := v1alpha1.AddToScheme(scheme.Scheme); err != nil {
-		return str.log.ErrorN("adding KubeFox CRs to scheme failed: %v", err)
-	}
+// veRelease holds the parsed routes of the active Release of a
+// VirtualEnvironment, a matcher per AppDeployment, and the hash of the Release,
+// its data and AppDeployments.
+type veRelease struct {
+	hash     uint64
+	matchers []*matcher.EventMatcher
+}
 
-	cfg, err := ctrl.GetConfig()
-	if err != nil {
-		return str.log.ErrorN("loading K8s config failed: %v", err)
-	}
+// cachedSecrets are the secrets of a data provider and the version of the
+// secrets they were read at.
+type cachedSecrets struct {
+	key     api.DataKey
+	version int64
+	data    *api.Data
+}
 
-	str.resCache, err = ctrlcache.New(cfg, ctrlcache.Options{
-		Scheme:                      scheme.Scheme,
-		DefaultNamespaces:           map[string]ctrlcache.Config{config.Namespace: {}},
-		ReaderFailOnMissingInformer: true,
-	})
-	if err != nil {
-		return str.log.ErrorN("creating resource cache failed: %v", err)
+func (str *store) Open() error {
+	ctx, cancel := context.WithTimeout(str.ctx, time.Minute*3)
+	defer cancel()
+
+	if err := str.src.Open(ctx, str); err != nil {
+		return err
 	}
 
-	err = str.initInformers(initCtx,
-		&v1alpha1.Environment{},
-		&v1alpha1.VirtualEnvironment{},
-		&v1alpha1.ReleaseManifest{},
-		&v1alpha1.HTTPAdapter{},
-		&v1alpha1.AppDeployment{},
-		&v1alpha1.Platform{},
-	)
-	if err != nil {
+	if err := str.updateComponentCache(ctx); err != nil {
+		return err
+	}
+	if err := str.updateReleaseMatcher(ctx); err != nil {
 		return err
 	}
 
-	go func() {
-		if err := str.resCache.Start(str.ctx); err != nil {
-			str.log.Error(err)
-		}
-	}()
-	str.resCache.WaitForCacheSync(initCtx)
+	go str.watchReleases()
+	go str.watchSecrets()
 
 	return nil
 }
 
-func (str *store) initInformers(ctx context.Context, objs ...client.Object) error {
-	for _, obj := range objs {
-		// Getting the informer adds it to the cache.
-		if inf, err := str.resCache.GetInformer(ctx, obj); err != nil {
-			return err
-		} else {
-			inf.AddEventHandler(str)
-		}
-	}
-	return nil
-}
-
 func (str *store) Close() {
 	str.cancel()
+	str.src.Close()
 }
 
 func (str *store) ComponentDef(ctx context.Context, comp *core.Component) (*api.ComponentDefinition, error) {
-	if str.compCache == nil {
+	str.compMutex.RLock()
+	compCache := str.compCache
+	str.compMutex.RUnlock()
+
+	if compCache == nil {
 		if err := str.updateComponentCache(ctx); err != nil {
 			return nil, err
 		}
+		str.compMutex.RLock()
+		compCache = str.compCache
+		str.compMutex.RUnlock()
 	}
 
-	def, found := str.compCache[comp.GroupKey()]
+	def, found := compCache[comp.GroupKey()]
 	if !found {
 		return nil, core.ErrNotFound()
 	}
@@ -207,17 +261,27 @@ func (str *store) IsGenesisAdapter(ctx context.Context, comp *core.Component) bo
 
 func (str *store) Platform(ctx context.Context) (*v1alpha1.Platform, error) {
 	obj := &v1alpha1.Platform{}
-	return obj, str.resCache.Get(ctx, str.key(config.Platform), obj)
+	return obj, str.src.Get(ctx, str.key(config.Platform), obj)
 }
 
 func (str *store) AppDeployment(ctx context.Context, name string) (*v1alpha1.AppDeployment, error) {
 	obj := &v1alpha1.AppDeployment{}
-	return obj, str.resCache.Get(ctx, str.key(name), obj)
+	return obj, str.src.Get(ctx, str.key(name), obj)
+}
+
+func (str *store) VirtualEnvironment(ctx context.Context, name string) (*v1alpha1.VirtualEnvironment, error) {
+	obj := &v1alpha1.VirtualEnvironment{}
+	return obj, str.src.Get(ctx, str.key(name), obj)
+}
+
+func (str *store) Environment(ctx context.Context, name string) (*v1alpha1.Environment, error) {
+	obj := &v1alpha1.Environment{}
+	return obj, str.src.Get(ctx, k8s.Key("", name), obj)
 }
 
 func (str *store) ReleaseMatcher(ctx context.Context) (*matcher.EventMatcher, error) {
-	if str.releaseMatcher != nil {
-		return str.releaseMatcher, nil
+	if relM := str.releaseMatcher.Load(); relM != nil {
+		return relM, nil
 	}
 
 	// There are no matchers in cache, perform full reload.
@@ -225,7 +289,43 @@ func (str *store) ReleaseMatcher(ctx context.Context) (*matcher.EventMatcher, er
 		return nil, err
 	}
 
-	return str.releaseMatcher, nil
+	return str.releaseMatcher.Load(), nil
+}
+
+// ReloadReleaseMatcher rebuilds the release matcher from the active Releases
+// of all VirtualEnvironments.
+func (str *store) ReloadReleaseMatcher(ctx context.Context) error {
+	return str.updateReleaseMatcher(ctx)
+}
+
+// Caches returns the entries of each cache by cache name.
+func (str *store) Caches() map[string][]cache.Entry {
+	return map[string][]cache.Entry{
+		cacheDeploymentMatcher: str.depMatcherCache.Entries(),
+		cacheSecrets:           str.secretsCache.Entries(),
+		cacheValidation:        str.validationCache.Entries(),
+	}
+}
+
+// EvictCache deletes all entries of the named cache. If name is empty all
+// caches are cleared.
+func (str *store) EvictCache(name string) error {
+	switch name {
+	case "":
+		str.depMatcherCache.Clear()
+		str.secretsCache.Clear()
+		str.validationCache.Clear()
+	case cacheDeploymentMatcher:
+		str.depMatcherCache.Clear()
+	case cacheSecrets:
+		str.secretsCache.Clear()
+	case cacheValidation:
+		str.validationCache.Clear()
+	default:
+		return core.ErrNotFound(fmt.Errorf("cache '%s' not found", name))
+	}
+
+	return nil
 }
 
 func (str *store) DeploymentMatcher(ctx *BrokerEventContext) (*matcher.EventMatcher, error) {
@@ -263,10 +363,10 @@ func (str *store) AttachEventContext(ctx *BrokerEventContext) error {
 		key      string
 	)
 
-	if err := str.resCache.Get(ctx, str.key(ctx.Event.Context.VirtualEnvironment), ve); err != nil {
+	if err := str.src.Get(ctx, str.key(ctx.Event.Context.VirtualEnvironment), ve); err != nil {
 		return err
 	}
-	if err := str.resCache.Get(ctx, str.key(ctx.Event.Context.AppDeployment), appDep); err != nil {
+	if err := str.src.Get(ctx, str.key(ctx.Event.Context.AppDeployment), appDep); err != nil {
 		return err
 	}
 
@@ -274,7 +374,7 @@ func (str *store) AttachEventContext(ctx *BrokerEventContext) error {
 	switch {
 	case ctx.Event.Context.ReleaseManifest != "":
 		manifest = &v1alpha1.ReleaseManifest{}
-		if err := str.resCache.Get(ctx, str.key(ctx.Event.Context.ReleaseManifest), manifest); err != nil {
+		if err := str.src.Get(ctx, str.key(ctx.Event.Context.ReleaseManifest), manifest); err != nil {
 			return err
 		}
 
@@ -287,7 +387,7 @@ func (str *store) AttachEventContext(ctx *BrokerEventContext) error {
 
 	default:
 		env := &v1alpha1.Environment{}
-		if err := str.resCache.Get(ctx, k8s.Key("", ve.Spec.Environment), env); err != nil {
+		if err := str.src.Get(ctx, k8s.Key("", ve.Spec.Environment), env); err != nil {
 			return err
 		}
 
@@ -304,9 +404,8 @@ func (str *store) AttachEventContext(ctx *BrokerEventContext) error {
 
 	}
 
-	dataHash, _ := hashstructure.Hash(data, hashstructure.FormatV2, nil)
-	key = fmt.Sprintf("%s-%d_%s-%s",
-		appDep.UID, appDep.Generation, dataProviderUID, fmt.Sprint(dataHash))
+	key = fmt.Sprintf("%s-%d_%s-%d",
+		appDep.UID, appDep.Generation, dataProviderUID, hashData(data))
 
 	ctx.VirtualEnv = ve
 	ctx.AppDeployment = appDep
@@ -358,22 +457,29 @@ func (str *store) Adapter(ctx *BrokerEventContext, name string, typ api.Componen
 		return nil, core.ErrNotFound()
 	}
 
-	return a, str.resCache.Get(ctx, str.key(name), a)
+	return a, str.src.Get(ctx, str.key(name), a)
 }
 
 func (str *store) mergeSecrets(ctx context.Context, d api.DataProvider, data *api.Data) error {
-	key := fmt.Sprintf("%s-%s", d.GetDataKey(), d.GetResourceVersion())
+	dataKey := d.GetDataKey()
+	key := secretsKey(dataKey)
 	if secs, _ := str.secretsCache.Get(key); secs != nil {
 		str.log.Debugf("using cached secrets for '%s'", key)
-		data.Merge(secs)
+		data.Merge(secs.data)
 		return nil
 	}
 
+	// The version is read before the secrets. If the secrets are written in
+	// between they are read again after the next check of versions.
+	ver, _, err := str.src.DataVersion(ctx, dataKey)
+	if k8s.IgnoreNotFound(err) != nil {
+		return err
+	}
 	secs := &api.Data{}
-	if err := str.vaultCli.GetData(ctx, d.GetDataKey(), secs); k8s.IgnoreNotFound(err) != nil {
+	if err := str.src.GetData(ctx, dataKey, secs); k8s.IgnoreNotFound(err) != nil {
 		return err
 	}
-	str.secretsCache.Set(key, secs)
+	str.secretsCache.Set(key, &cachedSecrets{key: dataKey, version: ver, data: secs})
 	data.Merge(secs)
 
 	return nil
@@ -384,159 +490,553 @@ func (str *store) OnAdd(obj interface{}, isInInitialList bool) {
 		str.log.Debugf("%T initialized", obj)
 		return
 	}
-	str.onChange(obj, "added")
+	str.onChange(nil, obj, "added")
 }
 
 func (str *store) OnUpdate(oldObj, obj interface{}) {
-	str.onChange(obj, "updated")
+	if o, ok := oldObj.(client.Object); ok {
+		if n, ok := obj.(client.Object); ok && o.GetResourceVersion() == n.GetResourceVersion() {
+			// Periodic resync, nothing changed.
+			return
+		}
+	}
+	str.onChange(oldObj, obj, "updated")
 }
 
 func (str *store) OnDelete(obj interface{}) {
-	str.onChange(obj, "deleted")
+	if tomb, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+		obj = tomb.Obj
+	}
+	str.onChange(nil, obj, "deleted")
 }
 
-func (str *store) onChange(obj interface{}, op string) {
+// onChange invalidates the cache entries built from the changed resource.
+// Cached deployment matchers, validation problems and secrets are only evicted
+// if the generation of the resource changed, status updates do not affect
+// them. The Releases of VirtualEnvironments using the resource are queued to
+// be rebuilt.
+func (str *store) onChange(oldObj, obj interface{}, op string) {
 	str.log.Debugf("%T %s", obj, op)
 
-	updateComps := false
-	switch obj.(type) {
-	case *v1alpha1.AppDeployment, *v1alpha1.Platform:
-		updateComps = true
+	cur, ok := obj.(client.Object)
+	if !ok {
+		return
+	}
+	deleted := op == "deleted"
+	specChanged := true
+	if old, ok := oldObj.(client.Object); ok {
+		specChanged = old.GetGeneration() != cur.GetGeneration()
 	}
 
-	go func() {
+	var changedVEs []string
+	switch o := cur.(type) {
+	case *v1alpha1.AppDeployment:
+		if deleted {
+			str.setComponentSource(o.UID, nil)
+		} else {
+			str.updateAppDeploymentComponents(o)
+		}
+		if specChanged {
+			str.evictEventContexts(func(key string) bool {
+				return strings.HasPrefix(key, string(o.UID)+"-")
+			})
+		}
+		// Availability of the AppDeployment is part of its status.
+		for _, ve := range str.virtualEnvs() {
+			if ve.Status.ActiveRelease.ContainsAppDeployment(o.Name) {
+				changedVEs = append(changedVEs, ve.Name)
+			}
+		}
+
+	case *v1alpha1.Platform:
+		if deleted {
+			str.setComponentSource(o.UID, nil)
+		} else {
+			str.setComponentSource(o.UID, platformComponents(o))
+		}
+
+	case *v1alpha1.VirtualEnvironment:
+		if specChanged {
+			str.evictEventContexts(dataProviderKey(o.UID))
+			str.evictSecrets(o.GetDataKey())
+		}
+		changedVEs = []string{o.Name}
+
+	case *v1alpha1.ReleaseManifest:
+		if specChanged {
+			str.evictEventContexts(dataProviderKey(o.UID))
+			str.evictSecrets(o.GetDataKey())
+			for _, ve := range str.virtualEnvs() {
+				if ve.Status.ActiveRelease.ContainsReleaseManifest(o.Name) {
+					changedVEs = append(changedVEs, ve.Name)
+				}
+			}
+		}
+
+	case *v1alpha1.Environment:
+		if specChanged {
+			str.evictSecrets(o.GetDataKey())
+			for _, ve := range str.virtualEnvs() {
+				if ve.Spec.Environment == o.Name {
+					str.evictEventContexts(dataProviderKey(ve.UID))
+					changedVEs = append(changedVEs, ve.Name)
+				}
+			}
+		}
+	}
+
+	str.queueReleases(changedVEs...)
+}
+
+func (str *store) virtualEnvs() []v1alpha1.VirtualEnvironment {
+	veList := &v1alpha1.VirtualEnvironmentList{}
+	if err := str.src.List(str.ctx, veList); err != nil {
+		str.log.Warnf("listing VirtualEnvironments failed: %v", err)
+		return nil
+	}
+
+	return veList.Items
+}
+
+// evictEventContexts deletes the cached deployment matchers and validation
+// problems of event contexts whose key matches fn. Keys are made of the UID and
+// generation of the AppDeployment and the UID of the data provider.
+func (str *store) evictEventContexts(fn func(key string) bool) {
+	for _, e := range str.depMatcherCache.Entries() {
+		if fn(e.Key) {
+			str.depMatcherCache.Delete(e.Key)
+			brktel.StoreInvalidations.WithLabelValues(cacheDeploymentMatcher).Inc()
+		}
+	}
+	for _, e := range str.validationCache.Entries() {
+		if fn(e.Key) {
+			str.validationCache.Delete(e.Key)
+			brktel.StoreInvalidations.WithLabelValues(cacheValidation).Inc()
+		}
+	}
+}
+
+// dataProviderKey returns a func matching keys of event contexts whose data is
+// provided by the resource with uid.
+func dataProviderKey(uid types.UID) func(string) bool {
+	return func(key string) bool {
+		return strings.Contains(key, "_"+string(uid)+"-")
+	}
+}
+
+func (str *store) evictSecrets(key api.DataKey) {
+	k := secretsKey(key)
+	if _, found := str.secretsCache.peek(k); found {
+		str.secretsCache.Delete(k)
+		brktel.StoreInvalidations.WithLabelValues(cacheSecrets).Inc()
+	}
+}
+
+func secretsKey(key api.DataKey) string {
+	return fmt.Sprintf("%s/%s/%s", key.Namespace, key.Kind, key.Name)
+}
+
+// watchSecrets periodically evicts cached secrets whose version changed since
+// they were read. Secrets can be written to Vault without changing the
+// resource they belong to.
+func (str *store) watchSecrets() {
+	ticker := time.NewTicker(secretsCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			str.checkSecrets()
+		case <-str.ctx.Done():
+			return
+		}
+	}
+}
+
+func (str *store) checkSecrets() {
+	ctx, cancel := context.WithTimeout(str.ctx, secretsCheckInterval)
+	defer cancel()
+
+	for _, e := range str.secretsCache.Entries() {
+		secs, _ := str.secretsCache.peek(e.Key)
+		if secs == nil {
+			continue
+		}
+
+		ver, updated, err := str.src.DataVersion(ctx, secs.key)
+		if k8s.IgnoreNotFound(err) != nil {
+			str.log.Debugf("checking version of secrets '%s' failed: %v", e.Key, err)
+			continue
+		}
+		if ver == secs.version {
+			continue
+		}
+
+		str.log.Debugf("secrets '%s' changed from version %d to %d", e.Key, secs.version, ver)
+		str.secretsCache.Delete(e.Key)
+		brktel.StoreInvalidations.WithLabelValues(cacheSecrets).Inc()
+		if !updated.IsZero() {
+			brktel.StoreStaleness.WithLabelValues(cacheSecrets).Observe(time.Since(updated).Seconds())
+		}
+	}
+}
+
+// queueReleases queues the Releases of the named VirtualEnvironments to be
+// rebuilt. Changes received while a rebuild is in progress are coalesced into
+// the next rebuild.
+func (str *store) queueReleases(names ...string) {
+	if len(names) == 0 {
+		return
+	}
+
+	now := time.Now()
+	str.pendingMutex.Lock()
+	for _, name := range names {
+		if _, found := str.pending[name]; !found {
+			str.pending[name] = now
+		}
+	}
+	str.pendingMutex.Unlock()
+
+	select {
+	case str.releaseChanged <- struct{}{}:
+	default:
+	}
+}
+
+func (str *store) watchReleases() {
+	for {
+		select {
+		case <-str.releaseChanged:
+		case <-str.ctx.Done():
+			return
+		}
+
+		str.pendingMutex.Lock()
+		pending := str.pending
+		str.pending = make(map[string]time.Time)
+		str.pendingMutex.Unlock()
+
+		names := make([]string, 0, len(pending))
+		for name := range pending {
+			names = append(names, name)
+		}
+
 		ctx, cancel := context.WithTimeout(str.ctx, time.Minute)
-		defer cancel()
+		err := str.updateReleaseMatcher(ctx, names...)
+		cancel()
+		if err != nil {
+			str.log.Warnf("rebuilding release matcher failed: %v", err)
+			continue
+		}
 
-		if updateComps {
-			str.updateComponentCache(ctx)
+		for _, received := range pending {
+			brktel.StoreStaleness.WithLabelValues(cacheReleaseMatcher).Observe(time.Since(received).Seconds())
 		}
-		str.updateReleaseMatcher(ctx)
-	}()
+	}
+}
+
+func (str *store) OnReleaseChange(fn func(virtualEnv string)) {
+	str.releaseMutex.Lock()
+	defer str.releaseMutex.Unlock()
+
+	str.releaseHooks = append(str.releaseHooks, fn)
 }
 
+// updateComponentCache reads the Component definitions of all AppDeployments
+// and the Platform.
 func (str *store) updateComponentCache(ctx context.Context) error {
-	compCache := map[string]*api.ComponentDefinition{}
+	start := time.Now()
+
+	sources := map[types.UID]*compSource{}
 
 	appDepList := &v1alpha1.AppDeploymentList{}
-	if err := str.resCache.List(ctx, appDepList); err != nil {
+	if err := str.src.List(ctx, appDepList); err != nil {
 		// Force rebuild on next used.
-		str.compCache = nil
+		str.setComponentSources(nil)
 		return err
 	}
-
-	for _, appDep := range appDepList.Items {
-		for compName, compSpec := range appDep.Spec.Components {
-			comp := core.NewComponent(
-				compSpec.Type,
-				appDep.Spec.AppName,
-				compName,
-				compSpec.Hash,
-			)
-			compCache[comp.GroupKey()] = compSpec
-		}
+	for i := range appDepList.Items {
+		appDep := &appDepList.Items[i]
+		sources[appDep.UID] = appDeploymentComponents(appDep)
 	}
 
 	p := &v1alpha1.Platform{}
-	if err := str.resCache.Get(ctx, str.key(config.Platform), p); err != nil {
+	if err := str.src.Get(ctx, str.key(config.Platform), p); err != nil {
 		// Force rebuild on next used.
-		str.compCache = nil
+		str.setComponentSources(nil)
 		return err
 	}
+	sources[p.UID] = platformComponents(p)
+
+	str.setComponentSources(sources)
+	brktel.StoreRebuildDuration.WithLabelValues(cacheComponents).Observe(time.Since(start).Seconds())
+
+	return nil
+}
+
+// updateAppDeploymentComponents replaces the Component definitions of the
+// AppDeployment if its generation changed.
+func (str *store) updateAppDeploymentComponents(appDep *v1alpha1.AppDeployment) {
+	str.compMutex.RLock()
+	cur := str.compSources[appDep.UID]
+	str.compMutex.RUnlock()
+
+	if cur != nil && cur.gen == appDep.Generation {
+		return
+	}
+	str.setComponentSource(appDep.UID, appDeploymentComponents(appDep))
+}
+
+// setComponentSource replaces the Component definitions read from the resource
+// with uid and merges the definitions of all resources. If src is nil the
+// definitions of the resource are removed.
+func (str *store) setComponentSource(uid types.UID, src *compSource) {
+	start := time.Now()
+
+	str.compMutex.Lock()
+	defer str.compMutex.Unlock()
+
+	if str.compSources == nil {
+		// Cache is rebuilt on next use.
+		return
+	}
+	if src == nil {
+		delete(str.compSources, uid)
+	} else {
+		str.compSources[uid] = src
+	}
+	str.compCache = mergeComponentSources(str.compSources)
+
+	brktel.StoreRebuildDuration.WithLabelValues(cacheComponents).Observe(time.Since(start).Seconds())
+}
+
+func (str *store) setComponentSources(sources map[types.UID]*compSource) {
+	str.compMutex.Lock()
+	defer str.compMutex.Unlock()
+
+	str.compSources = sources
+	if sources == nil {
+		str.compCache = nil
+	} else {
+		str.compCache = mergeComponentSources(sources)
+	}
+}
+
+func mergeComponentSources(sources map[types.UID]*compSource) map[string]*api.ComponentDefinition {
+	compCache := map[string]*api.ComponentDefinition{}
+	for _, src := range sources {
+		for k, def := range src.defs {
+			compCache[k] = def
+		}
+	}
+
+	return compCache
+}
+
+func appDeploymentComponents(appDep *v1alpha1.AppDeployment) *compSource {
+	src := &compSource{
+		gen:  appDep.Generation,
+		defs: map[string]*api.ComponentDefinition{},
+	}
+	for compName, compSpec := range appDep.Spec.Components {
+		comp := core.NewComponent(
+			compSpec.Type,
+			appDep.Spec.AppName,
+			compName,
+			compSpec.Hash,
+		)
+		src.defs[comp.GroupKey()] = compSpec
+	}
 
+	return src
+}
+
+func platformComponents(p *v1alpha1.Platform) *compSource {
+	src := &compSource{
+		gen:  p.Generation,
+		defs: map[string]*api.ComponentDefinition{},
+	}
 	for _, c := range p.Status.Components {
 		if c.Name == api.PlatformComponentHTTPSrv {
 			comp := core.NewPlatformComponent(c.Type, c.Name, c.Hash)
-			compCache[comp.GroupKey()] = &api.ComponentDefinition{
+			src.defs[comp.GroupKey()] = &api.ComponentDefinition{
 				Type: c.Type,
 			}
 		}
 	}
 
-	str.compCache = compCache
-	return nil
+	return src
 }
 
-func (str *store) updateReleaseMatcher(ctx context.Context) error {
-	veList := &v1alpha1.VirtualEnvironmentList{}
-	if err := str.resCache.List(ctx, veList); err != nil {
-		// Force rebuild on next use.
-		str.releaseMatcher = nil
-		return err
-	}
-
-	relM := matcher.New()
-	for _, ve := range veList.Items {
-		release := ve.Status.ActiveRelease
-		if release == nil {
-			str.log.Debugf("VirtualEnvironment '%s' does not have an active Release", ve.Name)
-			continue
+// updateReleaseMatcher rebuilds the Releases of the named VirtualEnvironments
+// and merges the parsed routes of all Releases into the release matcher. If
+// no names are passed, or the matcher was never built, the Releases of all
+// VirtualEnvironments are rebuilt. Release hooks are called for each
+// VirtualEnvironment whose Release changed or was removed.
+func (str *store) updateReleaseMatcher(ctx context.Context, names ...string) error {
+	str.releaseMutex.Lock()
+	defer str.releaseMutex.Unlock()
+
+	start := time.Now()
+
+	releases := make(map[string]*veRelease, len(str.releases))
+	if len(names) == 0 || str.releases == nil {
+		veList := &v1alpha1.VirtualEnvironmentList{}
+		if err := str.src.List(ctx, veList); err != nil {
+			// Force rebuild on next use.
+			str.releaseMatcher.Store(nil)
+			str.releases = nil
+			return err
 		}
-
-		var data *api.Data
-		switch {
-		case release.ReleaseManifest != "":
-			manifest := &v1alpha1.ReleaseManifest{}
-			if err := str.resCache.Get(ctx, str.key(release.ReleaseManifest), manifest); err != nil {
-				str.log.Warn(err)
-				continue
+		for i := range veList.Items {
+			if rel := str.buildRelease(ctx, &veList.Items[i]); rel != nil {
+				releases[veList.Items[i].Name] = rel
 			}
-			data = &manifest.Data
-
-		default:
-			env := &v1alpha1.Environment{}
-			if err := str.resCache.Get(ctx, k8s.Key("", ve.Spec.Environment), env); err != nil {
-				str.log.Warn(err)
-				continue
-			}
-			ve.Data.Import(&env.Data)
-			data = &ve.Data
 		}
 
-		for appName, app := range release.Apps {
-			appDep := &v1alpha1.AppDeployment{}
-			if err := str.resCache.Get(ctx, str.key(app.AppDeployment), appDep); err != nil {
-				str.log.Warn(err)
+	} else {
+		for name, rel := range str.releases {
+			releases[name] = rel
+		}
+		for _, name := range names {
+			delete(releases, name)
+
+			ve := &v1alpha1.VirtualEnvironment{}
+			if err := str.src.Get(ctx, str.key(name), ve); err != nil {
+				if !k8s.IsNotFound(err) {
+					// Keep previous Release.
+					str.log.Warn(err)
+					if rel := str.releases[name]; rel != nil {
+						releases[name] = rel
+					}
+				}
 				continue
 			}
-
-			avail := k8s.Condition(appDep.Status.Conditions, api.ConditionTypeAvailable)
-			if avail.Status == metav1.ConditionFalse {
-				str.log.Debugf("AppDeployment '%s/%s' for App '%s' is not available, reason: '%s'",
-					appDep.Namespace, appDep.Name, appName, avail.Reason)
-				continue
+			if rel := str.buildRelease(ctx, ve); rel != nil {
+				releases[name] = rel
 			}
+		}
+	}
 
-			brkCtx := &BrokerEventContext{
-				Context:       ctx,
-				AppDeployment: appDep,
-				Data:          data,
-				Event: &core.Event{
-					Context: &core.EventContext{
-						Platform:           config.Platform,
-						VirtualEnvironment: ve.Name,
-						AppDeployment:      appDep.Name,
-						ReleaseManifest:    release.ReleaseManifest,
-					},
-				},
-			}
+	veNames := make([]string, 0, len(releases))
+	for name := range releases {
+		veNames = append(veNames, name)
+	}
+	slices.Sort(veNames)
 
-			routes, err := str.buildRoutes(brkCtx)
-			if err != nil {
-				str.log.Warn(err)
+	// Routes of unchanged Releases are not parsed again, only the order they
+	// are tested in is rebuilt.
+	var matchers []*matcher.EventMatcher
+	for _, name := range veNames {
+		matchers = append(matchers, releases[name].matchers...)
+	}
+	relM := matcher.Merge(matchers...)
+
+	if str.releases != nil {
+		for ve, rel := range str.releases {
+			if r, found := releases[ve]; found && r.hash == rel.hash {
 				continue
 			}
-			if err := relM.AddRoutes(routes...); err != nil {
-				str.log.Warn(err)
-				continue
+			str.log.Debugf("Release of VirtualEnvironment '%s' changed", ve)
+			for _, fn := range str.releaseHooks {
+				go fn(ve)
 			}
 		}
 	}
 
-	str.releaseMatcher = relM
+	str.releases = releases
+	str.releaseMatcher.Store(relM)
+	brktel.StoreRebuildDuration.WithLabelValues(cacheReleaseMatcher).Observe(time.Since(start).Seconds())
+
 	return nil
 }
 
+// buildRelease parses the routes of the active Release of the
+// VirtualEnvironment. If the VirtualEnvironment does not have an active Release
+// or its data cannot be read nil is returned.
+func (str *store) buildRelease(ctx context.Context, ve *v1alpha1.VirtualEnvironment) *veRelease {
+	release := ve.Status.ActiveRelease
+	if release == nil {
+		str.log.Debugf("VirtualEnvironment '%s' does not have an active Release", ve.Name)
+		return nil
+	}
+
+	var data *api.Data
+	switch {
+	case release.ReleaseManifest != "":
+		manifest := &v1alpha1.ReleaseManifest{}
+		if err := str.src.Get(ctx, str.key(release.ReleaseManifest), manifest); err != nil {
+			str.log.Warn(err)
+			return nil
+		}
+		data = &manifest.Data
+
+	default:
+		env := &v1alpha1.Environment{}
+		if err := str.src.Get(ctx, k8s.Key("", ve.Spec.Environment), env); err != nil {
+			str.log.Warn(err)
+			return nil
+		}
+		ve.Data.Import(&env.Data)
+		data = &ve.Data
+	}
+
+	rel := &veRelease{}
+	appDepGens := make(map[string]int64, len(release.Apps))
+	for appName, app := range release.Apps {
+		appDep := &v1alpha1.AppDeployment{}
+		if err := str.src.Get(ctx, str.key(app.AppDeployment), appDep); err != nil {
+			str.log.Warn(err)
+			continue
+		}
+		appDepGens[appDep.Name] = appDep.Generation
+
+		avail := k8s.Condition(appDep.Status.Conditions, api.ConditionTypeAvailable)
+		if avail.Status == metav1.ConditionFalse {
+			str.log.Debugf("AppDeployment '%s/%s' for App '%s' is not available, reason: '%s'",
+				appDep.Namespace, appDep.Name, appName, avail.Reason)
+			continue
+		}
+
+		brkCtx := &BrokerEventContext{
+			Context:       ctx,
+			AppDeployment: appDep,
+			Data:          data,
+			Event: &core.Event{
+				Context: &core.EventContext{
+					Platform:           config.Platform,
+					VirtualEnvironment: ve.Name,
+					AppDeployment:      appDep.Name,
+					ReleaseManifest:    release.ReleaseManifest,
+				},
+			},
+		}
+
+		routes, err := str.buildRoutes(brkCtx)
+		if err != nil {
+			str.log.Warn(err)
+			continue
+		}
+		// Routes of each AppDeployment are parsed separately so one
+		// AppDeployment cannot break the matcher.
+		appDepM := matcher.New()
+		if err := appDepM.AddRoutes(routes...); err != nil {
+			str.log.Warn(err)
+			continue
+		}
+		rel.matchers = append(rel.matchers, appDepM)
+	}
+
+	rel.hash, _ = hashstructure.Hash(struct {
+		Id, ReleaseManifest string
+		Data                uint64
+		AppDeployments      map[string]int64
+	}{release.Id, release.ReleaseManifest, hashData(data), appDepGens}, hashstructure.FormatV2, nil)
+
+	return rel
+}
+
 func (str *store) buildRoutes(ctx *BrokerEventContext) ([]*core.Route, error) {
 	var routes []*core.Route
 	for compName, compSpec := range ctx.AppDeployment.Spec.Components {
@@ -551,6 +1051,7 @@ func (str *store) buildRoutes(ctx *BrokerEventContext) ([]*core.Route, error) {
 			if err != nil {
 				return nil, err
 			}
+			route.Priority = r.Priority
 			route.Component = comp
 			route.EventContext = &core.EventContext{
 				Platform:           ctx.Event.Context.Platform,
@@ -569,6 +1070,16 @@ func (str *store) buildRoutes(ctx *BrokerEventContext) ([]*core.Route, error) {
 	return routes, nil
 }
 
+// hashData returns the hash of the data. The values of Vals are unexported and
+// ignored by hashstructure, the JSON encoding of the data is hashed instead.
+func hashData(data *api.Data) uint64 {
+	b, _ := json.Marshal(data)
+	h := fnv.New64a()
+	h.Write(b)
+
+	return h.Sum64()
+}
+
 func (str *store) key(name string) types.NamespacedName {
 	return k8s.Key(config.Namespace, name)
 }
@@ -11,8 +11,12 @@ package engine
 import (
 	"context"
 	"fmt"
+	"hash/fnv"
+	"slices"
+	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/xigxog/kubefox/api"
 	"github.com/xigxog/kubefox/core"
@@ -22,6 +26,11 @@ import (
 type SubscriptionMgr interface {
 	Create(ctx context.Context, cfg *SubscriptionConf) (ReplicaSubscription, GroupSubscription, error)
 	Subscription(comp *core.Component) (Subscription, bool)
+	ReplicaSubscription(comp *core.Component) (ReplicaSubscription, bool)
+	Subscriptions() []ReplicaSubscription
+	Groups() []*GroupInfo
+	// Count returns the number of replica and group subscriptions.
+	Count() (replicas int, groups int)
 	Close()
 	Adapter(componentType api.ComponentType) (GroupSubscription, bool)
 }
@@ -44,10 +53,37 @@ type ReplicaSubscription interface {
 	Component() *core.Component
 	ComponentDef() *api.ComponentDefinition
 	IsGroupEnabled() bool
+	// InFlight returns the number of requests sent to the replica that it has
+	// not responded to.
+	InFlight() int
+	// IsInFlight returns true if the request was sent to the replica and it
+	// has not responded to it.
+	IsInFlight(id string) bool
+	// TakeContentRef returns true if an event referencing the offloaded
+	// content was sent to the replica. The reference can only be taken once.
+	TakeContentRef(ref string) bool
+	// Complete marks the request the response is for as responded to by the
+	// replica and records the result of the request.
+	Complete(resp *core.Event)
+	// Seen records an event received from the replica, status is set if the
+	// event is a reply to a heartbeat.
+	Seen(status *api.ReplicaStatus)
+	// CheckHealth ejects or reinstates the replica based on its heartbeats
+	// and request results and returns its health.
+	CheckHealth() *HealthInfo
+	Health() *HealthInfo
 	Cancel(err error)
 	Err() error
 }
 
+// GroupInfo describes a group subscription and the replicas that are members
+// of it.
+type GroupInfo struct {
+	Key      string   `json:"key"`
+	Adapter  bool     `json:"adapter,omitempty"`
+	Replicas []string `json:"replicas"`
+}
+
 type SubscriptionConf struct {
 	Component    *core.Component
 	ComponentDef *api.ComponentDefinition
@@ -68,8 +104,12 @@ type subscriptionMgr struct {
 type groupSubscription struct {
 	shortHash string
 	name      string
-	subMap    map[string]bool
+	subMap    map[string]*subscription
 	sendCh    chan *evtRespCh
+	mgr       *subscriptionMgr
+
+	// Incremented each time a replica is selected round robin.
+	next atomic.Uint64
 
 	ctx    context.Context
 	cancel context.CancelFunc
@@ -83,6 +123,10 @@ type subscription struct {
 	sendFunc   SendEvent
 	sendCh     chan *evtRespCh
 	grpEnabled bool
+	inFlight   *pendingReqs
+	// Offloaded content of events sent to the replica it has not fetched.
+	contentRefs *pendingReqs
+	health      *replicaHealth
 
 	ctx      context.Context
 	cancel   context.CancelCauseFunc
@@ -140,28 +184,32 @@ func (mgr *subscriptionMgr) Create(ctx context.Context, cfg *SubscriptionConf) (
 			s = &groupSubscription{
 				shortHash: cfg.Component.ShortHash(),
 				name:      cfg.Component.Name,
-				subMap:    make(map[string]bool),
+				subMap:    make(map[string]*subscription),
 				sendCh:    make(chan *evtRespCh),
+				mgr:       mgr,
 				ctx:       ctx,
 				cancel:    cancel,
 			}
 			mgr.grpMap[cfg.Component.GroupKey()] = s
 		}
-		s.subMap[cfg.Component.Id] = true
 		grpSub = s
 	}
 
 	subCtx, subCancel := context.WithCancelCause(ctx)
 	sub := &subscription{
-		comp:       cfg.Component,
-		compDef:    cfg.ComponentDef,
-		mgr:        mgr,
-		sendFunc:   cfg.SendFunc,
-		grpEnabled: cfg.EnableGroup,
-		ctx:        subCtx,
-		cancel:     subCancel,
+		comp:        cfg.Component,
+		compDef:     cfg.ComponentDef,
+		mgr:         mgr,
+		sendFunc:    cfg.SendFunc,
+		grpEnabled:  cfg.EnableGroup,
+		inFlight:    newPendingReqs(),
+		contentRefs: newPendingReqs(),
+		health:      newReplicaHealth(),
+		ctx:         subCtx,
+		cancel:      subCancel,
 	}
 	if grpSub != nil {
+		grpSub.subMap[cfg.Component.Id] = sub
 		sub.sendCh = grpSub.sendCh
 		go sub.processSendChan()
 
@@ -220,16 +268,58 @@ func (mgr *subscriptionMgr) GroupSubscription(comp *core.Component) (GroupSubscr
 }
 
 func (mgr *subscriptionMgr) Subscriptions() []ReplicaSubscription {
+	mgr.mutex.RLock()
+	defer mgr.mutex.RUnlock()
+
 	list := make([]ReplicaSubscription, 0, len(mgr.subMap))
-	for _, s := range mgr.subMap {
-		if sub, found := mgr.ReplicaSubscription(s.Component()); found {
+	for _, sub := range mgr.subMap {
+		if sub.IsActive() {
 			list = append(list, sub)
 		}
 	}
+	slices.SortFunc(list, func(a, b ReplicaSubscription) int {
+		return strings.Compare(a.Component().Key(), b.Component().Key())
+	})
+
+	return list
+}
+
+func (mgr *subscriptionMgr) Groups() []*GroupInfo {
+	mgr.mutex.RLock()
+	defer mgr.mutex.RUnlock()
+
+	adapters := make(map[*groupSubscription]bool, len(mgr.adapterMap))
+	for _, grp := range mgr.adapterMap {
+		adapters[grp] = true
+	}
+
+	list := make([]*GroupInfo, 0, len(mgr.grpMap))
+	for key, grp := range mgr.grpMap {
+		info := &GroupInfo{
+			Key:      key,
+			Adapter:  adapters[grp],
+			Replicas: make([]string, 0, len(grp.subMap)),
+		}
+		for id := range grp.subMap {
+			info.Replicas = append(info.Replicas, id)
+		}
+		slices.Sort(info.Replicas)
+		list = append(list, info)
+	}
+	slices.SortFunc(list, func(a, b *GroupInfo) int {
+		return strings.Compare(a.Key, b.Key)
+	})
 
 	return list
 }
 
+func (mgr *subscriptionMgr) Count() (int, int) {
+	mgr.mutex.RLock()
+	defer mgr.mutex.RUnlock()
+
+	return len(mgr.subMap), len(mgr.grpMap)
+}
+
 func (mgr *subscriptionMgr) Close() {
 	mgr.log.Info("subscription manager closing")
 
@@ -255,6 +345,19 @@ func (mgr *subscriptionMgr) cancel(sub *subscription, err error) {
 			log.Debug("component group is empty, canceling")
 			grp.cancel()
 			delete(mgr.grpMap, sub.comp.GroupKey())
+		} else {
+			// Ensure the group still has a replica to send events to.
+			var remaining *subscription
+			for _, s := range grp.subMap {
+				if !s.health.isEjected() {
+					remaining = nil
+					break
+				}
+				remaining = s
+			}
+			if remaining != nil {
+				remaining.reinstate()
+			}
 		}
 	}
 
@@ -269,7 +372,18 @@ func (sub *groupSubscription) Name() string {
 	return sub.name
 }
 
+// SendEvent sends the event to a replica of the group selected by the load
+// balancing strategy of the Component. With the default LocalFirst strategy
+// the event is sent to the first replica ready to receive it.
 func (grp *groupSubscription) SendEvent(evt *BrokerEventContext) error {
+	if lb := loadBalancing(evt); lb != nil && lb.Strategy != "" &&
+		lb.Strategy != api.LoadBalancingLocalFirst {
+
+		if sub := grp.selectReplica(evt, lb); sub != nil {
+			return sub.SendEvent(evt)
+		}
+	}
+
 	respCh := make(chan *sendResp)
 	grp.sendCh <- &evtRespCh{mEvt: evt, respCh: respCh}
 	resp := <-respCh
@@ -286,13 +400,49 @@ func (sub *groupSubscription) Context() context.Context {
 }
 
 func (sub *subscription) SendEvent(evt *BrokerEventContext) error {
+	// Added before sending so the request is in flight if the replica
+	// responds before sendFunc returns.
+	req := evt.Event.Category == core.Category_REQUEST
+	if req {
+		sub.inFlight.add(evt.Event.Id, time.Now().Add(evt.Event.TTL()))
+	}
+	ref := evt.Event.Value(api.ValKeyContentRef)
+	if ref != "" {
+		sub.contentRefs.add(ref, time.Now().Add(evt.Event.TTL()))
+		// Removes references that expired without being fetched.
+		sub.contentRefs.count()
+	}
 	if err := sub.sendFunc(evt); err != nil {
+		if req {
+			sub.inFlight.remove(evt.Event.Id)
+		}
+		if ref != "" {
+			sub.contentRefs.remove(ref)
+		}
+		sub.recordResult(err)
 		return err
 	}
 
 	return nil
 }
 
+func (sub *subscription) InFlight() int {
+	return sub.inFlight.count()
+}
+
+func (sub *subscription) IsInFlight(id string) bool {
+	return sub.inFlight.has(id)
+}
+
+func (sub *subscription) TakeContentRef(ref string) bool {
+	return sub.contentRefs.take(ref)
+}
+
+func (sub *subscription) Complete(resp *core.Event) {
+	sub.inFlight.remove(resp.ParentId)
+	sub.recordResult(resp.Err())
+}
+
 func (sub *subscription) IsActive() bool {
 	return !sub.canceled.Load()
 }
@@ -332,13 +482,105 @@ func (sub *subscription) Err() error {
 
 func (sub *subscription) processSendChan() {
 	for {
+		// Ejected replicas stop receiving from the group until reinstated.
+		sendCh := sub.sendCh
+		if sub.health.isEjected() {
+			sendCh = nil
+		}
+
 		select {
-		case evtRespCh := <-sub.sendCh:
+		case evtRespCh := <-sendCh:
 			err := sub.SendEvent(evtRespCh.mEvt)
 			evtRespCh.respCh <- &sendResp{Err: err}
 
+		case <-sub.health.changed:
+
 		case <-sub.ctx.Done():
 			return
 		}
 	}
 }
+
+// selectReplica returns the active replica subscribed to this Broker selected
+// by the strategy, replicas subscribed to other Brokers are not considered.
+// Ejected replicas are only selected if all replicas are ejected. Nil is
+// returned if the group has no active replicas.
+func (grp *groupSubscription) selectReplica(evt *BrokerEventContext, lb *api.LoadBalancing) *subscription {
+	grp.mgr.mutex.RLock()
+	var replicas, ejected []*subscription
+	for _, sub := range grp.subMap {
+		switch {
+		case !sub.IsActive():
+		case sub.health.isEjected():
+			ejected = append(ejected, sub)
+		default:
+			replicas = append(replicas, sub)
+		}
+	}
+	grp.mgr.mutex.RUnlock()
+
+	if len(replicas) == 0 {
+		replicas = ejected
+	}
+
+	if len(replicas) == 0 {
+		return nil
+	}
+	slices.SortFunc(replicas, func(a, b *subscription) int {
+		return strings.Compare(a.comp.Id, b.comp.Id)
+	})
+
+	switch lb.Strategy {
+	case api.LoadBalancingHash:
+		key := evt.Event.Header(lb.Header)
+		if key == "" {
+			key = evt.Event.TraceId()
+		}
+		if key == "" {
+			key = evt.Event.Id
+		}
+
+		// Rendezvous hashing moves only the keys of a replica when it is
+		// added or removed.
+		var (
+			selected *subscription
+			max      uint64
+		)
+		for _, sub := range replicas {
+			h := fnv.New64a()
+			h.Write([]byte(key + "|" + sub.comp.Id))
+			if s := h.Sum64(); selected == nil || s > max {
+				selected, max = sub, s
+			}
+		}
+		return selected
+
+	case api.LoadBalancingLeastLoaded:
+		// Start at the next replica so ties are spread evenly.
+		start := int(grp.next.Add(1) % uint64(len(replicas)))
+		selected, min := replicas[start], replicas[start].InFlight()
+		for i := 1; i < len(replicas); i++ {
+			sub := replicas[(start+i)%len(replicas)]
+			if n := sub.InFlight(); n < min {
+				selected, min = sub, n
+			}
+		}
+		return selected
+
+	default: // RoundRobin
+		return replicas[grp.next.Add(1)%uint64(len(replicas))]
+	}
+}
+
+// loadBalancing returns the load balancing strategy of the event's target.
+func loadBalancing(evt *BrokerEventContext) *api.LoadBalancing {
+	if evt.AppDeployment == nil || evt.Event == nil {
+		return nil
+	}
+	def, err := evt.AppDeployment.GetDefinition(evt.Event.Target)
+	if err != nil {
+		return nil
+	}
+
+	return def.LoadBalancing
+}
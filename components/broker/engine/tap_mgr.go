@@ -0,0 +1,153 @@
+// Copyright 2024 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package engine
+
+import (
+	"context"
+	"maps"
+	"math/rand"
+	"sync"
+
+	"github.com/xigxog/kubefox/api"
+	"github.com/xigxog/kubefox/core"
+	"github.com/xigxog/kubefox/logkf"
+	"github.com/xigxog/kubefox/matcher"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	tapBufferSize = 256
+	redactedValue = "[REDACTED]"
+)
+
+// TapMgr keeps track of open taps and sends them copies of routed events.
+// Sending never blocks, if a tap is not keeping up events are dropped.
+type TapMgr interface {
+	Create(ctx context.Context, req *core.TapRequest) (<-chan *core.Event, error)
+	Publish(evt *core.Event)
+}
+
+type tapMgr struct {
+	taps map[*tap]bool
+
+	mutex sync.RWMutex
+
+	log *logkf.Logger
+}
+
+type tap struct {
+	req     *core.TapRequest
+	matcher *matcher.EventMatcher
+	sendCh  chan *core.Event
+}
+
+func NewTapMgr() TapMgr {
+	return &tapMgr{
+		taps: make(map[*tap]bool),
+		log:  logkf.Global,
+	}
+}
+
+// Create opens a tap, the tap is closed once ctx is done.
+func (mgr *tapMgr) Create(ctx context.Context, req *core.TapRequest) (<-chan *core.Event, error) {
+	rule := req.Rule
+	if rule == "" {
+		rule = "All()"
+	}
+	route, err := core.NewRoute(0, rule)
+	if err != nil {
+		return nil, core.ErrRouteInvalid(err)
+	}
+	if err := route.Resolve(&api.Data{}); err != nil {
+		return nil, core.ErrRouteInvalid(err)
+	}
+	m := matcher.New()
+	if err := m.AddRoutes(route); err != nil {
+		return nil, core.ErrRouteInvalid(err)
+	}
+
+	t := &tap{
+		req:     req,
+		matcher: m,
+		sendCh:  make(chan *core.Event, tapBufferSize),
+	}
+
+	mgr.mutex.Lock()
+	mgr.taps[t] = true
+	mgr.mutex.Unlock()
+
+	mgr.log.Debugf("tap opened with rule '%s'", rule)
+
+	go func() {
+		<-ctx.Done()
+
+		mgr.mutex.Lock()
+		delete(mgr.taps, t)
+		close(t.sendCh)
+		mgr.mutex.Unlock()
+
+		mgr.log.Debugf("tap with rule '%s' closed", rule)
+	}()
+
+	return t.sendCh, nil
+}
+
+func (mgr *tapMgr) Publish(evt *core.Event) {
+	mgr.mutex.RLock()
+	defer mgr.mutex.RUnlock()
+
+	for t := range mgr.taps {
+		if !t.filter(evt) {
+			continue
+		}
+
+		// Matching can add params to the event, use a copy to prevent
+		// changes to the routed event.
+		cp := proto.Clone(evt).(*core.Event)
+		if _, matched := t.matcher.Match(cp); !matched {
+			continue
+		}
+		cp.Params = maps.Clone(evt.Params)
+		t.redact(cp)
+
+		select {
+		case t.sendCh <- cp:
+		default:
+			mgr.log.WithEvent(evt).Debug("tap buffer full, dropping event")
+		}
+	}
+}
+
+func (t *tap) filter(evt *core.Event) bool {
+	if t.req.VirtualEnvironment != "" &&
+		(evt.Context == nil || evt.Context.VirtualEnvironment != t.req.VirtualEnvironment) {
+		return false
+	}
+	if t.req.Component != "" &&
+		(evt.Source == nil || evt.Source.Name != t.req.Component) &&
+		(evt.Target == nil || evt.Target.Name != t.req.Component) {
+		return false
+	}
+	if t.req.SampleRate > 0 && t.req.SampleRate < 1 && rand.Float64() >= t.req.SampleRate {
+		return false
+	}
+
+	return true
+}
+
+func (t *tap) redact(evt *core.Event) {
+	if t.req.RedactContent {
+		evt.Content = nil
+	}
+	for _, h := range t.req.RedactHeaders {
+		if len(evt.HeaderAll(h)) > 0 {
+			evt.SetHeader(h, redactedValue)
+		}
+	}
+}
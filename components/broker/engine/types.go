@@ -30,6 +30,10 @@ const (
 	ReceiverGRPCServer
 	ReceiverHTTPServer
 	ReceiverHTTPClient
+	ReceiverAdminServer
+	ReceiverIdempotencyMgr
+	ReceiverDeadLetterMgr
+	ReceiverResponseCache
 )
 
 type SendEvent func(*BrokerEventContext) error
@@ -53,6 +57,10 @@ type BrokerEventContext struct {
 
 	TargetAdapter common.Adapter
 
+	// Token authorizing the explanation of a route miss, removed from the
+	// Event so it is not sent to Components.
+	ExplainToken string
+
 	Span   *telemetry.Span
 	Log    *logkf.Logger
 	Cancel context.CancelCauseFunc
@@ -139,6 +147,14 @@ func (r Receiver) String() string {
 		return "http-server"
 	case ReceiverHTTPClient:
 		return "http-client"
+	case ReceiverAdminServer:
+		return "admin-server"
+	case ReceiverIdempotencyMgr:
+		return "idempotency-mgr"
+	case ReceiverDeadLetterMgr:
+		return "dead-letter-mgr"
+	case ReceiverResponseCache:
+		return "response-cache"
 	default:
 		return "unknown"
 	}
@@ -0,0 +1,107 @@
+// Copyright 2024 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+const eventsUsage = `Query and replay events recorded by the Broker.
+
+Usage:
+  broker events query [flags]
+  broker events replay [flags]
+
+Use "broker events <command> -h" for command flags.
+`
+
+// events implements the events subcommand, a client of the Broker admin API.
+func events(args []string) {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, eventsUsage)
+		os.Exit(1)
+	}
+
+	var (
+		addr, traceId, evtId, comp, start, end, virtEnv string
+		limit                                           int
+		newId, testing                                  bool
+		timeout                                         time.Duration
+	)
+
+	cmd, args := args[0], args[1:]
+	flags := flag.NewFlagSet("events "+cmd, flag.ExitOnError)
+	flags.StringVar(&addr, "admin-addr", "127.0.0.1:1112", "Address and port of the Broker admin server.")
+	token := tokenFlag(flags)
+
+	var req *http.Request
+	switch cmd {
+	case "query":
+		flags.StringVar(&traceId, "trace-id", "", "Only include events that are part of trace.")
+		flags.StringVar(&evtId, "event-id", "", "Only include event with id.")
+		flags.StringVar(&comp, "component", "", "Only include events with a source or target matching Component name or group key.")
+		flags.StringVar(&start, "start", "", "Only include events recorded at or after time, RFC3339 format.")
+		flags.StringVar(&end, "end", "", "Only include events recorded at or before time, RFC3339 format.")
+		flags.IntVar(&limit, "limit", 0, "Maximum number of events to return, 0 returns all matching events.")
+		flags.Parse(args)
+
+		q := url.Values{}
+		setParam(q, "traceId", traceId)
+		setParam(q, "eventId", evtId)
+		setParam(q, "component", comp)
+		setParam(q, "start", start)
+		setParam(q, "end", end)
+		if limit > 0 {
+			q.Set("limit", strconv.Itoa(limit))
+		}
+		req, _ = http.NewRequest(http.MethodGet, fmt.Sprintf("http://%s/events?%s", addr, q.Encode()), nil)
+
+	case "replay":
+		flags.StringVar(&evtId, "event-id", "", "Id of the genesis event to replay. (required)")
+		flags.StringVar(&virtEnv, "virtual-env", "", "VirtualEnvironment to replay event into. (required)")
+		flags.BoolVar(&newId, "new-id", false, "Assign a new id to the replayed event.")
+		flags.BoolVar(&testing, "testing", false, "Use the VirtualEnvironment's current data instead of the active ReleaseManifest.")
+		flags.DurationVar(&timeout, "timeout", 0, "Timeout of the replayed event, defaults to the Platform default.")
+		flags.Parse(args)
+
+		if evtId == "" || virtEnv == "" {
+			fmt.Fprint(os.Stderr, "The flags \"event-id\" and \"virtual-env\" are required.\n\n")
+			flags.Usage()
+			os.Exit(1)
+		}
+
+		q := url.Values{}
+		q.Set("virtualEnv", virtEnv)
+		q.Set("newId", strconv.FormatBool(newId))
+		q.Set("testing", strconv.FormatBool(testing))
+		if timeout > 0 {
+			q.Set("timeout", timeout.String())
+		}
+		req, _ = http.NewRequest(http.MethodPost,
+			fmt.Sprintf("http://%s/events/%s/replay?%s", addr, url.PathEscape(evtId), q.Encode()), nil)
+
+	default:
+		fmt.Fprint(os.Stderr, eventsUsage)
+		os.Exit(1)
+	}
+
+	callAdmin(req, *token)
+}
+
+func setParam(q url.Values, key, val string) {
+	if val != "" {
+		q.Set(key, val)
+	}
+}
@@ -10,7 +10,9 @@ package main
 
 import (
 	"flag"
+	"os"
 	"runtime"
+	"strings"
 	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
@@ -25,20 +27,67 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "events" {
+		events(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "admin" {
+		admin(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "deadletters" {
+		deadLetters(os.Args[2:])
+		return
+	}
+
 	flag.StringVar(&config.Instance, "instance", "", "KubeFox instance Broker is part of. (required)")
 	flag.StringVar(&config.Platform, "platform", "", "Platform instance Broker if part of. (required)")
 	flag.StringVar(&config.Namespace, "namespace", "", "Namespace of Platform instance. (required)")
 	flag.StringVar(&config.GRPCSrvAddr, "grpc-addr", "127.0.0.1:6060", "Address and port the gRPC server should bind to.")
 	flag.StringVar(&config.HealthSrvAddr, "health-addr", "127.0.0.1:1111", `Address and port the HTTP health server should bind to, set to "false" to disable.`)
+	flag.StringVar(&config.AdminSrvAddr, "admin-addr", "127.0.0.1:1112", `Address and port the HTTP admin server should bind to, set to "false" to disable.`)
 	flag.StringVar(&config.NATSAddr, "nats-addr", "127.0.0.1:4222", "Address and port of NATS server.")
 	flag.StringVar(&config.VaultURL, "vault-url", "https://127.0.0.1:8200", "URL of Vault server.")
 	flag.StringVar(&config.TelemetryAddr, "telemetry-addr", "127.0.0.1:4318", `Address and port of OTEL telemetry collector, set to "false" to disable.`)
 	flag.DurationVar(&config.TelemetryInterval, "telemetry-interval", time.Minute, `Interval at which to report metrics, , set to "0" to disable.`)
 	flag.Int64Var(&config.MaxEventSize, "max-event-size", api.DefaultMaxEventSizeBytes, "Maximum size of event in bytes.")
+	flag.DurationVar(&config.IdempotencyWindow, "idempotency-window", api.DefaultIdempotencyWindowSeconds*time.Second, `Duration idempotency keys and stored responses are kept, set to "0" to disable.`)
+	flag.StringVar(&config.IdempotencyStorage, "idempotency-storage", string(api.StorageTypeFile), `Storage of idempotency key value bucket; one of ["File", "Memory"].`)
+	flag.BoolVar(&config.RateLimitLocal, "rate-limit-local", false, "Count requests limited by a RateLimitPolicy in memory instead of sharing counts between Brokers using NATS.")
+	flag.StringVar(&config.RateLimitStorage, "rate-limit-storage", string(api.StorageTypeMemory), `Storage of rate limit key value bucket; one of ["File", "Memory"].`)
+	flag.DurationVar(&config.DeadLetterRetention, "dead-letter-retention", api.DefaultDeadLetterRetentionSeconds*time.Second, `Duration messages that cannot be delivered are kept in the dead-letter stream, set to "0" to disable.`)
+	flag.IntVar(&config.DeadLetterMaxAttempts, "dead-letter-max-attempts", api.DefaultDeadLetterMaxAttempts, "Number of times delivery of a message is attempted before it is dead-lettered.")
+	flag.DurationVar(&config.DeadLetterBackoff, "dead-letter-backoff", api.DefaultDeadLetterBackoffSeconds*time.Second, "Delay before the first redelivery of a message, doubled for each following attempt.")
+	flag.DurationVar(&config.DeadLetterMaxBackoff, "dead-letter-max-backoff", api.DefaultDeadLetterMaxBackoffSeconds*time.Second, "Maximum delay between redeliveries of a message.")
+	flag.Int64Var(&config.DeadLetterMaxSize, "dead-letter-max-size", api.DefaultDeadLetterMaxSizeBytes, "Maximum size in bytes of the dead-letter and retry streams, the oldest messages are discarded once exceeded.")
+	flag.Int64Var(&config.DeadLetterMaxMsgs, "dead-letter-max-messages", api.DefaultDeadLetterMaxMessages, "Maximum number of messages in the dead-letter and retry streams, the oldest messages are discarded once exceeded.")
+	flag.Int64Var(&config.OffloadThreshold, "offload-threshold", 0, `Size in bytes above which event content is stored in the NATS object store, set to "0" to disable.`)
+	flag.Int64Var(&config.OffloadMaxSize, "offload-max-size", api.DefaultOffloadMaxSizeBytes, "Maximum size of event in bytes while offloading is enabled.")
+	flag.StringVar(&config.OffloadStorage, "offload-storage", string(api.StorageTypeFile), `Storage of event content object store bucket; one of ["File", "Memory"].`)
+	flag.Int64Var(&config.RespCacheMaxSize, "response-cache-max-size", api.DefaultResponseCacheMaxEntrySizeBytes, `Maximum size of cached response content in bytes, set to "0" to disable response caching.`)
+	flag.Int64Var(&config.RespCacheMaxMemory, "response-cache-max-memory", api.DefaultResponseCacheMaxMemorySizeBytes, "Maximum total size in bytes of responses cached in memory, least recently used responses are evicted.")
+	flag.BoolVar(&config.RespCacheShared, "response-cache-shared", false, "Share cached responses between Brokers using a NATS key value bucket.")
+	flag.StringVar(&config.RespCacheStorage, "response-cache-storage", string(api.StorageTypeMemory), `Storage of response cache key value bucket; one of ["File", "Memory"].`)
+	flag.BoolVar(&config.RecordEvents, "record-events", false, "Record routed events to the JetStream event stream for querying and replay.")
+	flag.Func("record-redact-headers", "Comma separated headers whose values are masked in recorded events, in addition to Authorization, Cookie, Proxy-Authorization and Set-Cookie.", func(s string) error {
+		for _, h := range strings.Split(s, ",") {
+			if h = strings.TrimSpace(h); h != "" {
+				config.RecordRedactHeaders = append(config.RecordRedactHeaders, h)
+			}
+		}
+		return nil
+	})
+	flag.DurationVar(&config.ShutdownTimeout, "shutdown-timeout", 20*time.Second, "Maximum time to wait for in-flight events to complete during shutdown.")
+	flag.DurationVar(&config.HeartbeatInterval, "heartbeat-interval", 10*time.Second, `Interval at which heartbeats are sent to subscribed components, set to "0" to disable.`)
+	flag.IntVar(&config.OutlierThreshold, "outlier-threshold", 5, `Number of consecutive errors or timeouts after which a replica is ejected, set to "0" to disable.`)
+	flag.DurationVar(&config.OutlierEjectionTime, "outlier-ejection-time", 30*time.Second, "Duration a replica ejected for errors or timeouts is not sent events.")
 	flag.IntVar(&config.NumWorkers, "num-workers", runtime.NumCPU(), "Number of worker threads to start, default is number of logical CPUs.")
 	flag.StringVar(&config.LogFormat, "log-format", "console", `Log format; one of ["json", "console"].`)
 	flag.StringVar(&config.LogLevel, "log-level", "debug", `Log level; one of ["debug", "info", "warn", "error"].`)
 	flag.StringVar(&config.TokenPath, "token-path", api.PathSvcAccToken, "Path to Service Account Token")
+	flag.StringVar(&config.ResourceDir, "resource-dir", "", "Directory of resource YAML files to use instead of Kubernetes, Components are not authenticated. Requires insecure and loopback grpc-addr and admin-addr.")
+	flag.StringVar(&config.SecretsFile, "secrets-file", "", "YAML file of secrets to use instead of Vault, only used with resource-dir.")
+	flag.BoolVar(&config.Insecure, "insecure", false, "Use plaintext gRPC and NATS connections instead of TLS, only for local development.")
 	flag.Parse()
 
 	utils.CheckRequiredFlag("instance", config.Instance)
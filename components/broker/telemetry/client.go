@@ -34,6 +34,12 @@ const (
 	maxQueueSize = 1_000
 )
 
+// Values of the signal label of telemetry metrics.
+const (
+	signalSpans = "spans"
+	signalLogs  = "logs"
+)
+
 var (
 	Tracer = otel.Tracer("")
 )
@@ -143,6 +149,7 @@ func (cl *Client) AddProtoSpans(comp *core.Component, spans []*tracev1.Span) {
 		return
 	case len(cl.spans) > maxQueueSize:
 		cl.log.Warnf("maximum number of queued spans exceeded, discarding %d incoming", len(spans))
+		TelemetryDropped.WithLabelValues(signalSpans).Add(float64(len(spans)))
 		return
 	}
 
@@ -185,6 +192,7 @@ func (cl *Client) AddProtoSpans(comp *core.Component, spans []*tracev1.Span) {
 	}
 
 	cl.spans = append(cl.spans, resSpans)
+	TelemetryQueueDepth.WithLabelValues(signalSpans).Set(float64(len(cl.spans)))
 }
 
 func (cl *Client) AddProtoLogs(comp *core.Component, logRecords []*logsv1.LogRecord) {
@@ -196,6 +204,7 @@ func (cl *Client) AddProtoLogs(comp *core.Component, logRecords []*logsv1.LogRec
 		return
 	case len(cl.logs) > maxQueueSize:
 		cl.log.Warnf("maximum number of queued log records exceeded, discarding %d incoming", len(logRecords))
+		TelemetryDropped.WithLabelValues(signalLogs).Add(float64(len(logRecords)))
 		return
 	}
 
@@ -211,6 +220,7 @@ func (cl *Client) AddProtoLogs(comp *core.Component, logRecords []*logsv1.LogRec
 	}
 
 	cl.logs = append(cl.logs, resSpans)
+	TelemetryQueueDepth.WithLabelValues(signalLogs).Set(float64(len(cl.logs)))
 }
 
 // TODO have broker/grpc server create resource and pass that instead of comp so
@@ -263,6 +273,7 @@ func (cl *Client) publishSpans(ctx context.Context) {
 		cl.log.Errorf("error uploading traces: %v", err)
 	}
 	cl.spans = nil
+	TelemetryQueueDepth.WithLabelValues(signalSpans).Set(0)
 }
 
 func (cl *Client) publishLogs(ctx context.Context) {
@@ -278,6 +289,7 @@ func (cl *Client) publishLogs(ctx context.Context) {
 		cl.log.Errorf("error uploading logs: %v", err)
 	}
 	cl.logs = nil
+	TelemetryQueueDepth.WithLabelValues(signalLogs).Set(0)
 }
 
 // func (cl *Client) tls() (*tls.Config, error) {
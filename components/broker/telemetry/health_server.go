@@ -17,6 +17,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/xigxog/kubefox/components/broker/config"
 	"github.com/xigxog/kubefox/logkf"
 )
@@ -27,8 +28,9 @@ type HealthProvider interface {
 }
 
 type HealthServer struct {
-	httpSrv   *http.Server
-	providers []HealthProvider
+	httpSrv    *http.Server
+	providers  []HealthProvider
+	metricsHdl http.Handler
 
 	mutex sync.Mutex
 
@@ -37,8 +39,9 @@ type HealthServer struct {
 
 func NewHealthServer() *HealthServer {
 	return &HealthServer{
-		providers: make([]HealthProvider, 0),
-		log:       logkf.Global,
+		providers:  make([]HealthProvider, 0),
+		metricsHdl: promhttp.Handler(),
+		log:        logkf.Global,
 	}
 }
 
@@ -89,7 +92,14 @@ func (srv *HealthServer) Shutdown(timeout time.Duration) {
 	}
 }
 
+// ServeHTTP serves metrics in Prometheus exposition format at /metrics. All
+// other paths report the health of the registered providers.
 func (srv *HealthServer) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/metrics" {
+		srv.metricsHdl.ServeHTTP(resp, req)
+		return
+	}
+
 	ctx, cancel := context.WithTimeout(req.Context(), time.Second*10)
 	defer cancel()
 
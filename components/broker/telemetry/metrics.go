@@ -0,0 +1,146 @@
+// Copyright 2024 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package telemetry
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	metricsNamespace = "kubefox"
+	metricsSubsystem = "broker"
+)
+
+// Phases of routing an event, matching the child spans of the route span.
+const (
+	PhaseFindTarget = "find_target"
+	PhaseSend       = "send"
+)
+
+// Metrics served in Prometheus exposition format at /metrics by the health
+// server.
+var (
+	EventsRouted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "events_routed_total",
+		Help:      "Number of events routed by category, receiver and outcome code.",
+	}, []string{"category", "receiver", "code"})
+
+	RouteDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "route_duration_seconds",
+		Help:      "Time taken to route events by phase.",
+		Buckets:   []float64{.0001, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 5},
+	}, []string{"phase"})
+
+	NATSPublishErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "nats_publish_errors_total",
+		Help:      "Number of events that failed to publish to NATS.",
+	})
+
+	ContentOffloads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "content_offloads_total",
+		Help:      "Number of event contents stored in or loaded from the NATS object store by op, 'store' or 'load'.",
+	}, []string{"op"})
+
+	ResponseCacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "response_cache_requests_total",
+		Help:      "Number of requests checked against the response cache by result, 'hit', 'miss' or 'bypass'.",
+	}, []string{"result"})
+
+	ResponseCacheEvictions = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "response_cache_evictions_total",
+		Help:      "Number of cached responses evicted from memory to stay within the maximum size.",
+	})
+
+	CacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "cache_requests_total",
+		Help:      "Number of store cache lookups by cache and result, 'hit' or 'miss'.",
+	}, []string{"cache", "result"})
+
+	StoreRebuildDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "store_rebuild_duration_seconds",
+		Help:      "Time taken to rebuild the release matcher or component cache by cache.",
+		Buckets:   []float64{.0005, .001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"cache"})
+
+	StoreStaleness = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "store_staleness_seconds",
+		Help:      "Time from a change to resources or secrets until the store reflects it by cache.",
+		Buckets:   []float64{.001, .005, .01, .05, .1, .5, 1, 5, 10, 30, 60, 120},
+	}, []string{"cache"})
+
+	StoreInvalidations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "store_invalidations_total",
+		Help:      "Number of store cache entries evicted because the resources or secrets they were built from changed by cache.",
+	}, []string{"cache"})
+
+	TelemetryQueueDepth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "telemetry_queue_depth",
+		Help:      "Number of resource spans and logs waiting to be exported by signal.",
+	}, []string{"signal"})
+
+	TelemetryDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "telemetry_dropped_total",
+		Help:      "Number of spans and log records discarded because the queue was full by signal.",
+	}, []string{"signal"})
+
+	GRPCStreams = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "grpc_streams",
+		Help:      "Number of open gRPC streams by method.",
+	}, []string{"method"})
+)
+
+// RegisterSubscriptions registers gauges of the number of replica and group
+// subscriptions. Count is called each time metrics are scraped.
+func RegisterSubscriptions(count func() (replicas int, groups int)) {
+	opts := func(name, help string) prometheus.GaugeOpts {
+		return prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      name,
+			Help:      help,
+		}
+	}
+	promauto.NewGaugeFunc(opts("subscriptions", "Number of component replicas subscribed to the broker."),
+		func() float64 {
+			r, _ := count()
+			return float64(r)
+		})
+	promauto.NewGaugeFunc(opts("subscription_groups", "Number of component groups subscribed to the broker."),
+		func() float64 {
+			_, g := count()
+			return float64(g)
+		})
+}
@@ -0,0 +1,108 @@
+// Copyright 2024 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package adapter
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/lestrrat-go/jwx/jwt"
+)
+
+// JWTVerifier verifies bearer tokens of HTTP requests using the keys published
+// at a JWKS URL. Claims of verified tokens are added to the genesis event
+// where they can be matched using the Claim predicate.
+type JWTVerifier struct {
+	url      string
+	keys     *jwk.AutoRefresh
+	validate []jwt.ParseOption
+}
+
+func NewJWTVerifier(ctx context.Context) *JWTVerifier {
+	if JWKSURL == "" {
+		return nil
+	}
+
+	keys := jwk.NewAutoRefresh(ctx)
+	keys.Configure(JWKSURL, jwk.WithMinRefreshInterval(5*time.Minute))
+
+	opts := []jwt.ParseOption{jwt.WithValidate(true)}
+	if JWTIssuer != "" {
+		opts = append(opts, jwt.WithIssuer(JWTIssuer))
+	}
+	if JWTAudience != "" {
+		opts = append(opts, jwt.WithAudience(JWTAudience))
+	}
+
+	return &JWTVerifier{
+		url:      JWKSURL,
+		keys:     keys,
+		validate: opts,
+	}
+}
+
+// Verify returns the claims of the bearer token of the request. If the request
+// does not contain a bearer token nil is returned. Claim values are converted
+// to strings, arrays result in multiple values.
+func (v *JWTVerifier) Verify(ctx context.Context, httpReq *http.Request) (map[string][]string, error) {
+	scheme, token, found := strings.Cut(httpReq.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return nil, nil
+	}
+
+	keySet, err := v.keys.Fetch(ctx, v.url)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch JWKS: %w", err)
+	}
+
+	parsed, err := jwt.ParseString(strings.TrimSpace(token),
+		append([]jwt.ParseOption{jwt.WithKeySet(keySet)}, v.validate...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := parsed.AsMap(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	m := make(map[string][]string, len(claims))
+	for k, c := range claims {
+		switch val := c.(type) {
+		case []interface{}:
+			for _, i := range val {
+				m[k] = append(m[k], claimStr(i))
+			}
+		case []string:
+			m[k] = val
+		default:
+			m[k] = []string{claimStr(val)}
+		}
+	}
+
+	return m, nil
+}
+
+func claimStr(c interface{}) string {
+	switch val := c.(type) {
+	case string:
+		return val
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	case time.Time:
+		return strconv.FormatInt(val.Unix(), 10)
+	default:
+		return fmt.Sprint(val)
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright 2024 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package adapter
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kubefox",
+		Subsystem: "httpsrv",
+		Name:      "requests_total",
+		Help:      "Number of HTTP requests by method and response status code.",
+	}, []string{"method", "code"})
+
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "kubefox",
+		Subsystem: "httpsrv",
+		Name:      "request_duration_seconds",
+		Help:      "Time taken to respond to HTTP requests by method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+)
+
+// statusRecorder records the status code written to the wrapped
+// http.ResponseWriter.
+type statusRecorder struct {
+	http.ResponseWriter
+
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func observeRequest(method string, status int, start time.Time) {
+	requestsTotal.WithLabelValues(method, strconv.Itoa(status)).Inc()
+	requestDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+}
@@ -40,6 +40,7 @@ type Server struct {
 	wrapped    *http.Server
 	brk        *grpc.Client
 	httpClient *HTTPClient
+	jwt        *JWTVerifier
 
 	log *logkf.Logger
 }
@@ -48,6 +49,7 @@ func New(broker *grpc.Client, httpClient *HTTPClient) *Server {
 	return &Server{
 		brk:        broker,
 		httpClient: httpClient,
+		jwt:        NewJWTVerifier(context.Background()),
 		log:        logkf.Global,
 	}
 }
@@ -121,6 +123,13 @@ func (srv *Server) Shutdown() {
 // IDEA have `kubefox-set-cookie` which takes dynamic context and puts it into
 // cookie so do not have to set query params?
 func (srv *Server) ServeHTTP(resWriter http.ResponseWriter, httpReq *http.Request) {
+	start := time.Now()
+	rec := &statusRecorder{ResponseWriter: resWriter, status: http.StatusOK}
+	defer func() {
+		observeRequest(httpReq.Method, rec.status, start)
+	}()
+	resWriter = rec
+
 	ctx, cancel := context.WithTimeoutCause(httpReq.Context(), EventTimeout, core.ErrTimeout())
 	defer cancel()
 
@@ -193,10 +202,31 @@ func (srv *Server) ServeHTTP(resWriter http.ResponseWriter, httpReq *http.Reques
 	// https://github.com/open-telemetry/opentelemetry-collector-contrib/tree/main/exporter/azuremonitorexporter#attribute-mapping
 	parseSpan = rootSpan.StartChildSpan("Parse HTTP request")
 
+	// Only pass explain headers to Broker if allowed by Platform. The Broker
+	// authorizes the explain token before adding the trace to the response.
+	explain := strings.EqualFold(core.GetParamOrHeader(httpReq, api.HeaderExplain), "true")
+	explainToken := httpReq.Header.Get(api.HeaderExplainToken)
+	core.DelParamOrHeader(httpReq, api.HeaderExplain)
+	httpReq.Header.Del(api.HeaderExplainToken)
+	if explain && AllowExplain {
+		httpReq.Header.Set(api.HeaderExplain, "true")
+		httpReq.Header.Set(api.HeaderExplainToken, explainToken)
+	}
+
 	if err := req.SetHTTPRequest(httpReq, MaxEventSize); err != nil {
 		writeError(resWriter, err, srv.log)
 		return
 	}
+	if srv.jwt != nil {
+		claims, err := srv.jwt.Verify(ctx, httpReq)
+		if err != nil {
+			writeError(resWriter, core.ErrUnauthorized(fmt.Errorf("bearer token is invalid: %w", err)), srv.log)
+			return
+		}
+		if claims != nil {
+			req.SetValueMap(api.ValKeyClaims, claims)
+		}
+	}
 	parseSpan.End()
 
 	log = log.WithEvent(req)
@@ -257,6 +287,15 @@ func writeError(resWriter http.ResponseWriter, err error, log *logkf.Logger) {
 	kfErr := &core.Err{}
 	if ok := errors.As(err, &kfErr); ok {
 		statusCode = kfErr.HTTPCode()
+		setHeader(resWriter, api.HeaderRetryAfter, kfErr.Detail(api.ErrDetailRetryAfter))
+
+		// Broker includes match trace if explain header was set.
+		if exp := kfErr.Detail(api.ErrDetailExplain); exp != "" {
+			resWriter.Header().Set(api.HeaderContentType, "application/json")
+			resWriter.WriteHeader(statusCode)
+			resWriter.Write([]byte(exp))
+			return
+		}
 	}
 
 	resWriter.WriteHeader(statusCode)
@@ -19,4 +19,7 @@ var (
 	EventTimeout              time.Duration
 	MaxEventSize              int64
 	WorkerCount               int
+	JWKSURL                   string
+	JWTIssuer, JWTAudience    string
+	AllowExplain              bool
 )
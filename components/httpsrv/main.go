@@ -39,6 +39,10 @@ func main() {
 	flag.Int64Var(&adapter.MaxEventSize, "max-event-size", api.DefaultMaxEventSizeBytes, "Maximum size of event in bytes.")
 	flag.IntVar(&adapter.WorkerCount, "http-worker-count", runtime.NumCPU()*2, "The number of workers to listen for events in the HTTP server.")
 	flag.DurationVar(&adapter.EventTimeout, "timeout", time.Minute, "Default timeout for an event.")
+	flag.StringVar(&adapter.JWKSURL, "jwks-url", "", "URL of JWKS used to verify bearer tokens, if not set tokens are not verified.")
+	flag.StringVar(&adapter.JWTIssuer, "jwt-issuer", "", "Required issuer of bearer tokens.")
+	flag.StringVar(&adapter.JWTAudience, "jwt-audience", "", "Required audience of bearer tokens.")
+	flag.BoolVar(&adapter.AllowExplain, "allow-explain", false, `Return route match trace if request sets "kf-explain" header to "true" and no route matches.`)
 	flag.StringVar(&logFormat, "log-format", "console", "Log format. [options 'json', 'console']")
 	flag.StringVar(&logLevel, "log-level", "debug", "Log level. [options 'debug', 'info', 'warn', 'error']")
 	flag.StringVar(&tokenPath, "token-path", api.PathSvcAccToken, "Path to Service Account Token")
@@ -77,6 +81,7 @@ func main() {
 		BrokerAddr:    adapter.BrokerAddr,
 		HealthSrvAddr: adapter.HealthSrvAddr,
 		TokenPath:     tokenPath,
+		MaxEventSize:  adapter.MaxEventSize,
 	})
 
 	httpClient := adapter.NewHTTPClient(broker)
@@ -0,0 +1,320 @@
+// Copyright 2024 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/xigxog/kubefox/api"
+	"github.com/xigxog/kubefox/api/kubernetes/v1alpha1"
+	"github.com/xigxog/kubefox/components/broker/config"
+	"github.com/xigxog/kubefox/components/broker/engine"
+	"github.com/xigxog/kubefox/components/httpsrv/adapter"
+	"github.com/xigxog/kubefox/core"
+	"github.com/xigxog/kubefox/grpc"
+	"github.com/xigxog/kubefox/k8s"
+	"github.com/xigxog/kubefox/logkf"
+)
+
+const startTimeout = 10 * time.Second
+
+// compFlags holds the Components to spawn, each in the form
+// '<appDeployment>/<component>=<command>'.
+type compFlags []string
+
+func (f *compFlags) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *compFlags) Set(val string) error {
+	*f = append(*f, val)
+	return nil
+}
+
+// dev implements the dev subcommand. It runs a Broker using resources from a
+// local directory, httpsrv and NATS in process, kit Components are run as child
+// processes. All connections are plaintext and should only be made over
+// localhost.
+func dev(args []string) {
+	var (
+		dir, httpAddr, dataDir string
+		embedNATS              bool
+		comps                  compFlags
+	)
+
+	flags := flag.NewFlagSet("dev", flag.ExitOnError)
+	flags.StringVar(&dir, "dir", ".", "Directory of Environment, VirtualEnvironment, AppDeployment and HTTPAdapter YAML files.")
+	flags.StringVar(&config.SecretsFile, "secrets-file", "", `YAML file of secrets, defaults to "secrets.yaml" in dir.`)
+	flags.StringVar(&config.Platform, "platform", "dev", "Name of the Platform.")
+	flags.StringVar(&config.Namespace, "namespace", "kubefox-dev", "Namespace resources without a namespace are placed in.")
+	flags.StringVar(&config.GRPCSrvAddr, "grpc-addr", "127.0.0.1:6060", "Address and port the Broker gRPC server should bind to.")
+	flags.StringVar(&config.AdminSrvAddr, "admin-addr", "127.0.0.1:1112", `Address and port the Broker admin server should bind to, set to "false" to disable.`)
+	flags.StringVar(&httpAddr, "http-addr", "127.0.0.1:8080", "Address and port the HTTP server should bind to.")
+	flags.StringVar(&config.NATSAddr, "nats-addr", "127.0.0.1:4222", "Address and port of NATS server.")
+	flags.BoolVar(&embedNATS, "embed-nats", true, `Run NATS in process at nats-addr, set to "false" to use a running server.`)
+	flags.StringVar(&dataDir, "data-dir", filepath.Join(os.TempDir(), "kubefox-dev"), "Directory NATS stores JetStream data in.")
+	flags.Var(&comps, "component", "Component to run, in the form '<appDeployment>/<component>=<command>'. The command is split on whitespace. Can be repeated.")
+	flags.StringVar(&config.LogFormat, "log-format", "console", `Log format; one of ["json", "console"].`)
+	flags.StringVar(&config.LogLevel, "log-level", "debug", `Log level; one of ["debug", "info", "warn", "error"].`)
+	flags.Parse(args)
+
+	config.Instance = "dev"
+	config.ResourceDir = dir
+	config.Insecure = true
+	if config.SecretsFile == "" {
+		config.SecretsFile = filepath.Join(dir, "secrets.yaml")
+	}
+
+	config.HealthSrvAddr = "false"
+	config.TelemetryAddr = "false"
+	config.MaxEventSize = api.DefaultMaxEventSizeBytes
+	config.RecordEvents = true
+	config.NumWorkers = runtime.NumCPU()
+	config.ShutdownTimeout = 5 * time.Second
+	config.HeartbeatInterval = 10 * time.Second
+	config.OutlierThreshold = 5
+	config.OutlierEjectionTime = 30 * time.Second
+	config.IdempotencyWindow = api.DefaultIdempotencyWindowSeconds * time.Second
+	config.IdempotencyStorage = string(api.StorageTypeMemory)
+	config.RateLimitLocal = true
+	config.RespCacheMaxSize = api.DefaultResponseCacheMaxEntrySizeBytes
+	config.RespCacheMaxMemory = api.DefaultResponseCacheMaxMemorySizeBytes
+	config.DeadLetterRetention = api.DefaultDeadLetterRetentionSeconds * time.Second
+	config.DeadLetterMaxAttempts = api.DefaultDeadLetterMaxAttempts
+	config.DeadLetterBackoff = api.DefaultDeadLetterBackoffSeconds * time.Second
+	config.DeadLetterMaxBackoff = api.DefaultDeadLetterMaxBackoffSeconds * time.Second
+	config.DeadLetterMaxSize = api.DefaultDeadLetterMaxSizeBytes
+	config.DeadLetterMaxMsgs = api.DefaultDeadLetterMaxMessages
+
+	baseLog := logkf.BuildLoggerOrDie(config.LogFormat, config.LogLevel)
+	defer baseLog.Sync()
+
+	var natsSrv *server.Server
+	if embedNATS {
+		srv, err := startNATS(dataDir)
+		if err != nil {
+			baseLog.Fatalf("starting nats server failed: %v", err)
+		}
+		natsSrv = srv
+	}
+	stopNATS := func() {
+		if natsSrv != nil {
+			natsSrv.Shutdown()
+			natsSrv.WaitForShutdown()
+		}
+	}
+
+	var procs []*exec.Cmd
+
+	logkf.Global = baseLog.
+		WithInstance(config.Instance).
+		WithPlatform(config.Platform).
+		WithPlatformComponent(api.PlatformComponentBroker)
+	brk := engine.New()
+	brkLog := logkf.Global
+
+	// httpsrv is created with its own logger, the Components capture the global
+	// logger when created.
+	httpComp := core.NewPlatformComponent(api.ComponentTypeHTTPAdapter, api.PlatformComponentHTTPSrv, engine.StandaloneHash())
+	httpComp.Id = core.GenerateId()
+	logkf.Global = baseLog.WithComponent(httpComp)
+	httpSrv := newHTTPSrv(httpComp, httpAddr)
+	logkf.Global = brkLog
+
+	go func() {
+		if err := waitForAddr(config.GRPCSrvAddr); err != nil {
+			brkLog.Error(err)
+			return
+		}
+		if err := httpSrv.Run(); err != nil {
+			brkLog.Errorf("httpsrv stopped: %v", err)
+		}
+	}()
+
+	for _, c := range comps {
+		cmd, err := startComponent(dir, c)
+		if err != nil {
+			for _, p := range procs {
+				stop(p)
+			}
+			stopNATS()
+			brkLog.Fatalf("starting component '%s' failed: %v", c, err)
+		}
+		procs = append(procs, cmd)
+	}
+
+	brk.OnShutdown(func() {
+		httpSrv.Shutdown()
+		// Components are stopped before NATS.
+		for i := len(procs) - 1; i >= 0; i-- {
+			stop(procs[i])
+		}
+		stopNATS()
+	})
+	brk.Start()
+}
+
+func newHTTPSrv(comp *core.Component, httpAddr string) *adapter.Server {
+	adapter.Platform = config.Platform
+	adapter.HTTPAddr = httpAddr
+	adapter.HTTPSAddr = "false"
+	adapter.BrokerAddr = config.GRPCSrvAddr
+	adapter.HealthSrvAddr = "false"
+	adapter.MaxEventSize = config.MaxEventSize
+	adapter.WorkerCount = runtime.NumCPU() * 2
+	adapter.EventTimeout = time.Minute
+	adapter.AllowExplain = true
+
+	brk := grpc.NewClient(grpc.ClientOpts{
+		Platform:      config.Platform,
+		Component:     comp,
+		Pod:           "dev",
+		BrokerAddr:    config.GRPCSrvAddr,
+		HealthSrvAddr: "false",
+		Insecure:      true,
+		MaxEventSize:  adapter.MaxEventSize,
+	})
+
+	httpClient := adapter.NewHTTPClient(brk)
+	adapter.NewListener(brk, httpClient).StartWorkers(adapter.WorkerCount)
+
+	return adapter.New(brk, httpClient)
+}
+
+// startNATS runs a NATS server in process with JetStream enabled at the NATS
+// address and waits for it to accept connections.
+func startNATS(dataDir string) (*server.Server, error) {
+	host, portStr, err := net.SplitHostPort(config.NATSAddr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid nats port: %w", err)
+	}
+
+	srv, err := server.NewServer(&server.Options{
+		Host:      host,
+		Port:      port,
+		JetStream: true,
+		StoreDir:  dataDir,
+		// Signals are handled by the Broker.
+		NoSigs: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	srv.ConfigureLogger()
+
+	go srv.Start()
+	if !srv.ReadyForConnections(startTimeout) {
+		srv.Shutdown()
+		return nil, fmt.Errorf("nats server not ready after %s", startTimeout)
+	}
+
+	return srv, nil
+}
+
+// startComponent runs the kit Component described by spec, in the form
+// '<appDeployment>/<component>=<command>'. The App name and hash of the
+// Component are taken from the AppDeployment.
+func startComponent(dir, spec string) (*exec.Cmd, error) {
+	name, command, found := strings.Cut(spec, "=")
+	appDepName, compName, ok := strings.Cut(name, "/")
+	if !found || !ok || len(strings.Fields(command)) == 0 {
+		return nil, fmt.Errorf("expected '<appDeployment>/<component>=<command>'")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), startTimeout)
+	defer cancel()
+
+	src := engine.NewFileSource(dir, config.SecretsFile)
+	if err := src.Open(ctx, nil); err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	appDep := &v1alpha1.AppDeployment{}
+	if err := src.Get(ctx, k8s.Key(config.Namespace, appDepName), appDep); err != nil {
+		return nil, err
+	}
+	compSpec, found := appDep.Spec.Components[compName]
+	if !found {
+		return nil, fmt.Errorf("component not found in AppDeployment '%s'", appDepName)
+	}
+
+	args := strings.Fields(command)
+	args = append(args,
+		"-platform", config.Platform,
+		"-app", appDep.Spec.AppName,
+		"-name", compName,
+		"-hash", compSpec.Hash,
+		"-broker-addr", config.GRPCSrvAddr,
+		"-health-addr", "false",
+		"-log-format", config.LogFormat,
+		"-log-level", config.LogLevel,
+		"-insecure",
+	)
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	return cmd, nil
+}
+
+// stop interrupts the process and kills it if it has not exited after a few
+// seconds.
+func stop(cmd *exec.Cmd) {
+	if cmd.Process == nil {
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(done)
+	}()
+
+	cmd.Process.Signal(os.Interrupt)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		cmd.Process.Kill()
+	}
+}
+
+// waitForAddr waits until addr accepts TCP connections.
+func waitForAddr(addr string) error {
+	deadline := time.Now().Add(startTimeout)
+	for {
+		conn, err := net.DialTimeout("tcp", addr, time.Second)
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("'%s' not reachable after %s: %w", addr, startTimeout, err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -0,0 +1,32 @@
+// Copyright 2024 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+const usage = `Run KubeFox without Kubernetes.
+
+Usage:
+  kubefox dev [flags]
+
+Use "kubefox <command> -h" for command flags.
+`
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "dev" {
+		dev(os.Args[2:])
+		return
+	}
+
+	fmt.Fprint(os.Stderr, usage)
+	os.Exit(1)
+}
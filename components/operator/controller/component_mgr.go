@@ -143,6 +143,11 @@ func (cm *ComponentManager) ReconcileApps(ctx context.Context, namespace string)
 	if platform.Spec.Events.MaxSize.IsZero() {
 		maxEventSize = api.DefaultMaxEventSizeBytes
 	}
+	// Components can send and receive events up to the offload max size as
+	// the Broker stores large content in NATS.
+	if _, offloadMaxSize := offloadSizes(platform, maxEventSize); offloadMaxSize > 0 {
+		maxEventSize = offloadMaxSize
+	}
 	td := TemplateData{
 		Data: templates.Data{
 			Instance: templates.Instance{
@@ -330,6 +335,7 @@ func availableCondition(
 		condition   = &metav1.Condition{Type: api.ConditionTypeAvailable}
 		available   = false
 		unavailable = false
+		notReady    int32
 		problems    api.Problems
 	)
 	for name, c := range spec.Components {
@@ -360,6 +366,11 @@ func availableCondition(
 		switch {
 		case cond.Status == corev1.ConditionTrue:
 			available = true
+			// Replicas ejected by the Broker for failing health checks report
+			// themselves not ready.
+			if n := dep.Status.Replicas - dep.Status.ReadyReplicas; n > 0 {
+				notReady += n
+			}
 		default:
 			unavailable = true
 			problems = append(problems,
@@ -387,6 +398,11 @@ func availableCondition(
 		condition.Status = metav1.ConditionFalse
 		condition.Reason = api.ConditionReasonComponentUnavailable
 		condition.Message = "One or more Component Deployments is unavailable, see `status.problems` for details."
+	case available && notReady > 0:
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = api.ConditionReasonComponentsDegraded
+		condition.Message = fmt.Sprintf(
+			"Component Deployments have minimum required Pods available, %d Pods are not ready.", notReady)
 	case available:
 		condition.Status = metav1.ConditionTrue
 		condition.Reason = api.ConditionReasonComponentsAvailable
@@ -483,3 +499,25 @@ func findCondition(status appsv1.DeploymentStatus, condType appsv1.DeploymentCon
 
 	return appsv1.DeploymentCondition{Type: condType, Status: corev1.ConditionUnknown}
 }
+
+// offloadSizes returns the size above which event content is offloaded to the
+// NATS object store and the maximum size of events while offloading is
+// enabled. Both are 0 if offloading is disabled. The threshold is limited to
+// the max event size so offloaded events always fit in a NATS message.
+func offloadSizes(platform *v1alpha1.Platform, maxEventSize int64) (threshold int64, maxSize int64) {
+	spec := platform.Spec.Events.Offload
+	if !spec.Enabled {
+		return 0, 0
+	}
+
+	threshold = spec.ThresholdSize.Value()
+	if spec.ThresholdSize.IsZero() {
+		threshold = api.DefaultOffloadThresholdBytes
+	}
+	maxSize = spec.MaxSize.Value()
+	if spec.MaxSize.IsZero() {
+		maxSize = api.DefaultOffloadMaxSizeBytes
+	}
+
+	return min(threshold, maxEventSize), max(min(maxSize, api.MaxOffloadSizeBytesLimit), maxEventSize)
+}
@@ -153,6 +153,70 @@ func (r *PlatformReconciler) reconcile(ctx context.Context, platform *v1alpha1.P
 	if platform.Spec.Events.MaxSize.IsZero() {
 		maxEventSize = api.DefaultMaxEventSizeBytes
 	}
+	idemWindow := time.Duration(platform.Spec.Events.Idempotency.WindowSeconds) * time.Second
+	switch {
+	case platform.Spec.Events.Idempotency.Disabled:
+		idemWindow = 0
+	case idemWindow == 0:
+		idemWindow = api.DefaultIdempotencyWindowSeconds * time.Second
+	}
+	idemStorage := platform.Spec.Events.Idempotency.Storage
+	if idemStorage == "" {
+		idemStorage = api.StorageTypeFile
+	}
+	rateLimitStorage := platform.Spec.Events.RateLimit.Storage
+	if rateLimitStorage == "" {
+		rateLimitStorage = api.StorageTypeMemory
+	}
+	dl := platform.Spec.Events.DeadLetter
+	dlRetention := time.Duration(dl.RetentionSeconds) * time.Second
+	switch {
+	case dl.Disabled:
+		dlRetention = 0
+	case dlRetention == 0:
+		dlRetention = api.DefaultDeadLetterRetentionSeconds * time.Second
+	}
+	dlAttempts := dl.MaxAttempts
+	if dlAttempts == 0 {
+		dlAttempts = api.DefaultDeadLetterMaxAttempts
+	}
+	dlBackoff := time.Duration(dl.BackoffSeconds) * time.Second
+	if dlBackoff == 0 {
+		dlBackoff = api.DefaultDeadLetterBackoffSeconds * time.Second
+	}
+	dlMaxBackoff := time.Duration(dl.MaxBackoffSeconds) * time.Second
+	if dlMaxBackoff == 0 {
+		dlMaxBackoff = api.DefaultDeadLetterMaxBackoffSeconds * time.Second
+	}
+	dlMaxSize := dl.MaxSize.Value()
+	if dl.MaxSize.IsZero() {
+		dlMaxSize = api.DefaultDeadLetterMaxSizeBytes
+	}
+	dlMaxMsgs := dl.MaxMessages
+	if dlMaxMsgs == 0 {
+		dlMaxMsgs = api.DefaultDeadLetterMaxMessages
+	}
+	offloadThreshold, offloadMaxSize := offloadSizes(platform, maxEventSize)
+	offloadStorage := platform.Spec.Events.Offload.Storage
+	if offloadStorage == "" {
+		offloadStorage = api.StorageTypeFile
+	}
+	rc := platform.Spec.Events.ResponseCache
+	rcMaxSize := rc.MaxEntrySize.Value()
+	switch {
+	case rc.Disabled:
+		rcMaxSize = 0
+	case rc.MaxEntrySize.IsZero():
+		rcMaxSize = api.DefaultResponseCacheMaxEntrySizeBytes
+	}
+	rcMaxMemory := rc.MaxMemorySize.Value()
+	if rc.MaxMemorySize.IsZero() {
+		rcMaxMemory = api.DefaultResponseCacheMaxMemorySizeBytes
+	}
+	rcStorage := rc.Storage
+	if rcStorage == "" {
+		rcStorage = api.StorageTypeMemory
+	}
 	platformTD := &TemplateData{
 		Data: templates.Data{
 			Instance: templates.Instance{
@@ -172,8 +236,27 @@ func (r *PlatformReconciler) reconcile(ctx context.Context, platform *v1alpha1.P
 			BuildInfo: build.Info,
 			Telemetry: platform.Spec.Telemetry,
 			Values: map[string]any{
-				api.ValKeyMaxEventSize: maxEventSize,
-				api.ValKeyVaultURL:     r.VaultURL,
+				api.ValKeyMaxEventSize:         maxEventSize,
+				api.ValKeyVaultURL:             r.VaultURL,
+				api.ValKeyRecordEvents:         platform.Spec.Events.Recording.Enabled,
+				api.ValKeyRecordRedactHeaders:  strings.Join(platform.Spec.Events.Recording.RedactHeaders, ","),
+				api.ValKeyIdempotencyWindow:    idemWindow.String(),
+				api.ValKeyIdempotencyStorage:   idemStorage,
+				api.ValKeyRateLimitLocal:       platform.Spec.Events.RateLimit.Local,
+				api.ValKeyRateLimitStorage:     rateLimitStorage,
+				api.ValKeyDeadLetterRetention:  dlRetention.String(),
+				api.ValKeyDeadLetterAttempts:   dlAttempts,
+				api.ValKeyDeadLetterBackoff:    dlBackoff.String(),
+				api.ValKeyDeadLetterMaxBackoff: dlMaxBackoff.String(),
+				api.ValKeyDeadLetterMaxSize:    dlMaxSize,
+				api.ValKeyDeadLetterMaxMsgs:    dlMaxMsgs,
+				api.ValKeyOffloadThreshold:     offloadThreshold,
+				api.ValKeyOffloadMaxSize:       offloadMaxSize,
+				api.ValKeyOffloadStorage:       offloadStorage,
+				api.ValKeyRespCacheMaxSize:     rcMaxSize,
+				api.ValKeyRespCacheMaxMemory:   rcMaxMemory,
+				api.ValKeyRespCacheShared:      rc.Shared,
+				api.ValKeyRespCacheStorage:     rcStorage,
 			},
 		},
 	}
@@ -271,6 +354,10 @@ func (r *PlatformReconciler) reconcile(ctx context.Context, platform *v1alpha1.P
 	td.Values["serviceType"] = platform.Spec.HTTPSrv.Service.Type
 	td.Values["httpPort"] = platform.Spec.HTTPSrv.Service.Ports.HTTP
 	td.Values["httpsPort"] = platform.Spec.HTTPSrv.Service.Ports.HTTPS
+	td.Values["jwksURL"] = platform.Spec.HTTPSrv.JWT.JWKSURL
+	td.Values["jwtIssuer"] = platform.Spec.HTTPSrv.JWT.Issuer
+	td.Values["jwtAudience"] = platform.Spec.HTTPSrv.JWT.Audience
+	td.Values["allowExplain"] = platform.Spec.HTTPSrv.AllowExplain && platform.Spec.NonProduction
 	if err := r.setupVaultComponent(ctx, td, false); err != nil {
 		return err
 	}
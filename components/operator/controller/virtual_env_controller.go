@@ -10,7 +10,9 @@ package controller
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"slices"
 	"sort"
 	"time"
 
@@ -22,6 +24,7 @@ import (
 	"github.com/xigxog/kubefox/core"
 	"github.com/xigxog/kubefox/k8s"
 	"github.com/xigxog/kubefox/logkf"
+	"github.com/xigxog/kubefox/matcher"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -528,12 +531,24 @@ func (r *VirtualEnvReconciler) updateProblems(ctx *VirtualEnvContext, rel *v1alp
 		}
 	}
 
+	var relRoutes []*core.Route
+	generations := map[string]int64{}
 	for appName, app := range rel.Apps {
 		appDep := &v1alpha1.AppDeployment{}
 		err := r.Get(ctx, k8s.Key(ctx.Namespace, app.AppDeployment), appDep)
 
 		switch {
 		case err == nil:
+			routes, err := appDep.Routes(data, &core.EventContext{
+				VirtualEnvironment: ctx.Name,
+				AppDeployment:      appDep.Name,
+				ReleaseManifest:    rel.ReleaseManifest,
+			})
+			if err == nil {
+				relRoutes = append(relRoutes, routes...)
+				generations[appDep.Name] = appDep.Generation
+			}
+
 			progressing := k8s.Condition(appDep.Status.Conditions, api.ConditionTypeProgressing)
 			available := k8s.Condition(appDep.Status.Conditions, api.ConditionTypeAvailable)
 
@@ -561,6 +576,17 @@ func (r *VirtualEnvReconciler) updateProblems(ctx *VirtualEnvContext, rel *v1alp
 				})
 			}
 
+			crossAppProblems, err := r.crossAppProblems(ctx, rel, appDep, manifest)
+			if err != nil {
+				return err
+			}
+			for _, p := range crossAppProblems {
+				rel.Problems = append(rel.Problems, common.Problem{
+					ObservedTime: ctx.Now,
+					Problem:      p,
+				})
+			}
+
 			if available.Status == metav1.ConditionFalse &&
 				available.Reason != api.ConditionReasonProblemsFound {
 
@@ -692,6 +718,196 @@ func (r *VirtualEnvReconciler) updateProblems(ctx *VirtualEnvContext, rel *v1alp
 		}
 	}
 
+	return r.updateRouteConflicts(ctx, rel, relRoutes, generations)
+}
+
+// crossAppProblems checks that Components of other Apps the AppDeployment
+// depends on are part of the AppDeployments pinned by the Release.
+func (r *VirtualEnvReconciler) crossAppProblems(ctx *VirtualEnvContext, rel *v1alpha1.ReleaseStatus,
+	appDep *v1alpha1.AppDeployment, manifest *v1alpha1.ReleaseManifest) (api.Problems, error) {
+
+	var problems api.Problems
+	for compName, comp := range appDep.Spec.Components {
+		for depName, dep := range comp.Dependencies {
+			if dep.App == "" || dep.App == appDep.Spec.AppName || dep.Type != api.ComponentTypeKubeFox {
+				continue
+			}
+
+			var (
+				target *v1alpha1.AppDeployment
+				err    error
+			)
+			if manifest != nil {
+				target, err = manifest.GetAppDeploymentOfApp(dep.App)
+			} else if name := rel.AppDeployment(dep.App); name != "" {
+				target = &v1alpha1.AppDeployment{}
+				err = r.Get(ctx, k8s.Key(ctx.Namespace, name), target)
+			} else {
+				err = core.ErrNotFound()
+			}
+			if k8s.IgnoreNotFound(err) != nil && !errors.Is(err, core.ErrNotFound()) {
+				return nil, err
+			}
+
+			source := api.ProblemSource{
+				Kind:               api.ProblemSourceKindAppDeployment,
+				Name:               appDep.Name,
+				ObservedGeneration: appDep.Generation,
+				Path:               fmt.Sprintf("$.spec.components.%s.dependencies.%s", compName, depName),
+			}
+
+			var targetDef *api.ComponentDefinition
+			if err == nil {
+				targetDef = target.Spec.Components[depName]
+			}
+			switch {
+			case err != nil:
+				problems = append(problems, api.Problem{
+					Type: api.ProblemTypeDependencyNotFound,
+					Message: fmt.Sprintf(`Component "%s" dependency "%s" of App "%s" not found, App is not part of Release.`,
+						compName, depName, dep.App),
+					Causes: []api.ProblemSource{
+						source,
+						{
+							Kind:               api.ProblemSourceKindVirtualEnv,
+							Name:               ctx.Name,
+							ObservedGeneration: ctx.Generation,
+							Path:               fmt.Sprintf("$.spec.release.apps.%s", dep.App),
+						},
+					},
+				})
+
+			case targetDef == nil:
+				problems = append(problems, api.Problem{
+					Type: api.ProblemTypeDependencyNotFound,
+					Message: fmt.Sprintf(`Component "%s" dependency "%s" not found in AppDeployment "%s" of App "%s".`,
+						compName, depName, target.Name, dep.App),
+					Causes: []api.ProblemSource{
+						source,
+						{
+							Kind:               api.ProblemSourceKindAppDeployment,
+							Name:               target.Name,
+							ObservedGeneration: target.Generation,
+						},
+					},
+				})
+
+			case targetDef.Type != dep.Type:
+				problems = append(problems, api.Problem{
+					Type: api.ProblemTypeDependencyInvalid,
+					Message: fmt.Sprintf(`Component "%s" dependency "%s" of App "%s" has type "%s" but expected "%s".`,
+						compName, depName, dep.App, targetDef.Type, dep.Type),
+					Causes: []api.ProblemSource{
+						source,
+						{
+							Kind:               api.ProblemSourceKindAppDeployment,
+							Name:               target.Name,
+							ObservedGeneration: target.Generation,
+							Path:               fmt.Sprintf("$.spec.components.%s.type", depName),
+							Value:              (*string)(&targetDef.Type),
+						},
+					},
+				})
+			}
+		}
+	}
+
+	return problems, nil
+}
+
+// updateRouteConflicts adds RouteConflict problems to the Release for routes
+// that conflict with routes of other Apps in the Release or with routes of the
+// active Releases of other VirtualEnvironments. Genesis events without a
+// context are matched against the routes of all active Releases, conflicts
+// cause events to be silently sent to the wrong VirtualEnvironment. Conflicts
+// within an AppDeployment are reported by its validation.
+func (r *VirtualEnvReconciler) updateRouteConflicts(ctx *VirtualEnvContext,
+	rel *v1alpha1.ReleaseStatus, relRoutes []*core.Route, generations map[string]int64) error {
+
+	if len(relRoutes) == 0 {
+		return nil
+	}
+
+	routes := slices.Clone(relRoutes)
+
+	veList := &v1alpha1.VirtualEnvironmentList{}
+	if err := r.List(ctx, veList, client.InNamespace(ctx.Namespace)); err != nil {
+		return err
+	}
+	for _, ve := range veList.Items {
+		if ve.Name == ctx.Name || ve.Status.ActiveRelease == nil {
+			continue
+		}
+		active := ve.Status.ActiveRelease
+
+		var data *api.Data
+		if active.ReleaseManifest != "" {
+			manifest := &v1alpha1.ReleaseManifest{}
+			if err := r.Get(ctx, k8s.Key(ctx.Namespace, active.ReleaseManifest), manifest); err != nil {
+				if k8s.IsNotFound(err) {
+					continue
+				}
+				return err
+			}
+			data = &manifest.Data
+
+		} else {
+			env := &v1alpha1.Environment{}
+			if err := r.Get(ctx, k8s.Key("", ve.Spec.Environment), env); err != nil {
+				if k8s.IsNotFound(err) {
+					continue
+				}
+				return err
+			}
+			data = ve.Data.DeepCopy()
+			data.Import(&env.Data)
+		}
+
+		for _, app := range active.Apps {
+			appDep := &v1alpha1.AppDeployment{}
+			if err := r.Get(ctx, k8s.Key(ctx.Namespace, app.AppDeployment), appDep); err != nil {
+				if k8s.IsNotFound(err) {
+					continue
+				}
+				return err
+			}
+			generations[appDep.Name] = appDep.Generation
+
+			appRoutes, err := appDep.Routes(data, &core.EventContext{
+				VirtualEnvironment: ve.Name,
+				AppDeployment:      appDep.Name,
+				ReleaseManifest:    active.ReleaseManifest,
+			})
+			if err != nil {
+				continue
+			}
+			routes = append(routes, appRoutes...)
+		}
+	}
+
+	m := matcher.New()
+	if err := m.AddRoutes(routes...); err != nil {
+		// Invalid routes are reported by the Broker when matching.
+		return nil
+	}
+
+	for _, c := range m.Conflicts() {
+		a, b := c.Route.EventContext, c.ShadowedBy.EventContext
+		if a.VirtualEnvironment != ctx.Name && b.VirtualEnvironment != ctx.Name {
+			continue
+		}
+		if a.VirtualEnvironment == b.VirtualEnvironment && a.AppDeployment == b.AppDeployment {
+			continue
+		}
+
+		rel.Problems = append(rel.Problems, common.Problem{
+			ObservedTime: ctx.Now,
+			Problem: v1alpha1.RouteConflictProblem(c, func(route *core.Route) int64 {
+				return generations[route.EventContext.AppDeployment]
+			}),
+		})
+	}
+
 	return nil
 }
 
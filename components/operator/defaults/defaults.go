@@ -109,6 +109,7 @@ var (
 		ReadinessProbe: &v1.Probe{
 			ProbeHandler: v1.ProbeHandler{
 				HTTPGet: &v1.HTTPGetAction{
+					Path: "/readyz",
 					Port: intstr.FromString("health"),
 				},
 			},
@@ -130,6 +131,7 @@ var (
 		ReadinessProbe: &v1.Probe{
 			ProbeHandler: v1.ProbeHandler{
 				HTTPGet: &v1.HTTPGetAction{
+					Path: "/readyz",
 					Port: intstr.FromString("health"),
 				},
 			},
@@ -7,5 +7,8 @@ items:
   - {{- include "clusterrolebinding-auth.yaml" . | nindent 4 }}
   - {{- include "role.yaml" . | nindent 4 }}
   - {{- include "rolebinding.yaml" . | nindent 4 }}
+  - {{- include "serviceaccount-admin.yaml" . | nindent 4 }}
+  - {{- include "role-admin.yaml" . | nindent 4 }}
+  - {{- include "rolebinding-admin.yaml" . | nindent 4 }}
   - {{- include "service-headless.yaml" . | nindent 4 }}
   - {{- include "daemonset.yaml" . | nindent 4 }}
@@ -55,6 +55,12 @@ func (r *PlatformWebhook) Handle(ctx context.Context, req admission.Request) adm
 		return admission.Errored(http.StatusBadRequest, err)
 	}
 
+	if platform.Spec.HTTPSrv.AllowExplain && !platform.Spec.NonProduction {
+		return admission.Denied(
+			fmt.Sprintf(`The Platform "%s" is not allowed: allowExplain requires the Platform to be marked nonProduction`,
+				req.Name))
+	}
+
 	svc := &platform.Spec.HTTPSrv.Service
 	if svc.Type == "" {
 		svc.Type = "ClusterIP"
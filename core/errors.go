@@ -40,6 +40,7 @@ const (
 	CodeUnauthorized
 	CodeUnknownContentType
 	CodeUnsupportedAdapter
+	CodeRateLimited
 )
 
 type Err struct {
@@ -50,15 +51,28 @@ type Err struct {
 type err struct {
 	*Stack `json:"-"`
 
-	Code     Code       `json:"code"`
-	GRPCCode codes.Code `json:"grpcCode"`
-	HTTPCode int        `json:"httpCode"`
-	Msg      string     `json:"msg,omitempty"`
-	Cause    string     `json:"cause,omitempty"`
+	Code      Code              `json:"code"`
+	GRPCCode  codes.Code        `json:"grpcCode"`
+	HTTPCode  int               `json:"httpCode"`
+	Msg       string            `json:"msg,omitempty"`
+	Retryable bool              `json:"retryable,omitempty"`
+	Component string            `json:"component,omitempty"`
+	Details   map[string]string `json:"details,omitempty"`
+	Cause     string            `json:"cause,omitempty"`
+	// Causes contains the KubeFox errors found in the cause chain, outermost
+	// first. It allows the chain to be rebuilt after unmarshalling.
+	Causes []err `json:"causes,omitempty"`
 
 	cause error
 }
 
+// chainErr links a cause message to the next KubeFox error in the chain when
+// the chain is rebuilt after unmarshalling.
+type chainErr struct {
+	msg  string
+	next error
+}
+
 func ErrBrokerMismatch(cause ...error) *Err {
 	return NewKubeFoxErr("broker mismatch", CodeBrokerMismatch, codes.FailedPrecondition, http.StatusBadGateway, cause...)
 }
@@ -91,6 +105,10 @@ func ErrPortUnavailable(cause ...error) *Err {
 	return NewKubeFoxErr("port unavailable", CodePortUnavailable, codes.Unavailable, http.StatusConflict, cause...)
 }
 
+func ErrRateLimited(cause ...error) *Err {
+	return NewKubeFoxErr("rate limited", CodeRateLimited, codes.ResourceExhausted, http.StatusTooManyRequests, cause...)
+}
+
 func ErrRouteInvalid(cause ...error) *Err {
 	return NewKubeFoxErr("route invalid", CodeRouteInvalid, codes.InvalidArgument, http.StatusBadRequest, cause...)
 }
@@ -132,16 +150,82 @@ func NewKubeFoxErr(msg string, code Code, grpcCode codes.Code, httpCode int, cau
 
 	return &Err{
 		err: err{
-			Stack:    s,
-			Msg:      msg,
-			Code:     code,
-			GRPCCode: grpcCode,
-			HTTPCode: httpCode,
-			cause:    c,
+			Stack:     s,
+			Msg:       msg,
+			Code:      code,
+			GRPCCode:  grpcCode,
+			HTTPCode:  httpCode,
+			Retryable: code.Retryable(),
+			cause:     c,
 		},
 	}
 }
 
+// IsRetryable returns true if err or a KubeFox error in its chain is marked as
+// retryable.
+func IsRetryable(err error) bool {
+	kfErr := &Err{}
+	if !errors.As(err, &kfErr) {
+		return false
+	}
+	for _, e := range append([]*Err{kfErr}, kfErr.Causes()...) {
+		if e.Retryable() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Retryable returns true if errors with the code are transient by default.
+func (c Code) Retryable() bool {
+	switch c {
+	case CodeBrokerUnavailable, CodeComponentGone, CodeRateLimited, CodeTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c Code) String() string {
+	switch c {
+	case CodeUnexpected:
+		return "Unexpected"
+	case CodeBrokerMismatch:
+		return "BrokerMismatch"
+	case CodeBrokerUnavailable:
+		return "BrokerUnavailable"
+	case CodeComponentGone:
+		return "ComponentGone"
+	case CodeComponentMismatch:
+		return "ComponentMismatch"
+	case CodeContentTooLarge:
+		return "ContentTooLarge"
+	case CodeInvalid:
+		return "Invalid"
+	case CodeNotFound:
+		return "NotFound"
+	case CodePortUnavailable:
+		return "PortUnavailable"
+	case CodeRouteInvalid:
+		return "RouteInvalid"
+	case CodeRouteNotFound:
+		return "RouteNotFound"
+	case CodeTimeout:
+		return "Timeout"
+	case CodeUnauthorized:
+		return "Unauthorized"
+	case CodeUnknownContentType:
+		return "UnknownContentType"
+	case CodeUnsupportedAdapter:
+		return "UnsupportedAdapter"
+	case CodeRateLimited:
+		return "RateLimited"
+	default:
+		return fmt.Sprintf("Code(%d)", int(c))
+	}
+}
+
 func (e *Err) Code() Code {
 	return e.err.Code
 }
@@ -158,13 +242,81 @@ func (e *Err) HTTPCode() int {
 	return e.err.HTTPCode
 }
 
+// Retryable returns true if the operation that caused the error can be
+// retried.
+func (e *Err) Retryable() bool {
+	return e.err.Retryable
+}
+
+// SetRetryable overrides the default retryable flag of the error's code.
+func (e *Err) SetRetryable(retryable bool) *Err {
+	e.err.Retryable = retryable
+	return e
+}
+
+// Component returns the key of the Component the error originated from.
+func (e *Err) Component() string {
+	return e.err.Component
+}
+
+// SetComponent sets the Component the error originated from. If the
+// Component is already set it is not modified so the originating Component
+// is kept as the error is passed between Components.
+func (e *Err) SetComponent(comp *Component) *Err {
+	if e.err.Component == "" && comp != nil {
+		e.err.Component = comp.Key()
+	}
+	return e
+}
+
+// Details returns key/value pairs providing structured information about the
+// error.
+func (e *Err) Details() map[string]string {
+	return e.err.Details
+}
+
+// Detail returns the value of the detail key.
+func (e *Err) Detail(key string) string {
+	return e.err.Details[key]
+}
+
+// SetDetail adds the key/value pair to the error's details.
+func (e *Err) SetDetail(key, value string) *Err {
+	if e.err.Details == nil {
+		e.err.Details = make(map[string]string)
+	}
+	e.err.Details[key] = value
+	return e
+}
+
+// Causes returns the KubeFox errors in the cause chain, outermost first.
+func (e *Err) Causes() []*Err {
+	var causes []*Err
+	for c := e.err.cause; c != nil; {
+		next := &Err{}
+		if !errors.As(c, &next) {
+			break
+		}
+		causes = append(causes, next)
+		c = next.err.cause
+	}
+
+	return causes
+}
+
 func (e *Err) Unwrap() error {
 	return e.err.cause
 }
 
+// Is returns true if err is a KubeFox error with the same code. The zero value
+// &Err{} matches any KubeFox error.
 func (e *Err) Is(err error) bool {
-	_, ok := err.(*Err)
-	return ok
+	t, ok := err.(*Err)
+	if !ok {
+		return false
+	}
+
+	return t.err.Msg == "" || t.err.Code == e.err.Code
 }
 
 func (e *Err) Error() string {
@@ -192,18 +344,59 @@ func (e *Err) UnmarshalJSON(value []byte) error {
 	if err := json.Unmarshal(value, &e.err); err != nil {
 		return err
 	}
-	if e.err.Cause != "" {
-		e.err.cause = errors.New(e.err.Cause)
+
+	// Rebuild cause chain starting with the innermost error.
+	var next error
+	for i := len(e.err.Causes) - 1; i >= 0; i-- {
+		c := &Err{err: e.err.Causes[i]}
+		c.err.cause = link(c.err.Cause, next)
+		next = c
 	}
+	e.err.cause = link(e.err.Cause, next)
+	e.err.Causes = nil
+
 	return nil
 }
 
-// MarshalJSON implements the json.Marshaller interface.
+// MarshalJSON implements the json.Marshaller interface. A copy of the error
+// is marshalled so the Err is not modified.
 func (e *Err) MarshalJSON() ([]byte, error) {
-	if e.err.cause != nil {
-		e.err.Cause = e.err.cause.Error()
+	out := e.err
+	out.Cause, out.Causes = "", nil
+	if out.cause != nil {
+		out.Cause = out.cause.Error()
+	}
+	for _, c := range e.Causes() {
+		cErr := c.err
+		cErr.Cause, cErr.Causes = "", nil
+		if cErr.cause != nil {
+			cErr.Cause = cErr.cause.Error()
+		}
+		out.Causes = append(out.Causes, cErr)
+	}
+
+	return json.Marshal(out)
+}
+
+func link(msg string, next error) error {
+	switch {
+	case next == nil && msg == "":
+		return nil
+	case next == nil:
+		return errors.New(msg)
+	case next.Error() == msg:
+		return next
+	default:
+		return &chainErr{msg: msg, next: next}
 	}
-	return json.Marshal(e.err)
+}
+
+func (c *chainErr) Error() string {
+	return c.msg
+}
+
+func (c *chainErr) Unwrap() error {
+	return c.next
 }
 
 func callers() *Stack {
@@ -44,7 +44,7 @@ func TestErrors_JSON(t *testing.T) {
 		t.FailNow()
 	}
 
-	if string(b) != `{"grpcCode":9,"httpCode":502,"msg":"broker mismatch"}` {
+	if string(b) != `{"code":1,"grpcCode":9,"httpCode":502,"msg":"broker mismatch","cause":"this is the cause"}` {
 		t.Fail()
 	}
 
@@ -67,3 +67,97 @@ func TestErrors_Is(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestErrors_Is_Code(t *testing.T) {
+	err := fmt.Errorf("wrapped: %w", ErrTimeout())
+
+	if !errors.Is(err, ErrTimeout()) {
+		t.Fail()
+	}
+	if errors.Is(err, ErrNotFound()) {
+		t.Fail()
+	}
+}
+
+func TestErrors_Chain_JSON(t *testing.T) {
+	comp := NewComponent("kubefox", "app", "backend", "abc")
+	root := ErrNotFound(errors.New("record missing")).
+		SetComponent(comp).
+		SetDetail("table", "users")
+	testErr := ErrUnexpected(fmt.Errorf("calling backend: %w", root)).
+		SetDetail("attempt", "2")
+
+	b, err := json.Marshal(testErr)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	t.Log(string(b))
+
+	if testErr.err.Cause != "" || testErr.err.Causes != nil {
+		t.Log("marshalling modified the error")
+		t.Fail()
+	}
+
+	e := &Err{}
+	if err := json.Unmarshal(b, e); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	if e.Error() != testErr.Error() {
+		t.Logf("expected '%s' but got '%s'", testErr.Error(), e.Error())
+		t.Fail()
+	}
+	if e.Detail("attempt") != "2" {
+		t.Fail()
+	}
+	if !errors.Is(e, ErrNotFound()) {
+		t.Fail()
+	}
+
+	causes := e.Causes()
+	if len(causes) != 1 {
+		t.Logf("expected 1 cause but got %d", len(causes))
+		t.FailNow()
+	}
+	if causes[0].Code() != CodeNotFound ||
+		causes[0].Component() != comp.Key() ||
+		causes[0].Detail("table") != "users" ||
+		causes[0].Error() != root.Error() {
+
+		t.Fail()
+	}
+}
+
+func TestErrors_Retryable(t *testing.T) {
+	if !IsRetryable(fmt.Errorf("wrapped: %w", ErrInvalid(ErrTimeout()))) {
+		t.Fail()
+	}
+	if IsRetryable(ErrInvalid()) {
+		t.Fail()
+	}
+	if IsRetryable(errors.New("not kubefox")) {
+		t.Fail()
+	}
+}
+
+func TestErrors_RateLimited(t *testing.T) {
+	err := ErrRateLimited().SetDetail("retryAfter", "2")
+
+	if err.HTTPCode() != 429 || !err.Retryable() {
+		t.Fail()
+	}
+	if !errors.Is(fmt.Errorf("wrapped: %w", err), ErrRateLimited()) {
+		t.Fail()
+	}
+}
+
+func TestErrors_Code_String(t *testing.T) {
+	if s := ErrRouteNotFound().Code().String(); s != "RouteNotFound" {
+		t.Errorf("got %s", s)
+	}
+	if s := Code(100).String(); s != "Code(100)" {
+		t.Errorf("got %s", s)
+	}
+}
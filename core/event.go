@@ -58,6 +58,7 @@ func NewErr(err error, opts EventOpts) *Event {
 	if ok := errors.As(err, &kfErr); !ok {
 		kfErr = ErrUnexpected(err)
 	}
+	kfErr.SetComponent(opts.Source)
 	evt.SetJSON(kfErr)
 
 	return applyOpts(evt, Category_RESPONSE, opts)
@@ -599,6 +599,99 @@ func (x *Telemetry) GetSpans() []*v12.Span {
 	return nil
 }
 
+type TapRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Rule written in the route matcher language that events must match, if
+	// not set all events match.
+	Rule               string `protobuf:"bytes,1,opt,name=rule,proto3" json:"rule,omitempty"`
+	VirtualEnvironment string `protobuf:"bytes,2,opt,name=virtual_environment,json=virtualEnvironment,proto3" json:"virtual_environment,omitempty"`
+	// Name of Component that must be either the source or target of events.
+	Component string `protobuf:"bytes,3,opt,name=component,proto3" json:"component,omitempty"`
+	// Fraction of matching events to send, 0 sends all matching events.
+	SampleRate float64 `protobuf:"fixed64,4,opt,name=sample_rate,json=sampleRate,proto3" json:"sample_rate,omitempty"`
+	// Remove content of events before sending.
+	RedactContent bool `protobuf:"varint,5,opt,name=redact_content,json=redactContent,proto3" json:"redact_content,omitempty"`
+	// Headers whose values are redacted before sending.
+	RedactHeaders []string `protobuf:"bytes,6,rep,name=redact_headers,json=redactHeaders,proto3" json:"redact_headers,omitempty"`
+}
+
+func (x *TapRequest) Reset() {
+	*x = TapRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_protobuf_msgs_proto_msgTypes[6]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *TapRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TapRequest) ProtoMessage() {}
+
+func (x *TapRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_protobuf_msgs_proto_msgTypes[6]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TapRequest.ProtoReflect.Descriptor instead.
+func (*TapRequest) Descriptor() ([]byte, []int) {
+	return file_protobuf_msgs_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *TapRequest) GetRule() string {
+	if x != nil {
+		return x.Rule
+	}
+	return ""
+}
+
+func (x *TapRequest) GetVirtualEnvironment() string {
+	if x != nil {
+		return x.VirtualEnvironment
+	}
+	return ""
+}
+
+func (x *TapRequest) GetComponent() string {
+	if x != nil {
+		return x.Component
+	}
+	return ""
+}
+
+func (x *TapRequest) GetSampleRate() float64 {
+	if x != nil {
+		return x.SampleRate
+	}
+	return 0
+}
+
+func (x *TapRequest) GetRedactContent() bool {
+	if x != nil {
+		return x.RedactContent
+	}
+	return false
+}
+
+func (x *TapRequest) GetRedactHeaders() []string {
+	if x != nil {
+		return x.RedactHeaders
+	}
+	return nil
+}
+
 var File_protobuf_msgs_proto protoreflect.FileDescriptor
 
 var file_protobuf_msgs_proto_rawDesc = []byte{
@@ -711,14 +804,28 @@ var file_protobuf_msgs_proto_rawDesc = []byte{
 	0x69, 0x63, 0x73, 0x12, 0x38, 0x0a, 0x05, 0x73, 0x70, 0x61, 0x6e, 0x73, 0x18, 0x0f, 0x20, 0x03,
 	0x28, 0x0b, 0x32, 0x22, 0x2e, 0x6f, 0x70, 0x65, 0x6e, 0x74, 0x65, 0x6c, 0x65, 0x6d, 0x65, 0x74,
 	0x72, 0x79, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x74, 0x72, 0x61, 0x63, 0x65, 0x2e, 0x76,
-	0x31, 0x2e, 0x53, 0x70, 0x61, 0x6e, 0x52, 0x05, 0x73, 0x70, 0x61, 0x6e, 0x73, 0x2a, 0x3f, 0x0a,
-	0x08, 0x43, 0x61, 0x74, 0x65, 0x67, 0x6f, 0x72, 0x79, 0x12, 0x0b, 0x0a, 0x07, 0x55, 0x4e, 0x4b,
-	0x4e, 0x4f, 0x57, 0x4e, 0x10, 0x00, 0x12, 0x0b, 0x0a, 0x07, 0x4d, 0x45, 0x53, 0x53, 0x41, 0x47,
-	0x45, 0x10, 0x01, 0x12, 0x0b, 0x0a, 0x07, 0x52, 0x45, 0x51, 0x55, 0x45, 0x53, 0x54, 0x10, 0x02,
-	0x12, 0x0c, 0x0a, 0x08, 0x52, 0x45, 0x53, 0x50, 0x4f, 0x4e, 0x53, 0x45, 0x10, 0x03, 0x42, 0x20,
-	0x5a, 0x1e, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x78, 0x69, 0x67,
-	0x78, 0x6f, 0x67, 0x2f, 0x6b, 0x75, 0x62, 0x65, 0x66, 0x6f, 0x78, 0x2f, 0x63, 0x6f, 0x72, 0x65,
-	0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x31, 0x2e, 0x53, 0x70, 0x61, 0x6e, 0x52, 0x05, 0x73, 0x70, 0x61, 0x6e, 0x73, 0x22, 0xde, 0x01,
+	0x0a, 0x0a, 0x54, 0x61, 0x70, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x12, 0x0a, 0x04,
+	0x72, 0x75, 0x6c, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x72, 0x75, 0x6c, 0x65,
+	0x12, 0x2f, 0x0a, 0x13, 0x76, 0x69, 0x72, 0x74, 0x75, 0x61, 0x6c, 0x5f, 0x65, 0x6e, 0x76, 0x69,
+	0x72, 0x6f, 0x6e, 0x6d, 0x65, 0x6e, 0x74, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x12, 0x76,
+	0x69, 0x72, 0x74, 0x75, 0x61, 0x6c, 0x45, 0x6e, 0x76, 0x69, 0x72, 0x6f, 0x6e, 0x6d, 0x65, 0x6e,
+	0x74, 0x12, 0x1c, 0x0a, 0x09, 0x63, 0x6f, 0x6d, 0x70, 0x6f, 0x6e, 0x65, 0x6e, 0x74, 0x18, 0x03,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x63, 0x6f, 0x6d, 0x70, 0x6f, 0x6e, 0x65, 0x6e, 0x74, 0x12,
+	0x1f, 0x0a, 0x0b, 0x73, 0x61, 0x6d, 0x70, 0x6c, 0x65, 0x5f, 0x72, 0x61, 0x74, 0x65, 0x18, 0x04,
+	0x20, 0x01, 0x28, 0x01, 0x52, 0x0a, 0x73, 0x61, 0x6d, 0x70, 0x6c, 0x65, 0x52, 0x61, 0x74, 0x65,
+	0x12, 0x25, 0x0a, 0x0e, 0x72, 0x65, 0x64, 0x61, 0x63, 0x74, 0x5f, 0x63, 0x6f, 0x6e, 0x74, 0x65,
+	0x6e, 0x74, 0x18, 0x05, 0x20, 0x01, 0x28, 0x08, 0x52, 0x0d, 0x72, 0x65, 0x64, 0x61, 0x63, 0x74,
+	0x43, 0x6f, 0x6e, 0x74, 0x65, 0x6e, 0x74, 0x12, 0x25, 0x0a, 0x0e, 0x72, 0x65, 0x64, 0x61, 0x63,
+	0x74, 0x5f, 0x68, 0x65, 0x61, 0x64, 0x65, 0x72, 0x73, 0x18, 0x06, 0x20, 0x03, 0x28, 0x09, 0x52,
+	0x0d, 0x72, 0x65, 0x64, 0x61, 0x63, 0x74, 0x48, 0x65, 0x61, 0x64, 0x65, 0x72, 0x73, 0x2a, 0x3f,
+	0x0a, 0x08, 0x43, 0x61, 0x74, 0x65, 0x67, 0x6f, 0x72, 0x79, 0x12, 0x0b, 0x0a, 0x07, 0x55, 0x4e,
+	0x4b, 0x4e, 0x4f, 0x57, 0x4e, 0x10, 0x00, 0x12, 0x0b, 0x0a, 0x07, 0x4d, 0x45, 0x53, 0x53, 0x41,
+	0x47, 0x45, 0x10, 0x01, 0x12, 0x0b, 0x0a, 0x07, 0x52, 0x45, 0x51, 0x55, 0x45, 0x53, 0x54, 0x10,
+	0x02, 0x12, 0x0c, 0x0a, 0x08, 0x52, 0x45, 0x53, 0x50, 0x4f, 0x4e, 0x53, 0x45, 0x10, 0x03, 0x42,
+	0x20, 0x5a, 0x1e, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x78, 0x69,
+	0x67, 0x78, 0x6f, 0x67, 0x2f, 0x6b, 0x75, 0x62, 0x65, 0x66, 0x6f, 0x78, 0x2f, 0x63, 0x6f, 0x72,
+	0x65, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
 }
 
 var (
@@ -734,7 +841,7 @@ func file_protobuf_msgs_proto_rawDescGZIP() []byte {
 }
 
 var file_protobuf_msgs_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
-var file_protobuf_msgs_proto_msgTypes = make([]protoimpl.MessageInfo, 9)
+var file_protobuf_msgs_proto_msgTypes = make([]protoimpl.MessageInfo, 10)
 var file_protobuf_msgs_proto_goTypes = []interface{}{
 	(Category)(0),        // 0: kubefox.proto.v1.Category
 	(*Component)(nil),    // 1: kubefox.proto.v1.Component
@@ -743,12 +850,13 @@ var file_protobuf_msgs_proto_goTypes = []interface{}{
 	(*Event)(nil),        // 4: kubefox.proto.v1.Event
 	(*MatchedEvent)(nil), // 5: kubefox.proto.v1.MatchedEvent
 	(*Telemetry)(nil),    // 6: kubefox.proto.v1.Telemetry
-	nil,                  // 7: kubefox.proto.v1.Event.ParamsEntry
-	nil,                  // 8: kubefox.proto.v1.Event.ValuesEntry
-	nil,                  // 9: kubefox.proto.v1.MatchedEvent.EnvEntry
-	(*v1.LogRecord)(nil), // 10: opentelemetry.proto.logs.v1.LogRecord
-	(*v11.Metric)(nil),   // 11: opentelemetry.proto.metrics.v1.Metric
-	(*v12.Span)(nil),     // 12: opentelemetry.proto.trace.v1.Span
+	(*TapRequest)(nil),   // 7: kubefox.proto.v1.TapRequest
+	nil,                  // 8: kubefox.proto.v1.Event.ParamsEntry
+	nil,                  // 9: kubefox.proto.v1.Event.ValuesEntry
+	nil,                  // 10: kubefox.proto.v1.MatchedEvent.EnvEntry
+	(*v1.LogRecord)(nil), // 11: opentelemetry.proto.logs.v1.LogRecord
+	(*v11.Metric)(nil),   // 12: opentelemetry.proto.metrics.v1.Metric
+	(*v12.Span)(nil),     // 13: opentelemetry.proto.trace.v1.Span
 }
 var file_protobuf_msgs_proto_depIdxs = []int32{
 	3,  // 0: kubefox.proto.v1.Event.parent_span:type_name -> kubefox.proto.v1.SpanContext
@@ -756,13 +864,13 @@ var file_protobuf_msgs_proto_depIdxs = []int32{
 	2,  // 2: kubefox.proto.v1.Event.context:type_name -> kubefox.proto.v1.EventContext
 	1,  // 3: kubefox.proto.v1.Event.source:type_name -> kubefox.proto.v1.Component
 	1,  // 4: kubefox.proto.v1.Event.target:type_name -> kubefox.proto.v1.Component
-	7,  // 5: kubefox.proto.v1.Event.params:type_name -> kubefox.proto.v1.Event.ParamsEntry
-	8,  // 6: kubefox.proto.v1.Event.values:type_name -> kubefox.proto.v1.Event.ValuesEntry
+	8,  // 5: kubefox.proto.v1.Event.params:type_name -> kubefox.proto.v1.Event.ParamsEntry
+	9,  // 6: kubefox.proto.v1.Event.values:type_name -> kubefox.proto.v1.Event.ValuesEntry
 	4,  // 7: kubefox.proto.v1.MatchedEvent.event:type_name -> kubefox.proto.v1.Event
-	9,  // 8: kubefox.proto.v1.MatchedEvent.env:type_name -> kubefox.proto.v1.MatchedEvent.EnvEntry
-	10, // 9: kubefox.proto.v1.Telemetry.log_records:type_name -> opentelemetry.proto.logs.v1.LogRecord
-	11, // 10: kubefox.proto.v1.Telemetry.metrics:type_name -> opentelemetry.proto.metrics.v1.Metric
-	12, // 11: kubefox.proto.v1.Telemetry.spans:type_name -> opentelemetry.proto.trace.v1.Span
+	10, // 8: kubefox.proto.v1.MatchedEvent.env:type_name -> kubefox.proto.v1.MatchedEvent.EnvEntry
+	11, // 9: kubefox.proto.v1.Telemetry.log_records:type_name -> opentelemetry.proto.logs.v1.LogRecord
+	12, // 10: kubefox.proto.v1.Telemetry.metrics:type_name -> opentelemetry.proto.metrics.v1.Metric
+	13, // 11: kubefox.proto.v1.Telemetry.spans:type_name -> opentelemetry.proto.trace.v1.Span
 	12, // [12:12] is the sub-list for method output_type
 	12, // [12:12] is the sub-list for method input_type
 	12, // [12:12] is the sub-list for extension type_name
@@ -848,6 +956,18 @@ func file_protobuf_msgs_proto_init() {
 				return nil
 			}
 		}
+		file_protobuf_msgs_proto_msgTypes[6].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*TapRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
 	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
@@ -855,7 +975,7 @@ func file_protobuf_msgs_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_protobuf_msgs_proto_rawDesc,
 			NumEnums:      1,
-			NumMessages:   9,
+			NumMessages:   10,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
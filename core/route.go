@@ -9,6 +9,7 @@
 package core
 
 import (
+	"cmp"
 	"regexp"
 
 	"github.com/xigxog/kubefox/api"
@@ -23,7 +24,12 @@ type Route struct {
 
 	Id           int
 	ResolvedRule string
-	Priority     int
+	// Priority declared by the Component. Routes with a higher priority are
+	// tested first.
+	Priority int
+	// Length of the resolved rule, used to order routes of equal priority.
+	// Longer (more specific) rules are tested first.
+	Length int
 
 	Component    *Component
 	EventContext *EventContext
@@ -46,7 +52,33 @@ func (r *Route) Resolve(data *api.Data) (err error) {
 		return
 	}
 	// Normalize path args so they don't affect length.
-	r.Priority = len(RuleParamRegexp.ReplaceAllString(r.ResolvedRule, "$1{}"))
+	r.Length = len(RuleParamRegexp.ReplaceAllString(r.ResolvedRule, "$1{}"))
 
 	return
 }
+
+// CompareRoutes returns a negative number if route a should be tested before
+// route b and a positive number if after. Routes are ordered by priority, then
+// by length of the resolved rule. Ties are broken by Component, route id and
+// Event context so the order is deterministic.
+func CompareRoutes(a, b *Route) int {
+	if c := cmp.Compare(b.Priority, a.Priority); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(b.Length, a.Length); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(a.Component.GetApp(), b.Component.GetApp()); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(a.Component.GetName(), b.Component.GetName()); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(a.Id, b.Id); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(a.EventContext.GetVirtualEnvironment(), b.EventContext.GetVirtualEnvironment()); c != 0 {
+		return c
+	}
+	return cmp.Compare(a.EventContext.GetAppDeployment(), b.EventContext.GetAppDeployment())
+}
@@ -24,6 +24,7 @@ const _ = grpc.SupportPackageIsVersion7
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type BrokerClient interface {
 	Subscribe(ctx context.Context, opts ...grpc.CallOption) (Broker_SubscribeClient, error)
+	Tap(ctx context.Context, in *core.TapRequest, opts ...grpc.CallOption) (Broker_TapClient, error)
 }
 
 type brokerClient struct {
@@ -65,11 +66,44 @@ func (x *brokerSubscribeClient) Recv() (*core.MatchedEvent, error) {
 	return m, nil
 }
 
+func (c *brokerClient) Tap(ctx context.Context, in *core.TapRequest, opts ...grpc.CallOption) (Broker_TapClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Broker_ServiceDesc.Streams[1], "/kubefox.proto.v1.Broker/Tap", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &brokerTapClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Broker_TapClient interface {
+	Recv() (*core.Event, error)
+	grpc.ClientStream
+}
+
+type brokerTapClient struct {
+	grpc.ClientStream
+}
+
+func (x *brokerTapClient) Recv() (*core.Event, error) {
+	m := new(core.Event)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // BrokerServer is the server API for Broker service.
 // All implementations must embed UnimplementedBrokerServer
 // for forward compatibility
 type BrokerServer interface {
 	Subscribe(Broker_SubscribeServer) error
+	Tap(*core.TapRequest, Broker_TapServer) error
 	mustEmbedUnimplementedBrokerServer()
 }
 
@@ -80,6 +114,9 @@ type UnimplementedBrokerServer struct {
 func (UnimplementedBrokerServer) Subscribe(Broker_SubscribeServer) error {
 	return status.Errorf(codes.Unimplemented, "method Subscribe not implemented")
 }
+func (UnimplementedBrokerServer) Tap(*core.TapRequest, Broker_TapServer) error {
+	return status.Errorf(codes.Unimplemented, "method Tap not implemented")
+}
 func (UnimplementedBrokerServer) mustEmbedUnimplementedBrokerServer() {}
 
 // UnsafeBrokerServer may be embedded to opt out of forward compatibility for this service.
@@ -119,6 +156,27 @@ func (x *brokerSubscribeServer) Recv() (*core.Event, error) {
 	return m, nil
 }
 
+func _Broker_Tap_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(core.TapRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(BrokerServer).Tap(m, &brokerTapServer{stream})
+}
+
+type Broker_TapServer interface {
+	Send(*core.Event) error
+	grpc.ServerStream
+}
+
+type brokerTapServer struct {
+	grpc.ServerStream
+}
+
+func (x *brokerTapServer) Send(m *core.Event) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 // Broker_ServiceDesc is the grpc.ServiceDesc for Broker service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -133,6 +191,11 @@ var Broker_ServiceDesc = grpc.ServiceDesc{
 			ServerStreams: true,
 			ClientStreams: true,
 		},
+		{
+			StreamName:    "Tap",
+			Handler:       _Broker_Tap_Handler,
+			ServerStreams: true,
+		},
 	},
 	Metadata: "broker_svc.proto",
 }
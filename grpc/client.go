@@ -9,6 +9,7 @@
 package grpc
 
 import (
+	"bytes"
 	context "context"
 	"errors"
 	"fmt"
@@ -24,12 +25,14 @@ import (
 	"github.com/xigxog/kubefox/core"
 	"github.com/xigxog/kubefox/telemetry"
 
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/xigxog/kubefox/logkf"
 	otelgrpc "go.opentelemetry.io/proto/otlp/collector/trace/v1"
 	logsv1 "go.opentelemetry.io/proto/otlp/logs/v1"
 	tracev1 "go.opentelemetry.io/proto/otlp/trace/v1"
 	gogrpc "google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -40,6 +43,22 @@ type ClientOpts struct {
 	BrokerAddr    string
 	HealthSrvAddr string
 	TokenPath     string
+	// Insecure connects to the Broker over plaintext gRPC without a token,
+	// only for use with a standalone Broker during local development.
+	Insecure bool
+	// Maximum size of events sent and received in bytes. The gRPC default
+	// limit is used if 0.
+	MaxEventSize int64
+}
+
+// msgOverheadBytes is added to the max event size to allow for the fields of
+// messages wrapping events.
+const msgOverheadBytes = 65536 // 64 KiB
+
+// MaxMsgSize returns the maximum size of gRPC messages containing events up to
+// maxEventSize bytes.
+func MaxMsgSize(maxEventSize int64) int {
+	return int(maxEventSize) + msgOverheadBytes
 }
 
 type Broker struct {
@@ -57,11 +76,22 @@ type Client struct {
 	recvCh chan *ComponentEvent
 	errCh  chan error
 
+	// Receive time of requests waiting for a worker by id, reported in
+	// heartbeat replies so the broker can detect stalled workers.
+	queued map[string]time.Time
+	// Fetches of offloaded content by id of the content request.
+	fetches map[string]*contentFetch
+
 	reqMapMutex sync.RWMutex
+	queuedMutex sync.Mutex
+	fetchMutex  sync.Mutex
 	sendMutex   sync.Mutex
 
-	healthSrv *http.Server
-	healthy   atomic.Bool
+	healthSrv  *http.Server
+	metricsHdl http.Handler
+	healthy    atomic.Bool
+	// Set while the broker has ejected the replica for failing health checks.
+	ejected atomic.Bool
 
 	log *logkf.Logger
 }
@@ -77,6 +107,12 @@ type ActiveReq struct {
 	expiration time.Time
 }
 
+// contentFetch collects the chunks of offloaded content streamed by the broker.
+type contentFetch struct {
+	buf  bytes.Buffer
+	done chan error
+}
+
 func NewClient(opts ClientOpts) *Client {
 	if opts.TokenPath == "" {
 		opts.TokenPath = api.PathSvcAccToken
@@ -85,6 +121,8 @@ func NewClient(opts ClientOpts) *Client {
 	c := &Client{
 		ClientOpts: opts,
 		reqMap:     make(map[string]*ActiveReq),
+		queued:     make(map[string]time.Time),
+		fetches:    make(map[string]*contentFetch),
 		recvCh:     make(chan *ComponentEvent),
 		errCh:      make(chan error),
 		log:        logkf.Global,
@@ -115,9 +153,12 @@ func (c *Client) Start(def *api.ComponentDefinition, maxAttempts int) {
 }
 
 func (c *Client) run(def *api.ComponentDefinition, retry int) (int, error) {
-	creds, err := credentials.NewClientTLSFromFile(api.PathCACert, "")
-	if err != nil {
-		return retry + 1, fmt.Errorf("unable to load root CA certificate: %v", err)
+	creds := insecure.NewCredentials()
+	if !c.Insecure {
+		var err error
+		if creds, err = credentials.NewClientTLSFromFile(api.PathCACert, ""); err != nil {
+			return retry + 1, fmt.Errorf("unable to load root CA certificate: %v", err)
+		}
 	}
 	grpcCfg := `{
 		"methodConfig": [{
@@ -132,17 +173,26 @@ func (c *Client) run(def *api.ComponentDefinition, retry int) (int, error) {
 		  }
 		}]}`
 
-	conn, err := gogrpc.NewClient(c.BrokerAddr,
+	dialOpts := []gogrpc.DialOption{
 		gogrpc.WithPerRPCCredentials(c),
 		gogrpc.WithTransportCredentials(creds),
 		gogrpc.WithDefaultServiceConfig(grpcCfg),
-	)
+	}
+	if c.MaxEventSize > 0 {
+		dialOpts = append(dialOpts, gogrpc.WithDefaultCallOptions(
+			gogrpc.MaxCallRecvMsgSize(MaxMsgSize(c.MaxEventSize)),
+			gogrpc.MaxCallSendMsgSize(MaxMsgSize(c.MaxEventSize)),
+		))
+	}
+
+	conn, err := gogrpc.NewClient(c.BrokerAddr, dialOpts...)
 	if err != nil {
 		return retry + 1, fmt.Errorf("unable to connect to broker: %v", err)
 	}
 
 	defer func() {
 		c.healthy.Store(false)
+		c.ejected.Store(false)
 		if err := conn.Close(); err != nil {
 			c.log.Error(err)
 		}
@@ -200,7 +250,27 @@ func (c *Client) run(def *api.ComponentDefinition, retry int) (int, error) {
 			go c.recvReq(evt)
 
 		case core.Category_RESPONSE:
-			go c.recvResp(evt.Event)
+			// Chunks of offloaded content are added in the order received.
+			if !c.recvContent(evt.Event) {
+				go c.recvResp(evt.Event)
+			}
+
+		case core.Category_MESSAGE:
+			switch evt.Event.EventType() {
+			case api.EventTypeHealth:
+				go c.recvHeartbeat(evt.Event)
+
+			case api.EventTypeDrain:
+				// The broker is shutting down, report not ready so new requests
+				// are not sent to the component. In-flight requests continue
+				// until the broker closes the subscription, after which it is
+				// reopened.
+				c.healthy.Store(false)
+				c.log.Info("broker draining, waiting for subscription to close")
+
+			default:
+				c.log.WithEvent(evt.Event).Debug("received unexpected message, dropping")
+			}
 
 		default:
 			c.log.WithEvent(evt.Event).Debug("received event on unexpected category, dropping")
@@ -258,14 +328,146 @@ func (c *Client) SendResp(resp *core.Event, start time.Time) error {
 }
 
 func (c *Client) recvReq(req *core.MatchedEvent) {
-	c.log.WithEvent(req.Event).Debug("receive request")
-	c.recvCh <- &ComponentEvent{MatchedEvent: req, ReceivedAt: time.Now()}
+	log := c.log.WithEvent(req.Event)
+	log.Debug("receive request")
+
+	now := time.Now()
+	if err := c.loadContent(req.Event); err != nil {
+		log.Warnf("unable to load offloaded content: %v", err)
+		errResp := core.NewErr(err, core.EventOpts{
+			Parent: req.Event,
+			Source: c.Component,
+			Target: req.Event.Source,
+		})
+		if err := c.SendResp(errResp, now); err != nil {
+			log.Debugf("unable to send error response: %v", err)
+		}
+		return
+	}
+
+	c.queuedMutex.Lock()
+	c.queued[req.Event.Id] = now
+	c.queuedMutex.Unlock()
+
+	c.recvCh <- &ComponentEvent{MatchedEvent: req, ReceivedAt: now}
+
+	c.queuedMutex.Lock()
+	delete(c.queued, req.Event.Id)
+	c.queuedMutex.Unlock()
+}
+
+// loadContent fetches the content of the event if it was offloaded and removes
+// the reference. The broker streams the content in chunks so it is never sent
+// as a single message.
+func (c *Client) loadContent(evt *core.Event) error {
+	ref := evt.Value(api.ValKeyContentRef)
+	if ref == "" {
+		return nil
+	}
+
+	req := core.NewReq(core.EventOpts{
+		Type:    api.EventTypeContent,
+		Source:  c.Component,
+		Target:  c.brkComp,
+		Timeout: evt.TTL(),
+	})
+	req.SetValue(api.ValKeyContentRef, ref)
+
+	fetch := &contentFetch{done: make(chan error, 1)}
+	c.fetchMutex.Lock()
+	c.fetches[req.Id] = fetch
+	c.fetchMutex.Unlock()
+	defer func() {
+		c.fetchMutex.Lock()
+		delete(c.fetches, req.Id)
+		c.fetchMutex.Unlock()
+	}()
+
+	if err := c.send(req, time.Now()); err != nil {
+		return err
+	}
+
+	timer := time.NewTimer(evt.TTL())
+	defer timer.Stop()
+
+	select {
+	case err := <-fetch.done:
+		if err != nil {
+			return err
+		}
+	case <-timer.C:
+		return core.ErrTimeout(fmt.Errorf("fetching offloaded content timed out"))
+	case <-c.brk.Context().Done():
+		return core.ErrBrokerUnavailable(fmt.Errorf("fetching offloaded content failed"))
+	}
+
+	evt.Content = fetch.buf.Bytes()
+	delete(evt.Values, api.ValKeyContentRef)
+
+	return nil
+}
+
+// recvContent adds a chunk of offloaded content to the fetch it is for.
+// Returns false if the event is not part of a fetch.
+func (c *Client) recvContent(evt *core.Event) bool {
+	c.fetchMutex.Lock()
+	fetch := c.fetches[evt.ParentId]
+	c.fetchMutex.Unlock()
+	if fetch == nil {
+		return false
+	}
+
+	var err error
+	switch {
+	case evt.Err() != nil:
+		err = evt.Err()
+	case evt.EventType() != api.EventTypeContent:
+		err = core.ErrUnexpected(fmt.Errorf("unexpected event of type %s", evt.Type))
+	default:
+		fetch.buf.Write(evt.Content)
+		if evt.Value(api.ValKeyContentEnd) != "true" {
+			return true
+		}
+	}
+
+	select {
+	case fetch.done <- err:
+	default:
+	}
+
+	return true
+}
+
+// status returns the number of requests waiting for a worker and how long the
+// oldest has been waiting.
+func (c *Client) status() *api.ReplicaStatus {
+	c.queuedMutex.Lock()
+	defer c.queuedMutex.Unlock()
+
+	status := &api.ReplicaStatus{Queued: len(c.queued)}
+	for _, t := range c.queued {
+		if ms := time.Since(t).Milliseconds(); ms > status.QueuedMillis {
+			status.QueuedMillis = ms
+		}
+	}
+
+	return status
 }
 
 func (c *Client) recvResp(resp *core.Event) {
 	log := c.log.WithEvent(resp)
 	log.Debug("receive response")
 
+	if err := c.loadContent(resp); err != nil {
+		log.Warnf("unable to load offloaded content: %v", err)
+		errResp := core.NewErr(err, core.EventOpts{
+			Source: resp.Source,
+			Target: resp.Target,
+		})
+		errResp.ParentId = resp.ParentId
+		resp = errResp
+	}
+
 	c.reqMapMutex.Lock()
 	respCh := c.reqMap[resp.ParentId]
 	delete(c.reqMap, resp.ParentId)
@@ -279,6 +481,39 @@ func (c *Client) recvResp(resp *core.Event) {
 	respCh.respCh <- resp
 }
 
+// recvHeartbeat records the health reported by the broker and replies so the
+// broker knows the component is alive. The heartbeat is not handled by a
+// worker, the reply reports requests waiting for a worker so the broker can
+// detect stalled workers.
+func (c *Client) recvHeartbeat(evt *core.Event) {
+	health := &api.ReplicaHealth{}
+	if err := evt.Bind(health); err != nil {
+		c.log.WithEvent(evt).Warnf("unable to read heartbeat: %v", err)
+		return
+	}
+	if prev := c.ejected.Swap(health.Ejected); prev != health.Ejected {
+		if health.Ejected {
+			c.log.Warnf("ejected by broker, %s", health.Reason)
+		} else {
+			c.log.Info("reinstated by broker")
+		}
+	}
+
+	reply := core.NewMsg(core.EventOpts{
+		Type:    api.EventTypeHealth,
+		Source:  c.Component,
+		Target:  c.brkComp,
+		Timeout: time.Second * 5,
+	})
+	if err := reply.SetJSON(c.status()); err != nil {
+		c.log.Error(err)
+		return
+	}
+	if err := c.send(reply, time.Now()); err != nil {
+		c.log.Debugf("unable to reply to heartbeat: %v", err)
+	}
+}
+
 func (c *Client) send(evt *core.Event, start time.Time) error {
 	// Need to protect the stream from being called by multiple threads.
 	c.sendMutex.Lock()
@@ -338,6 +573,7 @@ func (c *Client) StartHealthSrv() error {
 		return nil
 	}
 
+	c.metricsHdl = promhttp.Handler()
 	c.healthSrv = &http.Server{
 		WriteTimeout: time.Second * 3,
 		ReadTimeout:  time.Second * 3,
@@ -361,20 +597,32 @@ func (c *Client) StartHealthSrv() error {
 	return nil
 }
 
+// ServeHTTP reports the component unhealthy if it is not subscribed to the
+// broker. Requests to /readyz also report the component unhealthy while it is
+// ejected by the broker, so it is marked not ready without being restarted.
+// Metrics of the default Prometheus registry are served at /metrics.
 func (c *Client) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/metrics" {
+		c.metricsHdl.ServeHTTP(resp, req)
+		return
+	}
+
 	status := http.StatusOK
-	if !c.healthy.Load() {
+	if !c.healthy.Load() || (req.URL.Path == "/readyz" && c.ejected.Load()) {
 		status = http.StatusServiceUnavailable
 	}
 	resp.WriteHeader(status)
 }
 
 func (c *Client) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
-	b, err := os.ReadFile(c.ClientOpts.TokenPath)
-	if err != nil {
-		return nil, err
+	var token string
+	if !c.Insecure {
+		b, err := os.ReadFile(c.ClientOpts.TokenPath)
+		if err != nil {
+			return nil, err
+		}
+		token = string(b)
 	}
-	token := string(b)
 
 	return map[string]string{
 		api.GRPCKeyId:        c.Component.Id,
@@ -389,7 +637,7 @@ func (c *Client) GetRequestMetadata(ctx context.Context, uri ...string) (map[str
 }
 
 func (c *Client) RequireTransportSecurity() bool {
-	return true
+	return !c.Insecure
 }
 
 func (c *Client) startReqMapReaper() {
@@ -0,0 +1,22 @@
+// Copyright 2024 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package dep
+
+import "github.com/xigxog/kubefox/api"
+
+type Option func(*api.Dependency)
+
+// App sets the App the Component dependency belongs to. Requests to the
+// Component are sent to the AppDeployment of the App in the Release of the
+// VirtualEnvironment.
+func App(name string) Option {
+	return func(d *api.Dependency) {
+		d.App = name
+	}
+}
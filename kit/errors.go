@@ -0,0 +1,82 @@
+// Copyright 2024 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package kit
+
+import (
+	"errors"
+
+	"github.com/xigxog/kubefox/core"
+)
+
+// Error is the error returned when an Event fails. It carries the code, the
+// key of the Component the error originated from, whether the failure is
+// retryable, structured details and the chain of causes.
+type Error = core.Err
+
+// Constructors of Errors with the given cause. Errors are mutable, a new Error
+// is returned by each call. The returned Errors can be used as the target of
+// errors.Is, an error matches if it is an Error with the same code.
+//
+//	if errors.Is(err, kit.ErrNotFound()) {
+//		return ktx.Resp().SendStr("not found")
+//	}
+
+func ErrComponentGone(cause ...error) *Error {
+	return core.ErrComponentGone(cause...)
+}
+
+func ErrInvalid(cause ...error) *Error {
+	return core.ErrInvalid(cause...)
+}
+
+func ErrNotFound(cause ...error) *Error {
+	return core.ErrNotFound(cause...)
+}
+
+func ErrRateLimited(cause ...error) *Error {
+	return core.ErrRateLimited(cause...)
+}
+
+func ErrRouteNotFound(cause ...error) *Error {
+	return core.ErrRouteNotFound(cause...)
+}
+
+func ErrTimeout(cause ...error) *Error {
+	return core.ErrTimeout(cause...)
+}
+
+func ErrUnauthorized(cause ...error) *Error {
+	return core.ErrUnauthorized(cause...)
+}
+
+func ErrUnexpected(cause ...error) *Error {
+	return core.ErrUnexpected(cause...)
+}
+
+// AsError finds the first Error in err's chain. If found it is returned
+// along with true.
+//
+//	resp, err := ktx.Req(backend).Send()
+//	if kfErr, ok := kit.AsError(err); ok {
+//		ktx.Log().Infof("%s failed: %v", kfErr.Component(), kfErr.Details())
+//	}
+func AsError(err error) (*Error, bool) {
+	kfErr := &core.Err{}
+	if errors.As(err, &kfErr) {
+		return kfErr, true
+	}
+
+	return nil, false
+}
+
+// IsRetryable returns true if err or an Error in its chain is marked as
+// retryable.
+func IsRetryable(err error) bool {
+	return core.IsRetryable(err)
+}
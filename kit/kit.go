@@ -18,6 +18,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"sync"
 	"time"
 
@@ -25,6 +26,7 @@ import (
 	"github.com/xigxog/kubefox/build"
 	"github.com/xigxog/kubefox/core"
 	"github.com/xigxog/kubefox/grpc"
+	"github.com/xigxog/kubefox/kit/dep"
 	"github.com/xigxog/kubefox/kit/env"
 	"github.com/xigxog/kubefox/logkf"
 	"github.com/xigxog/kubefox/telemetry"
@@ -36,13 +38,6 @@ const (
 	maxAttempts = 5
 )
 
-// TODO also support declarative routes? Example:
-//
-//	kit.RouteBuilder().
-//	    Header("host", "google.com").
-//	    Query("param1", "fish").
-//	    Handler(myHandler)
-
 type kit struct {
 	compDef     api.ComponentDefinition
 	compDetails api.Details
@@ -70,7 +65,7 @@ func New() Kit {
 		},
 	}
 
-	var help bool
+	var help, insecure bool
 	var platform, app, name, hash string
 	var brokerAddr, healthAddr, logFormat, logLevel string
 	flag.StringVar(&platform, "platform", "", "KubeFox Platform name. (required)")
@@ -83,6 +78,7 @@ func New() Kit {
 	flag.IntVar(&svc.numWorkers, "num-workers", runtime.NumCPU(), "Number of worker threads to start, default is number of logical CPUs.")
 	flag.StringVar(&logFormat, "log-format", "console", "Log format. [options 'json', 'console']")
 	flag.StringVar(&logLevel, "log-level", "debug", "Log level. [options 'debug', 'info', 'warn', 'error']")
+	flag.BoolVar(&insecure, "insecure", false, "Connect to the Broker over plaintext gRPC, only for use with 'kubefox dev'.")
 	flag.BoolVar(&svc.export, "export", false, "Exports component configuration in JSON and exits.")
 	flag.BoolVar(&help, "help", false, "Show usage for component.")
 	flag.Parse()
@@ -103,7 +99,8 @@ Flags:
 		utils.CheckRequiredFlag("name", name)
 		utils.CheckRequiredFlag("hash", hash)
 
-		if hash != build.Info.Hash {
+		// Components run locally are usually built without a hash.
+		if hash != build.Info.Hash && !insecure {
 			fmt.Fprintf(os.Stderr, "hash '%s' does not match build info hash '%s'", hash, build.Info.Hash)
 			os.Exit(1)
 		}
@@ -128,6 +125,8 @@ Flags:
 		Component:     comp,
 		BrokerAddr:    brokerAddr,
 		HealthSrvAddr: healthAddr,
+		Insecure:      insecure,
+		MaxEventSize:  svc.maxEventSize,
 	})
 
 	svc.log.Info("kit created 🦊")
@@ -151,7 +150,52 @@ func (svc *kit) Description(description string) {
 	svc.compDetails.Title = description
 }
 
-func (svc *kit) Route(rule string, handler EventHandler) {
+func (svc *kit) LoadBalancing(lb api.LoadBalancing) {
+	svc.compDef.LoadBalancing = &lb
+}
+
+func (svc *kit) CachePolicy(policy api.CachePolicy) {
+	svc.compDef.CachePolicy = &policy
+}
+
+// Priority sets the priority of a route. Routes with a higher priority are
+// tested first, the default priority is 0.
+func Priority(priority int) RouteOption {
+	return func(r *api.RouteSpec) {
+		r.Priority = priority
+	}
+}
+
+// RateLimit limits the rate requests matching a route are routed. Requests
+// exceeding a limit are rejected with ErrRateLimited. The limits apply in
+// addition to the RateLimitPolicy of the VirtualEnvironment.
+//
+//	kit.Route("PathPrefix(`/search`)", search, kit.RateLimit(api.RateLimit{
+//		Key:      api.RateLimitKeyHeader,
+//		Header:   "X-Api-Key",
+//		Requests: 10,
+//	}))
+func RateLimit(limits ...api.RateLimit) RouteOption {
+	return func(r *api.RouteSpec) {
+		r.RateLimitPolicy = &api.RateLimitPolicy{Limits: limits}
+	}
+}
+
+// Cache caches responses to requests matching a route in the Broker of the
+// requesting Component. The policy takes precedence over the CachePolicy of
+// the Component.
+//
+//	kit.Route("Method(`GET`) && PathPrefix(`/products`)", products, kit.Cache(api.CachePolicy{
+//		TTLSeconds: 60,
+//		Vary:       []string{"Accept-Language"},
+//	}))
+func Cache(policy api.CachePolicy) RouteOption {
+	return func(r *api.RouteSpec) {
+		r.CachePolicy = &policy
+	}
+}
+
+func (svc *kit) Route(rule string, handler EventHandler, opts ...RouteOption) {
 	r := api.NewEnvTemplate("route", rule)
 	if r.ParseError() != nil {
 		svc.log.Fatalf("error parsing route '%s': %v", rule, r.ParseError())
@@ -168,10 +212,40 @@ func (svc *kit) Route(rule string, handler EventHandler) {
 		},
 		handler: handler,
 	}
+	for _, o := range opts {
+		o(&kitRoute.RouteSpec)
+	}
 	svc.routes = append(svc.routes, kitRoute)
 	svc.compDef.Routes = append(svc.compDef.Routes, kitRoute.RouteSpec)
 }
 
+func (svc *kit) RouteBuilder() RouteBuilder {
+	return &routeBuilder{kit: svc}
+}
+
+// exportDef returns the Component definition with routes in the order they are
+// tested. Environment variables used by rules are not resolved so the order of
+// routes with equal priority might differ once deployed.
+func (svc *kit) exportDef() *api.ComponentDefinition {
+	def := svc.compDef
+	def.Routes = slices.Clone(svc.compDef.Routes)
+
+	length := make(map[int]int, len(def.Routes))
+	for _, spec := range def.Routes {
+		if r, err := core.NewRoute(spec.Id, spec.Rule); err == nil && r.Resolve(nil) == nil {
+			length[spec.Id] = r.Length
+		}
+	}
+	slices.SortStableFunc(def.Routes, func(a, b api.RouteSpec) int {
+		return core.CompareRoutes(
+			&core.Route{Id: a.Id, Priority: a.Priority, Length: length[a.Id]},
+			&core.Route{Id: b.Id, Priority: b.Priority, Length: length[b.Id]},
+		)
+	})
+
+	return &def
+}
+
 func (svc *kit) Static(pathPrefix string, fsPrefix string, fs fs.FS) {
 	svc.Route("PathPrefix(`"+pathPrefix+"`)", func(ktx Kontext) error {
 		file := filepath.Join(fsPrefix, ktx.PathSuffix())
@@ -204,21 +278,37 @@ func (svc *kit) EnvVar(name string, opts ...env.VarOption) EnvVarDep {
 	return env.NewVar(name, envSchema.Type)
 }
 
-func (svc *kit) Component(name string) ComponentDep {
-	return svc.dependency(name, api.ComponentTypeKubeFox)
+func (svc *kit) Component(name string, opts ...dep.Option) ComponentDep {
+	return svc.dependency(name, api.ComponentTypeKubeFox, opts...)
 }
 
 func (svc *kit) HTTPAdapter(name string) ComponentDep {
 	return svc.dependency(name, api.ComponentTypeHTTPAdapter)
 }
 
-func (svc *kit) dependency(name string, typ api.ComponentType) ComponentDep {
+func (svc *kit) dependency(name string, typ api.ComponentType, opts ...dep.Option) ComponentDep {
+	d := &api.Dependency{Type: typ}
+	for _, o := range opts {
+		o(d)
+	}
+	if d.App == svc.brk.Component.App {
+		d.App = ""
+	}
+	// Dependencies are keyed by name, a Component cannot depend on Components
+	// with the same name in different Apps.
+	if cur, found := svc.compDef.Dependencies[name]; found && (cur.Type != d.Type || cur.App != d.App) {
+		svc.log.Fatalf("dependency '%s' is already registered with a different type or app", name)
+	}
+
 	c := &dependency{
 		typ:  typ,
 		app:  svc.brk.Component.App,
 		name: name,
 	}
-	svc.compDef.Dependencies[name] = &api.Dependency{Type: typ}
+	if d.App != "" {
+		c.app = d.App
+	}
+	svc.compDef.Dependencies[name] = d
 
 	return c
 }
@@ -233,7 +323,7 @@ func (svc *kit) Start() {
 
 func (svc *kit) start() (err error) {
 	if svc.export {
-		c, _ := json.MarshalIndent(svc.compDef, "", "  ")
+		c, _ := json.MarshalIndent(svc.exportDef(), "", "  ")
 		fmt.Println(string(c))
 		os.Exit(0)
 	}
@@ -322,7 +412,7 @@ func (svc *kit) recvReq(req *grpc.ComponentEvent) {
 	if err != nil {
 		log.Debugf("error returned by route handler: %v", err)
 
-		errEvt := core.NewErr(err, core.EventOpts{})
+		errEvt := core.NewErr(err, core.EventOpts{Source: svc.brk.Component})
 		if err := ktx.Resp().Forward(errEvt); err != nil {
 			log.Errorf("unexpected error sending response: %v", err)
 		}
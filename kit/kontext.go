@@ -25,6 +25,7 @@ import (
 	"github.com/xigxog/kubefox/core"
 	"github.com/xigxog/kubefox/logkf"
 	"github.com/xigxog/kubefox/telemetry"
+	"github.com/xigxog/kubefox/utils"
 )
 
 type kontext struct {
@@ -110,10 +111,7 @@ func (k *kontext) Req(target ComponentDep) Req {
 			Type:   target.EventType(),
 			Parent: k.Event,
 			Source: k.kit.brk.Component,
-			Target: core.NewTargetComponent(
-				target.Type(),
-				target.Name(),
-			),
+			Target: k.target(target),
 		}),
 		ktx: k,
 	}
@@ -124,10 +122,7 @@ func (k *kontext) Forward(target ComponentDep) Req {
 		Event: core.CloneToReq(k.Event, core.EventOpts{
 			Parent: k.Event,
 			Source: k.kit.brk.Component,
-			Target: core.NewTargetComponent(
-				target.Type(),
-				target.Name(),
-			),
+			Target: k.target(target),
 		}),
 		ktx: k,
 	}
@@ -146,16 +141,25 @@ func (k *kontext) Transport(target ComponentDep) http.RoundTripper {
 				Type:   target.EventType(),
 				Parent: k.Event,
 				Source: k.kit.brk.Component,
-				Target: core.NewTargetComponent(
-					target.Type(),
-					target.Name(),
-				),
+				Target: k.target(target),
 			}),
 			ktx: k,
 		},
 	}
 }
 
+// target returns the target Component of a request to the dependency. The App
+// is only set for Components of other Apps, the Broker resolves the others
+// using the AppDeployment of the request.
+func (k *kontext) target(dep ComponentDep) *core.Component {
+	comp := core.NewTargetComponent(dep.Type(), dep.Name())
+	if app := utils.CleanName(dep.App()); app != k.kit.brk.Component.App {
+		comp.App = app
+	}
+
+	return comp
+}
+
 func (k *kontext) sendReq(req *core.Event) (*core.Event, error) {
 	span := k.rootSpan.StartChildSpan(
 		fmt.Sprintf("Send REQUEST to %s", req.Target.Key()))
@@ -0,0 +1,163 @@
+// Copyright 2024 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package kit
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/xigxog/kubefox/api"
+)
+
+type routeBuilder struct {
+	kit        *kit
+	predicates []string
+	priority   int
+	rateLimits []api.RateLimit
+	cache      *api.CachePolicy
+}
+
+func (b *routeBuilder) Adapter(name string) RouteBuilder {
+	return b.add("Adapter", name)
+}
+
+func (b *routeBuilder) All() RouteBuilder {
+	return b.add("All")
+}
+
+func (b *routeBuilder) Body(path, value string) RouteBuilder {
+	return b.add("Body", path, value)
+}
+
+func (b *routeBuilder) Claim(key, value string) RouteBuilder {
+	return b.add("Claim", key, value)
+}
+
+func (b *routeBuilder) ContentType(contentType string) RouteBuilder {
+	return b.add("ContentType", contentType)
+}
+
+func (b *routeBuilder) Genesis() RouteBuilder {
+	return b.add("Genesis")
+}
+
+func (b *routeBuilder) Header(key, value string) RouteBuilder {
+	return b.add("Header", key, value)
+}
+
+func (b *routeBuilder) HeaderExists(key string) RouteBuilder {
+	return b.add("HeaderExists", key)
+}
+
+func (b *routeBuilder) HeaderI(key, value string) RouteBuilder {
+	return b.add("HeaderI", key, value)
+}
+
+func (b *routeBuilder) HeaderPrefix(key, prefix string) RouteBuilder {
+	return b.add("HeaderPrefix", key, prefix)
+}
+
+func (b *routeBuilder) Host(host string) RouteBuilder {
+	return b.add("Host", host)
+}
+
+func (b *routeBuilder) HostRegexp(regex string) RouteBuilder {
+	return b.add("HostRegexp", regex)
+}
+
+func (b *routeBuilder) Internal() RouteBuilder {
+	return b.add("Internal")
+}
+
+func (b *routeBuilder) Method(methods ...string) RouteBuilder {
+	return b.add("Method", methods...)
+}
+
+func (b *routeBuilder) Path(path string) RouteBuilder {
+	return b.add("Path", path)
+}
+
+func (b *routeBuilder) PathPrefix(prefix string) RouteBuilder {
+	return b.add("PathPrefix", prefix)
+}
+
+func (b *routeBuilder) Query(key, value string) RouteBuilder {
+	return b.add("Query", key, value)
+}
+
+func (b *routeBuilder) QueryExists(key string) RouteBuilder {
+	return b.add("QueryExists", key)
+}
+
+func (b *routeBuilder) QueryI(key, value string) RouteBuilder {
+	return b.add("QueryI", key, value)
+}
+
+func (b *routeBuilder) Sample(key, weight string) RouteBuilder {
+	return b.add("Sample", key, weight)
+}
+
+func (b *routeBuilder) Source(app, component string) RouteBuilder {
+	return b.add("Source", app, component)
+}
+
+func (b *routeBuilder) Type(evtType string) RouteBuilder {
+	return b.add("Type", evtType)
+}
+
+func (b *routeBuilder) Weight(weight string) RouteBuilder {
+	return b.add("Weight", weight)
+}
+
+func (b *routeBuilder) Priority(priority int) RouteBuilder {
+	b.priority = priority
+	return b
+}
+
+func (b *routeBuilder) RateLimit(limits ...api.RateLimit) RouteBuilder {
+	b.rateLimits = append(b.rateLimits, limits...)
+	return b
+}
+
+func (b *routeBuilder) Cache(policy api.CachePolicy) RouteBuilder {
+	b.cache = &policy
+	return b
+}
+
+func (b *routeBuilder) Rule() string {
+	if len(b.predicates) == 0 {
+		return "All()"
+	}
+	return strings.Join(b.predicates, " && ")
+}
+
+func (b *routeBuilder) Handler(handler EventHandler) {
+	opts := []RouteOption{Priority(b.priority)}
+	if len(b.rateLimits) > 0 {
+		opts = append(opts, RateLimit(b.rateLimits...))
+	}
+	if b.cache != nil {
+		opts = append(opts, Cache(*b.cache))
+	}
+	b.kit.Route(b.Rule(), handler, opts...)
+}
+
+func (b *routeBuilder) add(predicate string, inputs ...string) RouteBuilder {
+	quoted := make([]string, len(inputs))
+	for i, in := range inputs {
+		if strings.Contains(in, "`") {
+			b.kit.log.Fatalf("input '%s' of %s predicate contains a back tick", in, predicate)
+		}
+		quoted[i] = "`" + in + "`"
+	}
+	b.predicates = append(b.predicates,
+		fmt.Sprintf("%s(%s)", predicate, strings.Join(quoted, ", ")))
+
+	return b
+}
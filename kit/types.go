@@ -18,12 +18,16 @@ import (
 	"text/template"
 
 	"github.com/xigxog/kubefox/api"
+	"github.com/xigxog/kubefox/kit/dep"
 	"github.com/xigxog/kubefox/kit/env"
 	"github.com/xigxog/kubefox/logkf"
 )
 
 type EventHandler func(ktx Kontext) error
 
+// RouteOption configures a route registered with Kit.Route().
+type RouteOption func(*api.RouteSpec)
+
 type Kit interface {
 	// Start connects to the Broker passing the Component's Service Account
 	// Token to authenticate. Once connected Kit will accept incoming request
@@ -42,14 +46,53 @@ type Kit interface {
 	// used to combined predicates. Predicates can be negated with the '!' (not)
 	// operator. The following predicates are supported:
 	//
+	//   Adapter(`name`)
+	//     Matches if the Event was sent by the adapter with given name.
+	//
 	//   All()
 	//     Matches all Events.
 	//
+	//   Body(`$.path`, `value`)
+	//     Matches if the value selected by the JSONPath from the JSON content
+	//     of the Event is equal to `value`. Content larger than 1 MiB is not
+	//     matched.
+	//
+	//   Claim(`key`, `value`)
+	//     Matches if a claim `key` of the JWT verified by the HTTP adapter
+	//     exists and is equal to `value`.
+	//
+	//   ContentType(`application/json`)
+	//     Matches if the media type of the Event content is equal to given
+	//     input. Parameters such as charset are ignored.
+	//
+	//   Genesis()
+	//     Matches if the Event originated from an adapter.
+	//
 	//   Header(`key`, `value`)
 	//     Matches if a header `key` exists and is equal to `value`.
 	//
+	//   HeaderExists(`key`)
+	//     Matches if a header `key` exists.
+	//
+	//   HeaderI(`key`, `value`)
+	//     Matches if a header `key` exists and is equal to `value`, ignoring
+	//     case.
+	//
+	//   HeaderPrefix(`key`, `prefix`)
+	//     Matches if a header `key` exists and begins with `prefix`.
+	//
 	//   Host(`example.com`)
-	//     Matches if the domain (host header value) is equal to input.
+	//     Matches if the domain (host header value) is equal to input, ignoring
+	//     case. A label of '*' matches any single label, e.g. `*.example.com`.
+	//     If input includes a port, e.g. `example.com:8080`, the port must
+	//     match, otherwise any port matches.
+	//
+	//   HostRegexp(`[a-z]+\.example\.com`)
+	//     Matches if the domain, without port, matches the regular expression.
+	//     Named groups are extracted to parameters.
+	//
+	//   Internal()
+	//     Matches if the Event was sent by another Component.
 	//
 	//   Method(`GET`, ...)
 	//     Matches if the request method is one of the given methods (GET, POST,
@@ -64,9 +107,32 @@ type Kit interface {
 	//   Query(`key`, `value`)
 	//     Matches if a query parameter `key` exists and is equal to `value`.
 	//
+	//   QueryExists(`key`)
+	//     Matches if a query parameter `key` exists.
+	//
+	//   QueryI(`key`, `value`)
+	//     Matches if a query parameter `key` exists and is equal to `value`,
+	//     ignoring case.
+	//
+	//   Sample(`key`, `10%`)
+	//     Matches the given percent of Events by hashing the value of header
+	//     `key`, or of cookie `name` if `key` is 'cookie:name'. Events with the
+	//     same value always match the same way. If the value is missing the
+	//     trace id is used.
+	//
+	//   Source(`app`, `component`)
+	//     Matches if the Event was sent by the Component of the given App.
+	//
 	//   Type(`value`)
 	//     Matches if Event type is equal to given input.
 	//
+	//   Weight(`10`)
+	//     Matches the given percent of Events by hashing the trace id. Routes
+	//     using Weight or Sample whose other predicates are the same split
+	//     Events between them, for example routes 'Path(`/`) && Weight(`10`)'
+	//     and 'Path(`/`) && Weight(`90`)' of two Components match 10% and 90%
+	//     of requests to '/'.
+	//
 	// Predicate inputs can utilize regular expressions to match and optionally
 	// extract parts of an Event to a named parameter. Regular expression use
 	// the format '{<NAME>}' or '{[NAME]:<REGEX>}' to extract the matching part
@@ -90,7 +156,29 @@ type Kit interface {
 	//     func(ktx kit.Kontext) error {
 	//       return ktx.Resp().SendStr("The orderId is ", ktx.Param("orderId"))
 	//     })
-	Route(rule string, handler EventHandler)
+	//
+	// Routes are tested in order of priority. Routes with equal priority are
+	// tested in order of the length of their rule, longest first, as longer
+	// rules are usually more specific. The priority of a route can be set using
+	// the Priority option. For example, the following catch-all route is tested
+	// after all routes using the default priority of 0:
+	//
+	//   kit.Route("PathPrefix(`/`)", notFound, kit.Priority(-1))
+	Route(rule string, handler EventHandler, opts ...RouteOption)
+
+	// RouteBuilder returns a RouteBuilder that can be used to declare a route
+	// instead of writing the rule. All predicates added to the builder must
+	// match.
+	//
+	// For example:
+	//
+	//   kit.RouteBuilder().
+	//     Genesis().
+	//     Method("GET").
+	//     Path("/orders/{orderId}").
+	//     Claim("role", "admin").
+	//     Handler(myHandler)
+	RouteBuilder() RouteBuilder
 
 	Static(pathPrefix string, fsPrefix string, fs fs.FS)
 
@@ -121,7 +209,13 @@ type Kit interface {
 	//       r, _ := ktx.Req(backend).Send()
 	//       return ktx.Resp().SendStr("the resp from backend is ", r.Str())
 	//   })
-	Component(name string) ComponentDep
+	//
+	// Components of other Apps are declared using dep.App, the App must be
+	// part of the Release of the VirtualEnvironment. Dependency names must be
+	// unique, registering the same name with a different App is fatal.
+	//
+	//   b := kit.Component("billing", dep.App("payments"))
+	Component(name string, opts ...dep.Option) ComponentDep
 
 	// HTTPAdapter registers a dependency on the named HTTP Adapter. The
 	// returned ComponentDep can be used by EventHandlers to invoke the Adapter
@@ -142,10 +236,66 @@ type Kit interface {
 	// Description sets the Component's description.
 	Description(description string)
 
+	// LoadBalancing sets the strategy used by Brokers to select the replica
+	// of the Component events are sent to from the replicas subscribed to
+	// each Broker. The default strategy is LocalFirst.
+	LoadBalancing(lb api.LoadBalancing)
+
+	// CachePolicy caches responses of the Component in the Broker of the
+	// requesting Component. The policy of a route takes precedence, see
+	// kit.Cache().
+	CachePolicy(policy api.CachePolicy)
+
 	// Log returns a pre-configured structured logger for the Component.
 	Log() *logkf.Logger
 }
 
+// RouteBuilder declares a route predicate by predicate. Inputs follow the same
+// rules as inputs of predicates passed to Kit.Route(), regular expressions and
+// environment variables can be used.
+type RouteBuilder interface {
+	Adapter(name string) RouteBuilder
+	All() RouteBuilder
+	Body(path, value string) RouteBuilder
+	Claim(key, value string) RouteBuilder
+	ContentType(contentType string) RouteBuilder
+	Genesis() RouteBuilder
+	Header(key, value string) RouteBuilder
+	HeaderExists(key string) RouteBuilder
+	HeaderI(key, value string) RouteBuilder
+	HeaderPrefix(key, prefix string) RouteBuilder
+	Host(host string) RouteBuilder
+	HostRegexp(regex string) RouteBuilder
+	Internal() RouteBuilder
+	Method(methods ...string) RouteBuilder
+	Path(path string) RouteBuilder
+	PathPrefix(prefix string) RouteBuilder
+	Query(key, value string) RouteBuilder
+	QueryExists(key string) RouteBuilder
+	QueryI(key, value string) RouteBuilder
+	Sample(key, weight string) RouteBuilder
+	Source(app, component string) RouteBuilder
+	Type(evtType string) RouteBuilder
+	Weight(weight string) RouteBuilder
+
+	// Priority sets the priority of the route, see Kit.Route().
+	Priority(priority int) RouteBuilder
+
+	// RateLimit limits the rate requests matching the route are routed, see
+	// kit.RateLimit().
+	RateLimit(limits ...api.RateLimit) RouteBuilder
+
+	// Cache caches responses to requests matching the route, see
+	// kit.Cache().
+	Cache(policy api.CachePolicy) RouteBuilder
+
+	// Rule returns the rule built from the added predicates.
+	Rule() string
+
+	// Handler registers the route with the EventHandler.
+	Handler(handler EventHandler)
+}
+
 type Kontext interface {
 	EventReader
 
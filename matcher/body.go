@@ -0,0 +1,219 @@
+// Copyright 2024 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package matcher
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/xigxog/kubefox/api"
+	"github.com/xigxog/kubefox/core"
+)
+
+// segment is a single step of a JSONPath. If wildcard is true all members of
+// an object or elements of an array are selected. Otherwise, if isIndex is
+// true the element at index is selected, else the member name.
+type segment struct {
+	name     string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+// parsedBody is the content of an Event parsed as JSON. It is parsed at most
+// once while the Event is matched.
+type parsedBody struct {
+	doc    any
+	ok     bool
+	parsed bool
+}
+
+// body matches values of the Event content selected by a JSONPath. The content
+// must be JSON and no larger than api.MaxBodyMatchSizeBytes. Only scalar
+// values are compared, if the path selects multiple values the predicate
+// matches if any of them does.
+func (m *EventMatcher) body(path, val string) (EventPredicate, error) {
+	segs, err := parseJSONPath(path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSONPath of body predicate %s: %w", path, err)
+	}
+
+	regex, err := extractRegex(val)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex of body predicate %s: %w", val, err)
+	}
+
+	return func(e *core.Event) bool {
+		doc, ok := m.jsonBody(e)
+		if !ok {
+			return false
+		}
+		for _, v := range selectJSON(doc, segs) {
+			if s, ok := scalarStr(v); ok && matchStr(val, regex, s) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// jsonBody returns the parsed content of the Event. If the Event is being
+// matched the result is cached so multiple Body predicates parse it only once.
+func (m *EventMatcher) jsonBody(e *core.Event) (any, bool) {
+	cached, found := m.bodies.Load(e)
+	if !found {
+		return parseBody(e)
+	}
+
+	b := cached.(*parsedBody)
+	if !b.parsed {
+		b.doc, b.ok = parseBody(e)
+		b.parsed = true
+	}
+
+	return b.doc, b.ok
+}
+
+func parseBody(e *core.Event) (any, bool) {
+	if len(e.Content) == 0 || len(e.Content) > api.MaxBodyMatchSizeBytes {
+		return nil, false
+	}
+	t, _, _ := strings.Cut(e.GetContentType(), ";")
+	if !strings.Contains(strings.ToLower(t), "json") {
+		return nil, false
+	}
+
+	var doc any
+	dec := json.NewDecoder(bytes.NewReader(e.Content))
+	// Keep numbers as written so inputs can be compared exactly.
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return nil, false
+	}
+
+	return doc, true
+}
+
+// parseJSONPath parses the subset of JSONPath made up of member names
+// ('.name' or ['name']), array indexes ([0], negative indexes count from the
+// end) and wildcards ('.*' or [*]). The path must start with '$'.
+func parseJSONPath(path string) ([]segment, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("path must start with '$'")
+	}
+
+	var segs []segment
+	for i := 1; i < len(path); {
+		switch path[i] {
+		case '.':
+			i++
+			if i < len(path) && path[i] == '.' {
+				return nil, fmt.Errorf("recursive descent is not supported")
+			}
+			start := i
+			for i < len(path) && path[i] != '.' && path[i] != '[' {
+				i++
+			}
+			name := path[start:i]
+			switch name {
+			case "":
+				return nil, fmt.Errorf("missing member name at index %d", start)
+			case "*":
+				segs = append(segs, segment{wildcard: true})
+			default:
+				segs = append(segs, segment{name: name})
+			}
+
+		case '[':
+			end := strings.IndexByte(path[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed bracket started at index %d", i)
+			}
+			sel := strings.TrimSpace(path[i+1 : i+end])
+			switch {
+			case sel == "*":
+				segs = append(segs, segment{wildcard: true})
+			case len(sel) >= 2 && (sel[0] == '\'' || sel[0] == '"') && sel[len(sel)-1] == sel[0]:
+				segs = append(segs, segment{name: sel[1 : len(sel)-1]})
+			default:
+				idx, err := strconv.Atoi(sel)
+				if err != nil {
+					return nil, fmt.Errorf("invalid selector '%s' at index %d", sel, i)
+				}
+				segs = append(segs, segment{index: idx, isIndex: true})
+			}
+			i += end + 1
+
+		default:
+			return nil, fmt.Errorf("unexpected character '%c' at index %d", path[i], i)
+		}
+	}
+
+	return segs, nil
+}
+
+func selectJSON(doc any, segs []segment) []any {
+	cur := []any{doc}
+	for _, s := range segs {
+		var next []any
+		for _, v := range cur {
+			switch t := v.(type) {
+			case map[string]any:
+				switch {
+				case s.wildcard:
+					for _, mv := range t {
+						next = append(next, mv)
+					}
+				case !s.isIndex:
+					if mv, found := t[s.name]; found {
+						next = append(next, mv)
+					}
+				}
+
+			case []any:
+				switch {
+				case s.wildcard:
+					next = append(next, t...)
+				case s.isIndex:
+					idx := s.index
+					if idx < 0 {
+						idx += len(t)
+					}
+					if idx >= 0 && idx < len(t) {
+						next = append(next, t[idx])
+					}
+				}
+			}
+		}
+		if len(next) == 0 {
+			return nil
+		}
+		cur = next
+	}
+
+	return cur
+}
+
+func scalarStr(v any) (string, bool) {
+	switch t := v.(type) {
+	case string:
+		return t, true
+	case json.Number:
+		return t.String(), true
+	case bool:
+		return strconv.FormatBool(t), true
+	case nil:
+		return "null", true
+	}
+
+	return "", false
+}
@@ -0,0 +1,333 @@
+// Copyright 2024 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package matcher
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/textproto"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/xigxog/kubefox/core"
+)
+
+// Conflict is a route that can never be matched because every Event it
+// matches is matched first by another route.
+type Conflict struct {
+	// Route that is shadowed.
+	Route *core.Route
+	// ShadowedBy is the route matched instead of Route.
+	ShadowedBy *core.Route
+	// Identical is true if both routes have the same rule.
+	Identical bool
+}
+
+// term is a single predicate of a rule.
+type term struct {
+	name string
+	args []string
+}
+
+// conjunction is a rule made up only of predicates combined with '&&'. If the
+// rule contains other operators terms is nil and only identical rules are
+// compared.
+type conjunction struct {
+	key   string
+	terms []term
+}
+
+// Conflicts returns routes that are identical to or fully shadowed by a route
+// tested before them. Detection is conservative, only rules made up of
+// predicates combined with '&&' are checked for shadowing, other rules are
+// only checked for being identical.
+func (m *EventMatcher) Conflicts() []Conflict {
+	conjs := make([]conjunction, len(m.routes))
+	for i, r := range m.routes {
+		conjs[i] = parseConjunction(r.ResolvedRule)
+	}
+
+	var conflicts []Conflict
+	for j := range m.routes {
+		for i := 0; i < j; i++ {
+			// Routes with a split only match a share of Events.
+			if len(m.routes[i].splits) > 0 {
+				continue
+			}
+			identical := conjs[i].key == conjs[j].key
+			if identical || conjs[i].shadows(conjs[j]) {
+				conflicts = append(conflicts, Conflict{
+					Route:      m.routes[j].Route,
+					ShadowedBy: m.routes[i].Route,
+					Identical:  identical,
+				})
+				break
+			}
+		}
+	}
+
+	return conflicts
+}
+
+func parseConjunction(rule string) conjunction {
+	// Whitespace is not significant outside of inputs.
+	conj := conjunction{key: strings.Join(strings.Fields(rule), " ")}
+
+	expr, err := parser.ParseExpr(rule)
+	if err != nil {
+		return conj
+	}
+	terms, ok := addTerms(expr, nil)
+	if !ok {
+		return conj
+	}
+	slices.SortFunc(terms, func(a, b term) int {
+		return strings.Compare(a.String(), b.String())
+	})
+	conj.terms = terms
+
+	keys := make([]string, len(terms))
+	for i, t := range terms {
+		keys[i] = t.String()
+	}
+	conj.key = strings.Join(keys, " && ")
+
+	return conj
+}
+
+func addTerms(expr ast.Expr, terms []term) ([]term, bool) {
+	switch e := expr.(type) {
+	case *ast.ParenExpr:
+		return addTerms(e.X, terms)
+
+	case *ast.BinaryExpr:
+		if e.Op != token.LAND {
+			return nil, false
+		}
+		terms, ok := addTerms(e.X, terms)
+		if !ok {
+			return nil, false
+		}
+		return addTerms(e.Y, terms)
+
+	case *ast.CallExpr:
+		fun, ok := e.Fun.(*ast.Ident)
+		if !ok {
+			return nil, false
+		}
+		t := term{name: fun.Name}
+		for _, a := range e.Args {
+			lit, ok := a.(*ast.BasicLit)
+			if !ok || lit.Kind != token.STRING {
+				return nil, false
+			}
+			s, err := strconv.Unquote(lit.Value)
+			if err != nil {
+				return nil, false
+			}
+			t.args = append(t.args, s)
+		}
+		return append(terms, t), true
+	}
+
+	return nil, false
+}
+
+// shadows returns true if every Event matching o also matches c.
+func (c conjunction) shadows(o conjunction) bool {
+	if c.terms == nil || o.terms == nil {
+		return false
+	}
+
+	for _, t := range c.terms {
+		implied := false
+		for _, ot := range o.terms {
+			if ot.implies(t) {
+				implied = true
+				break
+			}
+		}
+		if !implied {
+			return false
+		}
+	}
+
+	return true
+}
+
+// implies returns true if every Event matching t also matches o.
+func (t term) implies(o term) bool {
+	if o.name == "All" || t.String() == o.String() {
+		return true
+	}
+
+	switch o.name {
+	case "Genesis":
+		return t.name == "Adapter"
+
+	case "Host":
+		if t.name != "Host" || len(t.args) != 1 || len(o.args) != 1 {
+			return false
+		}
+		oHost, oPort := splitHostPort(o.args[0])
+		tHost, tPort := splitHostPort(t.args[0])
+		return (oPort == "" || oPort == tPort) &&
+			coversParts(wildcardHost(oHost), wildcardHost(tHost), '.', false)
+
+	case "Path":
+		return t.name == "Path" && len(t.args) == 1 && len(o.args) == 1 &&
+			coversParts(o.args[0], t.args[0], '/', false)
+
+	case "PathPrefix":
+		return (t.name == "Path" || t.name == "PathPrefix") && len(t.args) == 1 && len(o.args) == 1 &&
+			coversParts(o.args[0], t.args[0], '/', true)
+
+	case "Method":
+		if t.name != "Method" {
+			return false
+		}
+		for _, m := range t.args {
+			if !slices.ContainsFunc(o.args, func(s string) bool { return strings.EqualFold(s, m) }) {
+				return false
+			}
+		}
+		return true
+
+	case "Type":
+		return t.name == "Type" && len(t.args) == 1 && len(o.args) == 1 &&
+			strings.EqualFold(t.args[0], o.args[0])
+
+	case "Header":
+		return t.name == o.name && len(t.args) == 2 && len(o.args) == 2 &&
+			textproto.CanonicalMIMEHeaderKey(t.args[0]) == textproto.CanonicalMIMEHeaderKey(o.args[0]) &&
+			coversVal(o.args[1], t.args[1])
+
+	case "HeaderExists":
+		return (t.name == "Header" || t.name == "HeaderI" || t.name == "HeaderPrefix" || t.name == "HeaderExists") &&
+			len(t.args) > 0 && len(o.args) == 1 &&
+			textproto.CanonicalMIMEHeaderKey(t.args[0]) == textproto.CanonicalMIMEHeaderKey(o.args[0])
+
+	case "HeaderPrefix":
+		if (t.name != "Header" && t.name != "HeaderPrefix") || len(t.args) != 2 || len(o.args) != 2 ||
+			textproto.CanonicalMIMEHeaderKey(t.args[0]) != textproto.CanonicalMIMEHeaderKey(o.args[0]) {
+			return false
+		}
+		if regex, _ := extractRegex(t.args[1]); t.name == "Header" && regex != nil {
+			return false
+		}
+		return strings.HasPrefix(t.args[1], o.args[1])
+
+	case "HeaderI":
+		return (t.name == "Header" || t.name == "HeaderI") && len(t.args) == 2 && len(o.args) == 2 &&
+			textproto.CanonicalMIMEHeaderKey(t.args[0]) == textproto.CanonicalMIMEHeaderKey(o.args[0]) &&
+			coversValI(o.args[1], t.args[1])
+
+	case "QueryExists":
+		return (t.name == "Query" || t.name == "QueryI" || t.name == "QueryExists") &&
+			len(t.args) > 0 && len(o.args) == 1 && t.args[0] == o.args[0]
+
+	case "QueryI":
+		return (t.name == "Query" || t.name == "QueryI") && len(t.args) == 2 && len(o.args) == 2 &&
+			t.args[0] == o.args[0] && coversValI(o.args[1], t.args[1])
+
+	case "Query", "Claim", "Body":
+		return t.name == o.name && len(t.args) == 2 && len(o.args) == 2 &&
+			t.args[0] == o.args[0] && coversVal(o.args[1], t.args[1])
+
+	case "Adapter", "ContentType", "Source":
+		if t.name != o.name || len(t.args) != len(o.args) {
+			return false
+		}
+		for i := range o.args {
+			if !coversVal(o.args[i], t.args[i]) {
+				return false
+			}
+		}
+		return true
+	}
+
+	return false
+}
+
+func (t term) String() string {
+	return t.name + "(`" + strings.Join(t.args, "`,`") + "`)"
+}
+
+// coversVal returns true if every value matching input b also matches input
+// a.
+func coversVal(a, b string) bool {
+	if a == b {
+		return true
+	}
+	regex, err := extractRegex(a)
+	if err != nil || regex == nil {
+		return false
+	}
+	if r := regex.String(); r == "^.*$" {
+		return true
+	}
+	if bRegex, _ := extractRegex(b); bRegex != nil {
+		return false
+	}
+
+	return regex.MatchString(b)
+}
+
+// coversValI is the case-insensitive variant of coversVal.
+func coversValI(a, b string) bool {
+	if strings.EqualFold(a, b) {
+		return true
+	}
+	regex, _ := extractRegex(a)
+
+	return regex != nil && regex.String() == "^.*$"
+}
+
+// coversParts returns true if every value matching pattern b also matches
+// pattern a.
+func coversParts(a, b string, sep byte, prefix bool) bool {
+	aParts, aParams, err := split(a, sep)
+	if err != nil {
+		return false
+	}
+	bParts, bParams, err := split(b, sep)
+	if err != nil {
+		return false
+	}
+	if len(bParts) < len(aParts) || (!prefix && len(bParts) != len(aParts)) {
+		return false
+	}
+
+	for i, aPart := range aParts {
+		aParam, aIsParam := aParams[i]
+		bParam, bIsParam := bParams[i]
+
+		switch {
+		case aIsParam && bIsParam:
+			r := aParam.regex.String()
+			if r != bParam.regex.String() && r != "^.*$" && r != "^.+$" && r != "^[^"+string(sep)+"]+$" {
+				return false
+			}
+		case aIsParam:
+			if !aParam.regex.MatchString(bParts[i]) {
+				return false
+			}
+		case bIsParam:
+			return false
+		default:
+			if aPart != bParts[i] {
+				return false
+			}
+		}
+	}
+
+	return true
+}
@@ -12,8 +12,9 @@ import (
 	"fmt"
 	"net/textproto"
 	"regexp"
-	"sort"
+	"slices"
 	"strings"
+	"sync"
 
 	"github.com/vulcand/predicate"
 	"github.com/xigxog/kubefox/api"
@@ -31,34 +32,60 @@ type param struct {
 type parsedRoute struct {
 	*core.Route
 
-	predicate EventPredicate
+	predicate    EventPredicate
+	requirements requirements
+	splits       []*weightSplit
+	splitGroup   string
+	splitBase    conjunction
 }
 
 type EventMatcher struct {
 	routes []*parsedRoute
+	index  *routeIndex
 	parser predicate.Parser
+
+	// hasBody is true if any route uses the Body predicate. The content of
+	// Events being matched are then cached in bodies.
+	hasBody bool
+	bodies  sync.Map
+	// Matchers that parsed routes using the Body predicate if the matcher was
+	// merged. Their predicates read content from their own bodies.
+	bodyParsers []*EventMatcher
+
+	// parsedSplits collects splits created while a rule is parsed.
+	parseMu      sync.Mutex
+	parsedSplits []*weightSplit
 }
 
 func New() *EventMatcher {
 	m := &EventMatcher{}
 
-	// TODO add various event criteria for route predicates:
-	// - only genesis events, only comp-comp, etc.
-	// - contains certain role/claim
-	// - comes from particular component or adapter
-	// - etc.
-
 	// Create a new parser and define the supported operators and methods
 	m.parser, _ = predicate.NewParser(predicate.Def{
 		Functions: map[string]interface{}{
-			"All":        m.all,
-			"Header":     m.header,
-			"Host":       m.host,
-			"Method":     m.method,
-			"Path":       m.path,
-			"PathPrefix": m.pathPrefix,
-			"Query":      m.query,
-			"Type":       m.eventType,
+			"Adapter":      m.adapter,
+			"All":          m.all,
+			"Body":         m.body,
+			"Claim":        m.claim,
+			"ContentType":  m.contentType,
+			"Genesis":      m.genesis,
+			"Header":       m.header,
+			"HeaderExists": m.headerExists,
+			"HeaderI":      m.headerI,
+			"HeaderPrefix": m.headerPrefix,
+			"Host":         m.host,
+			"HostRegexp":   m.hostRegexp,
+			"Internal":     m.internal,
+			"Method":       m.method,
+			"Path":         m.path,
+			"PathPrefix":   m.pathPrefix,
+			"Query":        m.query,
+			"QueryExists":  m.queryExists,
+			"QueryI":       m.queryI,
+			"Sample":       m.sample,
+			"Source":       m.source,
+			"Type":         m.eventType,
+			"Weight":       m.weight,
 		},
 		Operators: predicate.Operators{
 			AND: and,
@@ -76,27 +103,100 @@ func (m *EventMatcher) AddRoutes(routes ...*core.Route) error {
 			return fmt.Errorf("rule '%d' has not been resolved", r.Id)
 		}
 
-		parsed, err := m.parser.Parse(r.ResolvedRule)
+		pred, splits, err := m.parse(r.ResolvedRule)
 		if err != nil {
 			return err
 		}
 
-		m.routes = append(m.routes, &parsedRoute{
-			Route:     r,
-			predicate: parsed.(EventPredicate),
-		})
+		parsed := &parsedRoute{
+			Route:        r,
+			predicate:    pred,
+			requirements: parseRequirements(r.ResolvedRule),
+			splits:       splits,
+		}
+		if len(splits) == 1 {
+			parsed.splitGroup, parsed.splitBase = splitGroup(r)
+		}
+		m.routes = append(m.routes, parsed)
+		if strings.Contains(r.ResolvedRule, "Body(") {
+			m.hasBody = true
+		}
 	}
 
-	// Sort rules, longest (most specific) rule should be tested first.
-	sort.SliceStable(m.routes, func(i, j int) bool {
-		return m.routes[i].Priority > m.routes[j].Priority
-	})
+	m.sortRoutes()
+	m.assignSplits()
 
 	return nil
 }
 
+// Merge returns a matcher of the routes of all matchers. Rules are not parsed
+// again, only the order the routes are tested in is rebuilt. Splits are
+// assigned by the matchers that parsed them and must not span matchers, which
+// holds for routes of different AppDeployments.
+func Merge(matchers ...*EventMatcher) *EventMatcher {
+	m := New()
+	for _, src := range matchers {
+		m.routes = append(m.routes, src.routes...)
+		if !src.hasBody {
+			continue
+		}
+		m.hasBody = true
+		if len(src.bodyParsers) > 0 {
+			m.bodyParsers = append(m.bodyParsers, src.bodyParsers...)
+		} else {
+			m.bodyParsers = append(m.bodyParsers, src)
+		}
+	}
+	m.sortRoutes()
+
+	return m
+}
+
+// sortRoutes sorts routes, highest priority then longest (most specific) rule
+// should be tested first, and rebuilds the index.
+func (m *EventMatcher) sortRoutes() {
+	slices.SortStableFunc(m.routes, func(a, b *parsedRoute) int {
+		return core.CompareRoutes(a.Route, b.Route)
+	})
+	m.index = newRouteIndex(m.routes)
+}
+
+// Routes returns the routes of the matcher in the order they are tested.
+func (m *EventMatcher) Routes() []*core.Route {
+	routes := make([]*core.Route, len(m.routes))
+	for i, r := range m.routes {
+		routes[i] = r.Route
+	}
+
+	return routes
+}
+
+// Match returns the first route, in order of priority, whose rule matches the
+// Event. Only routes whose literal host, method and path prefix are satisfied
+// by the Event have their predicates evaluated.
 func (m *EventMatcher) Match(evt *core.Event) (*core.Route, bool) {
-	for _, r := range m.routes {
+	if m.index == nil {
+		return nil, false
+	}
+
+	if m.hasBody {
+		body := &parsedBody{}
+		for _, p := range append([]*EventMatcher{m}, m.bodyParsers...) {
+			p.bodies.Store(evt, body)
+			defer p.bodies.Delete(evt)
+		}
+	}
+
+	var method string
+	for _, r := range m.index.candidates(evt) {
+		if r.methods != nil {
+			if method == "" {
+				method = strings.ToUpper(evt.Value(api.ValKeyMethod))
+			}
+			if !r.methods[method] {
+				continue
+			}
+		}
 		if r.predicate(evt) {
 			return r.Route, true
 		}
@@ -105,12 +205,66 @@ func (m *EventMatcher) Match(evt *core.Event) (*core.Route, bool) {
 	return nil, false
 }
 
+func (m *EventMatcher) adapter(name string) (EventPredicate, error) {
+	regex, err := extractRegex(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex of adapter predicate %s: %w", name, err)
+	}
+
+	return func(e *core.Event) bool {
+		return api.ComponentType(e.GetSource().GetType()).IsAdapter() &&
+			matchStr(name, regex, e.GetSource().GetName())
+	}, nil
+}
+
 func (m *EventMatcher) all() EventPredicate {
 	return func(e *core.Event) bool {
 		return true
 	}
 }
 
+// claim matches claims of the JWT verified by the adapter that received the
+// genesis event. Claims are not available if the adapter does not verify JWTs.
+// The Broker removes claims from events not sent by a genesis adapter.
+func (m *EventMatcher) claim(key, val string) (EventPredicate, error) {
+	if key == "" {
+		return nil, fmt.Errorf("claim key must be provided")
+	}
+
+	regex, err := extractRegex(val)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex of claim predicate %s: %w", val, err)
+	}
+
+	return func(e *core.Event) bool {
+		return matchMap(key, val, regex, e.ValueMap(api.ValKeyClaims))
+	}, nil
+}
+
+func (m *EventMatcher) contentType(s string) (EventPredicate, error) {
+	regex, err := extractRegex(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex of content type predicate %s: %w", s, err)
+	}
+
+	return func(e *core.Event) bool {
+		// Parameters such as charset are ignored.
+		t, _, _ := strings.Cut(e.GetContentType(), ";")
+		t = strings.ToLower(strings.TrimSpace(t))
+		if regex != nil {
+			return regex.MatchString(t)
+		}
+		return strings.EqualFold(t, s)
+	}, nil
+}
+
+// genesis matches events that originated from an adapter.
+func (m *EventMatcher) genesis() EventPredicate {
+	return func(e *core.Event) bool {
+		return api.ComponentType(e.GetSource().GetType()).IsAdapter()
+	}
+}
+
 func (m *EventMatcher) header(key, val string) (EventPredicate, error) {
 	if key == "" {
 		return nil, fmt.Errorf("header key must be provided")
@@ -127,17 +281,109 @@ func (m *EventMatcher) header(key, val string) (EventPredicate, error) {
 	}, nil
 }
 
+// headerExists matches if the header is present, regardless of its value.
+func (m *EventMatcher) headerExists(key string) (EventPredicate, error) {
+	if key == "" {
+		return nil, fmt.Errorf("header key must be provided")
+	}
+	key = textproto.CanonicalMIMEHeaderKey(key)
+
+	return func(e *core.Event) bool {
+		_, found := e.ValueMap(api.ValKeyHeader)[key]
+		return found
+	}, nil
+}
+
+// headerI is the case-insensitive variant of header.
+func (m *EventMatcher) headerI(key, val string) (EventPredicate, error) {
+	if key == "" {
+		return nil, fmt.Errorf("header key must be provided")
+	}
+	key = textproto.CanonicalMIMEHeaderKey(key)
+
+	regex, err := extractRegexI(val)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex of header predicate %s: %w", val, err)
+	}
+
+	return func(e *core.Event) bool {
+		return matchMapI(key, val, regex, e.ValueMap(api.ValKeyHeader))
+	}, nil
+}
+
+func (m *EventMatcher) headerPrefix(key, prefix string) (EventPredicate, error) {
+	if key == "" {
+		return nil, fmt.Errorf("header key must be provided")
+	}
+	key = textproto.CanonicalMIMEHeaderKey(key)
+
+	return func(e *core.Event) bool {
+		for _, v := range e.ValueMap(api.ValKeyHeader)[key] {
+			if strings.HasPrefix(v, prefix) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// host matches the host of the Event. Hosts are compared case-insensitively. A
+// label of '*' matches any single label. If s includes a port the host of the
+// Event must have the same port, if the Event host does not include a port the
+// default port of the URL scheme is used. If s does not include a port any port
+// matches.
 func (m *EventMatcher) host(s string) (EventPredicate, error) {
-	parts, params, err := split(s, '.')
+	h, port := splitHostPort(s)
+	parts, params, err := split(wildcardHost(h), '.')
 	if err != nil {
 		return nil, err
 	}
+	for i := range parts {
+		if _, found := params[i]; !found {
+			parts[i] = strings.ToLower(parts[i])
+		}
+	}
 
 	return func(e *core.Event) bool {
-		return matchParts(api.ValKeyHost, ".", parts, params, e, false)
+		host, evtPort := eventHost(e)
+		if port != "" && port != evtPort {
+			return false
+		}
+		return matchParts(host, ".", parts, params, e, false)
 	}, nil
 }
 
+// hostRegexp matches the host of the Event, without port, against the regular
+// expression. Named groups of the expression are extracted to parameters.
+func (m *EventMatcher) hostRegexp(s string) (EventPredicate, error) {
+	r := strings.TrimSuffix(strings.TrimPrefix(s, "^"), "$")
+	regex, err := regexp.Compile("^(?i:" + r + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex of host regexp predicate %s: %w", s, err)
+	}
+
+	return func(e *core.Event) bool {
+		host, _ := eventHost(e)
+		match := regex.FindStringSubmatch(host)
+		if match == nil {
+			return false
+		}
+		for i, name := range regex.SubexpNames() {
+			if name != "" {
+				e.SetParam(name, match[i])
+			}
+		}
+		return true
+	}, nil
+}
+
+// internal matches events sent from one KubeFox Component to another.
+func (m *EventMatcher) internal() EventPredicate {
+	return func(e *core.Event) bool {
+		return api.ComponentType(e.GetSource().GetType()) == api.ComponentTypeKubeFox
+	}
+}
+
 func (m *EventMatcher) method(s ...string) EventPredicate {
 	return func(e *core.Event) bool {
 		m := e.Value(api.ValKeyMethod)
@@ -157,7 +403,7 @@ func (m *EventMatcher) path(s string) (EventPredicate, error) {
 	}
 
 	return func(e *core.Event) bool {
-		return matchParts(api.ValKeyPath, "/", parts, params, e, false)
+		return matchParts(e.Value(api.ValKeyPath), "/", parts, params, e, false)
 	}, nil
 }
 
@@ -168,7 +414,7 @@ func (m *EventMatcher) pathPrefix(s string) (EventPredicate, error) {
 	}
 
 	return func(e *core.Event) bool {
-		return matchParts(api.ValKeyPath, "/", parts, params, e, true)
+		return matchParts(e.Value(api.ValKeyPath), "/", parts, params, e, true)
 	}, nil
 }
 
@@ -187,6 +433,50 @@ func (m *EventMatcher) query(key, val string) (EventPredicate, error) {
 	}, nil
 }
 
+// queryExists matches if the query param is present, regardless of its value.
+func (m *EventMatcher) queryExists(key string) (EventPredicate, error) {
+	if key == "" {
+		return nil, fmt.Errorf("query param key must be provided")
+	}
+
+	return func(e *core.Event) bool {
+		_, found := e.ValueMap(api.ValKeyQuery)[key]
+		return found
+	}, nil
+}
+
+// queryI is the case-insensitive variant of query.
+func (m *EventMatcher) queryI(key, val string) (EventPredicate, error) {
+	if key == "" {
+		return nil, fmt.Errorf("query param key must be provided")
+	}
+
+	regex, err := extractRegexI(val)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex of query predicate %s: %w", val, err)
+	}
+
+	return func(e *core.Event) bool {
+		return matchMapI(key, val, regex, e.ValueMap(api.ValKeyQuery))
+	}, nil
+}
+
+func (m *EventMatcher) source(app, name string) (EventPredicate, error) {
+	appRegex, err := extractRegex(app)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex of source predicate %s: %w", app, err)
+	}
+	nameRegex, err := extractRegex(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex of source predicate %s: %w", name, err)
+	}
+
+	return func(e *core.Event) bool {
+		return matchStr(app, appRegex, e.GetSource().GetApp()) &&
+			matchStr(name, nameRegex, e.GetSource().GetName())
+	}, nil
+}
+
 func (m *EventMatcher) eventType(s string) EventPredicate {
 	return func(e *core.Event) bool {
 		return e.GetType() == s ||
@@ -232,21 +522,47 @@ func extractRegex(val string) (regex *regexp.Regexp, err error) {
 	return
 }
 
+func matchStr(val string, regex *regexp.Regexp, s string) bool {
+	if regex != nil {
+		return regex.MatchString(s)
+	}
+	return s == val
+}
+
 func matchMap(key, val string, regex *regexp.Regexp, m map[string][]string) bool {
 	if valArr, found := m[key]; found {
 		for _, v := range valArr {
-			if regex != nil && regex.MatchString(v) {
+			if matchStr(val, regex, v) {
 				return true
-			} else if v == val {
+			}
+		}
+	}
+	return false
+}
+
+// extractRegexI is the case-insensitive variant of extractRegex.
+func extractRegexI(val string) (*regexp.Regexp, error) {
+	if strings.HasPrefix(val, "{") && strings.HasSuffix(val, "}") {
+		return extractRegex("{(?i)" + strings.TrimPrefix(val[1:len(val)-1], "^") + "}")
+	}
+	return nil, nil
+}
+
+func matchMapI(key, val string, regex *regexp.Regexp, m map[string][]string) bool {
+	for _, v := range m[key] {
+		if regex != nil {
+			if regex.MatchString(v) {
 				return true
 			}
+		} else if strings.EqualFold(v, val) {
+			return true
 		}
 	}
 	return false
 }
 
 func matchParts(val string, sep string, parts []string, params map[int]*param, e *core.Event, prefix bool) bool {
-	evtParts := strings.Split(strings.Trim(e.Value(val), sep), sep)
+	evtParts := strings.Split(strings.Trim(val, sep), sep)
 
 	if len(parts) > len(evtParts) {
 		return false
@@ -284,6 +600,50 @@ func matchParts(val string, sep string, parts []string, params map[int]*param, e
 	return true
 }
 
+// eventHost returns the lower case host and port of the Event. If the host does
+// not include a port the default port of the URL scheme is returned.
+func eventHost(e *core.Event) (string, string) {
+	host, port := splitHostPort(strings.ToLower(e.Value(api.ValKeyHost)))
+	if port == "" {
+		switch u := e.Value(api.ValKeyURL); {
+		case strings.HasPrefix(u, "https:"):
+			port = "443"
+		case strings.HasPrefix(u, "http:"):
+			port = "80"
+		}
+	}
+
+	return strings.Trim(host, "."), port
+}
+
+// splitHostPort splits s into host and port. If s does not include a port
+// empty string is returned for the port.
+func splitHostPort(s string) (string, string) {
+	i := strings.LastIndexByte(s, ':')
+	if i < 0 || strings.Contains(s[i:], "]") {
+		return s, ""
+	}
+	// IPv6 address without brackets cannot include a port.
+	if !strings.HasPrefix(s, "[") && strings.Count(s, ":") > 1 {
+		return s, ""
+	}
+
+	return s[:i], s[i+1:]
+}
+
+// wildcardHost replaces labels of '*' with an unnamed parameter matching any
+// single label.
+func wildcardHost(s string) string {
+	parts := strings.Split(s, ".")
+	for i, p := range parts {
+		if p == "*" {
+			parts[i] = "{:[^.]+}"
+		}
+	}
+
+	return strings.Join(parts, ".")
+}
+
 // If this is ever placed in a hot path it should be optimized to use slices
 // instead of copying strings as it currently does for clarity.
 func split(s string, sep byte) ([]string, map[int]*param, error) {
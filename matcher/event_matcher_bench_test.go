@@ -0,0 +1,148 @@
+// Copyright 2024 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package matcher
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/xigxog/kubefox/api"
+	"github.com/xigxog/kubefox/core"
+)
+
+var benchSizes = []int{10, 100, 1000}
+
+// releaseRoutes returns routes similar to those of a release matcher with n
+// VirtualEnvironments, each with its own host.
+func releaseRoutes(b *testing.B, n int) []*core.Route {
+	rules := []string{
+		"Host(`%s`) && Method(`GET`) && Path(`/api/orders/{id}`)",
+		"Host(`%s`) && Method(`POST`) && Path(`/api/orders`)",
+		"Host(`%s`) && PathPrefix(`/static`)",
+		"Host(`%s`) && Method(`GET`,`PUT`) && Path(`/api/users/{id:[0-9]+}`) && Header(`x-tenant`, `{[a-z]+}`)",
+		"Host(`%s`) && (Path(`/health`) || Path(`/ready`))",
+	}
+
+	routes := make([]*core.Route, 0, n*len(rules))
+	for i := 0; i < n; i++ {
+		for _, r := range rules {
+			routes = append(routes, newRoute(b, len(routes), fmt.Sprintf(r, benchHost(i))))
+		}
+	}
+
+	return routes
+}
+
+// regexRoutes returns routes that cannot be indexed.
+func regexRoutes(b *testing.B, n int) []*core.Route {
+	routes := make([]*core.Route, 0, n)
+	for i := 0; i < n; i++ {
+		routes = append(routes, newRoute(b, i,
+			fmt.Sprintf("Host(`{[a-z]+}.%d.example.com`) || Header(`x-route`, `{r%d}`)", i, i)))
+	}
+
+	return routes
+}
+
+func newRoute(b *testing.B, id int, rule string) *core.Route {
+	r, err := core.NewRoute(id, rule)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := r.Resolve(nil); err != nil {
+		b.Fatal(err)
+	}
+	return r
+}
+
+func benchHost(i int) string {
+	return fmt.Sprintf("ve%d.example.com", i)
+}
+
+func benchEvt(host, method, path string) *core.Event {
+	e := core.NewEvent()
+	e.Type = string(api.EventTypeHTTP)
+	e.SetValue(api.ValKeyHost, host)
+	e.SetValue(api.ValKeyMethod, method)
+	e.SetValue(api.ValKeyPath, path)
+	e.SetValueMap(api.ValKeyHeader, map[string][]string{
+		"X-Tenant": {"acme"},
+	})
+
+	return e
+}
+
+func runMatch(b *testing.B, routes []*core.Route, e *core.Event, expected bool, match func(*EventMatcher, *core.Event) bool) {
+	m := New()
+	if err := m.AddRoutes(routes...); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if match(m, e) != expected {
+			b.Fatalf("expected match %t", expected)
+		}
+	}
+}
+
+func indexedMatch(m *EventMatcher, e *core.Event) bool {
+	_, matched := m.Match(e)
+	return matched
+}
+
+func linearMatch(m *EventMatcher, e *core.Event) bool {
+	for _, r := range m.routes {
+		if r.predicate(e) {
+			return true
+		}
+	}
+	return false
+}
+
+func BenchmarkMatch(b *testing.B) {
+	for _, n := range benchSizes {
+		b.Run(fmt.Sprintf("literal/routes=%d", n*5), func(b *testing.B) {
+			runMatch(b, releaseRoutes(b, n), benchEvt(benchHost(n-1), "PUT", "/api/users/42"), true, indexedMatch)
+		})
+		b.Run(fmt.Sprintf("miss/routes=%d", n*5), func(b *testing.B) {
+			runMatch(b, releaseRoutes(b, n), benchEvt("unknown.example.com", "GET", "/api/orders/1"), false, indexedMatch)
+		})
+		b.Run(fmt.Sprintf("regex/routes=%d", n), func(b *testing.B) {
+			runMatch(b, regexRoutes(b, n), benchEvt("a.0.example.com", "GET", "/"), true, indexedMatch)
+		})
+	}
+}
+
+func BenchmarkMatchLinear(b *testing.B) {
+	for _, n := range benchSizes {
+		b.Run(fmt.Sprintf("literal/routes=%d", n*5), func(b *testing.B) {
+			runMatch(b, releaseRoutes(b, n), benchEvt(benchHost(n-1), "PUT", "/api/users/42"), true, linearMatch)
+		})
+		b.Run(fmt.Sprintf("miss/routes=%d", n*5), func(b *testing.B) {
+			runMatch(b, releaseRoutes(b, n), benchEvt("unknown.example.com", "GET", "/api/orders/1"), false, linearMatch)
+		})
+	}
+}
+
+func BenchmarkAddRoutes(b *testing.B) {
+	for _, n := range benchSizes {
+		b.Run(fmt.Sprintf("routes=%d", n*5), func(b *testing.B) {
+			routes := releaseRoutes(b, n)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := New().AddRoutes(routes...); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -9,6 +9,7 @@
 package matcher
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/xigxog/kubefox/api"
@@ -90,6 +91,446 @@ func TestPriority(t *testing.T) {
 	t.Logf("route: %v, suffix: %s", r, e.PathSuffix())
 }
 
+func TestExplicitPriority(t *testing.T) {
+	catchAll, _ := core.NewRoute(1, "PathPrefix(`/customize`) && Method(`GET`,`PUT`,`POST`)")
+	catchAll.Priority = -1
+	catchAll.Resolve(nil)
+
+	specific, _ := core.NewRoute(2, "Path(`/customize/1/a`)")
+	specific.Resolve(nil)
+
+	// Equal priority and length, ordered by Component name.
+	tieB, _ := core.NewRoute(3, "Path(`/customize/{x}/a`)")
+	tieB.Component = &core.Component{Name: "b"}
+	tieB.Priority = 1
+	tieB.Resolve(nil)
+
+	tieA, _ := core.NewRoute(4, "Path(`/customize/1/{y}`)")
+	tieA.Component = &core.Component{Name: "a"}
+	tieA.Priority = 1
+	tieA.Resolve(nil)
+
+	m := New()
+	m.AddRoutes(catchAll, specific)
+	if r, _ := m.Match(evt(api.EventTypeHTTP)); r.Id != 2 {
+		t.Fatalf("expected route 2 to match, matched %d", r.Id)
+	}
+
+	m.AddRoutes(tieB, tieA)
+	if r, _ := m.Match(evt(api.EventTypeHTTP)); r.Id != 4 {
+		t.Fatalf("expected route 4 to match, matched %d", r.Id)
+	}
+}
+
+func TestExplain(t *testing.T) {
+	route1, _ := core.NewRoute(1, "Method(`PUT`) && Path(`/customize/{id}/a`)")
+	route1.Resolve(nil)
+
+	route2, _ := core.NewRoute(2, "Method(`GET`) && Path(`/customize/{id}/a`)")
+	route2.Resolve(nil)
+
+	m := New()
+	m.AddRoutes(route1, route2)
+
+	e := evt(api.EventTypeHTTP)
+	exp := m.Explain(e)
+	if !exp.Matched || len(exp.Routes) != 2 {
+		t.Fatalf("expected match with 2 routes, got %+v", exp)
+	}
+	if e.Param("id") != "" {
+		t.Fatalf("explain should not modify event")
+	}
+
+	for _, r := range exp.Routes {
+		if len(r.Predicates) != 2 {
+			t.Fatalf("route %d expected 2 predicates, got %d", r.Id, len(r.Predicates))
+		}
+		switch r.Id {
+		case 1:
+			if r.Matched || r.Candidate || r.Predicates[0].Matched || !r.Predicates[1].Matched {
+				t.Errorf("route 1 explanation is incorrect: %+v", r)
+			}
+			if r.Predicates[1].Params["id"] != "1" {
+				t.Errorf("route 1 path predicate should extract id, got %v", r.Predicates[1].Params)
+			}
+		case 2:
+			if !r.Matched || !r.Selected || r.Params["id"] != "1" {
+				t.Errorf("route 2 explanation is incorrect: %+v", r)
+			}
+		}
+	}
+}
+
+func TestEventCriteria(t *testing.T) {
+	adapter := core.NewComponent(api.ComponentTypeHTTPAdapter, "", "httpsrv", "")
+	comp := core.NewComponent(api.ComponentTypeKubeFox, "shop", "frontend", "")
+
+	tests := []struct {
+		rule   string
+		source *core.Component
+		match  bool
+	}{
+		{"Genesis()", adapter, true},
+		{"Genesis()", comp, false},
+		{"Internal()", comp, true},
+		{"Internal()", adapter, false},
+		{"Adapter(`httpsrv`)", adapter, true},
+		{"Adapter(`{http.*}`)", adapter, true},
+		{"Adapter(`httpsrv`)", comp, false},
+		{"Source(`shop`, `frontend`)", comp, true},
+		{"Source(`shop`, `{front.*}`)", comp, true},
+		{"Source(`shop`, `backend`)", comp, false},
+		{"ContentType(`application/json`)", comp, true},
+		{"ContentType(`{application/.*}`)", comp, true},
+		{"ContentType(`text/plain`)", comp, false},
+		{"Claim(`role`, `admin`)", adapter, true},
+		{"Claim(`role`, `{ad.*}`)", adapter, true},
+		{"Claim(`sub`, `admin`)", adapter, false},
+		{"Genesis() && !Claim(`role`, `user`)", adapter, true},
+		{"HeaderExists(`header-one`)", comp, true},
+		{"HeaderExists(`header-two`)", comp, false},
+		{"Header(`Header-One`, `H1`)", comp, false},
+		{"HeaderI(`Header-One`, `H1`)", comp, true},
+		{"HeaderI(`Header-One`, `{H[0-9]}`)", comp, true},
+		{"HeaderPrefix(`Header-One`, `h`)", comp, true},
+		{"HeaderPrefix(`Header-One`, `x`)", comp, false},
+		{"QueryExists(`q1`)", comp, true},
+		{"QueryExists(`q3`)", comp, false},
+		{"Query(`q1`, `Q1`)", comp, false},
+		{"QueryI(`q1`, `Q1`)", comp, true},
+	}
+
+	for _, test := range tests {
+		route, err := core.NewRoute(1, test.rule)
+		if err != nil {
+			t.Fatalf("unable to create route '%s': %v", test.rule, err)
+		}
+		route.Resolve(nil)
+
+		m := New()
+		if err := m.AddRoutes(route); err != nil {
+			t.Fatalf("unable to parse route '%s': %v", test.rule, err)
+		}
+
+		e := evt(api.EventTypeHTTP)
+		e.Source = test.source
+		e.ContentType = "application/json; charset=UTF-8"
+		e.SetValueMap(api.ValKeyClaims, map[string][]string{
+			"role": {"reader", "admin"},
+		})
+
+		if _, match := m.Match(e); match != test.match {
+			t.Errorf("rule '%s' with source '%s', expected match %t", test.rule, test.source.Key(), test.match)
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	catchAll, _ := core.NewRoute(1, "PathPrefix(`/customize`)")
+	catchAll.Resolve(nil)
+
+	body, _ := core.NewRoute(2, "Path(`/customize/1/a`) && Body(`$.action`, `opened`)")
+	body.Resolve(nil)
+
+	m1, m2 := New(), New()
+	m1.AddRoutes(catchAll)
+	m2.AddRoutes(body)
+
+	m := Merge(m1, m2)
+	if len(m.Routes()) != 2 {
+		t.Fatalf("expected 2 routes, got %d", len(m.Routes()))
+	}
+
+	e := evt(api.EventTypeHTTP)
+	if r, _ := m.Match(e); r.Id != 1 {
+		t.Fatalf("expected route 1 to match, matched %d", r.Id)
+	}
+
+	e.ContentType = "application/json"
+	e.Content = []byte(`{"action":"opened"}`)
+	if r, _ := m.Match(e); r.Id != 2 {
+		t.Fatalf("expected route 2 to match, matched %d", r.Id)
+	}
+	if r, _ := m1.Match(e); r.Id != 1 {
+		t.Fatalf("merged matchers should not change, matched %d", r.Id)
+	}
+}
+
+func TestBody(t *testing.T) {
+	content := `{"action":"opened","number":42,"draft":false,"labels":[{"name":"bug"},{"name":"ui"}],"x-y":null}`
+
+	tests := []struct {
+		rule  string
+		match bool
+	}{
+		{"Body(`$.action`, `opened`)", true},
+		{"Body(`$.action`, `{open.*}`)", true},
+		{"Body(`$.action`, `closed`)", false},
+		{"Body(`$.number`, `42`)", true},
+		{"Body(`$.draft`, `false`)", true},
+		{"Body(`$['x-y']`, `null`)", true},
+		{"Body(`$.labels[0].name`, `bug`)", true},
+		{"Body(`$.labels[-1].name`, `ui`)", true},
+		{"Body(`$.labels[*].name`, `ui`)", true},
+		{"Body(`$.labels[2].name`, `ui`)", false},
+		{"Body(`$.labels`, `{.*}`)", false},
+		{"Body(`$.missing`, `{.*}`)", false},
+		{"Body(`$.action`, `opened`) && Body(`$.number`, `{[0-9]+}`)", true},
+		{"Body(`$.action`, `opened`) && !Body(`$.draft`, `true`)", true},
+	}
+
+	for _, test := range tests {
+		route, err := core.NewRoute(1, test.rule)
+		if err != nil {
+			t.Fatalf("unable to create route '%s': %v", test.rule, err)
+		}
+		route.Resolve(nil)
+
+		m := New()
+		if err := m.AddRoutes(route); err != nil {
+			t.Fatalf("unable to parse route '%s': %v", test.rule, err)
+		}
+
+		e := evt(api.EventTypeHTTP)
+		e.ContentType = "application/json; charset=UTF-8"
+		e.Content = []byte(content)
+
+		if _, match := m.Match(e); match != test.match {
+			t.Errorf("rule '%s', expected match %t", test.rule, test.match)
+		}
+	}
+
+	route, _ := core.NewRoute(1, "Body(`$.action`, `opened`)")
+	route.Resolve(nil)
+	m := New()
+	m.AddRoutes(route)
+
+	e := evt(api.EventTypeHTTP)
+	e.ContentType = "text/plain"
+	e.Content = []byte(content)
+	if _, match := m.Match(e); match {
+		t.Error("content that is not JSON should not match")
+	}
+
+	e.ContentType = "application/json"
+	e.Content = []byte(`{"action":"opened","pad":"` + strings.Repeat("a", api.MaxBodyMatchSizeBytes) + `"}`)
+	if _, match := m.Match(e); match {
+		t.Error("content larger than limit should not match")
+	}
+}
+
+func TestSplit(t *testing.T) {
+	newRoutes := func(rules ...string) *EventMatcher {
+		routes := make([]*core.Route, len(rules))
+		for i, rule := range rules {
+			routes[i], _ = core.NewRoute(i, rule)
+			routes[i].Resolve(nil)
+		}
+		m := New()
+		if err := m.AddRoutes(routes...); err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+
+	m := newRoutes("Path(`/customize/1/a`) && Weight(`10`)", "Path(`/customize/1/a`) && Weight(`90%`)")
+	counts := map[int]int{}
+	for i := 0; i < 2000; i++ {
+		r, matched := m.Match(evt(api.EventTypeHTTP))
+		if !matched {
+			t.Fatal("weights of group add up to 100%, event should match")
+		}
+		counts[r.Id]++
+	}
+	if counts[0] < 120 || counts[0] > 280 {
+		t.Errorf("expected about 10%% of events to match canary route, got %d of 2000", counts[0])
+	}
+	if c := m.Conflicts(); len(c) > 0 {
+		t.Errorf("routes of split group should not conflict, got %v", c)
+	}
+	if g := m.SplitGroups(); len(g) != 1 || len(g[0].Routes) != 2 || g[0].Weight != 100 || g[0].Fallback {
+		t.Errorf("split group is incorrect: %+v", g)
+	}
+
+	m = newRoutes("Path(`/customize/1/a`) && Sample(`x-user`, `50%`)", "Path(`/customize/{id}/a`)")
+	e := evt(api.EventTypeHTTP)
+	e.SetHeader("x-user", "jane")
+	first, _ := m.Match(e)
+	for i := 0; i < 10; i++ {
+		e := evt(api.EventTypeHTTP)
+		e.SetHeader("x-user", "jane")
+		if r, _ := m.Match(e); r.Id != first.Id {
+			t.Fatal("events with same sample key should match same route")
+		}
+	}
+	if g := m.SplitGroups(); len(g) != 1 || g[0].Weight != 50 || !g[0].Fallback {
+		t.Errorf("split group is incorrect: %+v", g)
+	}
+}
+
+func TestHost(t *testing.T) {
+	tests := []struct {
+		rule   string
+		host   string
+		match  bool
+		params map[string]string
+	}{
+		{"Host(`example.com`)", "Example.COM", true, nil},
+		{"Host(`example.com`)", "example.com:8080", true, nil},
+		{"Host(`example.com:8080`)", "example.com:8080", true, nil},
+		{"Host(`example.com:8080`)", "example.com:9090", false, nil},
+		{"Host(`example.com:80`)", "example.com", true, nil},
+		{"Host(`example.com:443`)", "example.com", false, nil},
+		{"Host(`*.example.com`)", "acme.example.com", true, nil},
+		{"Host(`*.example.com`)", "acme.example.com:8080", true, nil},
+		{"Host(`*.example.com`)", "example.com", false, nil},
+		{"Host(`*.example.com`)", "a.b.example.com", false, nil},
+		{"Host(`{tenant}.example.com`)", "acme.example.com", true, map[string]string{"tenant": "acme"}},
+		{"HostRegexp(`(?P<tenant>[a-z]+)\\.example\\.com`)", "Acme.example.com:8080", true, map[string]string{"tenant": "acme"}},
+		{"HostRegexp(`[a-z]+\\.example\\.com`)", "a.b.example.com", false, nil},
+		{"Host(`[::1]:8080`)", "[::1]:8080", true, nil},
+	}
+
+	for _, test := range tests {
+		route, err := core.NewRoute(1, test.rule)
+		if err != nil {
+			t.Fatalf("unable to create route '%s': %v", test.rule, err)
+		}
+		route.Resolve(nil)
+
+		m := New()
+		if err := m.AddRoutes(route); err != nil {
+			t.Fatalf("unable to parse route '%s': %v", test.rule, err)
+		}
+
+		e := evt(api.EventTypeHTTP)
+		e.SetValue(api.ValKeyHost, test.host)
+		if _, match := m.Match(e); match != test.match {
+			t.Errorf("rule '%s' with host '%s', expected match %t", test.rule, test.host, test.match)
+		}
+		for k, v := range test.params {
+			if p := e.Param(k); p != v {
+				t.Errorf("rule '%s' with host '%s', expected param '%s' to be '%s', got '%s'", test.rule, test.host, k, v, p)
+			}
+		}
+	}
+}
+
+func TestInvalidCriteria(t *testing.T) {
+	for _, rule := range []string{
+		"Claim(``, `admin`)",
+		"Source(`shop`)",
+		"Adapter(`{[}`)",
+		"Body(`action`, `opened`)",
+		"Body(`$..action`, `opened`)",
+		"Body(`$.items[a]`, `1`)",
+		"Weight(`101`)",
+		"HostRegexp(`[`)",
+		"HeaderExists(``)",
+		"Sample(``, `10%`)",
+	} {
+		route, _ := core.NewRoute(1, rule)
+		route.Resolve(nil)
+
+		if err := New().AddRoutes(route); err == nil {
+			t.Errorf("rule '%s' should be invalid", rule)
+		}
+	}
+}
+
+func TestIndexedMatch(t *testing.T) {
+	rules := []string{
+		"Host(`a.example.com`) && Path(`/api/orders/{id}`)",
+		"Host(`a.example.com`) && Method(`POST`) && Path(`/api/orders`)",
+		"Host(`b.example.com`) && PathPrefix(`/api`)",
+		"PathPrefix(`/api/orders`)",
+		"Method(`get`) && Path(`/api/{resource}/{id}`)",
+		"Host(`{sub}.example.com`) && Path(`/api/orders/1`)",
+		"Path(`/api/orders/1`) || Host(`c.example.com`)",
+		"!Host(`a.example.com`) && Path(`/api/users`)",
+		"Path(`/\\{literal}/x`)",
+		"All()",
+	}
+	routes := make([]*core.Route, len(rules))
+	for i, rule := range rules {
+		routes[i], _ = core.NewRoute(i, rule)
+		routes[i].Resolve(nil)
+	}
+
+	m := New()
+	if err := m.AddRoutes(routes...); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, host := range []string{"a.example.com", "b.example.com", "c.example.com", "x.example.com"} {
+		for _, method := range []string{"GET", "POST", "PUT"} {
+			for _, path := range []string{"/", "/api", "/api/orders", "/api/orders/1", "/api/users", "/api/users/2", "/{literal}/x"} {
+				e := evt(api.EventTypeHTTP)
+				e.SetValue(api.ValKeyHost, host)
+				e.SetValue(api.ValKeyMethod, method)
+				e.SetValue(api.ValKeyPath, path)
+
+				var expected *core.Route
+				for _, r := range m.routes {
+					if r.predicate(e) {
+						expected = r.Route
+						break
+					}
+				}
+
+				actual, _ := m.Match(e)
+				if actual != expected {
+					t.Errorf("%s %s%s matched '%v', expected '%v'", method, host, path, actual, expected)
+				}
+			}
+		}
+	}
+}
+
+func TestConflicts(t *testing.T) {
+	tests := []struct {
+		rules     []string
+		conflict  bool
+		identical bool
+	}{
+		{[]string{"Path(`/a`) && Method(`GET`)", "Method(`GET`)  &&  Path(`/a`)"}, true, true},
+		{[]string{"PathPrefix(`/{all:.*}`)", "Path(`/orders`)"}, true, false},
+		{[]string{"PathPrefix(`/api`) && Header(`x-tenant`, `{}`)", "Path(`/api/orders/{id}`) && Header(`X-Tenant`, `acme`)"}, false, false},
+		{[]string{"Host(`{sub}.example.com`) && Method(`GET`,`POST`)", "Host(`a.example.com`) && Method(`GET`)"}, true, false},
+		{[]string{"Path(`/orders/{id:[0-9]+}`)", "Path(`/orders/{id}`)"}, false, false},
+		{[]string{"Path(`/orders`) || Path(`/users`)", "Path(`/orders`) || Path(`/users`)"}, true, true},
+		{[]string{"Path(`/orders`) || Path(`/users/{id}`)", "Path(`/orders`)"}, false, false},
+		{[]string{"Genesis() && Method(`GET`,`POST`,`PUT`)", "Adapter(`httpsrv`) && Method(`get`)"}, true, false},
+		{[]string{"Path(`/a`)", "Path(`/b`)"}, false, false},
+		{[]string{"Host(`*.example.com`) && All()", "Host(`a.example.com:8080`)"}, true, false},
+		{[]string{"Host(`*.example.com:8080`)", "Host(`a.example.com`)"}, false, false},
+		{[]string{"HeaderExists(`x-user`)", "Header(`X-User`, `j`)"}, true, false},
+		{[]string{"HeaderI(`x-user`, `JANE`) && Method(`GET`)", "Header(`X-User`, `jane`) && Method(`GET`)"}, true, false},
+	}
+
+	for _, test := range tests {
+		routes := make([]*core.Route, len(test.rules))
+		for i, rule := range test.rules {
+			routes[i], _ = core.NewRoute(i, rule)
+			routes[i].Resolve(nil)
+		}
+
+		m := New()
+		if err := m.AddRoutes(routes...); err != nil {
+			t.Fatal(err)
+		}
+
+		conflicts := m.Conflicts()
+		if found := len(conflicts) > 0; found != test.conflict {
+			t.Errorf("rules %v, expected conflict %t", test.rules, test.conflict)
+			continue
+		}
+		if test.conflict && conflicts[0].Identical != test.identical {
+			t.Errorf("rules %v, expected identical %t", test.rules, test.identical)
+		}
+	}
+}
+
 func evt(evtType api.EventType) *core.Event {
 	evt := core.NewEvent()
 	evt.Type = string(evtType)
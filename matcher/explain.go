@@ -0,0 +1,161 @@
+// Copyright 2024 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package matcher
+
+import (
+	"go/ast"
+	"go/parser"
+	"strings"
+
+	"github.com/xigxog/kubefox/api"
+	"github.com/xigxog/kubefox/core"
+	"google.golang.org/protobuf/proto"
+)
+
+// Explanation is a trace of matching an Event against all routes of a
+// matcher.
+type Explanation struct {
+	// Matched is true if a route matched the Event.
+	Matched bool `json:"matched"`
+	// Routes in the order they are tested.
+	Routes []*RouteExplanation `json:"routes"`
+}
+
+type RouteExplanation struct {
+	Id                 int    `json:"id"`
+	Component          string `json:"component,omitempty"`
+	VirtualEnvironment string `json:"virtualEnvironment,omitempty"`
+	AppDeployment      string `json:"appDeployment,omitempty"`
+	Rule               string `json:"rule"`
+	Priority           int    `json:"priority"`
+	Length             int    `json:"length"`
+
+	// Candidate is false if the literal host, method or path prefix required
+	// by the rule do not match the Event. Matching does not evaluate the
+	// predicates of routes that are not candidates.
+	Candidate bool `json:"candidate"`
+	// Matched is true if the rule matches the Event.
+	Matched bool `json:"matched"`
+	// Selected is true for the first route that matched. This is the route
+	// the Event is sent to.
+	Selected bool `json:"selected,omitempty"`
+	// Params extracted from the Event if the rule matched.
+	Params map[string]string `json:"params,omitempty"`
+	// Predicates of the rule, evaluated individually.
+	Predicates []*PredicateExplanation `json:"predicates,omitempty"`
+}
+
+type PredicateExplanation struct {
+	Predicate string            `json:"predicate"`
+	Matched   bool              `json:"matched"`
+	Params    map[string]string `json:"params,omitempty"`
+	Error     string            `json:"error,omitempty"`
+}
+
+// Explain matches the Event against all routes and returns the result of
+// every route and each of its predicates. The Event is not modified.
+func (m *EventMatcher) Explain(evt *core.Event) *Explanation {
+	exp := &Explanation{Routes: []*RouteExplanation{}}
+	if m.index == nil {
+		return exp
+	}
+
+	method := strings.ToUpper(evt.Value(api.ValKeyMethod))
+	candidates := map[*parsedRoute]bool{}
+	for _, r := range m.index.candidates(evt) {
+		candidates[r.parsedRoute] = r.methods == nil || r.methods[method]
+	}
+
+	for _, r := range m.routes {
+		matched, params := evaluate(r.predicate, evt)
+		re := &RouteExplanation{
+			Id:         r.Id,
+			Component:  r.Component.GroupKey(),
+			Rule:       r.ResolvedRule,
+			Priority:   r.Priority,
+			Length:     r.Length,
+			Candidate:  candidates[r],
+			Matched:    matched,
+			Params:     params,
+			Predicates: m.explainPredicates(r, evt),
+		}
+		if r.EventContext != nil {
+			re.VirtualEnvironment = r.EventContext.VirtualEnvironment
+			re.AppDeployment = r.EventContext.AppDeployment
+		}
+		if matched && !exp.Matched {
+			re.Selected = true
+			exp.Matched = true
+		}
+		exp.Routes = append(exp.Routes, re)
+	}
+
+	return exp
+}
+
+func (m *EventMatcher) explainPredicates(r *parsedRoute, evt *core.Event) []*PredicateExplanation {
+	rule := r.ResolvedRule
+	expr, err := parser.ParseExpr(rule)
+	if err != nil {
+		return []*PredicateExplanation{{Predicate: rule, Error: err.Error()}}
+	}
+
+	var (
+		preds  []*PredicateExplanation
+		splits int
+	)
+	ast.Inspect(expr, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+
+		// Positions start at 1.
+		pe := &PredicateExplanation{Predicate: rule[call.Pos()-1 : call.End()-1]}
+		if parsed, parsedSplits, err := m.parse(pe.Predicate); err != nil {
+			pe.Error = err.Error()
+		} else {
+			// Use the buckets assigned to the splits of the route.
+			for _, s := range parsedSplits {
+				if splits < len(r.splits) {
+					s.offset = r.splits[splits].offset
+				}
+				splits++
+			}
+			pe.Matched, pe.Params = evaluate(parsed, evt)
+		}
+		preds = append(preds, pe)
+
+		return false
+	})
+
+	return preds
+}
+
+// evaluate tests the predicate against a copy of the Event and returns the
+// result along with params set by the predicate.
+func evaluate(p EventPredicate, evt *core.Event) (bool, map[string]string) {
+	cp := proto.Clone(evt).(*core.Event)
+	if !p(cp) {
+		return false, nil
+	}
+
+	var params map[string]string
+	for k, v := range cp.Params {
+		if orig, found := evt.Params[k]; found && orig == v {
+			continue
+		}
+		if params == nil {
+			params = map[string]string{}
+		}
+		params[k] = cp.Param(k)
+	}
+
+	return true, params
+}
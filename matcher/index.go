@@ -0,0 +1,215 @@
+// Copyright 2024 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package matcher
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/xigxog/kubefox/api"
+	"github.com/xigxog/kubefox/core"
+)
+
+// routeIndex narrows down the routes that can match an Event before their
+// predicates are evaluated. Literal hosts, methods and path prefixes that
+// every match of a rule requires are extracted from the rule. Routes are
+// stored in a tree of path parts under their literal host. Routes without any
+// literal requirements, such as those using only regex or combining predicates
+// with '||', are stored at the root of the tree and are always evaluated.
+type routeIndex struct {
+	hosts   map[string]*pathNode
+	anyHost *pathNode
+}
+
+type pathNode struct {
+	children map[string]*pathNode
+	routes   []*indexedRoute
+}
+
+type indexedRoute struct {
+	*parsedRoute
+
+	// Position of route in the sorted list of routes, lower is tested first.
+	order int
+	// If not nil the method of the Event must be in set.
+	methods map[string]bool
+}
+
+// requirements are literal values an Event must have to match a rule.
+type requirements struct {
+	host    string
+	methods map[string]bool
+	path    []string
+}
+
+func newRouteIndex(routes []*parsedRoute) *routeIndex {
+	idx := &routeIndex{
+		hosts:   make(map[string]*pathNode),
+		anyHost: &pathNode{},
+	}
+	for i, r := range routes {
+		req := r.requirements
+		root := idx.anyHost
+		if req.host != "" {
+			if root = idx.hosts[req.host]; root == nil {
+				root = &pathNode{}
+				idx.hosts[req.host] = root
+			}
+		}
+		root.insert(req.path, &indexedRoute{
+			parsedRoute: r,
+			order:       i,
+			methods:     req.methods,
+		})
+	}
+
+	return idx
+}
+
+// candidates returns routes that can match the Event in the order they should
+// be tested.
+func (idx *routeIndex) candidates(evt *core.Event) []*indexedRoute {
+	parts := strings.Split(strings.Trim(evt.Value(api.ValKeyPath), "/"), "/")
+
+	var found []*indexedRoute
+	found = idx.anyHost.collect(parts, found)
+	if len(idx.hosts) > 0 {
+		host, _ := splitHostPort(strings.ToLower(evt.Value(api.ValKeyHost)))
+		if n := idx.hosts[strings.Trim(host, ".")]; n != nil {
+			found = n.collect(parts, found)
+		}
+	}
+	slices.SortFunc(found, func(a, b *indexedRoute) int {
+		return a.order - b.order
+	})
+
+	return found
+}
+
+func (n *pathNode) insert(path []string, r *indexedRoute) {
+	for _, p := range path {
+		if n.children == nil {
+			n.children = make(map[string]*pathNode)
+		}
+		child := n.children[p]
+		if child == nil {
+			child = &pathNode{}
+			n.children[p] = child
+		}
+		n = child
+	}
+	n.routes = append(n.routes, r)
+}
+
+// collect appends routes of the node and all nodes along path to found.
+func (n *pathNode) collect(path []string, found []*indexedRoute) []*indexedRoute {
+	for i := 0; n != nil; i++ {
+		found = append(found, n.routes...)
+		if i >= len(path) {
+			break
+		}
+		n = n.children[path[i]]
+	}
+
+	return found
+}
+
+// parseRequirements extracts the literal values required by rule. Only
+// predicates that are combined using '&&' are considered, anything negated or
+// part of an '||' expression is ignored. If the rule cannot be parsed no
+// requirements are returned and the route is always evaluated.
+func parseRequirements(rule string) requirements {
+	req := requirements{}
+
+	expr, err := parser.ParseExpr(rule)
+	if err != nil {
+		return req
+	}
+	req.add(expr)
+
+	return req
+}
+
+func (req *requirements) add(expr ast.Expr) {
+	switch e := expr.(type) {
+	case *ast.ParenExpr:
+		req.add(e.X)
+
+	case *ast.BinaryExpr:
+		if e.Op == token.LAND {
+			req.add(e.X)
+			req.add(e.Y)
+		}
+
+	case *ast.CallExpr:
+		fun, ok := e.Fun.(*ast.Ident)
+		if !ok {
+			return
+		}
+		args := make([]string, 0, len(e.Args))
+		for _, a := range e.Args {
+			lit, ok := a.(*ast.BasicLit)
+			if !ok || lit.Kind != token.STRING {
+				return
+			}
+			s, err := strconv.Unquote(lit.Value)
+			if err != nil {
+				return
+			}
+			args = append(args, s)
+		}
+
+		switch fun.Name {
+		case "Host":
+			if len(args) != 1 {
+				return
+			}
+			// The port is checked by the predicate.
+			host, _ := splitHostPort(args[0])
+			parts, params, err := split(wildcardHost(host), '.')
+			if err != nil || len(params) > 0 {
+				return
+			}
+			req.host = strings.ToLower(strings.Join(parts, "."))
+
+		case "Method":
+			methods := make(map[string]bool, len(args))
+			for _, m := range args {
+				m = strings.ToUpper(m)
+				if req.methods == nil || req.methods[m] {
+					methods[m] = true
+				}
+			}
+			req.methods = methods
+
+		case "Path", "PathPrefix":
+			if len(args) != 1 {
+				return
+			}
+			parts, params, err := split(args[0], '/')
+			if err != nil {
+				return
+			}
+			var prefix []string
+			for i, p := range parts {
+				if _, found := params[i]; found {
+					break
+				}
+				prefix = append(prefix, p)
+			}
+			if len(prefix) > len(req.path) {
+				req.path = prefix
+			}
+		}
+	}
+}
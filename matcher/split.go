@@ -0,0 +1,246 @@
+// Copyright 2024 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package matcher
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"hash/fnv"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/xigxog/kubefox/core"
+)
+
+// Weights have a precision of 0.01%.
+const splitBuckets = 10000
+
+// weightSplit selects a share of Events by hashing a key into buckets. Routes
+// with a split whose other predicates are the same form a group and are
+// assigned consecutive buckets, so the weights of a group add up.
+type weightSplit struct {
+	// key is a header name, 'cookie:<name>' or empty to use the trace id.
+	key    string
+	weight int
+	offset int
+}
+
+// SplitGroup is a set of routes that split Events matching the same
+// predicates by weight.
+type SplitGroup struct {
+	// Routes of the group in the order they are tested.
+	Routes []*core.Route
+	// Weight is the percent of Events matched by the routes of the group.
+	Weight float64
+	// Fallback is true if a route without a split tested after the group
+	// matches the remaining Events.
+	Fallback bool
+}
+
+func (m *EventMatcher) weight(w string) (EventPredicate, error) {
+	return m.newSplit("", w)
+}
+
+func (m *EventMatcher) sample(key, w string) (EventPredicate, error) {
+	if key == "" {
+		return nil, fmt.Errorf("sample key must be provided")
+	}
+
+	return m.newSplit(key, w)
+}
+
+func (m *EventMatcher) newSplit(key, w string) (EventPredicate, error) {
+	f, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(w, "%")), 64)
+	if err != nil || f < 0 || f > 100 {
+		return nil, fmt.Errorf("invalid weight %s, must be a percent between 0 and 100", w)
+	}
+
+	s := &weightSplit{
+		key:    key,
+		weight: int(math.Round(f * splitBuckets / 100)),
+	}
+	m.parsedSplits = append(m.parsedSplits, s)
+
+	return s.match, nil
+}
+
+func (s *weightSplit) match(e *core.Event) bool {
+	h := fnv.New64a()
+	h.Write([]byte(s.keyVal(e)))
+	b := int(h.Sum64() % splitBuckets)
+
+	return b >= s.offset && b < s.offset+s.weight
+}
+
+// keyVal returns the value of the key used to select the bucket of the Event.
+// If the key is missing the trace id is used so all Events of a request chain
+// are matched the same way.
+func (s *weightSplit) keyVal(e *core.Event) string {
+	var v string
+	switch {
+	case strings.HasPrefix(s.key, "cookie:"):
+		req := &http.Request{Header: http.Header{"Cookie": e.HeaderAll("Cookie")}}
+		if c, err := req.Cookie(strings.TrimPrefix(s.key, "cookie:")); err == nil {
+			v = c.Value
+		}
+	case s.key != "":
+		v = e.Header(s.key)
+	}
+	if v == "" {
+		v = e.TraceId()
+	}
+	if v == "" {
+		v = e.Id
+	}
+
+	return v
+}
+
+// parse parses the rule and returns the predicate along with the splits it
+// contains.
+func (m *EventMatcher) parse(rule string) (EventPredicate, []*weightSplit, error) {
+	m.parseMu.Lock()
+	defer m.parseMu.Unlock()
+
+	m.parsedSplits = nil
+	parsed, err := m.parser.Parse(rule)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return parsed.(EventPredicate), m.parsedSplits, nil
+}
+
+// assignSplits groups routes containing a single split at the top level of
+// their rule and assigns consecutive buckets to the routes of each group.
+func (m *EventMatcher) assignSplits() {
+	offsets := map[string]int{}
+	for _, r := range m.routes {
+		if r.splitGroup == "" {
+			continue
+		}
+		s := r.splits[0]
+		s.offset = offsets[r.splitGroup]
+		offsets[r.splitGroup] += s.weight
+	}
+}
+
+// SplitGroups returns the groups of routes that split Events by weight.
+func (m *EventMatcher) SplitGroups() []SplitGroup {
+	var (
+		groups []SplitGroup
+		bases  []conjunction
+		idx    = map[string]int{}
+	)
+	for _, r := range m.routes {
+		if r.splitGroup != "" {
+			i, found := idx[r.splitGroup]
+			if !found {
+				i = len(groups)
+				idx[r.splitGroup] = i
+				groups = append(groups, SplitGroup{})
+				bases = append(bases, r.splitBase)
+			}
+			groups[i].Routes = append(groups[i].Routes, r.Route)
+			groups[i].Weight += float64(r.splits[0].weight) * 100 / splitBuckets
+			continue
+		}
+		if len(r.splits) > 0 {
+			continue
+		}
+
+		conj := parseConjunction(r.ResolvedRule)
+		for i, g := range groups {
+			if !g.Fallback && sameContext(g.Routes[0], r.Route) &&
+				(conj.key == bases[i].key || conj.shadows(bases[i])) {
+				groups[i].Fallback = true
+			}
+		}
+	}
+
+	return groups
+}
+
+// splitGroup returns the key of the group of the route and the conjunction of
+// the predicates other than the split. If the rule does not contain a single
+// split at the top level empty string is returned.
+func splitGroup(r *core.Route) (string, conjunction) {
+	expr, err := parser.ParseExpr(r.ResolvedRule)
+	if err != nil {
+		return "", conjunction{}
+	}
+
+	var (
+		splitKey string
+		splits   int
+		others   []string
+	)
+	for _, t := range landTerms(expr, nil) {
+		if call, ok := t.(*ast.CallExpr); ok {
+			if fun, ok := call.Fun.(*ast.Ident); ok && (fun.Name == "Weight" || fun.Name == "Sample") {
+				splits++
+				if fun.Name == "Sample" && len(call.Args) > 0 {
+					if lit, ok := call.Args[0].(*ast.BasicLit); ok {
+						splitKey, _ = strconv.Unquote(lit.Value)
+					}
+				}
+				continue
+			}
+		}
+		// Whitespace is not significant outside of inputs.
+		src := r.ResolvedRule[t.Pos()-1 : t.End()-1]
+		others = append(others, strings.Join(strings.Fields(src), " "))
+	}
+	if splits != 1 {
+		return "", conjunction{}
+	}
+
+	slices.Sort(others)
+	base := "All()"
+	if len(others) > 0 {
+		base = strings.Join(others, " && ")
+	}
+
+	var ctx string
+	if r.EventContext != nil {
+		ctx = r.EventContext.VirtualEnvironment + "/" + r.EventContext.AppDeployment
+	}
+
+	return ctx + "|" + splitKey + "|" + base, parseConjunction(base)
+}
+
+// landTerms returns the operands of the top level '&&' operators of expr.
+func landTerms(expr ast.Expr, terms []ast.Expr) []ast.Expr {
+	switch e := expr.(type) {
+	case *ast.ParenExpr:
+		if b, ok := e.X.(*ast.BinaryExpr); ok && b.Op == token.LAND {
+			return landTerms(b, terms)
+		}
+	case *ast.BinaryExpr:
+		if e.Op == token.LAND {
+			return landTerms(e.Y, landTerms(e.X, terms))
+		}
+	}
+
+	return append(terms, expr)
+}
+
+func sameContext(a, b *core.Route) bool {
+	if a.EventContext == nil || b.EventContext == nil {
+		return a.EventContext == b.EventContext
+	}
+
+	return a.EventContext.VirtualEnvironment == b.EventContext.VirtualEnvironment &&
+		a.EventContext.AppDeployment == b.EventContext.AppDeployment
+}
@@ -11,6 +11,7 @@ package telemetry
 import (
 	crand "crypto/rand"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"math/rand"
 	"net/http"
@@ -303,14 +304,42 @@ func (s *Span) RecordErr(err error) {
 	defer s.mutex.Unlock()
 
 	s.SetRecord(true)
-	s.Events = append(s.Events, &tracev1.Span_Event{
-		TimeUnixNano: now(),
+
+	// Record an exception event for the error and each KubeFox error in its
+	// cause chain.
+	t := now()
+	s.Events = append(s.Events, exceptionEvent(t, err))
+	kfErr := &core.Err{}
+	if errors.As(err, &kfErr) {
+		for _, c := range kfErr.Causes() {
+			s.Events = append(s.Events, exceptionEvent(t, c))
+		}
+	}
+}
+
+func exceptionEvent(t uint64, err error) *tracev1.Span_Event {
+	attrs := []*commonv1.KeyValue{
+		Attr(AttrKeyExceptionType, typeStr(err)).KeyValue,
+		Attr(AttrKeyExceptionMsg, err.Error()).KeyValue,
+	}
+	if kfErr, ok := err.(*core.Err); ok {
+		attrs = append(attrs,
+			Attr(AttrKeyErrCode, int(kfErr.Code())).KeyValue,
+			Attr(AttrKeyErrRetryable, kfErr.Retryable()).KeyValue,
+		)
+		if kfErr.Component() != "" {
+			attrs = append(attrs, Attr(AttrKeyErrComponent, kfErr.Component()).KeyValue)
+		}
+		for k, v := range kfErr.Details() {
+			attrs = append(attrs, Attr(AttrKeyErrDetailPrefix+k, v).KeyValue)
+		}
+	}
+
+	return &tracev1.Span_Event{
+		TimeUnixNano: t,
 		Name:         EventNameException,
-		Attributes: []*commonv1.KeyValue{
-			Attr(AttrKeyExceptionType, typeStr(err)).KeyValue,
-			Attr(AttrKeyExceptionMsg, err.Error()).KeyValue,
-		},
-	})
+		Attributes:   attrs,
+	}
 }
 
 // End sets the span's and its children's end times. If an end time has already
@@ -15,6 +15,10 @@ const (
 	AttrKeyComponentId        = "kubefox.component.id"
 	AttrKeyComponentName      = "kubefox.component.name"
 	AttrKeyComponentType      = "kubefox.component.type"
+	AttrKeyErrCode            = "kubefox.error.code"
+	AttrKeyErrComponent       = "kubefox.error.component"
+	AttrKeyErrDetailPrefix    = "kubefox.error.detail."
+	AttrKeyErrRetryable       = "kubefox.error.retryable"
 	AttrKeyEventAppDeployment = "kubefox.event.context.app_deployment"
 	AttrKeyEventCategory      = "kubefox.event.category"
 	AttrKeyEventId            = "kubefox.event.id"
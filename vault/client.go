@@ -165,6 +165,38 @@ func (c *Client) GetData(ctx context.Context, key api.DataKey, data *api.Data) e
 	return nil
 }
 
+// DataVersion returns the current version of the data and the time it was
+// written. The version is incremented each time the data is written. If the
+// data does not exist 0 is returned.
+func (c *Client) DataVersion(ctx context.Context, key api.DataKey) (int64, time.Time, error) {
+	if key.Instance == "" {
+		key.Instance = c.Instance
+	}
+
+	secret, err := c.Logical().ReadWithContext(ctx, DataSubPath(key, "metadata"))
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	if secret == nil || secret.Data == nil {
+		return 0, time.Time{}, nil
+	}
+
+	ver, ok := secret.Data["current_version"].(json.Number)
+	if !ok {
+		return 0, time.Time{}, fmt.Errorf("metadata of data is missing current version")
+	}
+	v, err := ver.Int64()
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	// Update time is informational, ignore if missing.
+	updated, _ := secret.Data["updated_time"].(string)
+	t, _ := time.Parse(time.RFC3339Nano, updated)
+
+	return v, t, nil
+}
+
 func (c *Client) PutData(ctx context.Context, key api.DataKey, data *api.Data) error {
 	if key.Instance == "" {
 		key.Instance = c.Instance